@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -118,6 +119,15 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Optionally limit how much memory the HDF5 writers of a single job may buffer
+	if hdf5BufferCeiling := os.Getenv("HDF5_BUFFER_CEILING_MB"); hdf5BufferCeiling != "" {
+		ceilingMB, err := strconv.ParseInt(hdf5BufferCeiling, 10, 64)
+		if err != nil || ceilingMB <= 0 {
+			log.Fatal("HDF5_BUFFER_CEILING_MB must be a positive number of megabytes")
+		}
+		fileProcessor.WithHDF5BufferCeiling(ceilingMB * 1024 * 1024)
+	}
+
 	fileProcessor.Start(ctx)
 
 	fileUploadMiddleware := hytech_middleware.FileUploadMiddleware{
@@ -158,6 +168,10 @@ func main() {
 	handler.NewUploadHandler(router, dbClient, fileProcessor)
 	handler.NewDocumentationHandler(router, s3Repository)
 	handler.NewCarMetricsHandler(router, s3Repository, dbClient)
+	handler.NewSignalsHandler(router, dbClient)
+	handler.NewDerivedChannelsHandler(router, dbClient)
+	handler.NewEventRulesHandler(router, dbClient)
+	handler.NewCompareHandler(router, s3Repository, dbClient)
 
 	// Graceful shutdown: listen for interrupt signals
 	quit := make(chan os.Signal, 1)
@@ -14,8 +14,11 @@ require (
 	github.com/google/go-github v17.0.0+incompatible
 	github.com/jhump/protoreflect v1.16.0
 	github.com/joho/godotenv v1.5.1
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
 	go-hep.org/x/hep v0.35.0
 	go.mongodb.org/mongo-driver v1.16.0
+	gonum.org/v1/gonum v0.15.0
 	gonum.org/v1/hdf5 v0.0.0-20210714002203-8c5d23bc6946
 	gonum.org/v1/plot v0.14.0
 	google.golang.org/protobuf v1.34.2
@@ -65,6 +68,5 @@ require (
 	golang.org/x/image v0.18.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
-	gonum.org/v1/gonum v0.15.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
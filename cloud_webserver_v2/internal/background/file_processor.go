@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/hytech-racing/cloud-webserver-v2/internal/database"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
 
 	"github.com/hytech-racing/cloud-webserver-v2/internal/s3"
 )
@@ -74,6 +75,12 @@ type FileProcessor struct {
 
 	// activelyProcessing is used to show whether we are actively processing a FileJob
 	activelyProcessing bool
+
+	// hdf5BufferCeiling is the number of bytes the HDF5 writers of a job may hold in memory before they are forced to write
+	hdf5BufferCeiling int64
+
+	// PeakHDF5BufferedBytes is the most bytes the HDF5 writers of any job have held in memory at once since the server started
+	PeakHDF5BufferedBytes atomic.Int64
 }
 
 // FileJob contians all the logic and metadata for completing a job related to files.
@@ -122,14 +129,15 @@ func NewFileProcessor(uploadDir string, maxTotalSize int64, dbClient *database.D
 	}
 
 	fp := &FileProcessor{
-		directory:     uploadDir,
-		fileQueueChan: make(chan *FileJob, 100),
-		stopChan:      make(chan bool),
-		processingWg:  sync.WaitGroup{},
-		mu:            sync.RWMutex{},
-		maxTotalSize:  maxTotalSize,
-		dbClient:      dbClient,
-		s3Repository:  s3Repository,
+		directory:         uploadDir,
+		fileQueueChan:     make(chan *FileJob, 100),
+		stopChan:          make(chan bool),
+		processingWg:      sync.WaitGroup{},
+		mu:                sync.RWMutex{},
+		maxTotalSize:      maxTotalSize,
+		hdf5BufferCeiling: utils.DefaultHDF5BufferCeiling,
+		dbClient:          dbClient,
+		s3Repository:      s3Repository,
 	}
 
 	var totalSize int64
@@ -196,6 +204,31 @@ func (fp *FileProcessor) EnqueueFile(fileHeader *multipart.FileHeader, processor
 	return job, nil
 }
 
+// EnqueueRunFile returns a new FileJob for a file of a run already stored on S3 and adds it to the queue.
+// The file is not on the server yet, so processor needs to download it to the FilePath of the job.
+// size is the size of the file in bytes, it is counted in TotalSize until the job removes the file.
+func (fp *FileProcessor) EnqueueRunFile(filename string, size int64, date time.Time, processor FileJobProcessor) *FileJob {
+	id := fmt.Sprintf("job_%d", time.Now().UnixNano())
+	job := &FileJob{
+		ID:        id,
+		Filename:  filename,
+		Size:      size,
+		Status:    StatusPending,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		FilePath:  filepath.Join(fp.directory, fmt.Sprintf("%s_%s", id, filename)),
+		FileDir:   fp.directory,
+		Date:      date,
+		Processor: processor,
+	}
+
+	fp.TotalSize.Add(job.Size)
+	log.Printf("job put in queue, %v", job.ID)
+	fp.fileQueueChan <- job
+
+	return job
+}
+
 // jobQueueListener creates a listener which continuously polls the channels to check if there
 // is a new file job to process or if it should gracefully stop. It dequeues and processes the jobs here.
 func (fp *FileProcessor) jobQueueListener(ctx context.Context) {
@@ -252,6 +285,27 @@ func (fp *FileProcessor) Stop() {
 	fp.processingWg.Wait()
 }
 
+// WithHDF5BufferCeiling sets the number of bytes the HDF5 writers of a job may hold in memory
+func (fp *FileProcessor) WithHDF5BufferCeiling(ceiling int64) *FileProcessor {
+	fp.hdf5BufferCeiling = ceiling
+	return fp
+}
+
+// HDF5BufferCeiling returns the number of bytes the HDF5 writers of a job may hold in memory
+func (fp *FileProcessor) HDF5BufferCeiling() int64 {
+	return fp.hdf5BufferCeiling
+}
+
+// recordPeakHDF5BufferedBytes updates PeakHDF5BufferedBytes with the peak of a finished job
+func (fp *FileProcessor) recordPeakHDF5BufferedBytes(peak int64) {
+	for {
+		current := fp.PeakHDF5BufferedBytes.Load()
+		if peak <= current || fp.PeakHDF5BufferedBytes.CompareAndSwap(current, peak) {
+			return
+		}
+	}
+}
+
 // MaxTotalSize returns the max size allocated to the FileProcessor
 func (fp *FileProcessor) MaxTotalSize() int64 {
 	return fp.maxTotalSize
@@ -8,8 +8,11 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/foxglove/mcap/go/mcap"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/messaging"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/messaging/subscribers"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,7 +21,38 @@ import (
 // PostProcessMCAPUploadJob handles the post processing of MCAP files.
 // PostProcessMCAPUploadJob serves as a wrapper struct to hold the Process function
 // so it implicitely inherits FileJobProcessor.
-type PostProcessMCAPUploadJob struct{}
+type PostProcessMCAPUploadJob struct {
+	// CarModel is the car the run was logged on (HT09), its parameter set in effect on the date of the run is used.
+	// If it is empty, the car of subscribers.DefaultCarMetrics is used.
+	CarModel string
+
+	// InterpolationRate is the rate in Hz of the interpolated HDF5 and MCAP files.
+	// If it is 0, subscribers.DefaultInterpolationRate is used.
+	InterpolationRate float64
+
+	// InterpolationMethod is how numeric signals are resampled in the interpolated HDF5 and MCAP files.
+	// If it is empty, signals are linearly interpolated.
+	InterpolationMethod subscribers.ResampleMethod
+
+	// WriteMatFile creates a MATLAB .mat file of the run next to the HDF5 files
+	WriteMatFile bool
+
+	// HDF5LayoutVersion is the layout of the raw HDF5 file (utils.HDF5LayoutChunked or utils.HDF5LayoutSignals).
+	// If it is 0, the chunked layout is used since that is what the MPS scripts read.
+	HDF5LayoutVersion int
+
+	// EnergySignals are the accumulator voltage and current signals of the energy report.
+	// If they are empty, subscribers.DefaultEnergySignals are used.
+	EnergySignals subscribers.EnergySignals
+
+	// SpectrumSignals are the paths of the signals whose power spectral density is computed.
+	// If there are none, subscribers.DefaultSpectrumSignals are used.
+	SpectrumSignals []string
+
+	// DriverInputSignals are the throttle, brake and steering signals of the driver input report.
+	// If they are empty, subscribers.DefaultDriverInputSignals are used.
+	DriverInputSignals subscribers.DriverInputSignals
+}
 
 // Process reads MCAPs and sends the messages to multiple subscribers which
 // handle operations like creating HDF5 files and generating graphs.
@@ -29,10 +63,29 @@ func (p *PostProcessMCAPUploadJob) ProcessFileJob(fp *FileProcessor, job *FileJo
 	fp.updateJobStatus(job, StatusProcessing)
 
 	genericFileName := strings.Split(job.Filename, ".")[0]
-	mcapResults, err := p.readMCAPMessages(ctx, job, genericFileName)
+	budget := utils.NewHDF5BufferBudget(fp.HDF5BufferCeiling())
+
+	// Derived channels are read from the registry for every job, so a new channel applies to every run ingested after it.
+	// Runs ingested before it get it with a ReprocessDerivedChannelsJob.
+	derivedChannels, err := fp.dbClient.DerivedChannelUseCase().GetAllDerivedChannels(ctx)
+	if err != nil {
+		log.Printf("could not read derived channels, the run will be ingested without them: %v", err)
+	}
+	carMetrics := fp.carMetricsForRun(ctx, p.carModel(), job.Date)
+	evaluator := subscribers.NewDerivedChannelEvaluator(derivedChannels, subscribers.DerivedChannelConstants(carMetrics))
+
+	// Event rules are read for every job too, runs ingested before a rule was created don't have its events
+	eventRules, err := fp.dbClient.EventRuleUseCase().GetAllEventRules(ctx)
+	if err != nil {
+		log.Printf("could not read event rules, the run will be ingested without events: %v", err)
+	}
+
+	mcapResults, err := p.readMCAPMessages(ctx, job, genericFileName, budget, evaluator, carMetrics, eventRules, p.subscriberMapping())
 	if err != nil {
 		return err
 	}
+	log.Printf("HDF5 writers of job %v buffered at most %d bytes (ceiling %d bytes)", job.ID, budget.PeakBytes(), budget.Ceiling())
+	fp.recordPeakHDF5BufferedBytes(budget.PeakBytes())
 
 	// Extracting HDF5 file location from results
 	var hdf5Location string
@@ -42,6 +95,22 @@ func (p *PostProcessMCAPUploadJob) ProcessFileJob(fp *FileProcessor, job *FileJo
 		}
 	}
 
+	// Extracting interpolated HDF5 file location from results
+	var interpolatedHdf5Location string
+	if outer, ok := mcapResults[messaging.INTERPOLATED]; ok {
+		if data, ok := outer.ResultData["file_path"]; ok {
+			interpolatedHdf5Location = data.(string)
+		}
+	}
+
+	// Extracting interpolated MCAP file location from results
+	var interpolatedMcapLocation string
+	if outer, ok := mcapResults[messaging.INTERPOLATED_MCAP]; ok {
+		if data, ok := outer.ResultData["file_path"]; ok {
+			interpolatedMcapLocation = data.(string)
+		}
+	}
+
 	// Extracting VN Lat-Lon file location from results
 	var vnLatLonPlotWriter *io.WriterTo
 	if outer, ok := mcapResults[messaging.LATLON]; ok {
@@ -58,6 +127,133 @@ func (p *PostProcessMCAPUploadJob) ProcessFileJob(fp *FileProcessor, job *FileJo
 		}
 	}
 
+	// Extracting the preview pyramid from results
+	var previewPyramidWriter *io.WriterTo
+	if outer, ok := mcapResults[messaging.PREVIEW]; ok {
+		if data, ok := outer.ResultData["writer_to"]; ok {
+			previewPyramidWriter = data.(*io.WriterTo)
+		}
+	}
+
+	// Extracting the GPS tracks from results, they only exist if the run has a GPS fix
+	var gpxTrackWriter, kmlTrackWriter *io.WriterTo
+	if outer, ok := mcapResults[messaging.GPS_TRACK]; ok {
+		if data, ok := outer.ResultData["gpx_writer_to"]; ok {
+			gpxTrackWriter = data.(*io.WriterTo)
+		}
+		if data, ok := outer.ResultData["kml_writer_to"]; ok {
+			kmlTrackWriter = data.(*io.WriterTo)
+		}
+	}
+
+	// Extracting the energy report, the power plot and the laps from results, the report and plot only exist if the run logged the accumulator power
+	var energyReport *models.EnergyModel
+	var powerPlotWriter *io.WriterTo
+	var laps []models.LapModel
+	if outer, ok := mcapResults[messaging.ENERGY]; ok {
+		if data, ok := outer.ResultData["report"]; ok {
+			energyReport = data.(*models.EnergyModel)
+		}
+		if data, ok := outer.ResultData["writer_to"]; ok {
+			powerPlotWriter = data.(*io.WriterTo)
+		}
+		if data, ok := outer.ResultData["laps"]; ok {
+			laps = data.([]models.LapModel)
+		}
+	}
+
+	// Extracting the G-G diagram and the acceleration envelope from results, they only exist if the run logged the VectorNav accelerations
+	var ggEnvelope *models.GGEnvelopeModel
+	var ggDiagramWriter *io.WriterTo
+	if outer, ok := mcapResults[messaging.GG_DIAGRAM]; ok {
+		if data, ok := outer.ResultData["envelope"]; ok {
+			ggEnvelope = data.(*models.GGEnvelopeModel)
+		}
+		if data, ok := outer.ResultData["writer_to"]; ok {
+			ggDiagramWriter = data.(*io.WriterTo)
+		}
+	}
+
+	// Extracting the usage of the car from results
+	var usage *models.RunUsageModel
+	if outer, ok := mcapResults[messaging.USAGE]; ok {
+		if data, ok := outer.ResultData["usage"]; ok {
+			usage = data.(*models.RunUsageModel)
+		}
+	}
+
+	// Extracting the idle, moving and charging segments of the run from results
+	var segments []models.SegmentModel
+	if outer, ok := mcapResults[messaging.SEGMENTS]; ok {
+		if data, ok := outer.ResultData["segments"]; ok {
+			segments = data.([]models.SegmentModel)
+		}
+	}
+
+	// Extracting the events found by the event rules from results
+	var events []models.EventModel
+	var eventCounts map[string]int
+	if outer, ok := mcapResults[messaging.EVENTS]; ok {
+		if data, ok := outer.ResultData["events"]; ok {
+			events = data.([]models.EventModel)
+		}
+		if data, ok := outer.ResultData["event_counts"]; ok {
+			eventCounts = data.(map[string]int)
+		}
+	}
+
+	// Extracting the spectra and the spectrum plot from results, the plot only exists if one of the signals was logged
+	var spectra []models.SpectrumModel
+	var spectrumPlotWriter *io.WriterTo
+	if outer, ok := mcapResults[messaging.SPECTRUM]; ok {
+		if data, ok := outer.ResultData["spectra"]; ok {
+			spectra = data.([]models.SpectrumModel)
+		}
+		if data, ok := outer.ResultData["writer_to"]; ok {
+			spectrumPlotWriter = data.(*io.WriterTo)
+		}
+	}
+
+	// Extracting the driver input report from results, it only exists if the run logged the throttle
+	var driverInputs *models.DriverInputModel
+	if outer, ok := mcapResults[messaging.DRIVER_INPUTS]; ok {
+		if data, ok := outer.ResultData["report"]; ok {
+			driverInputs = data.(*models.DriverInputModel)
+		}
+	}
+
+	// Extracting the MAT file location from results, it only exists if it was asked for
+	var dotMatLocation string
+	if outer, ok := mcapResults[messaging.MAT_FILE]; ok {
+		if data, ok := outer.ResultData["file_path"]; ok {
+			dotMatLocation = data.(string)
+		}
+	}
+
+	// Extracting the signal catalog from results
+	var signals []models.SignalModel
+	if outer, ok := mcapResults[messaging.SIGNAL_CATALOG]; ok {
+		if data, ok := outer.ResultData["signals"]; ok {
+			signals = data.([]models.SignalModel)
+		}
+	}
+
+	// Extracting the data quality report from results
+	var qualityReport *models.DataQualityModel
+	if outer, ok := mcapResults[messaging.DATA_QUALITY]; ok {
+		if data, ok := outer.ResultData["report"]; ok {
+			qualityReport = data.(*models.DataQualityModel)
+		}
+	}
+	if qualityReport != nil {
+		if outer, ok := mcapResults[messaging.MATLAB]; ok {
+			if data, ok := outer.ResultData["failed_messages"]; ok {
+				qualityReport.FailedHDF5Messages = int64(data.(int))
+			}
+		}
+		qualityReport.CalculateScore()
+	}
+
 	// Uploading MCAP file to S3
 	mcapFileS3Reader, err := os.Open(job.FilePath)
 	if err != nil {
@@ -74,6 +270,12 @@ func (p *PostProcessMCAPUploadJob) ProcessFileJob(fp *FileProcessor, job *FileJo
 	}
 	log.Printf("uploaded mcap file %v to s3", mcapFileName)
 
+	// Uploading interpolated MCAP file to S3
+	interpolatedMcapFileEntry, err := fp.uploadOptionalFile(ctx, recordId, interpolatedMcapLocation, fmt.Sprintf("%s_interpolated.mcap", genericFileName))
+	if err != nil {
+		return err
+	}
+
 	// Uploading HDF5 file to S3
 	hdf5File, err := os.Open(hdf5Location)
 	if err != nil {
@@ -89,6 +291,18 @@ func (p *PostProcessMCAPUploadJob) ProcessFileJob(fp *FileProcessor, job *FileJo
 	}
 	log.Printf("uploaded hdf5 file %v to s3", hdf5FileName)
 
+	// Uploading interpolated HDF5 file to S3
+	interpolatedMatFileEntry, err := fp.uploadOptionalFile(ctx, recordId, interpolatedHdf5Location, fmt.Sprintf("%s_interpolated.h5", genericFileName))
+	if err != nil {
+		return err
+	}
+
+	// Uploading MAT file to S3
+	dotMatFileEntry, err := fp.uploadOptionalFile(ctx, recordId, dotMatLocation, fmt.Sprintf("%s.mat", genericFileName))
+	if err != nil {
+		return err
+	}
+
 	// Uploading Lat-Lon file to S3
 	vnLatLonPlotName := fmt.Sprintf("%v_LatLon.png", genericFileName)
 	vnLatLonPlotFileObjectPath := fmt.Sprintf("%s/%s", recordId.Hex(), vnLatLonPlotName)
@@ -107,32 +321,57 @@ func (p *PostProcessMCAPUploadJob) ProcessFileJob(fp *FileProcessor, job *FileJo
 	}
 	log.Printf("uploaded vn time vel plot %v to s3", vnTimeVelPlotName)
 
+	// Uploading preview pyramid to S3
+	previewPyramidFileEntry, err := fp.uploadWriterTo(ctx, recordId, previewPyramidWriter, fmt.Sprintf("%v_preview.bin", genericFileName))
+	if err != nil {
+		return err
+	}
+
+	// Uploading the GPS tracks, G-G diagram, power plot and spectrum plot to S3, the run is saved without the ones that fail
+	var gpsTrackFileEntries []models.FileModel
+	if gpxTrackWriter != nil && kmlTrackWriter != nil {
+		gpxTrackFileEntry := fp.uploadOptionalPlot(ctx, recordId, gpxTrackWriter, fmt.Sprintf("%v_track.gpx", genericFileName))
+		kmlTrackFileEntry := fp.uploadOptionalPlot(ctx, recordId, kmlTrackWriter, fmt.Sprintf("%v_track.kml", genericFileName))
+		if gpxTrackFileEntry != nil && kmlTrackFileEntry != nil {
+			gpsTrackFileEntries = []models.FileModel{*gpxTrackFileEntry, *kmlTrackFileEntry}
+		}
+	}
+	ggDiagramFileEntry := fp.uploadOptionalPlot(ctx, recordId, ggDiagramWriter, fmt.Sprintf("%v_GG.png", genericFileName))
+	powerPlotFileEntry := fp.uploadOptionalPlot(ctx, recordId, powerPlotWriter, fmt.Sprintf("%v_Power.png", genericFileName))
+	spectrumPlotFileEntry := fp.uploadOptionalPlot(ctx, recordId, spectrumPlotWriter, fmt.Sprintf("%v_Spectrum.png", genericFileName))
+
 	// After successful processing, if we are in PRODUCTION, save the mcap and h5 file to our docker volume
 	if os.Getenv("ENV") == "PRODUCTION" {
 		// Create the directory structure for the files
-		os.MkdirAll(fmt.Sprintf("/data/run_metadata/%s", recordId.Hex()), os.ModeDir)
+		os.MkdirAll(utils.RunFileCacheDirectory+recordId.Hex(), os.ModeDir)
 
 		// Create the HDF5 file in the volume
-		destHdf5File, err := os.Create(fmt.Sprintf("/data/run_metadata/%s", matObjectFilePath))
+		destHdf5File, err := os.Create(utils.RunFileCacheDirectory + matObjectFilePath)
 		if err != nil {
 			return fmt.Errorf("error to create h5 file in volume %w", err)
 		}
 		defer destHdf5File.Close()
 
-		// Copy the HDF5 file contents over to the file in the volume
+		// Copy the HDF5 file contents over to the file in the volume, the upload to S3 read it to the end
+		if _, err = hdf5File.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind h5 file: %w", err)
+		}
 		_, err = io.Copy(destHdf5File, hdf5File)
 		if err != nil {
 			return fmt.Errorf("failed to copy h5 file over to volume: %w", err)
 		}
 
 		// Create the MCAP file in the volume
-		destMcapFile, err := os.Create(fmt.Sprintf("/data/run_metadata/%s", mcapObjectFilePath))
+		destMcapFile, err := os.Create(utils.RunFileCacheDirectory + mcapObjectFilePath)
 		if err != nil {
 			return fmt.Errorf("error to create mcap file in volume %w", err)
 		}
 		defer destMcapFile.Close()
 
-		// Copy the MCAP file contents over to the file in the volume
+		// Copy the MCAP file contents over to the file in the volume, the upload to S3 read it to the end
+		if _, err = mcapFileS3Reader.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind mcap file: %w", err)
+		}
 		_, err = io.Copy(destMcapFile, mcapFileS3Reader)
 		if err != nil {
 			log.Printf("failed to copy mcap file over to volume: %v", err)
@@ -143,6 +382,24 @@ func (p *PostProcessMCAPUploadJob) ProcessFileJob(fp *FileProcessor, job *FileJo
 		return fmt.Errorf("failed to remove created mat mcapFile: %w", err)
 	}
 
+	if interpolatedHdf5Location != "" {
+		if err := os.Remove(interpolatedHdf5Location); err != nil {
+			return fmt.Errorf("failed to remove created interpolated mat file: %w", err)
+		}
+	}
+
+	if interpolatedMcapLocation != "" {
+		if err := os.Remove(interpolatedMcapLocation); err != nil {
+			return fmt.Errorf("failed to remove created interpolated mcap file: %w", err)
+		}
+	}
+
+	if dotMatLocation != "" {
+		if err := os.Remove(dotMatLocation); err != nil {
+			return fmt.Errorf("failed to remove created mat file: %w", err)
+		}
+	}
+
 	if err := os.Remove(job.FilePath); err != nil {
 		return fmt.Errorf("failed to remove processed mcapFile: %w", err)
 	}
@@ -156,17 +413,28 @@ func (p *PostProcessMCAPUploadJob) ProcessFileJob(fp *FileProcessor, job *FileJo
 		FilePath:  mcapObjectFilePath,
 		FileName:  mcapFileName,
 		FileHash:  fileHash,
+		FileSize:  localFileSize(mcapFileS3Reader),
+	}
+	// The raw MCAP file always comes first, signal queries read from it
+	mcapFiles := []models.FileModel{mcapFileEntry}
+	if interpolatedMcapFileEntry != nil {
+		mcapFiles = append(mcapFiles, *interpolatedMcapFileEntry)
 	}
-	mcapFiles := make([]models.FileModel, 1)
-	mcapFiles[0] = mcapFileEntry
 
 	matFileEntry := models.FileModel{
 		AwsBucket: fp.s3Repository.Bucket(),
 		FilePath:  matObjectFilePath,
 		FileName:  hdf5FileName,
+		FileSize:  localFileSize(hdf5File),
+	}
+	// The raw HDF5 file always comes first, the MPS reads from it
+	matFiles := []models.FileModel{matFileEntry}
+	if interpolatedMatFileEntry != nil {
+		matFiles = append(matFiles, *interpolatedMatFileEntry)
+	}
+	if dotMatFileEntry != nil {
+		matFiles = append(matFiles, *dotMatFileEntry)
 	}
-	matFiles := make([]models.FileModel, 1)
-	matFiles[0] = matFileEntry
 
 	contentFiles := make(map[string][]models.FileModel)
 	vnPlotFileEntry := models.FileModel{
@@ -185,14 +453,44 @@ func (p *PostProcessMCAPUploadJob) ProcessFileJob(fp *FileProcessor, job *FileJo
 	vnTimeVelPlotFiles := []models.FileModel{vnTimeVelPlotFileEntry}
 	contentFiles["vn_time_vel_plot"] = vnTimeVelPlotFiles
 
+	if previewPyramidFileEntry != nil {
+		contentFiles["preview_pyramid"] = []models.FileModel{*previewPyramidFileEntry}
+	}
+
+	if len(gpsTrackFileEntries) > 0 {
+		contentFiles["gps_track"] = gpsTrackFileEntries
+	}
+
+	if ggDiagramFileEntry != nil {
+		contentFiles["gg_diagram"] = []models.FileModel{*ggDiagramFileEntry}
+	}
+
+	if powerPlotFileEntry != nil {
+		contentFiles["power_plot"] = []models.FileModel{*powerPlotFileEntry}
+	}
+
+	if spectrumPlotFileEntry != nil {
+		contentFiles["spectrum_plot"] = []models.FileModel{*spectrumPlotFileEntry}
+	}
+
 	vehicleRunModel := &models.VehicleRunModel{
-		Date:         job.Date,
-		CarModel:     "HT09",
-		McapFiles:    mcapFiles,
-		MatFiles:     matFiles,
-		ContentFiles: contentFiles,
-		MpsRecord:    models.MpsRecordModel{},
-		Id:           recordId,
+		Date:          job.Date,
+		CarModel:      p.carModel(),
+		McapFiles:     mcapFiles,
+		MatFiles:      matFiles,
+		ContentFiles:  contentFiles,
+		MpsRecord:     models.MpsRecordModel{},
+		Signals:       signals,
+		QualityReport: qualityReport,
+		Laps:          laps,
+		Energy:        energyReport,
+		GGEnvelope:    ggEnvelope,
+		Usage:         usage,
+		Segments:      segments,
+		EventCounts:   eventCounts,
+		Spectra:       spectra,
+		DriverInputs:  driverInputs,
+		Id:            recordId,
 	}
 
 	_, err = fp.dbClient.VehicleRunUseCase().CreateVehicleRun(ctx, vehicleRunModel)
@@ -200,6 +498,18 @@ func (p *PostProcessMCAPUploadJob) ProcessFileJob(fp *FileProcessor, job *FileJo
 		log.Fatal(err)
 	}
 
+	if usage != nil {
+		err = fp.dbClient.CarUsageUseCase().AddRunUsage(ctx, p.carModel(), job.Date, *usage)
+		if err != nil {
+			log.Printf("could not add the usage of run %v to %v: %v", recordId.Hex(), p.carModel(), err)
+		}
+	}
+
+	err = fp.dbClient.EventUseCase().CreateRunEvents(ctx, recordId, events)
+	if err != nil {
+		log.Printf("could not save the events of run %v: %v", recordId.Hex(), err)
+	}
+
 	// Update the file processor's total size and estimated size after removing
 	fp.TotalSize.Add(-job.Size)
 	fp.MiddlewareEstimatedSize.Add(-job.Size)
@@ -210,11 +520,38 @@ func (p *PostProcessMCAPUploadJob) ProcessFileJob(fp *FileProcessor, job *FileJo
 	return nil
 }
 
-// readMCAPMessages reads an MCAP file and routes the topics to subscribers to perform operations on it.
+// subscriberMapping returns all the subsribers relavent to handling an uploaded MCAP file. You can attach more workers here if need be.
+func (p *PostProcessMCAPUploadJob) subscriberMapping() map[string]messaging.SubscriberFunc {
+	subscriberMapping := make(map[string]messaging.SubscriberFunc)
+	subscriberMapping[messaging.LATLON] = messaging.PlotLatLon
+	subscriberMapping[messaging.VELOCITY] = messaging.PlotTimeVelocity
+	subscriberMapping[messaging.MATLAB] = messaging.CreateRawMatlabFile
+	subscriberMapping[messaging.INTERPOLATED] = messaging.CreateInterpolatedMatlabFile
+	subscriberMapping[messaging.INTERPOLATED_MCAP] = messaging.CreateInterpolatedMcapFile
+	if p.WriteMatFile {
+		subscriberMapping[messaging.MAT_FILE] = messaging.CreateMatFile
+	}
+	subscriberMapping[messaging.SIGNAL_CATALOG] = messaging.CreateSignalCatalog
+	subscriberMapping[messaging.PREVIEW] = messaging.CreatePreviewPyramid
+	subscriberMapping[messaging.DATA_QUALITY] = messaging.CreateDataQualityReport
+	subscriberMapping[messaging.GPS_TRACK] = messaging.CreateGPSTrack
+	subscriberMapping[messaging.ENERGY] = messaging.CreateEnergyReport
+	subscriberMapping[messaging.GG_DIAGRAM] = messaging.CreateGGDiagram
+	subscriberMapping[messaging.USAGE] = messaging.CreateUsageReport
+	subscriberMapping[messaging.SEGMENTS] = messaging.CreateSegments
+	subscriberMapping[messaging.EVENTS] = messaging.CreateEventTimeline
+	subscriberMapping[messaging.SPECTRUM] = messaging.CreateSpectrum
+	subscriberMapping[messaging.DRIVER_INPUTS] = messaging.CreateDriverInputReport
+	return subscriberMapping
+}
+
+// readMCAPMessages reads an MCAP file and routes the topics to the subscribers of subscriberMapping to perform operations on it.
 // By default, we create a vectornav latitude and longitude plot and an HDF5 file with data sampled at 200hz.
 // It collects all the results (map[string]SubscriberResult aliased by SubscriberResults) generated by the subscribers
 // and returns that.
-func (p *PostProcessMCAPUploadJob) readMCAPMessages(ctx context.Context, job *FileJob, genericFileName string) (messaging.SubscriberResults, error) {
+// Derived channels are computed by evaluator and published under the subscribers.DerivedTopic.
+// carMetrics are the vehicle parameters of the run and eventRules the rules of the event timeline, both are passed to the subscribers in the INIT message.
+func (p *PostProcessMCAPUploadJob) readMCAPMessages(ctx context.Context, job *FileJob, genericFileName string, budget *utils.HDF5BufferBudget, evaluator *subscribers.DerivedChannelEvaluator, carMetrics models.CarMetricsModel, eventRules []models.EventRuleModel, subscriberMapping map[string]messaging.SubscriberFunc) (messaging.SubscriberResults, error) {
 	// mcapFile processing logic here
 	mcapFile, err := os.Open(job.FilePath)
 	if err != nil {
@@ -235,12 +572,6 @@ func (p *PostProcessMCAPUploadJob) readMCAPMessages(ctx context.Context, job *Fi
 		return nil, fmt.Errorf("could not get mcap mesages: %v", err)
 	}
 
-	// This is all the subsribers relavent to handling an MCAP mcapFile. You can attach more workers here if need be.
-	subscriberMapping := make(map[string]messaging.SubscriberFunc)
-	subscriberMapping[messaging.LATLON] = messaging.PlotLatLon
-	subscriberMapping[messaging.VELOCITY] = messaging.PlotTimeVelocity
-	subscriberMapping[messaging.MATLAB] = messaging.CreateRawMatlabFile
-
 	publisher := messaging.NewPublisher().WithRouter(routeMCAPDecodedMessage).WithResultsListener()
 	subscriber_names := make([]string, len(subscriberMapping))
 	idx := 0
@@ -258,6 +589,18 @@ func (p *PostProcessMCAPUploadJob) readMCAPMessages(ctx context.Context, job *Fi
 		initMessage["schema_list"] = mcapReader.SchemaList
 		initMessage["file_name"] = genericFileName
 		initMessage["file_path"] = job.FileDir
+		initMessage["interpolation_rate"] = p.interpolationRate()
+		initMessage["interpolation_method"] = p.interpolationMethod()
+		initMessage["hdf5_layout_version"] = p.hdf5LayoutVersion()
+		initMessage["schema_versions"] = mcapReader.SchemaVersions
+		initMessage["run_metadata"] = runMetadata(job, mcapReader, p.carModel(), carMetrics)
+		initMessage["hdf5_buffer_budget"] = budget
+		initMessage["signal_units"] = evaluator.Units()
+		initMessage["car_metrics"] = carMetrics
+		initMessage["energy_signals"] = p.EnergySignals
+		initMessage["event_rules"] = eventRules
+		initMessage["spectrum_signals"] = p.SpectrumSignals
+		initMessage["driver_input_signals"] = p.DriverInputSignals
 		publisher.Publish(ctx, &utils.DecodedMessage{Topic: messaging.INIT, Data: initMessage})
 
 		for {
@@ -276,16 +619,22 @@ func (p *PostProcessMCAPUploadJob) readMCAPMessages(ctx context.Context, job *Fi
 
 			if schema == nil {
 				log.Printf("no schema found for channel ID: %d, channel: %v", message.ChannelID, channel)
+				publishDecodeError(ctx, publisher, "", message, fmt.Errorf("no schema found for channel ID: %d", message.ChannelID))
 				continue
 			}
 
 			decodedMessage, err := mcapUtils.GetDecodedMessage(schema, message)
 			if err != nil {
 				log.Printf("error decoding message: %v", err)
+				publishDecodeError(ctx, publisher, schema.Name, message, err)
 				continue
 			}
 
 			publisher.Publish(ctx, decodedMessage)
+
+			if derivedMessage := evaluator.AddMessage(decodedMessage); derivedMessage != nil {
+				publisher.Publish(ctx, derivedMessage)
+			}
 		}
 
 		// Need to make sure to close the subscribers or our code will hang and wait forever
@@ -299,18 +648,165 @@ func (p *PostProcessMCAPUploadJob) readMCAPMessages(ctx context.Context, job *Fi
 	return publisher.Results(), nil
 }
 
+func (p *PostProcessMCAPUploadJob) carModel() string {
+	if p.CarModel == "" {
+		return subscribers.DefaultCarMetrics().CarModel
+	}
+	return p.CarModel
+}
+
+func (p *PostProcessMCAPUploadJob) interpolationRate() float64 {
+	if p.InterpolationRate == 0 {
+		return subscribers.DefaultInterpolationRate
+	}
+	return p.InterpolationRate
+}
+
+func (p *PostProcessMCAPUploadJob) interpolationMethod() subscribers.ResampleMethod {
+	if p.InterpolationMethod == "" {
+		return subscribers.ResampleLinear
+	}
+	return p.InterpolationMethod
+}
+
+func (p *PostProcessMCAPUploadJob) hdf5LayoutVersion() int {
+	if p.HDF5LayoutVersion == 0 {
+		return utils.HDF5LayoutChunked
+	}
+	return p.HDF5LayoutVersion
+}
+
+// uploadOptionalFile uploads the local file at localPath to S3 under the run recordId as fileName and returns its FileModel.
+// An empty localPath is a file its subscriber could not write, it is skipped and nil is returned.
+func (fp *FileProcessor) uploadOptionalFile(ctx context.Context, recordId primitive.ObjectID, localPath string, fileName string) (*models.FileModel, error) {
+	if localPath == "" {
+		log.Printf("no %v was written, skipping its upload", fileName)
+		return nil, nil
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %v: %w", fileName, err)
+	}
+	defer file.Close()
+
+	objectFilePath := fmt.Sprintf("%s/%s", recordId.Hex(), fileName)
+	err = fp.s3Repository.WriteObjectReader(ctx, file, objectFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not upload %v to s3: %w", fileName, err)
+	}
+	log.Printf("uploaded %v to s3", fileName)
+
+	return &models.FileModel{
+		AwsBucket: fp.s3Repository.Bucket(),
+		FilePath:  objectFilePath,
+		FileName:  fileName,
+		FileSize:  localFileSize(file),
+	}, nil
+}
+
+// uploadWriterTo uploads what writer writes to S3 as fileName in the folder of the run recordId.
+// A nil writer is a file the subscribers did not write, so nothing is uploaded and the returned file is nil.
+func (fp *FileProcessor) uploadWriterTo(ctx context.Context, recordId primitive.ObjectID, writer *io.WriterTo, fileName string) (*models.FileModel, error) {
+	if writer == nil {
+		log.Printf("no %v was written, skipping its upload", fileName)
+		return nil, nil
+	}
+
+	objectFilePath := fmt.Sprintf("%s/%s", recordId.Hex(), fileName)
+	err := fp.s3Repository.WriteObjectWriterTo(ctx, writer, objectFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not upload %v to s3: %w", fileName, err)
+	}
+	log.Printf("uploaded %v to s3", fileName)
+
+	return &models.FileModel{
+		AwsBucket: fp.s3Repository.Bucket(),
+		FilePath:  objectFilePath,
+		FileName:  fileName,
+	}, nil
+}
+
+// uploadOptionalPlot uploads a plot with uploadWriterTo, the run is saved without it if its upload fails
+func (fp *FileProcessor) uploadOptionalPlot(ctx context.Context, recordId primitive.ObjectID, writer *io.WriterTo, fileName string) *models.FileModel {
+	fileEntry, err := fp.uploadWriterTo(ctx, recordId, writer, fileName)
+	if err != nil {
+		log.Printf("the run is saved without %v: %v", fileName, err)
+	}
+	return fileEntry
+}
+
+// localFileSize returns the size of an open file, or 0 (an unknown size) if it can't be read
+func localFileSize(file *os.File) int64 {
+	info, err := file.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// carMetricsForRun returns the parameters of carModel in effect on date, or the defaults if the car has none
+func (fp *FileProcessor) carMetricsForRun(ctx context.Context, carModel string, date time.Time) models.CarMetricsModel {
+	carMetrics, err := fp.dbClient.CarMetricsUseCase().GetCarMetricsForRun(ctx, carModel, date)
+	if err != nil {
+		log.Printf("no %s car metrics in effect on %v, the run will be ingested with the default parameters: %v", carModel, date, err)
+		return subscribers.DefaultCarMetrics()
+	}
+	return *carMetrics
+}
+
+// runMetadata describes the run for the file level attributes of the HDF5 files
+func runMetadata(job *FileJob, mcapReader *utils.McapReader, carModel string, carMetrics models.CarMetricsModel) map[string]interface{} {
+	metadata := make(map[string]interface{})
+	metadata["file_name"] = job.Filename
+	metadata["car_model"] = carModel
+	// Version 0 means the car had no parameter set on the date of the run and the defaults were used
+	metadata["car_metrics_version"] = int64(carMetrics.Version)
+	metadata["created_at"] = time.Now().UTC().Format(time.RFC3339)
+	metadata["date"] = job.Date.UTC().Format(time.RFC3339)
+
+	// The start time is the origin of the times of every signal of the run, the same one the signal queries use
+	startTime, err := mcapReader.StartTime()
+	if err == nil {
+		metadata["start_time_ns"] = int64(startTime)
+	}
+
+	if mcapReader.Info != nil && mcapReader.Info.Statistics != nil {
+		statistics := mcapReader.Info.Statistics
+		metadata["end_time_ns"] = int64(statistics.MessageEndTime)
+		metadata["message_count"] = int64(statistics.MessageCount)
+	}
+
+	return metadata
+}
+
+// publishDecodeError lets the data quality subscriber know that a message could not be decoded
+func publishDecodeError(ctx context.Context, publisher *messaging.Publisher, schemaName string, message *mcap.Message, err error) {
+	errorMessage := make(map[string]interface{})
+	errorMessage["schema"] = schemaName
+	errorMessage["error"] = err.Error()
+	publisher.Publish(ctx, &utils.DecodedMessage{Topic: messaging.DECODE_ERROR, Data: errorMessage, LogTime: message.LogTime})
+}
+
 func routeMCAPDecodedMessage(ctx context.Context, decodedMessage *utils.DecodedMessage, possibleRoutes []string) []string {
 	// List of all the workers we want to send the messages to
 	var subscriberNames []string
 	switch topic := decodedMessage.Topic; topic {
-	case messaging.EOF:
+	case messaging.INIT, messaging.EOF:
 		subscriberNames = append(subscriberNames, possibleRoutes...)
+	case messaging.DECODE_ERROR:
+		subscriberNames = append(subscriberNames, messaging.DATA_QUALITY)
+	case subscribers.DerivedTopic:
+		// Derived channels have no schema to write in a MCAP file and are not logged by the car, so the data quality report skips them
+		subscriberNames = append(subscriberNames, messaging.EVENTS, messaging.SPECTRUM, messaging.MATLAB, messaging.INTERPOLATED, messaging.MAT_FILE, messaging.SIGNAL_CATALOG, messaging.PREVIEW)
 	case "hytech_msgs.VNData":
-		subscriberNames = append(subscriberNames, messaging.LATLON, messaging.MATLAB)
+		subscriberNames = append(subscriberNames, messaging.LATLON, messaging.GPS_TRACK, messaging.GG_DIAGRAM, messaging.USAGE, messaging.SEGMENTS, messaging.EVENTS, messaging.SPECTRUM, messaging.ENERGY, messaging.DRIVER_INPUTS, messaging.MATLAB, messaging.INTERPOLATED, messaging.INTERPOLATED_MCAP, messaging.MAT_FILE, messaging.SIGNAL_CATALOG, messaging.PREVIEW, messaging.DATA_QUALITY)
 	case "hytech_msgs.VehicleData":
-		subscriberNames = append(subscriberNames, messaging.VELOCITY, messaging.MATLAB)
+		subscriberNames = append(subscriberNames, messaging.VELOCITY, messaging.USAGE, messaging.SEGMENTS, messaging.EVENTS, messaging.SPECTRUM, messaging.ENERGY, messaging.DRIVER_INPUTS, messaging.MATLAB, messaging.INTERPOLATED, messaging.INTERPOLATED_MCAP, messaging.MAT_FILE, messaging.SIGNAL_CATALOG, messaging.PREVIEW, messaging.DATA_QUALITY)
 	default:
-		subscriberNames = append(subscriberNames, messaging.MATLAB)
+		// The energy report, the driver input report and the segments skip the topics which don't hold their signals, they can be set for every upload.
+		// The event timeline and the spectrum skip the topics without event rules or spectrum signals the same way.
+		subscriberNames = append(subscriberNames, messaging.ENERGY, messaging.DRIVER_INPUTS, messaging.SEGMENTS, messaging.EVENTS, messaging.SPECTRUM, messaging.MATLAB, messaging.INTERPOLATED, messaging.INTERPOLATED_MCAP, messaging.MAT_FILE, messaging.SIGNAL_CATALOG, messaging.PREVIEW, messaging.DATA_QUALITY)
 	}
 
 	return subscriberNames
@@ -0,0 +1,179 @@
+package background
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/messaging"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/messaging/subscribers"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReprocessDerivedChannelsJob evaluates the derived channel registry again against the MCAP file of a stored run,
+// so channels created or edited after the run was ingested apply to it too.
+// The HDF5 files and the preview pyramid of the run are written again and replace the ones on S3, and the signal catalog
+// of the run is replaced. The MCAP files, the .mat file and the reports of the run are left as they were ingested.
+type ReprocessDerivedChannelsJob struct {
+	// RunId is the id of the run to reprocess
+	RunId primitive.ObjectID
+
+	// Options are the HDF5 layout and the interpolation rate and method the HDF5 files are written with,
+	// the same options as an upload. Its CarModel is replaced by the car of the run.
+	Options *PostProcessMCAPUploadJob
+}
+
+// ProcessFileJob downloads the raw MCAP file of the run to the FilePath of job and reads it again with the subscribers
+// writing derived channels, then uploads their files over the ones of the run and updates its signal catalog.
+func (p *ReprocessDerivedChannelsJob) ProcessFileJob(fp *FileProcessor, job *FileJob) error {
+	ctx := context.Background()
+	fp.setCurrentlyProcessing(true)
+	defer fp.setCurrentlyProcessing(false)
+	fp.updateJobStatus(job, StatusProcessing)
+	defer fp.TotalSize.Add(-job.Size)
+
+	run, err := fp.dbClient.VehicleRunUseCase().GetVehicleRunById(ctx, p.RunId)
+	if err != nil {
+		return fmt.Errorf("could not read run %v: %w", p.RunId.Hex(), err)
+	}
+	if len(run.McapFiles) == 0 || len(run.MatFiles) == 0 {
+		return fmt.Errorf("run %v has no mcap or hdf5 file to reprocess", p.RunId.Hex())
+	}
+
+	// The raw MCAP and HDF5 files always come first
+	mcapFile := run.McapFiles[0]
+	err = fp.s3Repository.DownloadObject(ctx, mcapFile.AwsBucket, mcapFile.FilePath, job.FilePath)
+	if err != nil {
+		os.Remove(job.FilePath)
+		return fmt.Errorf("could not download mcap file of run %v: %w", p.RunId.Hex(), err)
+	}
+	defer os.Remove(job.FilePath)
+
+	// The files are written with the names they were ingested with, so they replace the ones on S3
+	genericFileName := strings.TrimSuffix(run.MatFiles[0].FileName, ".h5")
+	budget := utils.NewHDF5BufferBudget(fp.HDF5BufferCeiling())
+
+	derivedChannels, err := fp.dbClient.DerivedChannelUseCase().GetAllDerivedChannels(ctx)
+	if err != nil {
+		return fmt.Errorf("could not read derived channels: %w", err)
+	}
+	// The run keeps the car it was ingested with
+	p.Options.CarModel = run.CarModel
+	carMetrics := fp.carMetricsForRun(ctx, p.Options.carModel(), run.Date)
+	evaluator := subscribers.NewDerivedChannelEvaluator(derivedChannels, subscribers.DerivedChannelConstants(carMetrics))
+
+	// These are the subscribers receiving the messages of the subscribers.DerivedTopic whose results are stored with the run
+	subscriberMapping := make(map[string]messaging.SubscriberFunc)
+	subscriberMapping[messaging.MATLAB] = messaging.CreateRawMatlabFile
+	subscriberMapping[messaging.INTERPOLATED] = messaging.CreateInterpolatedMatlabFile
+	subscriberMapping[messaging.SIGNAL_CATALOG] = messaging.CreateSignalCatalog
+	subscriberMapping[messaging.PREVIEW] = messaging.CreatePreviewPyramid
+
+	mcapResults, err := p.Options.readMCAPMessages(ctx, job, genericFileName, budget, evaluator, carMetrics, nil, subscriberMapping)
+	if err != nil {
+		return err
+	}
+	fp.recordPeakHDF5BufferedBytes(budget.PeakBytes())
+
+	var hdf5Location, interpolatedHdf5Location string
+	if outer, ok := mcapResults[messaging.MATLAB]; ok {
+		if data, ok := outer.ResultData["file_path"]; ok {
+			hdf5Location = data.(string)
+		}
+	}
+	if outer, ok := mcapResults[messaging.INTERPOLATED]; ok {
+		if data, ok := outer.ResultData["file_path"]; ok {
+			interpolatedHdf5Location = data.(string)
+		}
+	}
+	defer removeLocalFile(hdf5Location)
+	defer removeLocalFile(interpolatedHdf5Location)
+
+	var signals []models.SignalModel
+	if outer, ok := mcapResults[messaging.SIGNAL_CATALOG]; ok {
+		if data, ok := outer.ResultData["signals"]; ok {
+			signals = data.([]models.SignalModel)
+		}
+	}
+
+	var previewPyramidWriter *io.WriterTo
+	if outer, ok := mcapResults[messaging.PREVIEW]; ok {
+		if data, ok := outer.ResultData["writer_to"]; ok {
+			previewPyramidWriter = data.(*io.WriterTo)
+		}
+	}
+
+	if hdf5Location == "" {
+		return fmt.Errorf("could not write the hdf5 file of run %v", p.RunId.Hex())
+	}
+	hdf5FileEntry, err := fp.uploadOptionalFile(ctx, run.Id, hdf5Location, run.MatFiles[0].FileName)
+	if err != nil {
+		return err
+	}
+	run.MatFiles[0] = *hdf5FileEntry
+
+	interpolatedHdf5FileEntry, err := fp.uploadOptionalFile(ctx, run.Id, interpolatedHdf5Location, fmt.Sprintf("%s_interpolated.h5", genericFileName))
+	if err != nil {
+		return err
+	}
+	if interpolatedHdf5FileEntry != nil {
+		run.MatFiles = replaceFileModel(run.MatFiles, *interpolatedHdf5FileEntry)
+	}
+
+	previewPyramidFileEntry, err := fp.uploadWriterTo(ctx, run.Id, previewPyramidWriter, fmt.Sprintf("%v_preview.bin", genericFileName))
+	if err != nil {
+		return err
+	}
+	if previewPyramidFileEntry != nil {
+		evictCachedRunFile(previewPyramidFileEntry.FilePath)
+	}
+
+	run.Signals = signals
+	err = fp.dbClient.VehicleRunUseCase().UpdateVehicleRun(ctx, run.Id, run)
+	if err != nil {
+		return fmt.Errorf("could not update run %v: %w", p.RunId.Hex(), err)
+	}
+
+	// The cached copies of the replaced files would be served until their size differs
+	for _, file := range run.MatFiles {
+		evictCachedRunFile(file.FilePath)
+	}
+
+	fp.updateJobStatus(job, StatusCompleted)
+	log.Printf("Completed reprocessing run %v with %d derived channels in job %v", p.RunId.Hex(), len(derivedChannels), job.ID)
+	return nil
+}
+
+// replaceFileModel replaces the file of files with the name of file, or appends file if there is none
+func replaceFileModel(files []models.FileModel, file models.FileModel) []models.FileModel {
+	for i := range files {
+		if files[i].FileName == file.FileName {
+			files[i] = file
+			return files
+		}
+	}
+	return append(files, file)
+}
+
+// removeLocalFile removes a file written by a subscriber, an empty path is a file that was not written
+func removeLocalFile(path string) {
+	if path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		log.Printf("could not remove %v: %v", path, err)
+	}
+}
+
+// evictCachedRunFile removes the copy of the file at objectPath on S3 from the local run file cache, if there is one
+func evictCachedRunFile(objectPath string) {
+	err := os.Remove(utils.RunFileCacheDirectory + objectPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("could not remove cached copy of %v: %v", objectPath, err)
+	}
+}
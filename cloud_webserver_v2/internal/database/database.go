@@ -15,9 +15,13 @@ import (
 // Whoever uses this struct to establish a connection to the database is responsible
 // for calling the Disconnect() method to gracefully disconnect from the database
 type DatabaseClient struct {
-	databaseClient       *mongo.Client
-	vehicleRunRepository repository.VehicleRunRepository
-	carMetricsRepository repository.CarMetricsRepository
+	databaseClient           *mongo.Client
+	vehicleRunRepository     repository.VehicleRunRepository
+	carMetricsRepository     repository.CarMetricsRepository
+	derivedChannelRepository repository.DerivedChannelRepository
+	carUsageRepository       repository.CarUsageRepository
+	eventRuleRepository      repository.EventRuleRepository
+	eventRepository          repository.EventRepository
 }
 
 const VehicleDataDatabase = "vehicle_data_db"
@@ -55,6 +59,30 @@ func NewDatabaseClient(ctx context.Context, uri string) (*DatabaseClient, error)
 	}
 	databaseClient.carMetricsRepository = carMetricsRepository
 
+	derivedChannelRepository, err := repository.NewMongoDerivedChannelRepository(client, vehicleDataDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("could not create derivedChannelRepository: %v", err)
+	}
+	databaseClient.derivedChannelRepository = derivedChannelRepository
+
+	carUsageRepository, err := repository.NewMongoCarUsageRepository(client, vehicleDataDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("could not create carUsageRepository: %v", err)
+	}
+	databaseClient.carUsageRepository = carUsageRepository
+
+	eventRuleRepository, err := repository.NewMongoEventRuleRepository(client, vehicleDataDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("could not create eventRuleRepository: %v", err)
+	}
+	databaseClient.eventRuleRepository = eventRuleRepository
+
+	eventRepository, err := repository.NewMongoEventRepository(client, vehicleDataDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("could not create eventRepository: %v", err)
+	}
+	databaseClient.eventRepository = eventRepository
+
 	return databaseClient, nil
 }
 
@@ -66,6 +94,22 @@ func (client *DatabaseClient) CarMetricsUseCase() *usecase.CarMetricsUseCase {
 	return usecase.NewCarMetricsUseCase(client.carMetricsRepository)
 }
 
+func (client *DatabaseClient) DerivedChannelUseCase() *usecase.DerivedChannelUseCase {
+	return usecase.NewDerivedChannelUseCase(client.derivedChannelRepository)
+}
+
+func (client *DatabaseClient) CarUsageUseCase() *usecase.CarUsageUseCase {
+	return usecase.NewCarUsageUseCase(client.carUsageRepository, client.vehicleRunRepository)
+}
+
+func (client *DatabaseClient) EventRuleUseCase() *usecase.EventRuleUseCase {
+	return usecase.NewEventRuleUseCase(client.eventRuleRepository)
+}
+
+func (client *DatabaseClient) EventUseCase() *usecase.EventUseCase {
+	return usecase.NewEventUseCase(client.eventRepository)
+}
+
 func (client *DatabaseClient) Disonnect(ctx context.Context) error {
 	err := client.databaseClient.Disconnect(ctx)
 	if err != nil {
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,12 +14,22 @@ import (
 
 const CarMetricsModel string = "car_metrics"
 
+// CarMetricsVersionIndex makes the versions of the parameter sets of a car unique
+const CarMetricsVersionIndex string = "car_metrics_version"
+
+// carMetricsSaveAttempts is how many times Save numbers a parameter set before giving up,
+// it only numbers it again when another parameter set of the car was saved with the same version at the same time
+const carMetricsSaveAttempts = 5
+
 // CarMetricsRepository contains the methods any db implementation needs to implement to interact with car metrics data
 type CarMetricsRepository interface {
 	GetAllCarMetrics(ctx context.Context) ([]models.CarMetricsModel, error)
 	Save(ctx context.Context, carMetrics models.CarMetricsModel) (models.CarMetricsModel, error)
 	UpdateMetricByID(ctx context.Context, idStr string, metricUpdates models.CarMetricsModel) (models.CarMetricsModel, error)
 	GetCarMetricsFromId(ctx context.Context, idStr string) (*models.CarMetricsModel, error)
+	GetCarMetricsFromCarModel(ctx context.Context, carModel string) ([]models.CarMetricsModel, error)
+	GetCarMetricsForRun(ctx context.Context, carModel string, date time.Time) (*models.CarMetricsModel, error)
+	DeleteCarMetricsFromId(ctx context.Context, idStr string) error
 }
 
 // MongoCarMetricsRepository conatins all the information needed to interact with a MongoDB implementation of the CarMetrics db
@@ -35,6 +46,17 @@ func NewMongoCarMetricsRepository(dbClient *mongo.Client, database *mongo.Databa
 		return nil, fmt.Errorf("could not get collection %s", CarMetricsModel)
 	}
 
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "car_model", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().
+			SetName(CarMetricsVersionIndex).
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"version": bson.M{"$gt": 0}}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create index %s: %v", CarMetricsVersionIndex, err)
+	}
+
 	return &MongoCarMetricsRepository{
 		dbClient:   dbClient,
 		db:         database,
@@ -42,17 +64,47 @@ func NewMongoCarMetricsRepository(dbClient *mongo.Client, database *mongo.Databa
 	}, nil
 }
 
-// Save creates a new Car Metrics document in the collection.
-// For now, I see this being used rarely as the current plan is to create a new document for each car (HT08, Ht09, etc.)
-// May change if we want to target more granular iterations of each car
+// Save creates a new Car Metrics document in the collection, versioned after the latest parameter set of its car.
+// Two parameter sets of a car saved at the same time can't get the same version, the unique CarMetricsVersionIndex
+// rejects the second one and it is numbered again after the first.
 func (repo *MongoCarMetricsRepository) Save(ctx context.Context, metrics models.CarMetricsModel) (models.CarMetricsModel, error) {
-	res, err := repo.collection.InsertOne(ctx, metrics)
+	for attempt := 0; attempt < carMetricsSaveAttempts; attempt++ {
+		version, err := repo.nextVersion(ctx, metrics.CarModel)
+		if err != nil {
+			return models.CarMetricsModel{}, err
+		}
+		metrics.Version = version
+
+		res, err := repo.collection.InsertOne(ctx, metrics)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return models.CarMetricsModel{}, fmt.Errorf("could not insert car model data: %v", err)
+		}
+
+		metrics.Id = res.InsertedID.(primitive.ObjectID)
+		return metrics, nil
+	}
+
+	return models.CarMetricsModel{}, fmt.Errorf("could not number the %s car metrics, they kept being saved at the same time", metrics.CarModel)
+}
+
+// nextVersion returns the version after the latest parameter set of carModel, 1 if it has none
+func (repo *MongoCarMetricsRepository) nextVersion(ctx context.Context, carModel string) (int, error) {
+	filter := bson.M{"car_model": carModel, "version": bson.M{"$gt": 0}}
+	opts := options.FindOne().SetSort(bson.M{"version": -1}).SetProjection(bson.M{"version": 1})
+
+	var latest models.CarMetricsModel
+	err := repo.collection.FindOne(ctx, filter, opts).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return 1, nil
+	}
 	if err != nil {
-		return models.CarMetricsModel{}, fmt.Errorf("could not insert car model data: %v", err)
+		return 0, fmt.Errorf("could not read the latest version of the %s car metrics: %v", carModel, err)
 	}
 
-	metrics.Id = res.InsertedID.(primitive.ObjectID)
-	return metrics, nil
+	return latest.Version + 1, nil
 }
 
 // UpdateMetricByID updates a document with idStr and updates the contents of the document with data specified in CarMetricsModel
@@ -107,9 +159,11 @@ func (repo *MongoCarMetricsRepository) GetCarMetricsFromId(ctx context.Context,
 	return &model, err
 }
 
+// GetAllCarMetrics gets every parameter set, sorted by car and by the date they took effect
 func (repo *MongoCarMetricsRepository) GetAllCarMetrics(ctx context.Context) ([]models.CarMetricsModel, error) {
 	filter := bson.M{}
-	cursor, err := repo.collection.Find(ctx, filter)
+	opts := options.Find().SetSort(bson.D{{Key: "car_model", Value: 1}, {Key: "effective_from", Value: 1}})
+	cursor, err := repo.collection.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -121,3 +175,60 @@ func (repo *MongoCarMetricsRepository) GetAllCarMetrics(ctx context.Context) ([]
 
 	return metrics, nil
 }
+
+// GetCarMetricsFromCarModel gets every parameter set of carModel, sorted by the date they took effect
+func (repo *MongoCarMetricsRepository) GetCarMetricsFromCarModel(ctx context.Context, carModel string) ([]models.CarMetricsModel, error) {
+	filter := bson.M{"car_model": carModel}
+	opts := options.Find().SetSort(bson.M{"effective_from": 1})
+	cursor, err := repo.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]models.CarMetricsModel, 0)
+	if err = cursor.All(ctx, &metrics); err != nil {
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// GetCarMetricsForRun gets the parameter set of carModel which was in effect on date, the one with the latest
+// effective_from before it. It returns mongo.ErrNoDocuments if the car has no parameter set that old.
+func (repo *MongoCarMetricsRepository) GetCarMetricsForRun(ctx context.Context, carModel string, date time.Time) (*models.CarMetricsModel, error) {
+	filter := bson.M{
+		"car_model":      carModel,
+		"effective_from": bson.M{"$lte": date},
+	}
+	opts := options.FindOne().SetSort(bson.M{"effective_from": -1})
+	result := repo.collection.FindOne(ctx, filter, opts)
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var model models.CarMetricsModel
+	err := result.Decode(&model)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode result into model: %v", err)
+	}
+
+	return &model, nil
+}
+
+// DeleteCarMetricsFromId deletes the parameter set with idStr
+func (repo *MongoCarMetricsRepository) DeleteCarMetricsFromId(ctx context.Context, idStr string) error {
+	objID, err := primitive.ObjectIDFromHex(idStr)
+	if err != nil {
+		return fmt.Errorf("invalid id: %s", idStr)
+	}
+
+	result, err := repo.collection.DeleteOne(ctx, bson.M{"_id": objID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
@@ -0,0 +1,245 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const CarUsageModel string = "car_usage"
+
+// CarUsageCarModelIndex makes every car have a single usage document
+const CarUsageCarModelIndex string = "car_usage_car_model"
+
+// CarUsageRepository contains the methods any db implementation needs to implement to interact with the usage counters
+// and the components of the cars
+type CarUsageRepository interface {
+	GetCarUsage(ctx context.Context, carModel string) (*models.CarUsageModel, error)
+	SetCarUsage(ctx context.Context, carModel string, usage models.UsageTotalsModel) error
+	AddRunUsage(ctx context.Context, carModel string, date time.Time, usage models.RunUsageModel) error
+	GetCarComponents(ctx context.Context, filters *bson.M) ([]models.CarComponentModel, error)
+	SaveCarComponent(ctx context.Context, component models.CarComponentModel) (models.CarComponentModel, error)
+	UpdateCarComponentFromId(ctx context.Context, id primitive.ObjectID, component models.CarComponentModel) (models.CarComponentModel, error)
+	SetCarComponentUsage(ctx context.Context, id primitive.ObjectID, usage models.UsageTotalsModel) error
+	GetCarComponentFromId(ctx context.Context, id primitive.ObjectID) (*models.CarComponentModel, error)
+	DeleteCarComponentFromId(ctx context.Context, id primitive.ObjectID) error
+}
+
+// MongoCarUsageRepository contains all the information needed to interact with a MongoDB implementation of the car usage counters.
+// Every car has a single usage document in the car_usage collection, holding its counters and its components.
+type MongoCarUsageRepository struct {
+	dbClient   *mongo.Client
+	db         *mongo.Database
+	collection *mongo.Collection
+}
+
+// NewMongoCarUsageRepository creates a new MongoCarUsageRepository with a MongoDB client and database
+func NewMongoCarUsageRepository(dbClient *mongo.Client, database *mongo.Database) (*MongoCarUsageRepository, error) {
+	collection := database.Collection(CarUsageModel)
+	if collection == nil {
+		return nil, fmt.Errorf("could not get collection %s", CarUsageModel)
+	}
+
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "car_model", Value: 1}},
+		Options: options.Index().SetName(CarUsageCarModelIndex).SetUnique(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create index %s: %v", CarUsageCarModelIndex, err)
+	}
+
+	return &MongoCarUsageRepository{
+		dbClient:   dbClient,
+		db:         database,
+		collection: collection,
+	}, nil
+}
+
+// carUsageFilter matches the usage document of carModel
+func carUsageFilter(carModel string) bson.M {
+	return bson.M{"car_model": carModel}
+}
+
+// GetCarUsage gets the usage document of carModel, it returns mongo.ErrNoDocuments if none of its runs were counted yet
+func (repo *MongoCarUsageRepository) GetCarUsage(ctx context.Context, carModel string) (*models.CarUsageModel, error) {
+	result := repo.collection.FindOne(ctx, carUsageFilter(carModel))
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var model models.CarUsageModel
+	err := result.Decode(&model)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode result into model: %v", err)
+	}
+
+	return &model, nil
+}
+
+// SetCarUsage replaces the counters of carModel, its usage document is created if it does not exist yet
+func (repo *MongoCarUsageRepository) SetCarUsage(ctx context.Context, carModel string, usage models.UsageTotalsModel) error {
+	update := bson.M{
+		"$set":         bson.M{"usage": usage},
+		"$setOnInsert": bson.M{"components": []models.CarComponentModel{}},
+	}
+	_, err := repo.collection.UpdateOne(ctx, carUsageFilter(carModel), update, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("could not set the usage of %s: %v", carModel, err)
+	}
+	return nil
+}
+
+// AddRunUsage counts a run of carModel dated date in the counters of the car and of the components installed on date,
+// in a single update. It returns mongo.ErrNoDocuments if the car has no usage document yet.
+func (repo *MongoCarUsageRepository) AddRunUsage(ctx context.Context, carModel string, date time.Time, usage models.RunUsageModel) error {
+	inc, maxValues, minValues := bson.M{}, bson.M{}, bson.M{}
+	for _, prefix := range []string{"usage.", "components.$[component].usage."} {
+		inc[prefix+"run_count"] = 1
+		inc[prefix+"distance"] = usage.Distance
+		inc[prefix+"motor_on_time"] = usage.MotorOnTime
+		maxValues[prefix+"max_speed"] = usage.MaxSpeed
+		maxValues[prefix+"last_run"] = date
+		minValues[prefix+"first_run"] = date
+	}
+	update := bson.M{"$inc": inc, "$max": maxValues, "$min": minValues}
+
+	// A component counts the runs from the date it was installed until the date it was removed, like GetUsageTotals
+	installed := bson.M{
+		"component.installed_at": bson.M{"$lte": date},
+		"$or": bson.A{
+			bson.M{"component.removed_at": nil},
+			bson.M{"component.removed_at": bson.M{"$gt": date}},
+		},
+	}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{installed}})
+
+	result, err := repo.collection.UpdateOne(ctx, carUsageFilter(carModel), update, opts)
+	if err != nil {
+		return fmt.Errorf("could not add run usage to %s: %v", carModel, err)
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// GetCarComponents returns the components matching filters, sorted by car, type and the date they were installed.
+// The filters are on the fields of the components (type, removed_at), car_model matches the car holding them.
+func (repo *MongoCarUsageRepository) GetCarComponents(ctx context.Context, filters *bson.M) ([]models.CarComponentModel, error) {
+	return repo.findCarComponents(ctx, *filters)
+}
+
+func (repo *MongoCarUsageRepository) findCarComponents(ctx context.Context, filters bson.M) ([]models.CarComponentModel, error) {
+	componentFilters := bson.M{}
+	for key, value := range filters {
+		componentFilters["components."+key] = value
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$components"}},
+		{{Key: "$match", Value: componentFilters}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$components"}}},
+		{{Key: "$sort", Value: bson.D{{Key: "car_model", Value: 1}, {Key: "type", Value: 1}, {Key: "installed_at", Value: 1}}}},
+	}
+
+	cursor, err := repo.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	components := make([]models.CarComponentModel, 0)
+	if err = cursor.All(ctx, &components); err != nil {
+		return nil, err
+	}
+
+	return components, nil
+}
+
+// SaveCarComponent adds a new component to the usage document of its car, which needs to exist
+func (repo *MongoCarUsageRepository) SaveCarComponent(ctx context.Context, component models.CarComponentModel) (models.CarComponentModel, error) {
+	if component.Id.IsZero() {
+		component.Id = primitive.NewObjectID()
+	}
+
+	update := bson.M{"$push": bson.M{"components": component}}
+	result, err := repo.collection.UpdateOne(ctx, carUsageFilter(component.CarModel), update)
+	if err != nil {
+		return models.CarComponentModel{}, fmt.Errorf("could not insert car component: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return models.CarComponentModel{}, mongo.ErrNoDocuments
+	}
+
+	return component, nil
+}
+
+// UpdateCarComponentFromId replaces the fields of the component with id, which keeps its id, car and usage
+func (repo *MongoCarUsageRepository) UpdateCarComponentFromId(ctx context.Context, id primitive.ObjectID, component models.CarComponentModel) (models.CarComponentModel, error) {
+	update := bson.M{"$set": bson.M{
+		"components.$.type":         component.Type,
+		"components.$.name":         component.Name,
+		"components.$.installed_at": component.InstalledAt,
+		"components.$.removed_at":   component.RemovedAt,
+		"components.$.notes":        component.Notes,
+	}}
+
+	err := repo.updateCarComponent(ctx, id, update)
+	if err != nil {
+		return models.CarComponentModel{}, err
+	}
+
+	updatedComponent, err := repo.GetCarComponentFromId(ctx, id)
+	if err != nil {
+		return models.CarComponentModel{}, err
+	}
+	return *updatedComponent, nil
+}
+
+// SetCarComponentUsage replaces the counters of the component with id
+func (repo *MongoCarUsageRepository) SetCarComponentUsage(ctx context.Context, id primitive.ObjectID, usage models.UsageTotalsModel) error {
+	return repo.updateCarComponent(ctx, id, bson.M{"$set": bson.M{"components.$.usage": usage}})
+}
+
+func (repo *MongoCarUsageRepository) updateCarComponent(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	result, err := repo.collection.UpdateOne(ctx, bson.M{"components._id": id}, update)
+	if err != nil {
+		return fmt.Errorf("could not update car component: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// GetCarComponentFromId gets a component with its id
+func (repo *MongoCarUsageRepository) GetCarComponentFromId(ctx context.Context, id primitive.ObjectID) (*models.CarComponentModel, error) {
+	components, err := repo.findCarComponents(ctx, bson.M{"_id": id})
+	if err != nil {
+		return nil, err
+	}
+	if len(components) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return &components[0], nil
+}
+
+// DeleteCarComponentFromId removes the component with id from its car
+func (repo *MongoCarUsageRepository) DeleteCarComponentFromId(ctx context.Context, id primitive.ObjectID) error {
+	update := bson.M{"$pull": bson.M{"components": bson.M{"_id": id}}}
+	result, err := repo.collection.UpdateOne(ctx, bson.M{"components._id": id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const DerivedChannelModel string = "derived_channels"
+
+// DerivedChannelRepository contains the methods any db implementation needs to implement to interact with the derived channel registry
+type DerivedChannelRepository interface {
+	GetAllDerivedChannels(ctx context.Context) ([]models.DerivedChannelModel, error)
+	Save(ctx context.Context, channel models.DerivedChannelModel) (models.DerivedChannelModel, error)
+	UpdateDerivedChannelFromId(ctx context.Context, id primitive.ObjectID, channel models.DerivedChannelModel) (models.DerivedChannelModel, error)
+	GetDerivedChannelFromId(ctx context.Context, id primitive.ObjectID) (*models.DerivedChannelModel, error)
+	DeleteDerivedChannelFromId(ctx context.Context, id primitive.ObjectID) error
+}
+
+// MongoDerivedChannelRepository contains all the information needed to interact with a MongoDB implementation of the derived channel registry
+type MongoDerivedChannelRepository struct {
+	dbClient   *mongo.Client
+	db         *mongo.Database
+	collection *mongo.Collection
+}
+
+// NewMongoDerivedChannelRepository creates a new MongoDerivedChannelRepository with a MongoDB client and database
+func NewMongoDerivedChannelRepository(dbClient *mongo.Client, database *mongo.Database) (*MongoDerivedChannelRepository, error) {
+	collection := database.Collection(DerivedChannelModel)
+	if collection == nil {
+		return nil, fmt.Errorf("could not get collection %s", DerivedChannelModel)
+	}
+
+	return &MongoDerivedChannelRepository{
+		dbClient:   dbClient,
+		db:         database,
+		collection: collection,
+	}, nil
+}
+
+// GetAllDerivedChannels returns every derived channel in the order they were created, which is the order they are evaluated in
+func (repo *MongoDerivedChannelRepository) GetAllDerivedChannels(ctx context.Context) ([]models.DerivedChannelModel, error) {
+	cursor, err := repo.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	channels := make([]models.DerivedChannelModel, 0)
+	if err = cursor.All(ctx, &channels); err != nil {
+		return nil, err
+	}
+
+	return channels, nil
+}
+
+// Save creates a new derived channel document in the collection
+func (repo *MongoDerivedChannelRepository) Save(ctx context.Context, channel models.DerivedChannelModel) (models.DerivedChannelModel, error) {
+	res, err := repo.collection.InsertOne(ctx, channel)
+	if err != nil {
+		return models.DerivedChannelModel{}, fmt.Errorf("could not insert derived channel: %v", err)
+	}
+
+	channel.Id = res.InsertedID.(primitive.ObjectID)
+	return channel, nil
+}
+
+// UpdateDerivedChannelFromId replaces the name, expression, units and description of the derived channel with id
+func (repo *MongoDerivedChannelRepository) UpdateDerivedChannelFromId(ctx context.Context, id primitive.ObjectID, channel models.DerivedChannelModel) (models.DerivedChannelModel, error) {
+	updatedChannel := models.DerivedChannelModel{}
+	update := bson.M{"$set": bson.M{
+		"name":        channel.Name,
+		"expression":  channel.Expression,
+		"units":       channel.Units,
+		"description": channel.Description,
+	}}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := repo.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&updatedChannel)
+	if err != nil {
+		return updatedChannel, err
+	}
+
+	return updatedChannel, nil
+}
+
+// GetDerivedChannelFromId gets a derived channel document with its id
+func (repo *MongoDerivedChannelRepository) GetDerivedChannelFromId(ctx context.Context, id primitive.ObjectID) (*models.DerivedChannelModel, error) {
+	result := repo.collection.FindOne(ctx, bson.M{"_id": id})
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var channel models.DerivedChannelModel
+	err := result.Decode(&channel)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode result into model: %v", err)
+	}
+
+	return &channel, nil
+}
+
+// DeleteDerivedChannelFromId deletes the derived channel with id, runs already ingested keep their derived signals until they are reprocessed
+func (repo *MongoDerivedChannelRepository) DeleteDerivedChannelFromId(ctx context.Context, id primitive.ObjectID) error {
+	result, err := repo.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const EventModel string = "run_events"
+
+// EventRepository contains the methods any db implementation needs to implement to interact with the events found in the runs
+type EventRepository interface {
+	SaveMany(ctx context.Context, events []models.EventModel) error
+	GetEvents(ctx context.Context, filters *bson.M) ([]models.EventModel, error)
+	DeleteEventsFromRunId(ctx context.Context, runId primitive.ObjectID) error
+}
+
+// MongoEventRepository contains all the information needed to interact with a MongoDB implementation of the run events
+type MongoEventRepository struct {
+	dbClient   *mongo.Client
+	db         *mongo.Database
+	collection *mongo.Collection
+}
+
+// NewMongoEventRepository creates a new MongoEventRepository with a MongoDB client and database
+func NewMongoEventRepository(dbClient *mongo.Client, database *mongo.Database) (*MongoEventRepository, error) {
+	collection := database.Collection(EventModel)
+	if collection == nil {
+		return nil, fmt.Errorf("could not get collection %s", EventModel)
+	}
+
+	return &MongoEventRepository{
+		dbClient:   dbClient,
+		db:         database,
+		collection: collection,
+	}, nil
+}
+
+// SaveMany inserts the events in the collection
+func (repo *MongoEventRepository) SaveMany(ctx context.Context, events []models.EventModel) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(events))
+	for i, event := range events {
+		documents[i] = event
+	}
+
+	_, err := repo.collection.InsertMany(ctx, documents)
+	if err != nil {
+		return fmt.Errorf("could not insert %d events: %v", len(events), err)
+	}
+	return nil
+}
+
+// GetEvents returns the events matching filters sorted by run and time
+func (repo *MongoEventRepository) GetEvents(ctx context.Context, filters *bson.M) ([]models.EventModel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "run_id", Value: 1}, {Key: "time", Value: 1}})
+	cursor, err := repo.collection.Find(ctx, filters, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]models.EventModel, 0)
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// DeleteEventsFromRunId deletes every event of the run with runId
+func (repo *MongoEventRepository) DeleteEventsFromRunId(ctx context.Context, runId primitive.ObjectID) error {
+	_, err := repo.collection.DeleteMany(ctx, bson.M{"run_id": runId})
+	return err
+}
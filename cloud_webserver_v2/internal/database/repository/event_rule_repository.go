@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const EventRuleModel string = "event_rules"
+
+// EventRuleRepository contains the methods any db implementation needs to implement to interact with the event rules
+type EventRuleRepository interface {
+	GetAllEventRules(ctx context.Context) ([]models.EventRuleModel, error)
+	Save(ctx context.Context, rule models.EventRuleModel) (models.EventRuleModel, error)
+	UpdateEventRuleFromId(ctx context.Context, id primitive.ObjectID, rule models.EventRuleModel) (models.EventRuleModel, error)
+	GetEventRuleFromId(ctx context.Context, id primitive.ObjectID) (*models.EventRuleModel, error)
+	DeleteEventRuleFromId(ctx context.Context, id primitive.ObjectID) error
+}
+
+// MongoEventRuleRepository contains all the information needed to interact with a MongoDB implementation of the event rules
+type MongoEventRuleRepository struct {
+	dbClient   *mongo.Client
+	db         *mongo.Database
+	collection *mongo.Collection
+}
+
+// NewMongoEventRuleRepository creates a new MongoEventRuleRepository with a MongoDB client and database
+func NewMongoEventRuleRepository(dbClient *mongo.Client, database *mongo.Database) (*MongoEventRuleRepository, error) {
+	collection := database.Collection(EventRuleModel)
+	if collection == nil {
+		return nil, fmt.Errorf("could not get collection %s", EventRuleModel)
+	}
+
+	return &MongoEventRuleRepository{
+		dbClient:   dbClient,
+		db:         database,
+		collection: collection,
+	}, nil
+}
+
+// GetAllEventRules returns every event rule sorted by name
+func (repo *MongoEventRuleRepository) GetAllEventRules(ctx context.Context) ([]models.EventRuleModel, error) {
+	cursor, err := repo.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]models.EventRuleModel, 0)
+	if err = cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Save creates a new event rule document in the collection
+func (repo *MongoEventRuleRepository) Save(ctx context.Context, rule models.EventRuleModel) (models.EventRuleModel, error) {
+	res, err := repo.collection.InsertOne(ctx, rule)
+	if err != nil {
+		return models.EventRuleModel{}, fmt.Errorf("could not insert event rule: %v", err)
+	}
+
+	rule.Id = res.InsertedID.(primitive.ObjectID)
+	return rule, nil
+}
+
+// UpdateEventRuleFromId replaces the name, signal, condition, threshold and description of the event rule with id
+func (repo *MongoEventRuleRepository) UpdateEventRuleFromId(ctx context.Context, id primitive.ObjectID, rule models.EventRuleModel) (models.EventRuleModel, error) {
+	updatedRule := models.EventRuleModel{}
+	update := bson.M{"$set": bson.M{
+		"name":        rule.Name,
+		"signal":      rule.Signal,
+		"condition":   rule.Condition,
+		"threshold":   rule.Threshold,
+		"description": rule.Description,
+	}}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := repo.collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&updatedRule)
+	if err != nil {
+		return updatedRule, err
+	}
+
+	return updatedRule, nil
+}
+
+// GetEventRuleFromId gets an event rule document with its id
+func (repo *MongoEventRuleRepository) GetEventRuleFromId(ctx context.Context, id primitive.ObjectID) (*models.EventRuleModel, error) {
+	result := repo.collection.FindOne(ctx, bson.M{"_id": id})
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var rule models.EventRuleModel
+	err := result.Decode(&rule)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode result into model: %v", err)
+	}
+
+	return &rule, nil
+}
+
+// DeleteEventRuleFromId deletes the event rule with id, runs already ingested keep their events
+func (repo *MongoEventRuleRepository) DeleteEventRuleFromId(ctx context.Context, id primitive.ObjectID) error {
+	result, err := repo.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
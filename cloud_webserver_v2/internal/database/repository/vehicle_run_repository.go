@@ -2,28 +2,62 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 
 	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const VehicleRunCollection string = "vehicle_run"
 
+// VehicleRunTextIndex is the text index the search text of the run filters is matched against.
+// Matches in the tags rank the highest, then in the file names, the location and event type, and last in the notes.
+const VehicleRunTextIndex string = "vehicle_run_text"
+
+var vehicleRunTextIndexWeights = bson.D{
+	{Key: "tags", Value: 10},
+	{Key: "mcap_files.file_name", Value: 5},
+	{Key: "mat_files.file_name", Value: 5},
+	{Key: "content_files.misc_files.file_name", Value: 5},
+	{Key: "location", Value: 3},
+	{Key: "event_type", Value: 3},
+	{Key: "notes", Value: 1},
+}
+
+// Error codes of MongoDB when an index with the same name or keys exists with other options, like other weights
+const (
+	indexOptionsConflictCode  = 85
+	indexKeySpecsConflictCode = 86
+)
+
 type VehicleRunRepository interface {
 	Save(ctx context.Context, vehicleRun *models.VehicleRunModel) (*models.VehicleRunModel, error)
 	GetWithVehicleFilters(ctx context.Context, filters *bson.M) ([]models.VehicleRunModel, error)
+	GetPageWithVehicleFilters(ctx context.Context, filters *bson.M, page *models.VehicleRunPageModel) ([]models.VehicleRunModel, int64, error)
+	GetTrendsWithVehicleFilters(ctx context.Context, filters *bson.M, query *models.TrendQueryModel) ([]models.TrendBucketModel, error)
 	GetVehicleRunFromId(ctx context.Context, id primitive.ObjectID) (*models.VehicleRunModel, error)
 	DeleteVehicleRunFromId(ctx context.Context, id primitive.ObjectID) error
 	UpdateVehicleRunFromId(ctx context.Context, id primitive.ObjectID, vehicleRun *models.VehicleRunModel) error
+	AddVehicleRunTags(ctx context.Context, id primitive.ObjectID, tags []string) (*models.VehicleRunModel, error)
+	RemoveVehicleRunTag(ctx context.Context, id primitive.ObjectID, tag string) (*models.VehicleRunModel, error)
+	GetSignalSummaries(ctx context.Context, filters *bson.M) ([]models.SignalSummaryModel, error)
+	GetUsageTotals(ctx context.Context, filters *bson.M) (models.UsageTotalsModel, error)
+	HasTextIndex() bool
 }
 
 type MongoVehicleRunRepository struct {
 	dbClient   *mongo.Client
 	db         *mongo.Database
 	collection *mongo.Collection
+
+	// hasTextIndex is false if VehicleRunTextIndex could not be created, the runs can't be searched with $text then
+	hasTextIndex bool
 }
 
 func NewMongoVehicleRunRepository(dbClient *mongo.Client, database *mongo.Database) (*MongoVehicleRunRepository, error) {
@@ -32,13 +66,51 @@ func NewMongoVehicleRunRepository(dbClient *mongo.Client, database *mongo.Databa
 		return nil, fmt.Errorf("could not get collection %s", VehicleRunCollection)
 	}
 
+	err := createTextIndex(context.Background(), collection)
+	if err != nil {
+		log.Printf("could not create text index %s, the runs are searched with regular expressions: %v", VehicleRunTextIndex, err)
+	}
+
 	return &MongoVehicleRunRepository{
-		dbClient:   dbClient,
-		db:         database,
-		collection: collection,
+		dbClient:     dbClient,
+		db:           database,
+		collection:   collection,
+		hasTextIndex: err == nil,
 	}, nil
 }
 
+// createTextIndex creates VehicleRunTextIndex on collection. A collection only has one text index, so if
+// VehicleRunTextIndex exists with other fields or weights it is dropped and created again with vehicleRunTextIndexWeights.
+func createTextIndex(ctx context.Context, collection *mongo.Collection) error {
+	textIndexKeys := bson.D{}
+	for _, weight := range vehicleRunTextIndexWeights {
+		textIndexKeys = append(textIndexKeys, bson.E{Key: weight.Key, Value: "text"})
+	}
+	index := mongo.IndexModel{
+		Keys:    textIndexKeys,
+		Options: options.Index().SetName(VehicleRunTextIndex).SetWeights(vehicleRunTextIndexWeights),
+	}
+
+	_, err := collection.Indexes().CreateOne(ctx, index)
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) || !(serverErr.HasErrorCode(indexOptionsConflictCode) || serverErr.HasErrorCode(indexKeySpecsConflictCode)) {
+		return err
+	}
+
+	log.Printf("text index %s changed, creating it again", VehicleRunTextIndex)
+	_, err = collection.Indexes().DropOne(ctx, VehicleRunTextIndex)
+	if err != nil {
+		return fmt.Errorf("could not drop the previous index: %v", err)
+	}
+	_, err = collection.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+// HasTextIndex returns true if the runs can be searched with $text on VehicleRunTextIndex
+func (repo *MongoVehicleRunRepository) HasTextIndex() bool {
+	return repo.hasTextIndex
+}
+
 // Inserts a VehicleRunModel into the MongoDB database
 func (repo *MongoVehicleRunRepository) Save(ctx context.Context, vehicleRun *models.VehicleRunModel) (*models.VehicleRunModel, error) {
 	res, err := repo.collection.InsertOne(ctx, vehicleRun)
@@ -70,6 +142,138 @@ func (repo *MongoVehicleRunRepository) GetWithVehicleFilters(ctx context.Context
 	return modelResults, nil
 }
 
+// Get a page of the VehicleRunModels from the MongoDB database with filters, along with the number of VehicleRunModels matching the filters
+func (repo *MongoVehicleRunRepository) GetPageWithVehicleFilters(ctx context.Context, filters *bson.M, page *models.VehicleRunPageModel) ([]models.VehicleRunModel, int64, error) {
+	total, err := repo.collection.CountDocuments(ctx, filters)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not count vehicle run data with filters %v, received error: %v", filters, err)
+	}
+
+	order := 1
+	if page.SortDescending {
+		order = -1
+	}
+	sort := bson.D{{Key: page.SortField, Value: order}, {Key: "_id", Value: order}}
+	projection := bson.M{}
+	// Without the text index the runs were searched with regular expressions, which have no score, and keep the SortField order
+	if page.SortByRelevance && repo.hasTextIndex {
+		// The relevance of a run is the score of its match on the text index, which needs to be projected to be sorted on
+		textScore := bson.M{"$meta": "textScore"}
+		sort = bson.D{{Key: "search_score", Value: textScore}, {Key: "_id", Value: -1}}
+		projection["search_score"] = textScore
+	}
+	if page.Fields != nil {
+		projection["_id"] = 1
+		for _, field := range page.Fields {
+			projection[field] = 1
+		}
+	}
+
+	findOptions := options.Find().
+		SetSort(sort).
+		SetSkip(int64((page.Page - 1) * page.Limit)).
+		SetLimit(int64(page.Limit))
+	if len(projection) != 0 {
+		findOptions.SetProjection(projection)
+	}
+
+	cursor, err := repo.collection.Find(ctx, filters, findOptions)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not find in vehicle run data with filters %v, received error: %v", filters, err)
+	}
+
+	var modelResults []models.VehicleRunModel
+	if err = cursor.All(ctx, &modelResults); err != nil {
+		return nil, 0, err
+	}
+
+	if modelResults == nil {
+		modelResults = make([]models.VehicleRunModel, 0)
+	}
+
+	return modelResults, total, nil
+}
+
+// Aggregate a metric of the VehicleRunModels matching the filters into buckets grouped by the groups of query, sorted by group.
+// Lap metrics have a value for every lap of the runs and signal metrics the value of query.Signal in the runs that logged it.
+func (repo *MongoVehicleRunRepository) GetTrendsWithVehicleFilters(ctx context.Context, filters *bson.M, query *models.TrendQueryModel) ([]models.TrendBucketModel, error) {
+	field, isSignalMetric := models.TrendSignalMetrics[query.Metric]
+	if !isSignalMetric {
+		field = models.TrendMetrics[query.Metric]
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: filters}}}
+	if isSignalMetric {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$unwind", Value: "$signals"}},
+			bson.D{{Key: "$match", Value: bson.M{"signals.path": query.Signal}}},
+		)
+	} else if strings.HasPrefix(field, "laps.") {
+		pipeline = append(pipeline, bson.D{{Key: "$unwind", Value: "$laps"}})
+	}
+	// Runs ingested before the metric was computed don't have it, they are left out instead of counting as 0
+	pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{field: bson.M{"$type": "number"}}}})
+
+	group := bson.D{}
+	for _, groupBy := range query.GroupBy {
+		switch groupBy {
+		case models.TrendGroupDate:
+			group = append(group, bson.E{Key: "date", Value: bson.M{"$dateToString": bson.M{"date": "$date", "format": trendDateFormats[query.Interval]}}})
+		case models.TrendGroupLocation:
+			group = append(group, bson.E{Key: "location", Value: "$location"})
+		case models.TrendGroupCar:
+			group = append(group, bson.E{Key: "car", Value: "$car_model"})
+		case models.TrendGroupEventType:
+			group = append(group, bson.E{Key: "event_type", Value: "$event_type"})
+		}
+	}
+
+	value := bson.M{"$" + query.Function: "$" + field}
+	if query.Function == models.TrendFunctionCount {
+		value = bson.M{"$sum": 1}
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":          group,
+			"value":        value,
+			"sample_count": bson.M{"$sum": 1},
+			"runs":         bson.M{"$addToSet": "$_id"},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":          0,
+			"group":        "$_id",
+			"value":        1,
+			"sample_count": 1,
+			"run_count":    bson.M{"$size": "$runs"},
+		}}},
+	)
+
+	cursor, err := repo.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("could not aggregate %s in vehicle run data with filters %v, received error: %v", query.Metric, filters, err)
+	}
+
+	var buckets []models.TrendBucketModel
+	if err = cursor.All(ctx, &buckets); err != nil {
+		return nil, err
+	}
+
+	if buckets == nil {
+		buckets = make([]models.TrendBucketModel, 0)
+	}
+
+	return buckets, nil
+}
+
+// trendDateFormats are the $dateToString formats grouping dates by every interval of a trend, weeks are ISO weeks
+var trendDateFormats = map[string]string{
+	models.TrendIntervalDay:   "%Y-%m-%d",
+	models.TrendIntervalWeek:  "%G-W%V",
+	models.TrendIntervalMonth: "%Y-%m",
+}
+
 // Get a VehicleRunModel from the MongoDB database from a VehicleRun ID
 func (repo *MongoVehicleRunRepository) GetVehicleRunFromId(ctx context.Context, id primitive.ObjectID) (*models.VehicleRunModel, error) {
 	filter := bson.M{"_id": id}
@@ -107,3 +311,96 @@ func (repo *MongoVehicleRunRepository) UpdateVehicleRunFromId(ctx context.Contex
 	}
 	return nil
 }
+
+// Adds tags to a VehicleRunModel from a VehicleRun ID, tags it already has are kept once, and returns the updated VehicleRunModel
+func (repo *MongoVehicleRunRepository) AddVehicleRunTags(ctx context.Context, id primitive.ObjectID, tags []string) (*models.VehicleRunModel, error) {
+	update := bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}}
+	return repo.updateVehicleRunTags(ctx, id, update)
+}
+
+// Removes a tag from a VehicleRunModel from a VehicleRun ID and returns the updated VehicleRunModel
+func (repo *MongoVehicleRunRepository) RemoveVehicleRunTag(ctx context.Context, id primitive.ObjectID, tag string) (*models.VehicleRunModel, error) {
+	update := bson.M{"$pull": bson.M{"tags": tag}}
+	return repo.updateVehicleRunTags(ctx, id, update)
+}
+
+func (repo *MongoVehicleRunRepository) updateVehicleRunTags(ctx context.Context, id primitive.ObjectID, update bson.M) (*models.VehicleRunModel, error) {
+	filter := bson.M{"_id": id}
+	result := repo.collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After))
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var model models.VehicleRunModel
+	if err := result.Decode(&model); err != nil {
+		return nil, fmt.Errorf("could not decode result into model: %v", err)
+	}
+
+	return &model, nil
+}
+
+// Get a summary of every signal found in the VehicleRunModels matching the filters
+func (repo *MongoVehicleRunRepository) GetSignalSummaries(ctx context.Context, filters *bson.M) ([]models.SignalSummaryModel, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filters}},
+		{{Key: "$unwind", Value: "$signals"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           "$signals.path",
+			"topic":         bson.M{"$first": "$signals.topic"},
+			"type":          bson.M{"$first": "$signals.type"},
+			"enum_names":    bson.M{"$first": "$signals.enum_names"},
+			"run_count":     bson.M{"$sum": 1},
+			"message_count": bson.M{"$sum": "$signals.message_count"},
+		}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+
+	cursor, err := repo.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("could not aggregate signals in vehicle run data with filters %v, received error: %v", filters, err)
+	}
+
+	var summaryResults []models.SignalSummaryModel
+	if err = cursor.All(ctx, &summaryResults); err != nil {
+		return nil, err
+	}
+
+	if summaryResults == nil {
+		summaryResults = make([]models.SignalSummaryModel, 0)
+	}
+
+	return summaryResults, nil
+}
+
+// Add up the usage of the VehicleRunModels matching the filters, runs ingested before their usage was computed only count as runs
+func (repo *MongoVehicleRunRepository) GetUsageTotals(ctx context.Context, filters *bson.M) (models.UsageTotalsModel, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filters}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           nil,
+			"run_count":     bson.M{"$sum": 1},
+			"distance":      bson.M{"$sum": "$usage.distance"},
+			"motor_on_time": bson.M{"$sum": "$usage.motor_on_time"},
+			"max_speed":     bson.M{"$max": "$usage.max_speed"},
+			"first_run":     bson.M{"$min": "$date"},
+			"last_run":      bson.M{"$max": "$date"},
+		}}},
+	}
+
+	cursor, err := repo.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return models.UsageTotalsModel{}, fmt.Errorf("could not aggregate usage in vehicle run data with filters %v, received error: %v", filters, err)
+	}
+
+	var totals []models.UsageTotalsModel
+	if err = cursor.All(ctx, &totals); err != nil {
+		return models.UsageTotalsModel{}, err
+	}
+
+	// No runs matched
+	if len(totals) == 0 {
+		return models.UsageTotalsModel{}, nil
+	}
+
+	return totals[0], nil
+}
@@ -2,6 +2,7 @@ package usecase
 
 import (
 	"context"
+	"time"
 
 	"github.com/hytech-racing/cloud-webserver-v2/internal/database/repository"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
@@ -17,6 +18,7 @@ func NewCarMetricsUseCase(carMetricsRepo repository.CarMetricsRepository) *CarMe
 	}
 }
 
+// CreateCarMetrics saves a new parameter set for the car of model, versioned after the parameter sets the car already has
 func (uc *CarMetricsUseCase) CreateCarMetrics(ctx context.Context, model models.CarMetricsModel) (models.CarMetricsModel, error) {
 	resModel, err := uc.carMetricsRepo.Save(ctx, model)
 	if err != nil {
@@ -37,3 +39,16 @@ func (uc *CarMetricsUseCase) GetAllCarMetrics(ctx context.Context) ([]models.Car
 func (uc *CarMetricsUseCase) UpdateCarMetrics(ctx context.Context, id string, updates models.CarMetricsModel) (models.CarMetricsModel, error) {
 	return uc.carMetricsRepo.UpdateMetricByID(ctx, id, updates)
 }
+
+func (uc *CarMetricsUseCase) GetCarMetricsByCarModel(ctx context.Context, carModel string) ([]models.CarMetricsModel, error) {
+	return uc.carMetricsRepo.GetCarMetricsFromCarModel(ctx, carModel)
+}
+
+// GetCarMetricsForRun returns the parameter set of carModel which was in effect on the date of a run
+func (uc *CarMetricsUseCase) GetCarMetricsForRun(ctx context.Context, carModel string, date time.Time) (*models.CarMetricsModel, error) {
+	return uc.carMetricsRepo.GetCarMetricsForRun(ctx, carModel, date)
+}
+
+func (uc *CarMetricsUseCase) DeleteCarMetricsById(ctx context.Context, id string) error {
+	return uc.carMetricsRepo.DeleteCarMetricsFromId(ctx, id)
+}
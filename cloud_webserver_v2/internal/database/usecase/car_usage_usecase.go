@@ -0,0 +1,161 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/database/repository"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CarUsageUseCase keeps the usage counters of the cars and of their components.
+// The counters are incremented when a run is ingested, and only counted again from the runs (one aggregation per car
+// and per component) when they can't be kept by increments: the first run of a car, a deleted or moved run,
+// or a component whose dates changed.
+type CarUsageUseCase struct {
+	carUsageRepo   repository.CarUsageRepository
+	vehicleRunRepo repository.VehicleRunRepository
+}
+
+func NewCarUsageUseCase(carUsageRepo repository.CarUsageRepository, vehicleRunRepo repository.VehicleRunRepository) *CarUsageUseCase {
+	return &CarUsageUseCase{
+		carUsageRepo,
+		vehicleRunRepo,
+	}
+}
+
+// GetCarUsage returns the counters and the components of carModel, they are counted from the runs if the car has none yet
+func (uc *CarUsageUseCase) GetCarUsage(ctx context.Context, carModel string) (*models.CarUsageModel, error) {
+	carUsage, err := uc.carUsageRepo.GetCarUsage(ctx, carModel)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return uc.RecountCarUsage(ctx, carModel)
+	}
+	return carUsage, err
+}
+
+// AddRunUsage counts a newly ingested run of carModel dated date in the counters of the car and of its installed components
+func (uc *CarUsageUseCase) AddRunUsage(ctx context.Context, carModel string, date time.Time, usage models.RunUsageModel) error {
+	err := uc.carUsageRepo.AddRunUsage(ctx, carModel, date, usage)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// The first run of the car, it is already saved so the recount includes it
+		_, err = uc.RecountCarUsage(ctx, carModel)
+	}
+	return err
+}
+
+// RecountCarUsage counts the counters of carModel and of all its components again from its runs
+func (uc *CarUsageUseCase) RecountCarUsage(ctx context.Context, carModel string) (*models.CarUsageModel, error) {
+	filters := usageTotalsFilter(carModel, time.Time{}, nil)
+	totals, err := uc.vehicleRunRepo.GetUsageTotals(ctx, &filters)
+	if err != nil {
+		return nil, err
+	}
+	err = uc.carUsageRepo.SetCarUsage(ctx, carModel, totals)
+	if err != nil {
+		return nil, err
+	}
+
+	components, err := uc.carUsageRepo.GetCarComponents(ctx, &bson.M{"car_model": carModel})
+	if err != nil {
+		return nil, err
+	}
+	for i := range components {
+		components[i].Usage, err = uc.recountCarComponentUsage(ctx, components[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &models.CarUsageModel{CarModel: carModel, Usage: totals, Components: components}, nil
+}
+
+// recountCarComponentUsage counts the usage of component again from the runs of its car while it was installed and stores it
+func (uc *CarUsageUseCase) recountCarComponentUsage(ctx context.Context, component models.CarComponentModel) (models.UsageTotalsModel, error) {
+	filters := usageTotalsFilter(component.CarModel, component.InstalledAt, component.RemovedAt)
+	totals, err := uc.vehicleRunRepo.GetUsageTotals(ctx, &filters)
+	if err != nil {
+		return models.UsageTotalsModel{}, err
+	}
+	return totals, uc.carUsageRepo.SetCarComponentUsage(ctx, component.Id, totals)
+}
+
+// CreateCarComponent adds a component to its car, its usage is counted from the runs of the car since it was installed
+func (uc *CarUsageUseCase) CreateCarComponent(ctx context.Context, model models.CarComponentModel) (models.CarComponentModel, error) {
+	// The usage document of the car holds its components
+	_, err := uc.GetCarUsage(ctx, model.CarModel)
+	if err != nil {
+		return models.CarComponentModel{}, err
+	}
+
+	component, err := uc.carUsageRepo.SaveCarComponent(ctx, model)
+	if err != nil {
+		return models.CarComponentModel{}, err
+	}
+
+	component.Usage, err = uc.recountCarComponentUsage(ctx, component)
+	return component, err
+}
+
+// GetCarComponents returns the components of carModel with componentType, empty values match every car or type.
+// If installed is true only the components still on the car are returned.
+func (uc *CarUsageUseCase) GetCarComponents(ctx context.Context, carModel string, componentType string, installed bool) ([]models.CarComponentModel, error) {
+	filters := bson.M{}
+	if carModel != "" {
+		filters["car_model"] = carModel
+	}
+	if componentType != "" {
+		filters["type"] = componentType
+	}
+	if installed {
+		filters["removed_at"] = nil
+	}
+
+	return uc.carUsageRepo.GetCarComponents(ctx, &filters)
+}
+
+func (uc *CarUsageUseCase) GetCarComponentById(ctx context.Context, id primitive.ObjectID) (*models.CarComponentModel, error) {
+	return uc.carUsageRepo.GetCarComponentFromId(ctx, id)
+}
+
+// UpdateCarComponent replaces the component with id, its usage is counted again if it moved to another car or its dates changed
+func (uc *CarUsageUseCase) UpdateCarComponent(ctx context.Context, id primitive.ObjectID, model models.CarComponentModel) (models.CarComponentModel, error) {
+	existing, err := uc.carUsageRepo.GetCarComponentFromId(ctx, id)
+	if err != nil {
+		return models.CarComponentModel{}, err
+	}
+
+	if existing.CarModel != model.CarModel {
+		err = uc.carUsageRepo.DeleteCarComponentFromId(ctx, id)
+		if err != nil {
+			return models.CarComponentModel{}, err
+		}
+		model.Id = id
+		return uc.CreateCarComponent(ctx, model)
+	}
+
+	component, err := uc.carUsageRepo.UpdateCarComponentFromId(ctx, id, model)
+	if err != nil {
+		return models.CarComponentModel{}, err
+	}
+
+	if !sameUsageWindow(*existing, component) {
+		component.Usage, err = uc.recountCarComponentUsage(ctx, component)
+	}
+	return component, err
+}
+
+// sameUsageWindow returns true if the components were installed and removed on the same dates
+func sameUsageWindow(a, b models.CarComponentModel) bool {
+	if !a.InstalledAt.Equal(b.InstalledAt) || (a.RemovedAt == nil) != (b.RemovedAt == nil) {
+		return false
+	}
+	return a.RemovedAt == nil || a.RemovedAt.Equal(*b.RemovedAt)
+}
+
+func (uc *CarUsageUseCase) DeleteCarComponentById(ctx context.Context, id primitive.ObjectID) error {
+	return uc.carUsageRepo.DeleteCarComponentFromId(ctx, id)
+}
@@ -0,0 +1,53 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/database/repository"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DerivedChannelUseCase struct {
+	derivedChannelRepo repository.DerivedChannelRepository
+}
+
+func NewDerivedChannelUseCase(derivedChannelRepo repository.DerivedChannelRepository) *DerivedChannelUseCase {
+	return &DerivedChannelUseCase{
+		derivedChannelRepo,
+	}
+}
+
+func (uc *DerivedChannelUseCase) CreateDerivedChannel(ctx context.Context, model models.DerivedChannelModel) (models.DerivedChannelModel, error) {
+	return uc.derivedChannelRepo.Save(ctx, model)
+}
+
+func (uc *DerivedChannelUseCase) GetAllDerivedChannels(ctx context.Context) ([]models.DerivedChannelModel, error) {
+	return uc.derivedChannelRepo.GetAllDerivedChannels(ctx)
+}
+
+func (uc *DerivedChannelUseCase) GetDerivedChannelById(ctx context.Context, id primitive.ObjectID) (*models.DerivedChannelModel, error) {
+	return uc.derivedChannelRepo.GetDerivedChannelFromId(ctx, id)
+}
+
+func (uc *DerivedChannelUseCase) UpdateDerivedChannel(ctx context.Context, id primitive.ObjectID, model models.DerivedChannelModel) (models.DerivedChannelModel, error) {
+	return uc.derivedChannelRepo.UpdateDerivedChannelFromId(ctx, id, model)
+}
+
+func (uc *DerivedChannelUseCase) DeleteDerivedChannelById(ctx context.Context, id primitive.ObjectID) error {
+	return uc.derivedChannelRepo.DeleteDerivedChannelFromId(ctx, id)
+}
+
+// DerivedChannelNameExists checks if another derived channel than the one with id (which may be nil) already has name
+func (uc *DerivedChannelUseCase) DerivedChannelNameExists(ctx context.Context, name string, id *primitive.ObjectID) (bool, error) {
+	channels, err := uc.derivedChannelRepo.GetAllDerivedChannels(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, channel := range channels {
+		if channel.Name == name && (id == nil || channel.Id != *id) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,53 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/database/repository"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type EventRuleUseCase struct {
+	eventRuleRepo repository.EventRuleRepository
+}
+
+func NewEventRuleUseCase(eventRuleRepo repository.EventRuleRepository) *EventRuleUseCase {
+	return &EventRuleUseCase{
+		eventRuleRepo,
+	}
+}
+
+func (uc *EventRuleUseCase) CreateEventRule(ctx context.Context, model models.EventRuleModel) (models.EventRuleModel, error) {
+	return uc.eventRuleRepo.Save(ctx, model)
+}
+
+func (uc *EventRuleUseCase) GetAllEventRules(ctx context.Context) ([]models.EventRuleModel, error) {
+	return uc.eventRuleRepo.GetAllEventRules(ctx)
+}
+
+func (uc *EventRuleUseCase) GetEventRuleById(ctx context.Context, id primitive.ObjectID) (*models.EventRuleModel, error) {
+	return uc.eventRuleRepo.GetEventRuleFromId(ctx, id)
+}
+
+func (uc *EventRuleUseCase) UpdateEventRule(ctx context.Context, id primitive.ObjectID, model models.EventRuleModel) (models.EventRuleModel, error) {
+	return uc.eventRuleRepo.UpdateEventRuleFromId(ctx, id, model)
+}
+
+func (uc *EventRuleUseCase) DeleteEventRuleById(ctx context.Context, id primitive.ObjectID) error {
+	return uc.eventRuleRepo.DeleteEventRuleFromId(ctx, id)
+}
+
+// EventRuleNameExists checks if another event rule than the one with id (which may be nil) already has name
+func (uc *EventRuleUseCase) EventRuleNameExists(ctx context.Context, name string, id *primitive.ObjectID) (bool, error) {
+	rules, err := uc.eventRuleRepo.GetAllEventRules(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, rule := range rules {
+		if rule.Name == name && (id == nil || rule.Id != *id) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,42 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/database/repository"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type EventUseCase struct {
+	eventRepo repository.EventRepository
+}
+
+func NewEventUseCase(eventRepo repository.EventRepository) *EventUseCase {
+	return &EventUseCase{
+		eventRepo,
+	}
+}
+
+// CreateRunEvents saves the events found in the run with runId
+func (uc *EventUseCase) CreateRunEvents(ctx context.Context, runId primitive.ObjectID, events []models.EventModel) error {
+	for i := range events {
+		events[i].RunId = runId
+	}
+	return uc.eventRepo.SaveMany(ctx, events)
+}
+
+// GetRunEvents returns the events of the run with runId in the order they happened, eventType can be empty to get every type
+func (uc *EventUseCase) GetRunEvents(ctx context.Context, runId primitive.ObjectID, eventType string) ([]models.EventModel, error) {
+	filters := bson.M{"run_id": runId}
+	if eventType != "" {
+		filters["type"] = eventType
+	}
+
+	return uc.eventRepo.GetEvents(ctx, &filters)
+}
+
+func (uc *EventUseCase) DeleteRunEvents(ctx context.Context, runId primitive.ObjectID) error {
+	return uc.eventRepo.DeleteEventsFromRunId(ctx, runId)
+}
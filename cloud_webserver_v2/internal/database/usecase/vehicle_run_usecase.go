@@ -3,6 +3,7 @@ package usecase
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/hytech-racing/cloud-webserver-v2/internal/database/repository"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
@@ -44,8 +45,53 @@ func (uc *VehicleRunUseCase) FindVehicleRunByMCAPFileHash(ctx context.Context, f
 }
 
 func (uc *VehicleRunUseCase) GetVehicleRunByFilters(ctx context.Context, filters *models.VehicleRunModelFilters) ([]models.VehicleRunModel, error) {
+	bson_filters_m, err := uc.vehicleRunFiltersToBson(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute the query
+	result, err := uc.vechicleRunRepo.GetWithVehicleFilters(context.TODO(), &bson_filters_m)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetVehicleRunPageByFilters returns a page of the runs matching filters and the number of runs matching them
+func (uc *VehicleRunUseCase) GetVehicleRunPageByFilters(ctx context.Context, filters *models.VehicleRunModelFilters, page *models.VehicleRunPageModel) ([]models.VehicleRunModel, int64, error) {
+	bson_filters_m, err := uc.vehicleRunFiltersToBson(filters)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return uc.vechicleRunRepo.GetPageWithVehicleFilters(ctx, &bson_filters_m, page)
+}
+
+// GetTrends aggregates a metric of the runs matching filters, see TrendQueryModel
+func (uc *VehicleRunUseCase) GetTrends(ctx context.Context, filters *models.VehicleRunModelFilters, query *models.TrendQueryModel) ([]models.TrendBucketModel, error) {
+	bson_filters_m, err := uc.vehicleRunFiltersToBson(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return uc.vechicleRunRepo.GetTrendsWithVehicleFilters(ctx, &bson_filters_m, query)
+}
+
+// GetSignalCatalog returns every signal found in the runs matching filters along with how many runs contain it
+func (uc *VehicleRunUseCase) GetSignalCatalog(ctx context.Context, filters *models.VehicleRunModelFilters) ([]models.SignalSummaryModel, error) {
+	bson_filters_m, err := uc.vehicleRunFiltersToBson(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return uc.vechicleRunRepo.GetSignalSummaries(ctx, &bson_filters_m)
+}
+
+// vehicleRunFiltersToBson converts VehicleRunModelFilters into the MongoDB query used to find the runs
+func (uc *VehicleRunUseCase) vehicleRunFiltersToBson(filters *models.VehicleRunModelFilters) (bson.M, error) {
 	bson_filters_m := bson.M{}
-	bson_or := bson.A{}
 
 	if filters.ID != nil {
 		id, err := primitive.ObjectIDFromHex(filters.ID.Hex())
@@ -72,27 +118,26 @@ func (uc *VehicleRunUseCase) GetVehicleRunByFilters(ctx context.Context, filters
 		}
 	}
 
-	if filters.SearchText != nil {
-		bson_or = append(bson_or, bson.M{
-			"mcap_files.file_name": bson.M{
-				"$regex": primitive.Regex{Pattern: *filters.SearchText, Options: "i"},
-			},
-		})
-		bson_or = append(bson_or, bson.M{
-			"mat_files.file_name": bson.M{
-				"$regex": primitive.Regex{Pattern: *filters.SearchText, Options: "i"},
-			},
-		})
-		bson_or = append(bson_or, bson.M{
-			"content_files.file_name": bson.M{
-				"$regex": primitive.Regex{Pattern: *filters.SearchText, Options: "i"},
-			},
-		})
-		bson_or = append(bson_or, bson.M{
-			"notes": bson.M{
-				"$regex": primitive.Regex{Pattern: *filters.SearchText, Options: "i"},
-			},
+	// Searches the text index of the runs, see VehicleRunTextIndex, or the file names and notes of the runs
+	// with a regular expression if the index could not be created
+	if filters.SearchText != nil && uc.vechicleRunRepo.HasTextIndex() {
+		bson_filters_m["$text"] = bson.M{"$search": *filters.SearchText}
+	} else if filters.SearchText != nil {
+		searchFilters := fileNameFilters(*filters.SearchText)
+		searchFilters = append(searchFilters, bson.M{
+			"notes": bson.M{"$regex": primitive.Regex{Pattern: *filters.SearchText, Options: "i"}},
 		})
+		bson_filters_m["$or"] = searchFilters
+	}
+
+	// Matches part of a file name, like a date, which the words of the text index don't
+	if filters.FileName != nil {
+		bson_filters_m["$and"] = bson.A{bson.M{"$or": fileNameFilters(*filters.FileName)}}
+	}
+
+	// Filters if the run has every one of our wanted tags
+	if len(filters.Tags) != 0 {
+		bson_filters_m["tags"] = bson.M{"$all": filters.Tags}
 	}
 
 	if filters.Location != nil {
@@ -108,17 +153,56 @@ func (uc *VehicleRunUseCase) GetVehicleRunByFilters(ctx context.Context, filters
 		bson_filters_m["mps_record."+*filters.MpsFunction] = bson.M{"$exists": true}
 	}
 
-	if len(bson_or) != 0 {
-		bson_filters_m["$or"] = bson_or
+	// Filters if the run contains our wanted signal
+	if filters.HasSignal != nil {
+		bson_filters_m["signals.path"] = *filters.HasSignal
 	}
 
-	// Execute the query
-	result, err := uc.vechicleRunRepo.GetWithVehicleFilters(context.TODO(), &bson_filters_m)
-	if err != nil {
-		return nil, err
+	// Filters if the run contains an event of our wanted type, the events themselves are in their own collection
+	if filters.HasEvent != nil {
+		bson_filters_m["event_counts."+*filters.HasEvent] = bson.M{"$exists": true}
 	}
 
-	return result, nil
+	if filters.MinQualityScore != nil || filters.MaxQualityScore != nil {
+		qualityFilter := bson.M{}
+		if filters.MinQualityScore != nil {
+			qualityFilter["$gte"] = *filters.MinQualityScore
+		}
+		if filters.MaxQualityScore != nil {
+			qualityFilter["$lte"] = *filters.MaxQualityScore
+		}
+		bson_filters_m["quality_report.score"] = qualityFilter
+	}
+
+	return bson_filters_m, nil
+}
+
+// fileNameFilters matches the runs with a file name matching pattern, ignoring case
+func fileNameFilters(pattern string) bson.A {
+	regex := bson.M{"$regex": primitive.Regex{Pattern: pattern, Options: "i"}}
+	return bson.A{
+		bson.M{"mcap_files.file_name": regex},
+		bson.M{"mat_files.file_name": regex},
+		bson.M{"content_files.misc_files.file_name": regex},
+	}
+}
+
+// GetUsageTotals adds up the usage of the runs of carModel dated from from until to, to can be nil to count every run after from
+func (uc *VehicleRunUseCase) GetUsageTotals(ctx context.Context, carModel string, from time.Time, to *time.Time) (models.UsageTotalsModel, error) {
+	filters := usageTotalsFilter(carModel, from, to)
+	return uc.vechicleRunRepo.GetUsageTotals(ctx, &filters)
+}
+
+// usageTotalsFilter matches the runs of carModel dated from from until to, to can be nil to match every run after from
+func usageTotalsFilter(carModel string, from time.Time, to *time.Time) bson.M {
+	dateFilter := bson.M{"$gte": from}
+	if to != nil {
+		dateFilter["$lt"] = *to
+	}
+	return bson.M{
+		"car_model": carModel,
+		"date":      dateFilter,
+	}
 }
 
 func (uc *VehicleRunUseCase) GetVehicleRunById(ctx context.Context, id primitive.ObjectID) (*models.VehicleRunModel, error) {
@@ -133,6 +217,16 @@ func (uc *VehicleRunUseCase) UpdateVehicleRun(ctx context.Context, id primitive.
 	return uc.vechicleRunRepo.UpdateVehicleRunFromId(ctx, id, model)
 }
 
+// AddTags adds tags to a vehicle run and returns the updated run
+func (uc *VehicleRunUseCase) AddTags(ctx context.Context, id primitive.ObjectID, tags []string) (*models.VehicleRunModel, error) {
+	return uc.vechicleRunRepo.AddVehicleRunTags(ctx, id, tags)
+}
+
+// RemoveTag removes a tag from a vehicle run and returns the updated run
+func (uc *VehicleRunUseCase) RemoveTag(ctx context.Context, id primitive.ObjectID, tag string) (*models.VehicleRunModel, error) {
+	return uc.vechicleRunRepo.RemoveVehicleRunTag(ctx, id, tag)
+}
+
 func (uc *VehicleRunUseCase) AddMiscFile(ctx context.Context, vehicleRunID primitive.ObjectID, awsBucket string, fileName string, filePath string) (*models.VehicleRunModel, error) {
 	vehicleRun, err := uc.vechicleRunRepo.GetVehicleRunFromId(ctx, vehicleRunID)
 	if err != nil {
@@ -154,6 +248,28 @@ func (uc *VehicleRunUseCase) AddMiscFile(ctx context.Context, vehicleRunID primi
 	return vehicleRun, nil
 }
 
+// AddExportFile adds a signal export saved on S3 to the exports content files of a vehicle run
+func (uc *VehicleRunUseCase) AddExportFile(ctx context.Context, vehicleRunID primitive.ObjectID, awsBucket string, fileName string, filePath string) (*models.VehicleRunModel, error) {
+	vehicleRun, err := uc.vechicleRunRepo.GetVehicleRunFromId(ctx, vehicleRunID)
+	if err != nil {
+		return nil, err
+	}
+	exportFile := models.FileModel{
+		AwsBucket: awsBucket,
+		FilePath:  filePath,
+		FileName:  fileName,
+	}
+	if vehicleRun.ContentFiles == nil {
+		vehicleRun.ContentFiles = make(map[string][]models.FileModel)
+	}
+	vehicleRun.ContentFiles["exports"] = append(vehicleRun.ContentFiles["exports"], exportFile)
+	err = uc.vechicleRunRepo.UpdateVehicleRunFromId(ctx, vehicleRunID, vehicleRun)
+	if err != nil {
+		return nil, err
+	}
+	return vehicleRun, nil
+}
+
 func (uc *VehicleRunUseCase) FileNameExists(ctx context.Context, vehicleRunID primitive.ObjectID, fileName string) (bool, error) {
 	vehicleRun, err := uc.vechicleRunRepo.GetVehicleRunFromId(ctx, vehicleRunID)
 	if err != nil {
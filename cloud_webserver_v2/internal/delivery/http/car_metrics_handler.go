@@ -1,12 +1,18 @@
 package http
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/database"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/s3"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type carMetricsHandler struct {
@@ -26,12 +32,33 @@ func NewCarMetricsHandler(
 
 	r.Route("/car_metrics", func(r chi.Router) {
 		r.Get("/", HandlerFunc(handler.GetAllCarMetrics).ServeHTTP)
+		r.Post("/", HandlerFunc(handler.CreateCarMetrics).ServeHTTP)
+		r.Get("/lookup", HandlerFunc(handler.LookupCarMetrics).ServeHTTP)
+		r.Get("/usage", HandlerFunc(handler.GetCarUsage).ServeHTTP)
+		r.Route("/components", func(r chi.Router) {
+			r.Get("/", HandlerFunc(handler.GetCarComponents).ServeHTTP)
+			r.Post("/", HandlerFunc(handler.CreateCarComponent).ServeHTTP)
+			r.Get("/{id}", HandlerFunc(handler.GetCarComponentFromID).ServeHTTP)
+			r.Post("/{id}", HandlerFunc(handler.UpdateCarComponentFromID).ServeHTTP)
+			r.Delete("/{id}", HandlerFunc(handler.DeleteCarComponentFromID).ServeHTTP)
+		})
+		r.Get("/{id}", HandlerFunc(handler.GetCarMetricsFromID).ServeHTTP)
+		r.Post("/{id}", HandlerFunc(handler.UpdateCarMetricsFromID).ServeHTTP)
+		r.Delete("/{id}", HandlerFunc(handler.DeleteCarMetricsFromID).ServeHTTP)
 	})
 }
 
+// GetAllCarMetrics responds with every parameter set, or only the ones of a car with the car_model query param
 func (h *carMetricsHandler) GetAllCarMetrics(w http.ResponseWriter, r *http.Request) *HandlerError {
 	ctx := r.Context()
-	resModels, err := h.dbClient.CarMetricsUseCase().GetAllCarMetrics(ctx)
+
+	var resModels []models.CarMetricsModel
+	var err error
+	if carModel := r.URL.Query().Get("car_model"); carModel != "" {
+		resModels, err = h.dbClient.CarMetricsUseCase().GetCarMetricsByCarModel(ctx, carModel)
+	} else {
+		resModels, err = h.dbClient.CarMetricsUseCase().GetAllCarMetrics(ctx)
+	}
 	if err != nil {
 		return NewHandlerError(err.Error(), http.StatusInternalServerError)
 	}
@@ -42,3 +69,421 @@ func (h *carMetricsHandler) GetAllCarMetrics(w http.ResponseWriter, r *http.Requ
 	render.JSON(w, r, data)
 	return nil
 }
+
+// CreateCarMetrics adds a new parameter set for a car, runs of the car dated after effective_from use it.
+// Form fields:
+//   - car_model, effective_from (RFC3339), gearbox_ratio, tire_radius (m)
+//   - mass (kg), wheelbase (m), track_width (m), battery_capacity (kWh), notes (optional)
+func (h *carMetricsHandler) CreateCarMetrics(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+
+	metrics, handlerErr := parseCarMetricsForm(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	if metrics.CarModel == "" || metrics.EffectiveFrom.IsZero() || metrics.GearboxRatio == 0 || metrics.TireRadius == 0 {
+		return NewHandlerError("invalid request, must pass in car_model, effective_from, gearbox_ratio and tire_radius", http.StatusBadRequest)
+	}
+
+	resModel, err := h.dbClient.CarMetricsUseCase().CreateCarMetrics(ctx, *metrics)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	data := make(map[string]interface{})
+	data["data"] = resModel
+	data["message"] = fmt.Sprintf("created version %d of the %s car metrics", resModel.Version, resModel.CarModel)
+	render.JSON(w, r, data)
+	return nil
+}
+
+// LookupCarMetrics responds with the parameter set ingest uses for a run of car_model on date (RFC3339)
+func (h *carMetricsHandler) LookupCarMetrics(w http.ResponseWriter, r *http.Request) *HandlerError {
+	carModel := r.URL.Query().Get("car_model")
+	dateStr := r.URL.Query().Get("date")
+	if carModel == "" || dateStr == "" {
+		return NewHandlerError("invalid request, must pass in car_model and date", http.StatusBadRequest)
+	}
+
+	date, err := time.Parse(time.RFC3339, dateStr)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not parse date %v, %v", dateStr, err), http.StatusBadRequest)
+	}
+
+	resModel, err := h.dbClient.CarMetricsUseCase().GetCarMetricsForRun(r.Context(), carModel, date)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no %s car metrics in effect on %v", carModel, dateStr), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	data := make(map[string]interface{})
+	data["data"] = resModel
+	data["message"] = fmt.Sprintf("found version %d of the %s car metrics", resModel.Version, resModel.CarModel)
+	render.JSON(w, r, data)
+	return nil
+}
+
+// GetCarMetricsFromID takes in an ID from a URL param and responds with the parameter set
+func (h *carMetricsHandler) GetCarMetricsFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	id, handlerErr := carMetricsID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	resModel, err := h.dbClient.CarMetricsUseCase().GetCarMetricsById(r.Context(), id)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no car metrics with id %v found", id), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	data := make(map[string]interface{})
+	data["data"] = resModel
+	data["message"] = fmt.Sprintf("found car metrics %s", id)
+	render.JSON(w, r, data)
+	return nil
+}
+
+// UpdateCarMetricsFromID takes in an ID from a URL param and replaces the parameters passed as form fields of CreateCarMetrics,
+// the other parameters are kept. Runs which were already ingested keep the values computed with the previous parameters.
+// The car_model of a parameter set can't change since its version counts the parameter sets of its car,
+// the parameters of another car are created with CreateCarMetrics.
+func (h *carMetricsHandler) UpdateCarMetricsFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	id, handlerErr := carMetricsID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	updates, handlerErr := parseCarMetricsForm(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	if updates.CarModel != "" {
+		existing, err := h.dbClient.CarMetricsUseCase().GetCarMetricsById(r.Context(), id)
+		if err != nil {
+			if err.Error() == "mongo: no documents in result" {
+				return NewHandlerError(fmt.Sprintf("no car metrics with id %v found", id), http.StatusNotFound)
+			}
+			return NewHandlerError(err.Error(), http.StatusInternalServerError)
+		}
+		if updates.CarModel != existing.CarModel {
+			return NewHandlerError(fmt.Sprintf("car metrics %v belong to %v, car_model can't be changed", id, existing.CarModel), http.StatusBadRequest)
+		}
+	}
+
+	resModel, err := h.dbClient.CarMetricsUseCase().UpdateCarMetrics(r.Context(), id, *updates)
+	if err != nil {
+		if strings.Contains(err.Error(), "mongo: no documents in result") {
+			return NewHandlerError(fmt.Sprintf("no car metrics with id %v found", id), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	data := make(map[string]interface{})
+	data["data"] = resModel
+	data["message"] = fmt.Sprintf("updated car metrics %s", id)
+	render.JSON(w, r, data)
+	return nil
+}
+
+// DeleteCarMetricsFromID takes in an ID from a URL param and deletes the parameter set
+func (h *carMetricsHandler) DeleteCarMetricsFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	id, handlerErr := carMetricsID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	err := h.dbClient.CarMetricsUseCase().DeleteCarMetricsById(r.Context(), id)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no car metrics with id %v found", id), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	data := make(map[string]interface{})
+	data["message"] = fmt.Sprintf("deleted car metrics %s", id)
+	render.JSON(w, r, data)
+	return nil
+}
+
+func carMetricsID(r *http.Request) (string, *HandlerError) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return "", NewHandlerError("invalid request, must pass in car metrics id", http.StatusBadRequest)
+	}
+
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return "", NewHandlerError(fmt.Sprintf("could not decode car metrics id %v, %v", id, err), http.StatusBadRequest)
+	}
+	return id, nil
+}
+
+// parseCarMetricsForm reads the parameters passed as form fields, the ones which are not passed are left empty
+func parseCarMetricsForm(r *http.Request) (*models.CarMetricsModel, *HandlerError) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		return nil, NewHandlerError("error parsing form data", http.StatusBadRequest)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	metrics := &models.CarMetricsModel{
+		CarModel: r.FormValue("car_model"),
+		Notes:    r.FormValue("notes"),
+	}
+
+	if effectiveFrom := r.FormValue("effective_from"); effectiveFrom != "" {
+		date, err := time.Parse(time.RFC3339, effectiveFrom)
+		if err != nil {
+			return nil, NewHandlerError(fmt.Sprintf("could not parse effective_from %v, %v", effectiveFrom, err), http.StatusBadRequest)
+		}
+		metrics.EffectiveFrom = date
+	}
+
+	parameters := map[string]*float64{
+		"gearbox_ratio":    &metrics.GearboxRatio,
+		"tire_radius":      &metrics.TireRadius,
+		"mass":             &metrics.Mass,
+		"wheelbase":        &metrics.Wheelbase,
+		"track_width":      &metrics.TrackWidth,
+		"battery_capacity": &metrics.BatteryCapacity,
+	}
+	for name, parameter := range parameters {
+		valueStr := r.FormValue(name)
+		if valueStr == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(valueStr, 64)
+		if err != nil || value <= 0 {
+			return nil, NewHandlerError(fmt.Sprintf("invalid %s %v, it must be a positive number", name, valueStr), http.StatusBadRequest)
+		}
+		*parameter = value
+	}
+
+	return metrics, nil
+}
+
+// GetCarUsage responds with the odometer of a car, the usage of its runs added up.
+// Query params: car_model, and optionally from and to (RFC3339) to only count the runs in between.
+// Without from and to the counters kept in the car_usage collection are read, with them the runs in between are added up.
+func (h *carMetricsHandler) GetCarUsage(w http.ResponseWriter, r *http.Request) *HandlerError {
+	queryParams := r.URL.Query()
+	carModel := queryParams.Get("car_model")
+	if carModel == "" {
+		return NewHandlerError("invalid request, must pass in car_model", http.StatusBadRequest)
+	}
+
+	if !queryParams.Has("from") && !queryParams.Has("to") {
+		carUsage, err := h.dbClient.CarUsageUseCase().GetCarUsage(r.Context(), carModel)
+		if err != nil {
+			return NewHandlerError(err.Error(), http.StatusInternalServerError)
+		}
+		return renderCarUsage(w, r, carModel, carUsage.Usage)
+	}
+
+	var from time.Time
+	if queryParams.Has("from") {
+		var err error
+		from, err = time.Parse(time.RFC3339, queryParams.Get("from"))
+		if err != nil {
+			return NewHandlerError(fmt.Sprintf("could not parse from %v, %v", queryParams.Get("from"), err), http.StatusBadRequest)
+		}
+	}
+
+	var to *time.Time
+	if queryParams.Has("to") {
+		parsed, err := time.Parse(time.RFC3339, queryParams.Get("to"))
+		if err != nil {
+			return NewHandlerError(fmt.Sprintf("could not parse to %v, %v", queryParams.Get("to"), err), http.StatusBadRequest)
+		}
+		to = &parsed
+	}
+
+	totals, err := h.dbClient.VehicleRunUseCase().GetUsageTotals(r.Context(), carModel, from, to)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+	return renderCarUsage(w, r, carModel, totals)
+}
+
+func renderCarUsage(w http.ResponseWriter, r *http.Request, carModel string, totals models.UsageTotalsModel) *HandlerError {
+	data := make(map[string]interface{})
+	data["data"] = totals
+	data["message"] = fmt.Sprintf("%s drove %.1f km in %d runs", carModel, totals.Distance/1000, totals.RunCount)
+	render.JSON(w, r, data)
+	return nil
+}
+
+// GetCarComponents responds with the components of the cars along with their usage counters.
+// Query params (optional): car_model, type, and installed=true to only get the components still on the car.
+func (h *carMetricsHandler) GetCarComponents(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+	queryParams := r.URL.Query()
+
+	installed := false
+	if queryParams.Has("installed") {
+		var err error
+		installed, err = strconv.ParseBool(queryParams.Get("installed"))
+		if err != nil {
+			return NewHandlerError("installed must be true or false", http.StatusBadRequest)
+		}
+	}
+
+	components, err := h.dbClient.CarUsageUseCase().GetCarComponents(ctx, queryParams.Get("car_model"), queryParams.Get("type"), installed)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	data := make(map[string]interface{})
+	data["data"] = components
+	data["message"] = fmt.Sprintf("found %d components", len(components))
+	render.JSON(w, r, data)
+	return nil
+}
+
+// CreateCarComponent adds a component to a car, the runs of the car while it is installed count towards its usage.
+// Form fields:
+//   - car_model, type (e.g. tire_set), name, installed_at (RFC3339)
+//   - removed_at (RFC3339), notes (optional)
+func (h *carMetricsHandler) CreateCarComponent(w http.ResponseWriter, r *http.Request) *HandlerError {
+	component, handlerErr := parseCarComponentForm(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	createdComponent, err := h.dbClient.CarUsageUseCase().CreateCarComponent(r.Context(), *component)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	data := make(map[string]interface{})
+	data["data"] = createdComponent
+	data["message"] = fmt.Sprintf("created %s %s", createdComponent.Type, createdComponent.Name)
+	render.JSON(w, r, data)
+	return nil
+}
+
+// GetCarComponentFromID takes in an ID from a URL param and responds with the component and its usage
+func (h *carMetricsHandler) GetCarComponentFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	objectId, handlerErr := carComponentID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	component, err := h.dbClient.CarUsageUseCase().GetCarComponentById(r.Context(), objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no component with id %v found", objectId.Hex()), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	data := make(map[string]interface{})
+	data["data"] = component
+	data["message"] = fmt.Sprintf("found %s %s", component.Type, component.Name)
+	render.JSON(w, r, data)
+	return nil
+}
+
+// UpdateCarComponentFromID takes in an ID from a URL param and replaces the component with the form fields of CreateCarComponent,
+// a component is removed from the car by setting its removed_at
+func (h *carMetricsHandler) UpdateCarComponentFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	objectId, handlerErr := carComponentID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	component, handlerErr := parseCarComponentForm(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	updatedComponent, err := h.dbClient.CarUsageUseCase().UpdateCarComponent(r.Context(), objectId, *component)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no component with id %v found", objectId.Hex()), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	data := make(map[string]interface{})
+	data["data"] = updatedComponent
+	data["message"] = fmt.Sprintf("updated %s %s", updatedComponent.Type, updatedComponent.Name)
+	render.JSON(w, r, data)
+	return nil
+}
+
+// DeleteCarComponentFromID takes in an ID from a URL param and deletes the component
+func (h *carMetricsHandler) DeleteCarComponentFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	objectId, handlerErr := carComponentID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	err := h.dbClient.CarUsageUseCase().DeleteCarComponentById(r.Context(), objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no component with id %v found", objectId.Hex()), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	data := make(map[string]interface{})
+	data["message"] = fmt.Sprintf("deleted component %s", objectId.Hex())
+	render.JSON(w, r, data)
+	return nil
+}
+
+func carComponentID(r *http.Request) (primitive.ObjectID, *HandlerError) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return primitive.NilObjectID, NewHandlerError("invalid request, must pass in component id", http.StatusBadRequest)
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, NewHandlerError(fmt.Sprintf("could not decode component id %v, %v", id, err), http.StatusBadRequest)
+	}
+	return objectId, nil
+}
+
+// parseCarComponentForm reads a component from the form fields of the request
+func parseCarComponentForm(r *http.Request) (*models.CarComponentModel, *HandlerError) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		return nil, NewHandlerError("error parsing form data", http.StatusBadRequest)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	component := &models.CarComponentModel{
+		CarModel: r.FormValue("car_model"),
+		Type:     r.FormValue("type"),
+		Name:     r.FormValue("name"),
+		Notes:    r.FormValue("notes"),
+	}
+	if component.CarModel == "" || component.Type == "" || component.Name == "" || r.FormValue("installed_at") == "" {
+		return nil, NewHandlerError("invalid request, must pass in car_model, type, name and installed_at", http.StatusBadRequest)
+	}
+
+	installedAt, err := time.Parse(time.RFC3339, r.FormValue("installed_at"))
+	if err != nil {
+		return nil, NewHandlerError(fmt.Sprintf("could not parse installed_at %v, %v", r.FormValue("installed_at"), err), http.StatusBadRequest)
+	}
+	component.InstalledAt = installedAt
+
+	if removedAtStr := r.FormValue("removed_at"); removedAtStr != "" {
+		removedAt, err := time.Parse(time.RFC3339, removedAtStr)
+		if err != nil {
+			return nil, NewHandlerError(fmt.Sprintf("could not parse removed_at %v, %v", removedAtStr, err), http.StatusBadRequest)
+		}
+		if !removedAt.After(installedAt) {
+			return nil, NewHandlerError("removed_at must be after installed_at", http.StatusBadRequest)
+		}
+		component.RemovedAt = &removedAt
+	}
+
+	return component, nil
+}
@@ -0,0 +1,257 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/database"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/messaging/subscribers"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/s3"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// MaxCompareRuns is the most runs a comparison can overlay
+	MaxCompareRuns = 6
+
+	// MaxCompareSignals is the most signals a comparison can overlay
+	MaxCompareSignals = 8
+)
+
+// compareHandler handles the comparisons of the signals of several runs, like two setups of the car on the same track
+type compareHandler struct {
+	s3Repository *s3.S3Repository
+	dbClient     *database.DatabaseClient
+}
+
+func NewCompareHandler(
+	r *chi.Mux,
+	s3Repository *s3.S3Repository,
+	dbClient *database.DatabaseClient,
+) {
+	handler := &compareHandler{
+		s3Repository: s3Repository,
+		dbClient:     dbClient,
+	}
+
+	r.Route("/compare", func(r chi.Router) {
+		r.Get("/", HandlerFunc(handler.CompareRuns).ServeHTTP)
+	})
+}
+
+// CompareRuns aligns signals of several runs on a common axis and responds with their overlay, an overlay plot and
+// the statistics of every signal with their deltas to the first run.
+// Query params:
+//   - runs: comma seperated ids of the runs to compare, the first one is the reference of the deltas
+//   - signals: comma seperated signal paths (VehicleData.current_rpms.FL,VNData.vn_gps.lat)
+//   - align: "time" (default), the time since the start of the runs, "distance", the GPS distance driven since the start of the runs,
+//     or "lap", the time since the start of a lap of every run
+//   - laps: comma seperated lap numbers, one for every run, compared with align=lap (optional, the fastest lap of every run by default)
+//   - points: number of points the signals are resampled on (optional)
+//   - plot: "false" leaves out the overlay plot (optional)
+func (h *compareHandler) CompareRuns(w http.ResponseWriter, r *http.Request) *HandlerError {
+	queryParams := r.URL.Query()
+
+	runsParam := queryParams.Get("runs")
+	if runsParam == "" {
+		return NewHandlerError("invalid request, must pass in query param runs with a value of comma seperated run ids", http.StatusBadRequest)
+	}
+	runIds := strings.Split(runsParam, ",")
+	if len(runIds) < 2 || len(runIds) > MaxCompareRuns {
+		return NewHandlerError(fmt.Sprintf("invalid request, must compare between 2 and %d runs", MaxCompareRuns), http.StatusBadRequest)
+	}
+
+	signalsParam := queryParams.Get("signals")
+	if signalsParam == "" {
+		return NewHandlerError("invalid request, must pass in query param signals with a value of comma seperated signal paths", http.StatusBadRequest)
+	}
+	signals := strings.Split(signalsParam, ",")
+	if len(signals) > MaxCompareSignals {
+		return NewHandlerError(fmt.Sprintf("invalid request, can compare at most %d signals", MaxCompareSignals), http.StatusBadRequest)
+	}
+
+	var err error
+	align := subscribers.CompareAlignTime
+	if queryParams.Has("align") {
+		align, err = subscribers.ParseCompareAlign(queryParams.Get("align"))
+		if err != nil {
+			return NewHandlerError(err.Error(), http.StatusBadRequest)
+		}
+	}
+
+	var lapNumbers []int
+	if queryParams.Has("laps") {
+		if align != subscribers.CompareAlignLap {
+			return NewHandlerError("invalid request, laps can only be passed in with align=lap", http.StatusBadRequest)
+		}
+		for _, lapParam := range strings.Split(queryParams.Get("laps"), ",") {
+			lapNumber, err := strconv.Atoi(lapParam)
+			if err != nil {
+				return NewHandlerError(fmt.Sprintf("invalid lap %v: %v", lapParam, err), http.StatusBadRequest)
+			}
+			lapNumbers = append(lapNumbers, lapNumber)
+		}
+		if len(lapNumbers) != len(runIds) {
+			return NewHandlerError("invalid request, laps must have a lap number for every run", http.StatusBadRequest)
+		}
+	}
+
+	points := subscribers.DefaultComparePoints
+	if queryParams.Has("points") {
+		points, err = strconv.Atoi(queryParams.Get("points"))
+		if err != nil || points < 2 || points > subscribers.MaxComparePoints {
+			return NewHandlerError(fmt.Sprintf("invalid points %v, must be between 2 and %d", queryParams.Get("points"), subscribers.MaxComparePoints), http.StatusBadRequest)
+		}
+	}
+
+	compareRuns := make([]subscribers.CompareRun, len(runIds))
+	for i, runId := range runIds {
+		lapNumber := 0
+		if lapNumbers != nil {
+			lapNumber = lapNumbers[i]
+		}
+
+		compareRun, handlerErr := h.readCompareRun(r.Context(), runId, signals, align, lapNumber)
+		if handlerErr != nil {
+			return handlerErr
+		}
+		compareRuns[i] = *compareRun
+	}
+
+	comparison, err := subscribers.CompareRuns(compareRuns, signals, align, points)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusBadRequest)
+	}
+
+	if queryParams.Get("plot") != "false" {
+		writerTo, err := subscribers.ComparePlot(comparison)
+		if err != nil {
+			log.Println(err)
+		} else if writerTo != nil {
+			var plotBuffer bytes.Buffer
+			_, err = (*writerTo).WriteTo(&plotBuffer)
+			if err != nil {
+				log.Printf("could not render comparison plot: %v", err)
+			} else {
+				comparison.Plot = base64.StdEncoding.EncodeToString(plotBuffer.Bytes())
+			}
+		}
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("compared %d signals of %d runs", len(signals), len(runIds))
+	response["data"] = comparison
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// readCompareRun reads the signals of a run in the window compared with align, the whole run or one of its laps.
+// A lapNumber of 0 is the fastest lap of the run.
+func (h *compareHandler) readCompareRun(ctx context.Context, runId string, signals []string, align subscribers.CompareAlign, lapNumber int) (*subscribers.CompareRun, *HandlerError) {
+	objectId, err := primitive.ObjectIDFromHex(runId)
+	if err != nil {
+		return nil, NewHandlerError(fmt.Sprintf("could not decode mcap id %v, %v", runId, err), http.StatusInternalServerError)
+	}
+
+	mcap, err := h.dbClient.VehicleRunUseCase().GetVehicleRunById(ctx, objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return nil, NewHandlerError(fmt.Sprintf("no run with id %v found", runId), http.StatusNotFound)
+		}
+		return nil, NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	if len(mcap.McapFiles) == 0 {
+		return nil, NewHandlerError(fmt.Sprintf("no mcap files found for run %v", runId), http.StatusFailedDependency)
+	}
+
+	compareRun := &subscribers.CompareRun{
+		Run: models.CompareRunModel{
+			Id:    runId,
+			Label: strings.TrimSuffix(mcap.McapFiles[0].FileName, ".mcap"),
+		},
+	}
+	query := utils.SignalQuery{Signals: append([]string{}, signals...)}
+
+	if align == subscribers.CompareAlignLap {
+		lap, handlerErr := compareLap(mcap, lapNumber)
+		if handlerErr != nil {
+			return nil, handlerErr
+		}
+		compareRun.Run.Lap = &lap.Number
+		compareRun.Run.Label = fmt.Sprintf("%s lap %d", compareRun.Run.Label, lap.Number)
+		compareRun.Run.Start, compareRun.Run.End = lap.Start, lap.End
+		query.Start, query.End = lap.Start, lap.End
+	}
+
+	if align == subscribers.CompareAlignDistance {
+		query.Signals = append(query.Signals, "VNData.vn_gps.lat", "VNData.vn_gps.lon")
+	}
+
+	localFilePath, err := getLocalRunFile(ctx, h.s3Repository, mcap.McapFiles[0])
+	if err != nil {
+		return nil, NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	mcapFile, err := os.Open(localFilePath)
+	if err != nil {
+		return nil, NewHandlerError(fmt.Sprintf("could not open mcap file: %v", err), http.StatusInternalServerError)
+	}
+	defer mcapFile.Close()
+
+	allSeries, err := utils.NewMcapUtils().QuerySignals(mcapFile, query)
+	if err != nil {
+		return nil, NewHandlerError(fmt.Sprintf("could not read run %v: %v", runId, err), http.StatusBadRequest)
+	}
+
+	compareRun.Series = allSeries[:len(signals)]
+	if align == subscribers.CompareAlignDistance {
+		compareRun.Latitude, compareRun.Longitude = allSeries[len(signals)], allSeries[len(signals)+1]
+	}
+
+	if align != subscribers.CompareAlignLap {
+		end := 0.0
+		for _, series := range compareRun.Series {
+			if len(series.Times) > 0 {
+				end = math.Max(end, series.Times[len(series.Times)-1])
+			}
+		}
+		compareRun.Run.End = end
+	}
+
+	return compareRun, nil
+}
+
+// compareLap returns the lap of a run with lapNumber, or its fastest lap if lapNumber is 0
+func compareLap(mcap *models.VehicleRunModel, lapNumber int) (*models.LapModel, *HandlerError) {
+	if len(mcap.Laps) == 0 {
+		return nil, NewHandlerError(fmt.Sprintf("run %v has no laps to align", mcap.Id.Hex()), http.StatusBadRequest)
+	}
+
+	var found *models.LapModel
+	for i, lap := range mcap.Laps {
+		if lapNumber == 0 && (found == nil || lap.Duration < found.Duration) {
+			found = &mcap.Laps[i]
+		} else if lap.Number == lapNumber {
+			found = &mcap.Laps[i]
+		}
+	}
+	if found == nil {
+		return nil, NewHandlerError(fmt.Sprintf("run %v has no lap %d", mcap.Id.Hex(), lapNumber), http.StatusNotFound)
+	}
+
+	return found, nil
+}
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/database"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/messaging/subscribers"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// derivedChannelsHandler handles the registry of derived channels, signals computed from other signals while a run is ingested
+type derivedChannelsHandler struct {
+	dbClient *database.DatabaseClient
+}
+
+func NewDerivedChannelsHandler(
+	r *chi.Mux,
+	dbClient *database.DatabaseClient,
+) {
+	handler := &derivedChannelsHandler{
+		dbClient: dbClient,
+	}
+
+	r.Route("/derived_channels", func(r chi.Router) {
+		r.Get("/", HandlerFunc(handler.GetAllDerivedChannels).ServeHTTP)
+		r.Post("/", HandlerFunc(handler.CreateDerivedChannel).ServeHTTP)
+		r.Get("/{id}", HandlerFunc(handler.GetDerivedChannelFromID).ServeHTTP)
+		r.Post("/{id}", HandlerFunc(handler.UpdateDerivedChannelFromID).ServeHTTP)
+		r.Delete("/{id}", HandlerFunc(handler.DeleteDerivedChannelFromID).ServeHTTP)
+	})
+}
+
+// GetAllDerivedChannels responds with every derived channel in the order they are evaluated in
+func (h *derivedChannelsHandler) GetAllDerivedChannels(w http.ResponseWriter, r *http.Request) *HandlerError {
+	channels, err := h.dbClient.DerivedChannelUseCase().GetAllDerivedChannels(r.Context())
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("found %d derived channels", len(channels))
+	response["data"] = channels
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// CreateDerivedChannel adds a derived channel to the registry, it is computed for every run ingested after it.
+// Form fields:
+//   - name: the signal is stored as Derived.<name>
+//   - expression: e.g. VehicleData.current_rpms.FL * 2 * pi * TireRadius / GearboxRatio / 60
+//   - units, description (optional)
+func (h *derivedChannelsHandler) CreateDerivedChannel(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+
+	channel, handlerErr := h.parseDerivedChannelForm(r, nil)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	createdChannel, err := h.dbClient.DerivedChannelUseCase().CreateDerivedChannel(ctx, *channel)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("created derived channel %s", createdChannel.Name)
+	response["data"] = createdChannel
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// GetDerivedChannelFromID takes in an ID from a URL param and responds with the derived channel
+func (h *derivedChannelsHandler) GetDerivedChannelFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	objectId, handlerErr := derivedChannelID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	channel, err := h.dbClient.DerivedChannelUseCase().GetDerivedChannelById(r.Context(), objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no derived channel with id %v found", objectId.Hex()), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("found derived channel %s", channel.Name)
+	response["data"] = channel
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// UpdateDerivedChannelFromID takes in an ID from a URL param and replaces the derived channel with the form fields of CreateDerivedChannel.
+// Runs which were already ingested keep the values computed with the previous expression.
+func (h *derivedChannelsHandler) UpdateDerivedChannelFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+
+	objectId, handlerErr := derivedChannelID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	channel, handlerErr := h.parseDerivedChannelForm(r, &objectId)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	updatedChannel, err := h.dbClient.DerivedChannelUseCase().UpdateDerivedChannel(ctx, objectId, *channel)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no derived channel with id %v found", objectId.Hex()), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("updated derived channel %s", updatedChannel.Name)
+	response["data"] = updatedChannel
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// DeleteDerivedChannelFromID takes in an ID from a URL param and removes the derived channel from the registry
+func (h *derivedChannelsHandler) DeleteDerivedChannelFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	objectId, handlerErr := derivedChannelID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	err := h.dbClient.DerivedChannelUseCase().DeleteDerivedChannelById(r.Context(), objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no derived channel with id %v found", objectId.Hex()), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("deleted derived channel %s", objectId.Hex())
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+func derivedChannelID(r *http.Request) (primitive.ObjectID, *HandlerError) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return primitive.NilObjectID, NewHandlerError("invalid request, must pass in derived channel id", http.StatusBadRequest)
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, NewHandlerError(fmt.Sprintf("could not decode derived channel id %v, %v", id, err), http.StatusBadRequest)
+	}
+	return objectId, nil
+}
+
+// parseDerivedChannelForm reads a derived channel from the form fields of the request and checks that its expression
+// can be evaluated and that its name is not used by another channel than the one with id (nil when creating a channel)
+func (h *derivedChannelsHandler) parseDerivedChannelForm(r *http.Request, id *primitive.ObjectID) (*models.DerivedChannelModel, *HandlerError) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		return nil, NewHandlerError("error parsing form data", http.StatusBadRequest)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	channel := &models.DerivedChannelModel{
+		Name:        r.FormValue("name"),
+		Expression:  r.FormValue("expression"),
+		Units:       r.FormValue("units"),
+		Description: r.FormValue("description"),
+	}
+
+	// Only the names of the constants matter here, their values depend on the car and date of every run
+	_, err := subscribers.ParseDerivedChannel(*channel, subscribers.DerivedChannelConstants(subscribers.DefaultCarMetrics()))
+	if err != nil {
+		return nil, NewHandlerError(err.Error(), http.StatusBadRequest)
+	}
+
+	exists, err := h.dbClient.DerivedChannelUseCase().DerivedChannelNameExists(r.Context(), channel.Name, id)
+	if err != nil {
+		return nil, NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+	if exists {
+		return nil, NewHandlerError(fmt.Sprintf("a derived channel named %s already exists", channel.Name), http.StatusConflict)
+	}
+
+	return channel, nil
+}
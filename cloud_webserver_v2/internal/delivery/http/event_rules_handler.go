@@ -0,0 +1,206 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/database"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/messaging/subscribers"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// eventRulesHandler handles the rules finding the events of the runs while they are ingested, like faults or state changes
+type eventRulesHandler struct {
+	dbClient *database.DatabaseClient
+}
+
+func NewEventRulesHandler(
+	r *chi.Mux,
+	dbClient *database.DatabaseClient,
+) {
+	handler := &eventRulesHandler{
+		dbClient: dbClient,
+	}
+
+	r.Route("/event_rules", func(r chi.Router) {
+		r.Get("/", HandlerFunc(handler.GetAllEventRules).ServeHTTP)
+		r.Post("/", HandlerFunc(handler.CreateEventRule).ServeHTTP)
+		r.Get("/{id}", HandlerFunc(handler.GetEventRuleFromID).ServeHTTP)
+		r.Post("/{id}", HandlerFunc(handler.UpdateEventRuleFromID).ServeHTTP)
+		r.Delete("/{id}", HandlerFunc(handler.DeleteEventRuleFromID).ServeHTTP)
+	})
+}
+
+// GetAllEventRules responds with every event rule
+func (h *eventRulesHandler) GetAllEventRules(w http.ResponseWriter, r *http.Request) *HandlerError {
+	rules, err := h.dbClient.EventRuleUseCase().GetAllEventRules(r.Context())
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("found %d event rules", len(rules))
+	response["data"] = rules
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// CreateEventRule adds an event rule, its events are found in every run ingested after it.
+// Form fields:
+//   - name: the type of the events found by the rule
+//   - signal: the signal path the rule watches, e.g. ACUCoreData.bms_fault
+//   - condition: "becomes_true", "changes", "rises_above" or "falls_below"
+//   - threshold: the value crossed by the rises_above and falls_below conditions
+//   - description (optional)
+func (h *eventRulesHandler) CreateEventRule(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+
+	rule, handlerErr := h.parseEventRuleForm(r, nil)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	createdRule, err := h.dbClient.EventRuleUseCase().CreateEventRule(ctx, *rule)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("created event rule %s", createdRule.Name)
+	response["data"] = createdRule
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// GetEventRuleFromID takes in an ID from a URL param and responds with the event rule
+func (h *eventRulesHandler) GetEventRuleFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	objectId, handlerErr := eventRuleID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	rule, err := h.dbClient.EventRuleUseCase().GetEventRuleById(r.Context(), objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no event rule with id %v found", objectId.Hex()), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("found event rule %s", rule.Name)
+	response["data"] = rule
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// UpdateEventRuleFromID takes in an ID from a URL param and replaces the event rule with the form fields of CreateEventRule.
+// Runs which were already ingested keep the events found with the previous rule.
+func (h *eventRulesHandler) UpdateEventRuleFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+
+	objectId, handlerErr := eventRuleID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	rule, handlerErr := h.parseEventRuleForm(r, &objectId)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	updatedRule, err := h.dbClient.EventRuleUseCase().UpdateEventRule(ctx, objectId, *rule)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no event rule with id %v found", objectId.Hex()), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("updated event rule %s", updatedRule.Name)
+	response["data"] = updatedRule
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// DeleteEventRuleFromID takes in an ID from a URL param and deletes the event rule
+func (h *eventRulesHandler) DeleteEventRuleFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	objectId, handlerErr := eventRuleID(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	err := h.dbClient.EventRuleUseCase().DeleteEventRuleById(r.Context(), objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no event rule with id %v found", objectId.Hex()), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("deleted event rule %s", objectId.Hex())
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+func eventRuleID(r *http.Request) (primitive.ObjectID, *HandlerError) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		return primitive.NilObjectID, NewHandlerError("invalid request, must pass in event rule id", http.StatusBadRequest)
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, NewHandlerError(fmt.Sprintf("could not decode event rule id %v, %v", id, err), http.StatusBadRequest)
+	}
+	return objectId, nil
+}
+
+// parseEventRuleForm reads an event rule from the form fields of the request and checks that it is valid
+// and that its name is not used by another rule than the one with id (nil when creating a rule)
+func (h *eventRulesHandler) parseEventRuleForm(r *http.Request, id *primitive.ObjectID) (*models.EventRuleModel, *HandlerError) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		return nil, NewHandlerError("error parsing form data", http.StatusBadRequest)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	rule := &models.EventRuleModel{
+		Name:        r.FormValue("name"),
+		Signal:      r.FormValue("signal"),
+		Condition:   r.FormValue("condition"),
+		Description: r.FormValue("description"),
+	}
+
+	if rule.Condition == models.EventConditionRisesAbove || rule.Condition == models.EventConditionFallsBelow {
+		threshold, err := strconv.ParseFloat(r.FormValue("threshold"), 64)
+		if err != nil {
+			return nil, NewHandlerError(fmt.Sprintf("invalid threshold %v, the %s condition needs one", r.FormValue("threshold"), rule.Condition), http.StatusBadRequest)
+		}
+		rule.Threshold = threshold
+	}
+
+	if err := subscribers.ValidateEventRule(*rule); err != nil {
+		return nil, NewHandlerError(err.Error(), http.StatusBadRequest)
+	}
+
+	exists, err := h.dbClient.EventRuleUseCase().EventRuleNameExists(r.Context(), rule.Name, id)
+	if err != nil {
+		return nil, NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+	if exists {
+		return nil, NewHandlerError(fmt.Sprintf("an event rule named %s already exists", rule.Name), http.StatusConflict)
+	}
+
+	return rule, nil
+}
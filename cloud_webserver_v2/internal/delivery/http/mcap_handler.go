@@ -1,9 +1,17 @@
 package http
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,10 +19,12 @@ import (
 	"github.com/go-chi/render"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/background"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/database"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/messaging/subscribers"
 	hytech_middleware "github.com/hytech-racing/cloud-webserver-v2/internal/middleware"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/mps"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/s3"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -24,8 +34,8 @@ import (
    - [x] Be able to write MATLAB files from the MCAP inputs.
    - [x] Store/organize those MCAP and Matlab files in AWS S3 (waiting on drivebrain to write MCAP files with dates/other info in metadata)
    - [x] After debugging, make UploadMcap route quickly give response and perform task after responding
-   - [ ] The interpolation logic is a little flawed. More docs on that is in the bookstack. We need to fix it but it is low-priority for now.
-   - [ ] Once interpolation logic is fixed, write an interpolated MCAP file with the data.
+   - [x] The interpolation logic is a little flawed. More docs on that is in the bookstack. We need to fix it but it is low-priority for now.
+   - [x] Once interpolation logic is fixed, write an interpolated MCAP file with the data.
 */
 
 // mcapHandler handles all requests related to MCAP data (uploads, deletions, edits, reading).
@@ -58,15 +68,27 @@ func NewMcapHandler(
 		r.With(fileUploadMiddleware.FileUploadSizeLimitMiddleware).Post("/bulk_upload", handler.BulkUploadMcaps)
 
 		// static routes
-		r.Get("/", handler.GetMcapsFromFilters)
+		r.Get("/", HandlerFunc(handler.GetMcapsFromFilters).ServeHTTP)
 		r.Get("/status", HandlerFunc(handler.CheckFileStatus).ServeHTTP)
+		r.Get("/trends", HandlerFunc(handler.GetRunTrends).ServeHTTP)
 
 		// parameterized routes
 		r.Get("/{id}", HandlerFunc(handler.GetMcapFromID).ServeHTTP)
 		r.Delete("/{id}", HandlerFunc(handler.DeleteMcapFromID).ServeHTTP)
+		r.Get("/{id}/signals", HandlerFunc(handler.GetSignalsFromID).ServeHTTP)
+		r.Get("/{id}/events", HandlerFunc(handler.GetEventsFromID).ServeHTTP)
+		r.Get("/{id}/driver_inputs", HandlerFunc(handler.GetDriverInputsFromID).ServeHTTP)
+		r.Get("/{id}/data", HandlerFunc(handler.GetSignalDataFromID).ServeHTTP)
+		r.Get("/{id}/preview", HandlerFunc(handler.GetSignalPreviewFromID).ServeHTTP)
+		r.Get("/{id}/hdf5/signals", HandlerFunc(handler.GetHDF5SignalsFromID).ServeHTTP)
+		r.Get("/{id}/hdf5/data", HandlerFunc(handler.GetHDF5SignalDataFromID).ServeHTTP)
+		r.Get("/{id}/export", HandlerFunc(handler.ExportSignalsFromID).ServeHTTP)
 		r.Get("/{id}/process", HandlerFunc(handler.ProcessMatlabJob).ServeHTTP)
+		r.Post("/{id}/reprocess", HandlerFunc(handler.ReprocessDerivedChannelsFromID).ServeHTTP)
 		r.Post("/{id}/updateMetadataRecords", HandlerFunc(handler.UpdateMetadataRecordFromID).ServeHTTP)
 		r.Delete("/{id}/resetMetaDataRecord/{metadata}", HandlerFunc(handler.ResetMetadataRecordFromID).ServeHTTP)
+		r.Post("/{id}/tags", HandlerFunc(handler.AddTagsFromID).ServeHTTP)
+		r.Delete("/{id}/tags/{tag}", HandlerFunc(handler.RemoveTagFromID).ServeHTTP)
 		r.Post("/{id}/addMiscFile", HandlerFunc(handler.UploadNewMiscFile).ServeHTTP)
 	})
 }
@@ -116,81 +138,981 @@ func (h *mcapHandler) UploadNewMiscFile(w http.ResponseWriter, r *http.Request)
 }
 
 // GetMcapsFromFilters takes in filters through Query parameters and will respond with a
-// map with a message and data field where data contains the filtered MCAPs
-func (h *mcapHandler) GetMcapsFromFilters(w http.ResponseWriter, r *http.Request) {
+// map with a message, data and pagination field where data contains a page of the filtered MCAPs
+// and pagination the total number of MCAPs matching the filters.
+// Query params, along with every run filter of parseVehicleRunFilters:
+//   - page: number of the page, starting at 1 (default 1)
+//   - limit: number of runs of a page (default models.DefaultVehicleRunPageLimit, at most models.MaxVehicleRunPageLimit)
+//   - sort: "date", "location" or "car", prefixed with "-" to sort in descending order, or "relevance" to rank the runs
+//     by how well they match search_text (default "relevance" with search_text, "-date" otherwise)
+//   - fields: comma seperated fields of the runs to respond with (id,date,location,mcap_files), by default every field.
+//     Only the urls of the requested file fields are signed.
+func (h *mcapHandler) GetMcapsFromFilters(w http.ResponseWriter, r *http.Request) *HandlerError {
 	ctx := r.Context()
 	queryParams := r.URL.Query()
+	filters := parseVehicleRunFilters(queryParams)
 
+	page, fields, handlerErr := parseVehicleRunPage(queryParams)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	resModels, total, err := h.dbClient.VehicleRunUseCase().GetVehicleRunPageByFilters(ctx, &filters, page)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	res := make([]interface{}, len(resModels))
+	for idx, model := range resModels {
+		responseModel := models.VehicleRunSerializeFields(ctx, h.s3Repository, model, fields)
+		if fields == nil {
+			res[idx] = responseModel
+			continue
+		}
+
+		res[idx], err = selectResponseFields(responseModel, fields)
+		if err != nil {
+			return NewHandlerError(err.Error(), http.StatusInternalServerError)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["data"] = res
+	data["message"] = fmt.Sprintf("found %d runs", total)
+	data["pagination"] = models.PaginationModel{
+		Total: total,
+		Page:  page.Page,
+		Limit: page.Limit,
+		Pages: (total + int64(page.Limit) - 1) / int64(page.Limit),
+	}
+	render.JSON(w, r, data)
+	return nil
+}
+
+// parseVehicleRunPage reads the page, limit, sort and fields query params of the run listing.
+// fields is the set of requested response fields, or nil if every field is requested.
+func parseVehicleRunPage(queryParams url.Values) (*models.VehicleRunPageModel, map[string]bool, *HandlerError) {
+	page := &models.VehicleRunPageModel{
+		Page:           1,
+		Limit:          models.DefaultVehicleRunPageLimit,
+		SortField:      "date",
+		SortDescending: true,
+	}
+
+	var err error
+	if queryParams.Has("page") {
+		page.Page, err = strconv.Atoi(queryParams.Get("page"))
+		if err != nil || page.Page < 1 {
+			return nil, nil, NewHandlerError(fmt.Sprintf("invalid page %v, must be a number starting at 1", queryParams.Get("page")), http.StatusBadRequest)
+		}
+	}
+
+	if queryParams.Has("limit") {
+		page.Limit, err = strconv.Atoi(queryParams.Get("limit"))
+		if err != nil || page.Limit < 1 || page.Limit > models.MaxVehicleRunPageLimit {
+			return nil, nil, NewHandlerError(fmt.Sprintf("invalid limit %v, must be between 1 and %d", queryParams.Get("limit"), models.MaxVehicleRunPageLimit), http.StatusBadRequest)
+		}
+	}
+
+	if queryParams.Has("search_text") && (!queryParams.Has("sort") || queryParams.Get("sort") == models.VehicleRunSortRelevance) {
+		page.SortByRelevance = true
+	} else if queryParams.Get("sort") == models.VehicleRunSortRelevance {
+		return nil, nil, NewHandlerError("invalid sort relevance, can only sort by relevance with search_text", http.StatusBadRequest)
+	} else if queryParams.Has("sort") {
+		sort := queryParams.Get("sort")
+		page.SortDescending = strings.HasPrefix(sort, "-")
+		sortField, ok := models.VehicleRunSortFields[strings.TrimPrefix(sort, "-")]
+		if !ok {
+			return nil, nil, NewHandlerError(fmt.Sprintf("invalid sort %v, must be date, location or car, prefixed with - to sort in descending order", sort), http.StatusBadRequest)
+		}
+		page.SortField = sortField
+	}
+
+	if queryParams.Get("fields") == "" {
+		return page, nil, nil
+	}
+
+	fields := make(map[string]bool)
+	page.Fields = make([]string, 0)
+	for _, field := range strings.Split(queryParams.Get("fields"), ",") {
+		modelField, ok := models.VehicleRunResponseFields[field]
+		if !ok {
+			return nil, nil, NewHandlerError(fmt.Sprintf("invalid field %v, must be a field of the runs like id, date or mcap_files", field), http.StatusBadRequest)
+		}
+		fields[field] = true
+		page.Fields = append(page.Fields, modelField)
+	}
+
+	return page, fields, nil
+}
+
+// selectResponseFields returns the fields of a serialized run, keyed by their json names
+func selectResponseFields(responseModel models.VehicleRunModelResponse, fields map[string]bool) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(responseModel)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode run %v: %v", responseModel.Id, err)
+	}
+
+	var allFields map[string]json.RawMessage
+	if err = json.Unmarshal(encoded, &allFields); err != nil {
+		return nil, fmt.Errorf("could not decode run %v: %v", responseModel.Id, err)
+	}
+
+	selected := make(map[string]interface{}, len(fields))
+	for field := range fields {
+		selected[field] = allFields[field]
+	}
+	return selected, nil
+}
+
+// GetRunTrends aggregates a metric of the runs matching the run filters into groups, like the max pack temperature per day
+// (metric=signal_max&signal=ACUCoreData.max_cell_temp&function=max&group_by=date) or the best lap time at a track per car
+// (metric=lap_time&function=min&group_by=car&location=MIS).
+// Query params, along with every run filter of GetMcapsFromFilters:
+//   - metric: one of models.TrendMetrics, or signal_min, signal_max or signal_mean with signal
+//   - signal: the signal path of the signal metrics
+//   - function: "avg" (default), "min", "max", "sum" or "count"
+//   - group_by: comma seperated groups, "date", "location", "car" or "event_type" (optional, every run is in the same group by default)
+//   - interval: "day" (default), "week" or "month", what dates are grouped by
+func (h *mcapHandler) GetRunTrends(w http.ResponseWriter, r *http.Request) *HandlerError {
+	queryParams := r.URL.Query()
+
+	query, handlerErr := parseTrendQuery(queryParams)
+	if handlerErr != nil {
+		return handlerErr
+	}
+	filters := parseVehicleRunFilters(queryParams)
+
+	buckets, err := h.dbClient.VehicleRunUseCase().GetTrends(r.Context(), &filters, query)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("aggregated %s of %s into %d groups", query.Function, query.Metric, len(buckets))
+	response["data"] = buckets
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// parseTrendQuery reads the metric, signal, function, group_by and interval query params of a trend
+func parseTrendQuery(queryParams url.Values) (*models.TrendQueryModel, *HandlerError) {
+	query := &models.TrendQueryModel{
+		Metric:   queryParams.Get("metric"),
+		Signal:   queryParams.Get("signal"),
+		Function: models.TrendFunctionAvg,
+		GroupBy:  make([]string, 0),
+		Interval: models.TrendIntervalDay,
+	}
+
+	_, isMetric := models.TrendMetrics[query.Metric]
+	_, isSignalMetric := models.TrendSignalMetrics[query.Metric]
+	if !isMetric && !isSignalMetric {
+		return nil, NewHandlerError(fmt.Sprintf("invalid metric %q, must be one of the run metrics or signal_min, signal_max or signal_mean", query.Metric), http.StatusBadRequest)
+	}
+	if isSignalMetric && !strings.Contains(query.Signal, ".") {
+		return nil, NewHandlerError(fmt.Sprintf("invalid request, metric %s needs a signal path like ACUCoreData.max_cell_temp", query.Metric), http.StatusBadRequest)
+	}
+
+	if queryParams.Has("function") {
+		query.Function = queryParams.Get("function")
+		if !slices.Contains(models.TrendFunctions, query.Function) {
+			return nil, NewHandlerError(fmt.Sprintf("invalid function %q, must be one of %v", query.Function, models.TrendFunctions), http.StatusBadRequest)
+		}
+	}
+
+	if queryParams.Get("group_by") != "" {
+		for _, groupBy := range strings.Split(queryParams.Get("group_by"), ",") {
+			if !slices.Contains(models.TrendGroups, groupBy) {
+				return nil, NewHandlerError(fmt.Sprintf("invalid group_by %q, must be one of %v", groupBy, models.TrendGroups), http.StatusBadRequest)
+			}
+			if !slices.Contains(query.GroupBy, groupBy) {
+				query.GroupBy = append(query.GroupBy, groupBy)
+			}
+		}
+	}
+
+	if queryParams.Has("interval") {
+		query.Interval = queryParams.Get("interval")
+		if !slices.Contains(models.TrendIntervals, query.Interval) {
+			return nil, NewHandlerError(fmt.Sprintf("invalid interval %q, must be one of %v", query.Interval, models.TrendIntervals), http.StatusBadRequest)
+		}
+	}
+
+	return query, nil
+}
+
+// parseVehicleRunFilters reads all the supported run filters from the query parameters of a request.
+// search_text matches whole words of the tags, file names, location, event type and notes of the runs, so part of a
+// file name like the 2024-05-11 of 2024-05-11_run.mcap is only matched with file_name, a case insensitive regular expression.
+func parseVehicleRunFilters(queryParams url.Values) models.VehicleRunModelFilters {
 	filters := models.VehicleRunModelFilters{}
 
-	if queryParams.Has("id") {
-		id, err := primitive.ObjectIDFromHex(queryParams.Get("id"))
-		if err == nil {
-			filters.ID = &id
-		}
+	if queryParams.Has("id") {
+		id, err := primitive.ObjectIDFromHex(queryParams.Get("id"))
+		if err == nil {
+			filters.ID = &id
+		}
+	}
+
+	utcFormat := "2006-01-02T15:04:05Z07:00"
+	if queryParams.Has("before_date") {
+		beforeDate := queryParams.Get("before_date")
+		parsedBeforeDate, err := time.Parse(utcFormat, beforeDate)
+		if err == nil {
+			filters.BeforeDate = &parsedBeforeDate
+		}
+	}
+
+	if queryParams.Has("after_date") {
+		afterDate := queryParams.Get("after_date")
+		parsedAfterDate, err := time.Parse(utcFormat, afterDate)
+		if err == nil {
+			filters.AfterDate = &parsedAfterDate
+		}
+	}
+
+	if queryParams.Has("location") {
+		location := queryParams.Get("location")
+		filters.Location = &location
+	}
+
+	if queryParams.Has("event_type") {
+		eventType := queryParams.Get("event_type")
+		filters.EventType = &eventType
+	}
+
+	if queryParams.Has("car_model") {
+		carModel := queryParams.Get("car_model")
+		filters.CarModel = &carModel
+	}
+
+	if queryParams.Has("search_text") {
+		search_text := queryParams.Get("search_text")
+		filters.SearchText = &search_text
+	}
+
+	if queryParams.Has("file_name") {
+		fileName := queryParams.Get("file_name")
+		filters.FileName = &fileName
+	}
+
+	if queryParams.Get("tags") != "" {
+		filters.Tags = strings.Split(queryParams.Get("tags"), ",")
+	}
+
+	if queryParams.Has("mps_function") {
+		mps_function := queryParams.Get("mps_function")
+		filters.MpsFunction = &mps_function
+	}
+
+	if queryParams.Has("has_signal") {
+		has_signal := queryParams.Get("has_signal")
+		filters.HasSignal = &has_signal
+	}
+
+	if queryParams.Has("has_event") {
+		has_event := queryParams.Get("has_event")
+		filters.HasEvent = &has_event
+	}
+
+	if queryParams.Has("min_quality_score") {
+		minQualityScore, err := strconv.ParseFloat(queryParams.Get("min_quality_score"), 64)
+		if err == nil {
+			filters.MinQualityScore = &minQualityScore
+		}
+	}
+
+	if queryParams.Has("max_quality_score") {
+		maxQualityScore, err := strconv.ParseFloat(queryParams.Get("max_quality_score"), 64)
+		if err == nil {
+			filters.MaxQualityScore = &maxQualityScore
+		}
+	}
+
+	return filters
+}
+
+// GetMcapFromID takes in an ID from a URL param and responds with an MCAP with that ID.
+func (h *mcapHandler) GetMcapFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+
+	mcapId := chi.URLParam(r, "id")
+	if mcapId == "" {
+		return NewHandlerError("invalid request, must pass in mcap id", http.StatusBadRequest)
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(mcapId)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not decode mcap id %v, %v", mcapId, err), http.StatusInternalServerError)
+	}
+
+	mcap, err := h.dbClient.VehicleRunUseCase().GetVehicleRunById(ctx, objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no run with id %v found", mcapId), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+	responseMcap := models.VehicleRunSerialize(ctx, h.s3Repository, *mcap)
+	data := make([]models.VehicleRunModelResponse, 1)
+	data[0] = responseMcap
+
+	response := make(map[string]interface{})
+	response["message"] = ""
+	response["data"] = data
+
+	render.JSON(w, r, response)
+
+	return nil
+}
+
+// GetSignalsFromID takes in an ID from a URL param and responds with the catalog of signals found in that run.
+// The optional topic query param only returns the signals logged under that topic.
+func (h *mcapHandler) GetSignalsFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+
+	mcapId := chi.URLParam(r, "id")
+	if mcapId == "" {
+		return NewHandlerError("invalid request, must pass in mcap id", http.StatusBadRequest)
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(mcapId)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not decode mcap id %v, %v", mcapId, err), http.StatusInternalServerError)
+	}
+
+	mcap, err := h.dbClient.VehicleRunUseCase().GetVehicleRunById(ctx, objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no run with id %v found", mcapId), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	topic := r.URL.Query().Get("topic")
+	signals := make([]models.SignalModel, 0, len(mcap.Signals))
+	for _, signal := range mcap.Signals {
+		if topic == "" || signal.Topic == topic || utils.TrimTopic(signal.Topic) == topic {
+			signals = append(signals, signal)
+		}
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("found %d signals", len(signals))
+	response["data"] = signals
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// GetEventsFromID takes in an ID from a URL param and responds with the events found in the run by the event rules,
+// in the order they happened.
+// Query params:
+//   - type: only responds with the events of this type (optional)
+func (h *mcapHandler) GetEventsFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+
+	mcapId := chi.URLParam(r, "id")
+	if mcapId == "" {
+		return NewHandlerError("invalid request, must pass in mcap id", http.StatusBadRequest)
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(mcapId)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not decode mcap id %v, %v", mcapId, err), http.StatusInternalServerError)
+	}
+
+	_, err = h.dbClient.VehicleRunUseCase().GetVehicleRunById(ctx, objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no run with id %v found", mcapId), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	events, err := h.dbClient.EventUseCase().GetRunEvents(ctx, objectId, r.URL.Query().Get("type"))
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("found %d events", len(events))
+	response["data"] = events
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// GetDriverInputsFromID takes in an ID from a URL param and responds with the driver input report of the run,
+// the throttle, brake and steering statistics of the whole run and of every lap.
+// Query params:
+//   - format: "json" (default), or "csv" or "parquet" to download the report as a table with a row per lap,
+//     the last row has no lap and holds the statistics of the whole run
+func (h *mcapHandler) GetDriverInputsFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+	queryParams := r.URL.Query()
+
+	var format utils.ExportFormat
+	if queryParams.Has("format") && queryParams.Get("format") != "json" {
+		var err error
+		format, err = utils.ParseExportFormat(queryParams.Get("format"))
+		if err != nil {
+			return NewHandlerError(fmt.Sprintf("unknown format %s, must be json, csv or parquet", queryParams.Get("format")), http.StatusBadRequest)
+		}
+	}
+
+	mcapId := chi.URLParam(r, "id")
+	if mcapId == "" {
+		return NewHandlerError("invalid request, must pass in mcap id", http.StatusBadRequest)
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(mcapId)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not decode mcap id %v, %v", mcapId, err), http.StatusInternalServerError)
+	}
+
+	mcap, err := h.dbClient.VehicleRunUseCase().GetVehicleRunById(ctx, objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no run with id %v found", mcapId), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	if mcap.DriverInputs == nil {
+		return NewHandlerError(fmt.Sprintf("run %v has no driver input report, it did not log the throttle", mcapId), http.StatusNotFound)
+	}
+
+	if format == "" {
+		response := make(map[string]interface{})
+		response["message"] = fmt.Sprintf("found driver inputs of %d laps", len(mcap.DriverInputs.Laps))
+		response["data"] = mcap.DriverInputs
+
+		render.JSON(w, r, response)
+		return nil
+	}
+
+	rows := make([][]interface{}, 0, len(mcap.DriverInputs.Laps)+1)
+	for _, lap := range mcap.DriverInputs.Laps {
+		rows = append(rows, driverInputRow(float64(lap.Lap), lap.DriverInputStatsModel))
+	}
+	rows = append(rows, driverInputRow(nil, mcap.DriverInputs.Run))
+
+	fileName := "driver_inputs"
+	if len(mcap.McapFiles) > 0 {
+		fileName = strings.TrimSuffix(mcap.McapFiles[0].FileName, ".mcap") + "_driver_inputs"
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName+"."+string(format)))
+	err = utils.WriteTable(w, format, driverInputColumns, rows)
+	if err != nil {
+		log.Printf("could not write driver input export: %v", err)
+	}
+	return nil
+}
+
+var driverInputColumns = []string{
+	"lap", "duration", "full_throttle", "average_throttle", "brake_applications", "braking", "coasting_time", "steering_rate", "steering_reversals",
+}
+
+// driverInputRow returns the cells of the driverInputColumns of stats, steering cells are empty if the steering was not logged
+func driverInputRow(lap interface{}, stats models.DriverInputStatsModel) []interface{} {
+	row := []interface{}{lap, stats.Duration, stats.FullThrottle, stats.AverageThrottle, float64(stats.BrakeApplications), stats.Braking, stats.CoastingTime, nil, nil}
+	if stats.SteeringRate != nil {
+		row[7] = *stats.SteeringRate
+	}
+	if stats.SteeringReversals != nil {
+		row[8] = float64(*stats.SteeringReversals)
+	}
+	return row
+}
+
+// GetSignalDataFromID takes in an ID from a URL param and responds with the raw data of the requested signals
+// without needing to download the whole HDF5 file.
+// Query params:
+//   - signals: comma seperated signal paths (VehicleData.current_rpms.FL,VNData.vn_gps.lat)
+//   - start, end: time window in seconds relative to the start of the run (optional)
+//   - segment: id of a segment of the run whose time window is used instead of start and end (optional)
+//   - max_points: max number of points per signal, signals with more points are downsampled (optional)
+//   - downsample: "lttb" (default) or "minmax"
+//   - format: "json" (default) or "binary", see writeSignalSeriesBinary for the binary layout
+func (h *mcapHandler) GetSignalDataFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+
+	request, handlerErr := parseSignalDataRequest(r.URL.Query())
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	mcapId := chi.URLParam(r, "id")
+	if mcapId == "" {
+		return NewHandlerError("invalid request, must pass in mcap id", http.StatusBadRequest)
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(mcapId)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not decode mcap id %v, %v", mcapId, err), http.StatusInternalServerError)
+	}
+
+	mcap, err := h.dbClient.VehicleRunUseCase().GetVehicleRunById(ctx, objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no run with id %v found", mcapId), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	segment, handlerErr := runSegment(r.URL.Query(), mcap)
+	if handlerErr != nil {
+		return handlerErr
+	}
+	if segment != nil {
+		request.query.Start, request.query.End = segment.Start, segment.End
+	}
+
+	if len(mcap.McapFiles) == 0 {
+		return NewHandlerError("no mcap files found", http.StatusFailedDependency)
+	}
+
+	localFilePath, err := getLocalRunFile(ctx, h.s3Repository, mcap.McapFiles[0])
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	mcapFile, err := os.Open(localFilePath)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not open mcap file: %v", err), http.StatusInternalServerError)
+	}
+	defer mcapFile.Close()
+
+	allSeries, err := utils.NewMcapUtils().QuerySignals(mcapFile, request.query)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusBadRequest)
+	}
+
+	writeSignalData(w, r, allSeries, request)
+	return nil
+}
+
+// GetHDF5SignalsFromID takes in an ID from a URL param and responds with the layout version and every signal path
+// of one of the HDF5 files of the run.
+// Query params:
+//   - file: "raw" (default) or "interpolated"
+func (h *mcapHandler) GetHDF5SignalsFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	reader, _, handlerErr := h.openRunHDF5File(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+	defer reader.Close()
+
+	signals := reader.Signals()
+	data := make(map[string]interface{})
+	data["layout_version"] = reader.LayoutVersion()
+	data["signals"] = signals
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("found %d signals", len(signals))
+	response["data"] = data
+
+	render.JSON(w, r, response)
+	return nil
+}
+
+// GetHDF5SignalDataFromID takes in an ID from a URL param and responds with the data of the requested signals,
+// read from one of the HDF5 files of the run instead of decoding its MCAP file.
+// Signals which are not numbers are responded with an error and no values, the binary format has no points for them.
+// It accepts the same query params as GetSignalDataFromID, plus:
+//   - file: "raw" (default) or "interpolated"
+func (h *mcapHandler) GetHDF5SignalDataFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	request, handlerErr := parseSignalDataRequest(r.URL.Query())
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	reader, mcap, handlerErr := h.openRunHDF5File(r)
+	if handlerErr != nil {
+		return handlerErr
+	}
+	defer reader.Close()
+
+	segment, handlerErr := runSegment(r.URL.Query(), mcap)
+	if handlerErr != nil {
+		return handlerErr
+	}
+	if segment != nil {
+		request.query.Start, request.query.End = segment.Start, segment.End
+	}
+
+	allSeries, err := reader.ReadSignals(request.query)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusBadRequest)
+	}
+
+	writeSignalData(w, r, allSeries, request)
+	return nil
+}
+
+// openRunHDF5File opens the HDF5 file picked by the file query param of the run with the id URL param, it returns the run too
+func (h *mcapHandler) openRunHDF5File(r *http.Request) (*utils.HDF5Reader, *models.VehicleRunModel, *HandlerError) {
+	ctx := r.Context()
+
+	// The raw HDF5 file always comes first and the interpolated one second
+	fileIndex := 0
+	switch r.URL.Query().Get("file") {
+	case "", "raw":
+	case "interpolated":
+		fileIndex = 1
+	default:
+		return nil, nil, NewHandlerError("invalid file, must be raw or interpolated", http.StatusBadRequest)
+	}
+
+	mcapId := chi.URLParam(r, "id")
+	if mcapId == "" {
+		return nil, nil, NewHandlerError("invalid request, must pass in mcap id", http.StatusBadRequest)
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(mcapId)
+	if err != nil {
+		return nil, nil, NewHandlerError(fmt.Sprintf("could not decode mcap id %v, %v", mcapId, err), http.StatusInternalServerError)
+	}
+
+	mcap, err := h.dbClient.VehicleRunUseCase().GetVehicleRunById(ctx, objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return nil, nil, NewHandlerError(fmt.Sprintf("no run with id %v found", mcapId), http.StatusNotFound)
+		}
+		return nil, nil, NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	if len(mcap.MatFiles) <= fileIndex || !strings.HasSuffix(mcap.MatFiles[fileIndex].FilePath, ".h5") {
+		return nil, nil, NewHandlerError("no such hdf5 file found for run", http.StatusFailedDependency)
+	}
+
+	localFilePath, err := getLocalRunFile(ctx, h.s3Repository, mcap.MatFiles[fileIndex])
+	if err != nil {
+		return nil, nil, NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	reader, err := utils.NewHDF5Reader(localFilePath)
+	if err != nil {
+		return nil, nil, NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	return reader, mcap, nil
+}
+
+// signalDataRequest holds the query params shared by the endpoints responding with signal data
+type signalDataRequest struct {
+	query      utils.SignalQuery
+	maxPoints  int
+	downsample func(times, values []float64, threshold int) ([]float64, []float64)
+	format     string
+}
+
+// parseSignalDataRequest reads the signals, start, end, max_points, downsample and format query params,
+// the segment query param needs the run and is read by runSegment
+func parseSignalDataRequest(queryParams url.Values) (*signalDataRequest, *HandlerError) {
+	query, handlerErr := parseSignalQuery(queryParams)
+	if handlerErr != nil {
+		return nil, handlerErr
+	}
+	request := &signalDataRequest{
+		query:      *query,
+		downsample: utils.DownsampleLTTB,
+	}
+
+	var err error
+	if queryParams.Has("max_points") {
+		request.maxPoints, err = strconv.Atoi(queryParams.Get("max_points"))
+		if err != nil || request.maxPoints < 0 {
+			return nil, NewHandlerError(fmt.Sprintf("invalid max_points %v", queryParams.Get("max_points")), http.StatusBadRequest)
+		}
+	}
+
+	switch queryParams.Get("downsample") {
+	case "", "lttb":
+	case "minmax":
+		request.downsample = utils.DownsampleMinMax
+	default:
+		return nil, NewHandlerError("invalid downsample, must be lttb or minmax", http.StatusBadRequest)
+	}
+
+	request.format = queryParams.Get("format")
+	if request.format != "" && request.format != "json" && request.format != "binary" {
+		return nil, NewHandlerError("invalid format, must be json or binary", http.StatusBadRequest)
+	}
+
+	return request, nil
+}
+
+// parseSignalQuery reads the signals, start and end query params shared by every endpoint reading signals of a run
+func parseSignalQuery(queryParams url.Values) (*utils.SignalQuery, *HandlerError) {
+	signalsParam := queryParams.Get("signals")
+	if signalsParam == "" {
+		return nil, NewHandlerError("invalid request, must pass in query param signals with a value of comma seperated signal paths", http.StatusBadRequest)
+	}
+	query := &utils.SignalQuery{Signals: strings.Split(signalsParam, ",")}
+
+	var err error
+	if queryParams.Has("start") {
+		query.Start, err = strconv.ParseFloat(queryParams.Get("start"), 64)
+		if err != nil {
+			return nil, NewHandlerError(fmt.Sprintf("invalid start %v: %v", queryParams.Get("start"), err), http.StatusBadRequest)
+		}
+	}
+
+	if queryParams.Has("end") {
+		query.End, err = strconv.ParseFloat(queryParams.Get("end"), 64)
+		if err != nil {
+			return nil, NewHandlerError(fmt.Sprintf("invalid end %v: %v", queryParams.Get("end"), err), http.StatusBadRequest)
+		}
+		if query.End < query.Start {
+			return nil, NewHandlerError("invalid request, end cannot come before start", http.StatusBadRequest)
+		}
+	}
+
+	return query, nil
+}
+
+// writeSignalData downsamples allSeries if the request asks for it and responds with them in the requested format
+func writeSignalData(w http.ResponseWriter, r *http.Request, allSeries []*utils.SignalSeries, request *signalDataRequest) {
+	if request.maxPoints > 0 {
+		for _, series := range allSeries {
+			series.Times, series.Values = request.downsample(series.Times, series.Values, request.maxPoints)
+		}
+	}
+
+	if request.format == "binary" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		err := writeSignalSeriesBinary(w, allSeries)
+		if err != nil {
+			log.Printf("could not write binary signal data: %v", err)
+		}
+		return
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("read %d signals", len(allSeries))
+	response["data"] = allSeries
+
+	render.JSON(w, r, response)
+}
+
+// runSegment finds the segment of run with the id of the segment query param, it is nil if there is no segment query param.
+// A segment replaces the start and end query params, which can't be passed with it.
+func runSegment(queryParams url.Values, run *models.VehicleRunModel) (*models.SegmentModel, *HandlerError) {
+	if !queryParams.Has("segment") {
+		return nil, nil
+	}
+	if queryParams.Has("start") || queryParams.Has("end") {
+		return nil, NewHandlerError("invalid request, segment cannot be passed with start or end", http.StatusBadRequest)
+	}
+
+	segmentId, err := strconv.Atoi(queryParams.Get("segment"))
+	if err != nil {
+		return nil, NewHandlerError(fmt.Sprintf("invalid segment %v", queryParams.Get("segment")), http.StatusBadRequest)
+	}
+
+	for i := range run.Segments {
+		if run.Segments[i].Id == segmentId {
+			return &run.Segments[i], nil
+		}
+	}
+	return nil, NewHandlerError(fmt.Sprintf("no segment with id %d found in run", segmentId), http.StatusNotFound)
+}
+
+// ExportSignalsFromID takes in an ID from a URL param and exports the requested signals as a CSV or Parquet table,
+// for the people analyzing runs with pandas or Excel instead of MATLAB.
+// Query params:
+//   - signals: comma seperated signal paths (VehicleData.current_rpms.FL,VNData.vn_gps.lat)
+//   - start, end: time window in seconds relative to the start of the run (optional)
+//   - segment: id of a segment of the run whose time window is used instead of start and end (optional)
+//   - format: "csv" (default) or "parquet"
+//   - layout: "wide" (default), a time column and a column per signal, or "long", a time, signal and value column
+//   - rate: resamples the signals at rate Hz so every row of the wide layout has a value of each signal (optional)
+//   - method: resample method used with rate, "linear" (default), "zoh" or "nearest"
+//   - save: "true" saves the export to S3 as an exports content file of the run and responds with its signed url,
+//     by default the export is streamed back as a download
+func (h *mcapHandler) ExportSignalsFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+	queryParams := r.URL.Query()
+
+	query, handlerErr := parseSignalQuery(queryParams)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	var err error
+	format := utils.ExportCSV
+	if queryParams.Has("format") {
+		format, err = utils.ParseExportFormat(queryParams.Get("format"))
+		if err != nil {
+			return NewHandlerError(err.Error(), http.StatusBadRequest)
+		}
+	}
+
+	layout := utils.ExportWide
+	if queryParams.Has("layout") {
+		layout, err = utils.ParseExportLayout(queryParams.Get("layout"))
+		if err != nil {
+			return NewHandlerError(err.Error(), http.StatusBadRequest)
+		}
+	}
+
+	var rate float64
+	if queryParams.Has("rate") {
+		rate, err = strconv.ParseFloat(queryParams.Get("rate"), 64)
+		if err != nil || rate <= 0 || rate > subscribers.MaxInterpolationRate {
+			return NewHandlerError(fmt.Sprintf("invalid rate %v, must be between 0 and %v Hz", queryParams.Get("rate"), subscribers.MaxInterpolationRate), http.StatusBadRequest)
+		}
+	}
+
+	method := subscribers.ResampleLinear
+	if queryParams.Has("method") {
+		method, err = subscribers.ParseResampleMethod(queryParams.Get("method"))
+		if err != nil {
+			return NewHandlerError(err.Error(), http.StatusBadRequest)
+		}
+	}
+
+	mcapId := chi.URLParam(r, "id")
+	if mcapId == "" {
+		return NewHandlerError("invalid request, must pass in mcap id", http.StatusBadRequest)
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(mcapId)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not decode mcap id %v, %v", mcapId, err), http.StatusInternalServerError)
+	}
+
+	mcap, err := h.dbClient.VehicleRunUseCase().GetVehicleRunById(ctx, objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no run with id %v found", mcapId), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	segment, handlerErr := runSegment(queryParams, mcap)
+	if handlerErr != nil {
+		return handlerErr
+	}
+	if segment != nil {
+		query.Start, query.End = segment.Start, segment.End
+	}
+
+	if len(mcap.McapFiles) == 0 {
+		return NewHandlerError("no mcap files found", http.StatusFailedDependency)
+	}
+
+	localFilePath, err := getLocalRunFile(ctx, h.s3Repository, mcap.McapFiles[0])
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
 	}
 
-	utcFormat := "2006-01-02T15:04:05Z07:00"
-	if queryParams.Has("before_date") {
-		beforeDate := queryParams.Get("before_date")
-		parsedBeforeDate, err := time.Parse(utcFormat, beforeDate)
-		if err == nil {
-			filters.BeforeDate = &parsedBeforeDate
-		}
+	mcapFile, err := os.Open(localFilePath)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not open mcap file: %v", err), http.StatusInternalServerError)
 	}
+	defer mcapFile.Close()
 
-	if queryParams.Has("after_date") {
-		afterDate := queryParams.Get("after_date")
-		parsedAfterDate, err := time.Parse(utcFormat, afterDate)
-		if err == nil {
-			filters.AfterDate = &parsedAfterDate
-		}
+	allSeries, err := utils.NewMcapUtils().QuerySignals(mcapFile, *query)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusBadRequest)
 	}
 
-	if queryParams.Has("location") {
-		location := queryParams.Get("location")
-		filters.Location = &location
+	if rate > 0 {
+		for i, series := range allSeries {
+			allSeries[i] = subscribers.ResampleSeries(series, rate, method)
+		}
 	}
 
-	if queryParams.Has("event_type") {
-		eventType := queryParams.Get("event_type")
-		filters.EventType = &eventType
+	exportName := fmt.Sprintf("%s_export_%s.%s", strings.TrimSuffix(mcap.McapFiles[0].FileName, ".mcap"), time.Now().Format("20060102_150405"), format)
+
+	if queryParams.Get("save") != "true" {
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportName))
+		err = utils.WriteSignalTable(w, format, layout, allSeries)
+		if err != nil {
+			log.Printf("could not write signal export: %v", err)
+		}
+		return nil
 	}
 
-	if queryParams.Has("car_model") {
-		carModel := queryParams.Get("car_model")
-		filters.CarModel = &carModel
+	// The export is written to a temporary file first since S3 needs to know the size of what it uploads
+	exportFile, err := os.CreateTemp("", "export_*."+string(format))
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not create export file: %v", err), http.StatusInternalServerError)
 	}
+	defer os.Remove(exportFile.Name())
+	defer exportFile.Close()
 
-	if queryParams.Has("search_text") {
-		search_text := queryParams.Get("search_text")
-		filters.SearchText = &search_text
+	err = utils.WriteSignalTable(exportFile, format, layout, allSeries)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not write signal export: %v", err), http.StatusInternalServerError)
+	}
+	_, err = exportFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
 	}
 
-	if queryParams.Has("mps_function") {
-		mps_function := queryParams.Get("mps_function")
-		filters.MpsFunction = &mps_function
+	s3Key := fmt.Sprintf("%s/exports/%s", objectId.Hex(), exportName)
+	err = h.s3Repository.WriteObjectReader(ctx, exportFile, s3Key)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("Failed to upload to S3: "+err.Error()), http.StatusInternalServerError)
 	}
 
-	resModels, err := h.dbClient.VehicleRunUseCase().GetVehicleRunByFilters(ctx, &filters)
+	_, err = h.dbClient.VehicleRunUseCase().AddExportFile(ctx, objectId, h.s3Repository.Bucket(), exportName, s3Key)
 	if err != nil {
-		log.Fatal(err)
+		return NewHandlerError(fmt.Sprintf("Failed to save export to vehicle run: "+err.Error()), http.StatusInternalServerError)
 	}
 
-	res := make([]models.VehicleRunModelResponse, len(resModels))
-	for idx, model := range resModels {
-		res[idx] = models.VehicleRunSerialize(ctx, h.s3Repository, model)
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("exported %d signals", len(allSeries))
+	response["data"] = models.FileModelResponse{
+		SignedUrl: h.s3Repository.GetSignedUrl(ctx, h.s3Repository.Bucket(), s3Key),
+		FileName:  exportName,
 	}
 
-	data := make(map[string]interface{})
-	data["data"] = res
-	data["message"] = make(map[string]interface{})
-	render.JSON(w, r, data)
+	render.JSON(w, r, response)
+	return nil
 }
 
-// GetMcapFromID takes in an ID from a URL param and responds with an MCAP with that ID.
-func (h *mcapHandler) GetMcapFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+// GetSignalPreviewFromID takes in an ID from a URL param and responds with pre-computed min/max/mean buckets of the
+// requested signals. The pyramid level is picked so that there is about one bucket per pixel in the window.
+// Query params:
+//   - signals: comma seperated signal paths (VehicleData.current_rpms.FL,VNData.vn_gps.lat)
+//   - start, end: time window in seconds relative to the start of the run (optional)
+//   - segment: id of a segment of the run whose time window is used instead of start and end (optional)
+//   - width: the width of the plot in pixels (defaults to 1000)
+func (h *mcapHandler) GetSignalPreviewFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
 	ctx := r.Context()
+	queryParams := r.URL.Query()
+
+	signalsParam := queryParams.Get("signals")
+	if signalsParam == "" {
+		return NewHandlerError("invalid request, must pass in query param signals with a value of comma seperated signal paths", http.StatusBadRequest)
+	}
+	signals := strings.Split(signalsParam, ",")
+
+	var err error
+	start := 0.0
+	if queryParams.Has("start") {
+		start, err = strconv.ParseFloat(queryParams.Get("start"), 64)
+		if err != nil {
+			return NewHandlerError(fmt.Sprintf("invalid start %v: %v", queryParams.Get("start"), err), http.StatusBadRequest)
+		}
+	}
+
+	width := 1000
+	if queryParams.Has("width") {
+		width, err = strconv.Atoi(queryParams.Get("width"))
+		if err != nil || width <= 0 {
+			return NewHandlerError(fmt.Sprintf("invalid width %v", queryParams.Get("width")), http.StatusBadRequest)
+		}
+	}
 
 	mcapId := chi.URLParam(r, "id")
 	if mcapId == "" {
@@ -209,15 +1131,94 @@ func (h *mcapHandler) GetMcapFromID(w http.ResponseWriter, r *http.Request) *Han
 		}
 		return NewHandlerError(err.Error(), http.StatusInternalServerError)
 	}
-	responseMcap := models.VehicleRunSerialize(ctx, h.s3Repository, *mcap)
-	data := make([]models.VehicleRunModelResponse, 1)
-	data[0] = responseMcap
+
+	segment, handlerErr := runSegment(queryParams, mcap)
+	if handlerErr != nil {
+		return handlerErr
+	}
+	if segment != nil {
+		start = segment.Start
+	}
+
+	previewFiles := mcap.ContentFiles["preview_pyramid"]
+	if len(previewFiles) == 0 {
+		return NewHandlerError("no preview found for run, use the data endpoint instead", http.StatusFailedDependency)
+	}
+
+	localFilePath, err := getLocalRunFile(ctx, h.s3Repository, previewFiles[0])
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	previewFile, err := os.Open(localFilePath)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not open preview file: %v", err), http.StatusInternalServerError)
+	}
+	defer previewFile.Close()
+
+	previewReader, err := utils.NewPreviewReader(previewFile)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	end := previewReader.Duration()
+	if segment != nil {
+		end = segment.End
+	} else if queryParams.Has("end") {
+		end, err = strconv.ParseFloat(queryParams.Get("end"), 64)
+		if err != nil {
+			return NewHandlerError(fmt.Sprintf("invalid end %v: %v", queryParams.Get("end"), err), http.StatusBadRequest)
+		}
+	}
+	if end < start {
+		return NewHandlerError("invalid request, end cannot come before start", http.StatusBadRequest)
+	}
+
+	tiles := make([]*utils.PreviewTile, len(signals))
+	for i, signal := range signals {
+		tiles[i], err = previewReader.ReadTile(signal, start, end, width)
+		if err != nil {
+			return NewHandlerError(err.Error(), http.StatusBadRequest)
+		}
+	}
 
 	response := make(map[string]interface{})
-	response["message"] = ""
-	response["data"] = data
+	response["message"] = fmt.Sprintf("read %d signal previews", len(tiles))
+	response["data"] = tiles
 
 	render.JSON(w, r, response)
+	return nil
+}
+
+// writeSignalSeriesBinary writes signal series in a compact little-endian binary layout:
+//
+//	uint32 number of signals
+//	for every signal:
+//	  uint16 length of the signal path, followed by the path bytes
+//	  uint32 number of points (n)
+//	  n float64 times, followed by n float64 values
+func writeSignalSeriesBinary(w io.Writer, allSeries []*utils.SignalSeries) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(allSeries))); err != nil {
+		return err
+	}
+
+	for _, series := range allSeries {
+		if err := binary.Write(w, binary.LittleEndian, uint16(len(series.Path))); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(series.Path)); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, uint32(len(series.Times))); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, series.Times); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, series.Values); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -230,10 +1231,16 @@ func (h *mcapHandler) UploadMcap(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.MultipartForm.RemoveAll()
 
+	processor, err := parseMcapUploadOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	file := r.MultipartForm.File["file"]
 	jobIds := make([]string, 1, len(file))
 	fileHeader := file[0]
-	job, err := h.fileProcessor.EnqueueFile(fileHeader, &background.PostProcessMCAPUploadJob{})
+	job, err := h.fileProcessor.EnqueueFile(fileHeader, processor)
 	if err != nil {
 		log.Printf("Failed to queue file %s: %v", fileHeader.Filename, err)
 		return
@@ -255,10 +1262,16 @@ func (h *mcapHandler) BulkUploadMcaps(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.MultipartForm.RemoveAll()
 
+	processor, err := parseMcapUploadOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	files := r.MultipartForm.File["files"]
 	jobIds := make([]string, 0, len(files))
 	for _, fileHeader := range files {
-		job, err := h.fileProcessor.EnqueueFile(fileHeader, &background.PostProcessMCAPUploadJob{})
+		job, err := h.fileProcessor.EnqueueFile(fileHeader, processor)
 		if err != nil {
 			log.Printf("Failed to queue file %s: %v", fileHeader.Filename, err)
 			continue
@@ -273,6 +1286,79 @@ func (h *mcapHandler) BulkUploadMcaps(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, response)
 }
 
+// parseMcapUploadOptions reads the optional query params of an upload:
+//   - car_model is the car the run was logged on, HT09 by default, its parameter set in effect on the date of the run is used
+//   - interpolation_rate (Hz) and interpolation_method (linear, zoh or nearest) control how the interpolated HDF5 and MCAP files are generated
+//   - mat_file=true also creates a MATLAB .mat file of the run
+//   - hdf5_layout (1 or 2) is the layout of the raw HDF5 file, 1 (chunk groups) is the default since the MPS scripts read it
+//   - voltage_signal and current_signal are the accumulator signals of the energy report, like ACUCoreData.pack_voltage
+//   - spectrum_signals are the comma seperated signal paths whose power spectral density is computed, like VNData.vn_linear_accel_m_ss.z
+//   - throttle_signal, brake_signal and steering_signal are the signals of the driver input report, like VehicleData.pedals_system_data.accel_percent
+func parseMcapUploadOptions(queryParams url.Values) (*background.PostProcessMCAPUploadJob, error) {
+	processor := &background.PostProcessMCAPUploadJob{
+		CarModel: queryParams.Get("car_model"),
+	}
+
+	if queryParams.Has("interpolation_rate") {
+		rate, err := strconv.ParseFloat(queryParams.Get("interpolation_rate"), 64)
+		if err != nil || rate <= 0 || rate > subscribers.MaxInterpolationRate {
+			return nil, fmt.Errorf("interpolation_rate must be a number between 0 and %v", subscribers.MaxInterpolationRate)
+		}
+		processor.InterpolationRate = rate
+	}
+
+	if queryParams.Has("interpolation_method") {
+		method, err := subscribers.ParseResampleMethod(queryParams.Get("interpolation_method"))
+		if err != nil {
+			return nil, err
+		}
+		processor.InterpolationMethod = method
+	}
+
+	if queryParams.Has("mat_file") {
+		writeMatFile, err := strconv.ParseBool(queryParams.Get("mat_file"))
+		if err != nil {
+			return nil, fmt.Errorf("mat_file must be true or false")
+		}
+		processor.WriteMatFile = writeMatFile
+	}
+
+	if queryParams.Has("hdf5_layout") {
+		layoutVersion, err := strconv.Atoi(queryParams.Get("hdf5_layout"))
+		if err != nil || (layoutVersion != utils.HDF5LayoutChunked && layoutVersion != utils.HDF5LayoutSignals) {
+			return nil, fmt.Errorf("hdf5_layout must be %d or %d", utils.HDF5LayoutChunked, utils.HDF5LayoutSignals)
+		}
+		processor.HDF5LayoutVersion = layoutVersion
+	}
+
+	for param, signal := range map[string]*string{
+		"voltage_signal":  &processor.EnergySignals.Voltage,
+		"current_signal":  &processor.EnergySignals.Current,
+		"throttle_signal": &processor.DriverInputSignals.Throttle,
+		"brake_signal":    &processor.DriverInputSignals.Brake,
+		"steering_signal": &processor.DriverInputSignals.Steering,
+	} {
+		if !queryParams.Has(param) {
+			continue
+		}
+		if !strings.Contains(queryParams.Get(param), ".") {
+			return nil, fmt.Errorf("%s must be a signal path like ACUCoreData.pack_voltage", param)
+		}
+		*signal = queryParams.Get(param)
+	}
+
+	if queryParams.Has("spectrum_signals") {
+		for _, signal := range strings.Split(queryParams.Get("spectrum_signals"), ",") {
+			if !strings.Contains(signal, ".") {
+				return nil, fmt.Errorf("spectrum_signals must be comma seperated signal paths like VNData.vn_linear_accel_m_ss.z")
+			}
+			processor.SpectrumSignals = append(processor.SpectrumSignals, signal)
+		}
+	}
+
+	return processor, nil
+}
+
 // DeleteMcapFromID takes in an ID from a URL param and deletes the MCAP information from MongoDB and from S3.
 func (h *mcapHandler) DeleteMcapFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
 	ctx := r.Context()
@@ -323,9 +1409,20 @@ func (h *mcapHandler) DeleteMcapFromID(w http.ResponseWriter, r *http.Request) *
 		return NewHandlerError(err.Error(), http.StatusInternalServerError)
 	}
 
+	err = h.dbClient.EventUseCase().DeleteRunEvents(ctx, objectId)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	if vehicleModel.Usage != nil {
+		h.recountCarUsage(ctx, vehicleModel.CarModel)
+	}
+
 	return nil
 }
 
+// ProcessMatlabJob submits MPS jobs running the comma seperated scripts query param of the version query param on the HDF5 file of the run.
+// With the segment query param, the start and end times of that segment of the run are passed to the scripts too.
 func (h *mcapHandler) ProcessMatlabJob(w http.ResponseWriter, r *http.Request) *HandlerError {
 	ctx := r.Context()
 
@@ -357,6 +1454,12 @@ func (h *mcapHandler) ProcessMatlabJob(w http.ResponseWriter, r *http.Request) *
 		}
 		return NewHandlerError(err.Error(), http.StatusInternalServerError)
 	}
+
+	segment, handlerErr := runSegment(r.URL.Query(), mcap)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
 	responseMcap := models.VehicleRunSerialize(ctx, h.s3Repository, *mcap)
 
 	matFiles := responseMcap.MatFiles
@@ -366,7 +1469,7 @@ func (h *mcapHandler) ProcessMatlabJob(w http.ResponseWriter, r *http.Request) *
 	}
 
 	for _, script := range scripts {
-		h.mpsClient.SubmitMatlabJob(ctx, h.s3Repository, mcapId, versionParam, script)
+		h.mpsClient.SubmitMatlabJob(ctx, h.s3Repository, mcapId, versionParam, script, segment)
 	}
 
 	render.JSON(w, r, "jobs submitted")
@@ -374,6 +1477,51 @@ func (h *mcapHandler) ProcessMatlabJob(w http.ResponseWriter, r *http.Request) *
 	return nil
 }
 
+// ReprocessDerivedChannelsFromID takes in an ID from a URL param and enqueues a job evaluating the current derived channel
+// registry against the MCAP file of the run, which replaces its HDF5 files, preview pyramid and signal catalog.
+// It accepts the hdf5_layout, interpolation_rate and interpolation_method query params of an upload.
+func (h *mcapHandler) ReprocessDerivedChannelsFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+
+	options, err := parseMcapUploadOptions(r.URL.Query())
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusBadRequest)
+	}
+
+	mcapId := chi.URLParam(r, "id")
+	if mcapId == "" {
+		return NewHandlerError("invalid request, must pass in mcap id", http.StatusBadRequest)
+	}
+
+	objectId, err := primitive.ObjectIDFromHex(mcapId)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not decode mcap id %v, %v", mcapId, err), http.StatusInternalServerError)
+	}
+
+	mcap, err := h.dbClient.VehicleRunUseCase().GetVehicleRunById(ctx, objectId)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no run with id %v found", mcapId), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	if len(mcap.McapFiles) == 0 || len(mcap.MatFiles) == 0 {
+		return NewHandlerError("no mcap or h5 files found", http.StatusFailedDependency)
+	}
+
+	processor := &background.ReprocessDerivedChannelsJob{RunId: objectId, Options: options}
+	mcapFile := mcap.McapFiles[0]
+	job := h.fileProcessor.EnqueueRunFile(mcapFile.FileName, mcapFile.FileSize, mcap.Date, processor)
+
+	response := make(map[string]interface{})
+	response["message"] = "created run reprocessing job"
+	response["data"] = []string{job.ID}
+
+	render.JSON(w, r, response)
+	return nil
+}
+
 // UpdateMetadataRecordFromID takes in an ID from a URL param and formdata that determines which metadata to update in our VehicleRunModels.
 func (h *mcapHandler) UpdateMetadataRecordFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
 	ctx := r.Context()
@@ -397,6 +1545,7 @@ func (h *mcapHandler) UpdateMetadataRecordFromID(w http.ResponseWriter, r *http.
 	if err != nil {
 		return NewHandlerError(fmt.Sprintf("could not get vehicle run by id %v, %v", mcapId, err), http.StatusInternalServerError)
 	}
+	previousCarModel, previousDate := runModel.CarModel, runModel.Date
 
 	for key, values := range r.Form {
 		if strings.HasPrefix(key, "mps.") {
@@ -437,6 +1586,7 @@ func (h *mcapHandler) UpdateMetadataRecordFromID(w http.ResponseWriter, r *http.
 	if err != nil {
 		return NewHandlerError(err.Error(), http.StatusInternalServerError)
 	}
+	h.recountMovedRunUsage(ctx, runModel, previousCarModel, previousDate)
 
 	return nil
 }
@@ -462,6 +1612,7 @@ func (h *mcapHandler) ResetMetadataRecordFromID(w http.ResponseWriter, r *http.R
 	if err != nil {
 		return NewHandlerError(fmt.Sprintf("could not get vehicle run by id %v, %v", mcapId, err), http.StatusInternalServerError)
 	}
+	previousCarModel, previousDate := runModel.CarModel, runModel.Date
 
 	switch metadata {
 	case "date":
@@ -485,6 +1636,96 @@ func (h *mcapHandler) ResetMetadataRecordFromID(w http.ResponseWriter, r *http.R
 	if err != nil {
 		return NewHandlerError(err.Error(), http.StatusInternalServerError)
 	}
+	h.recountMovedRunUsage(ctx, runModel, previousCarModel, previousDate)
+	return nil
+}
+
+// recountMovedRunUsage counts the usage counters of the cars of run again if it moved to another car or date,
+// the counters only keep the runs by increments when they are ingested
+func (h *mcapHandler) recountMovedRunUsage(ctx context.Context, run *models.VehicleRunModel, previousCarModel string, previousDate time.Time) {
+	if run.Usage == nil || (run.CarModel == previousCarModel && run.Date.Equal(previousDate)) {
+		return
+	}
+	h.recountCarUsage(ctx, previousCarModel)
+	if run.CarModel != previousCarModel {
+		h.recountCarUsage(ctx, run.CarModel)
+	}
+}
+
+// recountCarUsage counts the usage counters of carModel again from its runs, a failure is logged since the run is already updated
+func (h *mcapHandler) recountCarUsage(ctx context.Context, carModel string) {
+	_, err := h.dbClient.CarUsageUseCase().RecountCarUsage(ctx, carModel)
+	if err != nil {
+		log.Printf("could not recount the usage of %v: %v", carModel, err)
+	}
+}
+
+// AddTagsFromID adds the tags of the comma seperated tags form field to a run and responds with the updated run.
+// Tags the run already has are kept once.
+func (h *mcapHandler) AddTagsFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		return NewHandlerError("error parsing form data", http.StatusBadRequest)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	mcapId := chi.URLParam(r, "id")
+	objectId, err := primitive.ObjectIDFromHex(mcapId)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not decode mcap id %v, %v", mcapId, err), http.StatusInternalServerError)
+	}
+
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(r.FormValue("tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return NewHandlerError("invalid request, must pass in form field tags with a value of comma seperated tags", http.StatusBadRequest)
+	}
+
+	runModel, err := h.dbClient.VehicleRunUseCase().AddTags(ctx, objectId, tags)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no run with id %v found", mcapId), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("added %d tags to run %v", len(tags), mcapId)
+	response["data"] = models.VehicleRunSerialize(ctx, h.s3Repository, *runModel)
+	render.JSON(w, r, response)
+	return nil
+}
+
+// RemoveTagFromID removes the tag URL param from a run and responds with the updated run
+func (h *mcapHandler) RemoveTagFromID(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+	mcapId := chi.URLParam(r, "id")
+	objectId, err := primitive.ObjectIDFromHex(mcapId)
+	if err != nil {
+		return NewHandlerError(fmt.Sprintf("could not decode mcap id %v, %v", mcapId, err), http.StatusInternalServerError)
+	}
+
+	tag := chi.URLParam(r, "tag")
+	if tag == "" {
+		return NewHandlerError("invalid request, must pass in tag", http.StatusBadRequest)
+	}
+
+	runModel, err := h.dbClient.VehicleRunUseCase().RemoveTag(ctx, objectId, tag)
+	if err != nil {
+		if err.Error() == "mongo: no documents in result" {
+			return NewHandlerError(fmt.Sprintf("no run with id %v found", mcapId), http.StatusNotFound)
+		}
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("removed tag %v from run %v", tag, mcapId)
+	response["data"] = models.VehicleRunSerialize(ctx, h.s3Repository, *runModel)
+	render.JSON(w, r, response)
 	return nil
 }
 
@@ -512,3 +1753,39 @@ func (h *mcapHandler) CheckFileStatus(w http.ResponseWriter, r *http.Request) *H
 	render.JSON(w, r, data)
 	return nil
 }
+
+// getLocalRunFile returns the path to a local copy of a file stored on S3.
+// If the file is not in the local cache yet, or the cached copy does not have the size of the file on S3
+// (it was cut short while being copied), it is downloaded into it.
+func getLocalRunFile(ctx context.Context, s3Repository *s3.S3Repository, file models.FileModel) (string, error) {
+	localFilePath := utils.RunFileCacheDirectory + file.FilePath
+	info, err := os.Stat(localFilePath)
+	if err == nil {
+		size := file.FileSize
+		if size == 0 {
+			size, err = s3Repository.ObjectSize(ctx, file.AwsBucket, file.FilePath)
+			if err != nil {
+				return "", fmt.Errorf("error checking file on s3: %v", err)
+			}
+		}
+		if info.Size() == size {
+			return localFilePath, nil
+		}
+		log.Printf("cached file %v has %d bytes instead of %d, downloading it again", localFilePath, info.Size(), size)
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("error reading cached file: %v", err)
+	}
+
+	// The file is downloaded next to the cache and moved into it once complete, so a failed download is never served
+	downloadFilePath := fmt.Sprintf("%s.%d.download", localFilePath, time.Now().UnixNano())
+	err = s3Repository.DownloadObject(ctx, file.AwsBucket, file.FilePath, downloadFilePath)
+	if err != nil {
+		os.Remove(downloadFilePath)
+		return "", fmt.Errorf("error downloading file from s3: %v", err)
+	}
+	if err = os.Rename(downloadFilePath, localFilePath); err != nil {
+		return "", fmt.Errorf("error caching file downloaded from s3: %v", err)
+	}
+
+	return localFilePath, nil
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/database"
+)
+
+// signalsHandler handles all requests related to the signals logged across runs
+type signalsHandler struct {
+	dbClient *database.DatabaseClient
+}
+
+func NewSignalsHandler(
+	r *chi.Mux,
+	dbClient *database.DatabaseClient,
+) {
+	handler := &signalsHandler{
+		dbClient: dbClient,
+	}
+
+	r.Route("/signals", func(r chi.Router) {
+		r.Get("/", HandlerFunc(handler.GetSignalCatalog).ServeHTTP)
+	})
+}
+
+// GetSignalCatalog responds with every signal found across all runs and how many runs contain it.
+// It accepts the same query parameters as the run filters so the catalog can be narrowed down (e.g. by car_model or date).
+func (h *signalsHandler) GetSignalCatalog(w http.ResponseWriter, r *http.Request) *HandlerError {
+	ctx := r.Context()
+	filters := parseVehicleRunFilters(r.URL.Query())
+
+	signals, err := h.dbClient.VehicleRunUseCase().GetSignalCatalog(ctx, &filters)
+	if err != nil {
+		return NewHandlerError(err.Error(), http.StatusInternalServerError)
+	}
+
+	response := make(map[string]interface{})
+	response["message"] = fmt.Sprintf("found %d signals", len(signals))
+	response["data"] = signals
+
+	render.JSON(w, r, response)
+	return nil
+}
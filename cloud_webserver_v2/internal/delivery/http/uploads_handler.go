@@ -38,6 +38,8 @@ func (handler *uploadHandler) GetUploadLimits(w http.ResponseWriter, r *http.Req
 	data["current_file_size"] = currentFileSize
 	data["max_file_size"] = maxFileSize
 	data["available_file_size"] = maxFileSize - currentFileSize
+	data["hdf5_buffer_ceiling"] = handler.fileProcessor.HDF5BufferCeiling()
+	data["peak_hdf5_buffered_bytes"] = handler.fileProcessor.PeakHDF5BufferedBytes.Load()
 
 	response := make(map[string]interface{})
 	response["message"] = nil
@@ -2,12 +2,16 @@ package messaging
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"math"
+	"os"
 	"reflect"
 
+	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
 
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
 
 	"github.com/hytech-racing/cloud-webserver-v2/internal/messaging/subscribers"
@@ -21,14 +25,29 @@ Lots of the internal logic for the subscribers lives in the messaging/subscriber
 */
 
 const (
-	EOF  = "EOF_MESSAGE"
-	INIT = "INIT_MESSAGE"
+	EOF          = "EOF_MESSAGE"
+	INIT         = "INIT_MESSAGE"
+	DECODE_ERROR = "DECODE_ERROR_MESSAGE"
 )
 
 const (
-	LATLON   = "vn_plot"
-	VELOCITY = "velocity_plot"
-	MATLAB   = "matlab_writer"
+	LATLON            = "vn_plot"
+	VELOCITY          = "velocity_plot"
+	MATLAB            = "matlab_writer"
+	INTERPOLATED      = "interpolated_matlab_writer"
+	INTERPOLATED_MCAP = "interpolated_mcap_writer"
+	MAT_FILE          = "mat_writer"
+	SIGNAL_CATALOG    = "signal_catalog"
+	PREVIEW           = "preview_pyramid"
+	DATA_QUALITY      = "data_quality"
+	GPS_TRACK         = "gps_track"
+	ENERGY            = "energy_report"
+	GG_DIAGRAM        = "gg_diagram"
+	USAGE             = "usage"
+	SEGMENTS          = "segments"
+	EVENTS            = "events"
+	SPECTRUM          = "spectrum"
+	DRIVER_INPUTS     = "driver_inputs"
 )
 
 // Subscriber function type serves as a common header for all subscribers to a publisher
@@ -129,16 +148,22 @@ func PlotLatLon(id int, subscriberName string, ch <-chan SubscribedMessage, resu
 	}
 }
 
+// PlotTimeVelocity plots the speed of the car computed from the rpm of the front right motor,
+// with the gearing and tires of the car_metrics of the INIT message
 func PlotTimeVelocity(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
 	times := make([]float64, 0)
 	vels := make([]float64, 0)
 	first := true
 	var initialTime uint64
 	minTime, maxTime, minVel, maxVel := math.MaxFloat64, math.SmallestNonzeroFloat64, math.MaxFloat64, math.SmallestNonzeroFloat64
+	carMetrics := subscribers.DefaultCarMetrics()
 
 	for msg := range ch {
 		if msg.GetContent().Topic == EOF {
 			break
+		} else if msg.GetContent().Topic == INIT {
+			carMetrics = runCarMetrics(msg.GetContent().Data)
+			continue
 		}
 
 		data := msg.GetContent().Data
@@ -159,7 +184,7 @@ func PlotTimeVelocity(id int, subscriberName string, ch <-chan SubscribedMessage
 
 			decodedFL := veh_vec_floatDynamicMessage.GetField(fl_Descriptor)
 			decodedFR := veh_vec_floatDynamicMessage.GetField(fr_Descriptor)
-			if decodedFL == nil || decodedFL == nil {
+			if decodedFL == nil || decodedFR == nil {
 				continue
 			}
 
@@ -185,7 +210,7 @@ func PlotTimeVelocity(id int, subscriberName string, ch <-chan SubscribedMessage
 			first = false
 		}
 
-		vel := subscribers.RPMToLinearVelocity(rpm)
+		vel := subscribers.RPMToLinearVelocity(rpm, carMetrics)
 		time := subscribers.LogTimeToTime(logTime, initialTime)
 
 		minVel = math.Min(minVel, vel)
@@ -211,101 +236,651 @@ func PlotTimeVelocity(id int, subscriberName string, ch <-chan SubscribedMessage
 	}
 }
 
+// CreateGPSTrack builds a GPX and a KML track of the vn_gps positions, named after the file name of the INIT message.
+// No files are written for runs without a GPS fix.
+func CreateGPSTrack(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	track := subscribers.NewGPSTrack("")
+	for msg := range ch {
+		if msg.GetContent().Topic == EOF {
+			break
+		} else if msg.GetContent().Topic == INIT {
+			if fileName, ok := msg.GetContent().Data["file_name"].(string); ok {
+				track = subscribers.NewGPSTrack(fileName)
+			}
+			continue
+		}
+
+		track.AddMessage(msg.GetContent())
+	}
+
+	result := make(map[string]interface{})
+	if track.NumPoints() > 0 {
+		result["gpx_writer_to"] = track.GPX()
+		result["kml_writer_to"] = track.KML()
+	}
+
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// CreateInterpolatedMatlabFile writes a HDF5 file with every numeric signal resampled onto a uniform time base.
+// The rate and resample method are read from the INIT message.
 func CreateInterpolatedMatlabFile(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
 	var matlabWriter *subscribers.InterpolatedMatlabWriter
 	for msg := range ch {
 		if msg.GetContent().Topic == EOF {
 			break
 		} else if msg.GetContent().Topic == INIT {
-			schema, err := getInterpolatedSchemaMap(&msg)
+			data := msg.GetContent().Data
+			fileName, okName := data["file_name"].(string)
+			filePath, okPath := data["file_path"].(string)
+			if !okName || !okPath {
+				log.Printf("could not start interpolated matlab worker: no file name or path given")
+				matlabWriter = nil
+				continue
+			}
+
+			rate, ok := data["interpolation_rate"].(float64)
+			if !ok {
+				rate = subscribers.DefaultInterpolationRate
+			}
+			method, ok := data["interpolation_method"].(subscribers.ResampleMethod)
+			if !ok {
+				method = subscribers.ResampleLinear
+			}
+
+			metadata, schemaVersions, budget := hdf5FileMetadata(data)
+			var err error
+			matlabWriter, err = subscribers.CreateInterpolatedMatlabWriter(filePath, fileName, rate, method, metadata, schemaVersions, budget)
 			if err != nil {
-				log.Panic("could not get mcap schema map")
+				log.Printf("could not start interpolated matlab worker: %v", err)
+				matlabWriter = nil
+				continue
 			}
-			matlabWriter = subscribers.CreateInterpolatedMatlabWriter(0.001, schema)
+			matlabWriter.WithSignalUnits(signalUnits(data))
 		} else {
 			if matlabWriter != nil {
-				matlabWriter.AddSignalValue(msg.GetContent())
+				err := matlabWriter.AddSignalValue(msg.GetContent())
+				if err != nil {
+					log.Printf("could not write interpolated matlab signal: %v", err)
+				}
 			}
 		}
 	}
 
+	result := make(map[string]interface{})
 	if matlabWriter != nil {
-		matlabWriter.InterpolateEndOfSignalSlices()
+		err := matlabWriter.Close()
+		if err != nil {
+			log.Printf("could not close interpolated hdf5 file: %v", err)
+		}
+		result["file_path"] = matlabWriter.FilePath()
+	}
+
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// CreateInterpolatedMcapFile writes a MCAP file with every topic resampled onto a uniform time base.
+// The rate and resample method are read from the INIT message.
+func CreateInterpolatedMcapFile(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	var mcapWriter *subscribers.InterpolatedMcapWriter
+	for msg := range ch {
+		if msg.GetContent().Topic == EOF {
+			break
+		} else if msg.GetContent().Topic == INIT {
+			data := msg.GetContent().Data
+			fileName, okName := data["file_name"].(string)
+			filePath, okPath := data["file_path"].(string)
+			if !okName || !okPath {
+				log.Printf("could not start interpolated mcap worker: no file name or path given")
+				mcapWriter = nil
+				continue
+			}
+
+			rate, ok := data["interpolation_rate"].(float64)
+			if !ok {
+				rate = subscribers.DefaultInterpolationRate
+			}
+			method, ok := data["interpolation_method"].(subscribers.ResampleMethod)
+			if !ok {
+				method = subscribers.ResampleLinear
+			}
+
+			var err error
+			mcapWriter, err = subscribers.CreateInterpolatedMcapWriter(filePath, fileName, rate, method)
+			if err != nil {
+				log.Printf("could not start interpolated mcap worker: %v", err)
+				mcapWriter = nil
+				continue
+			}
+		} else {
+			if mcapWriter != nil {
+				err := mcapWriter.AddSignalValue(msg.GetContent())
+				if err != nil {
+					log.Printf("could not write interpolated mcap signal: %v", err)
+				}
+			}
+		}
 	}
 
 	result := make(map[string]interface{})
-	allSignalData := matlabWriter.GetAllSignalData()
-	result["interpolated_data"] = &allSignalData
+	if mcapWriter != nil {
+		err := mcapWriter.Close()
+		if err != nil {
+			log.Printf("could not close interpolated mcap file: %v", err)
+		}
+		result["file_path"] = mcapWriter.FilePath()
+	}
 
 	if results != nil {
 		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
 	}
 }
 
+// CreateMatFile writes a MATLAB .mat file with a struct for every topic and a time and value vector for every numeric signal
+func CreateMatFile(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	var matWriter *utils.MatWriter
+	var matLocation string
+	var firstLogTime *uint64
+	for msg := range ch {
+		if msg.GetContent().Topic == EOF {
+			break
+		} else if msg.GetContent().Topic == INIT {
+			data := msg.GetContent().Data
+			fileName, okName := data["file_name"].(string)
+			filePath, okPath := data["file_path"].(string)
+			if !okName || !okPath {
+				log.Printf("could not start mat file worker: no file name or path given")
+				matWriter = nil
+				continue
+			}
+
+			_, _, budget := hdf5FileMetadata(data)
+			var err error
+			matWriter, err = utils.NewMatWriter(filePath, budget)
+			if err != nil {
+				log.Printf("could not start mat file worker: %v", err)
+				matWriter = nil
+				continue
+			}
+			matLocation = fmt.Sprintf("%s/%s.mat", filePath, fileName)
+			continue
+		}
+
+		if matWriter == nil {
+			continue
+		}
+
+		decodedMessage := msg.GetContent()
+		if firstLogTime == nil {
+			logTime := decodedMessage.LogTime
+			firstLogTime = &logTime
+		}
+		timestamp := float64(int64(decodedMessage.LogTime-*firstLogTime)) / 1e9
+
+		utils.WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+			if floatValue, ok := utils.SignalFloatValue(value); ok {
+				if err := matWriter.AddValue(path, timestamp, floatValue); err != nil {
+					log.Printf("could not write mat signal %s: %v", path, err)
+				}
+			}
+		})
+	}
+
+	result := make(map[string]interface{})
+	if matWriter != nil {
+		err := writeMatFile(matWriter, matLocation)
+		if err != nil {
+			log.Printf("could not write mat file: %v", err)
+		} else {
+			result["file_path"] = matLocation
+		}
+	}
+
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// writeMatFile writes the MAT file at location and removes the temporary files of matWriter.
+// A partially written file is removed, so a MAT file is only ever uploaded whole.
+func writeMatFile(matWriter *utils.MatWriter, location string) error {
+	defer func() {
+		if err := matWriter.Close(); err != nil {
+			log.Printf("could not close mat writer: %v", err)
+		}
+	}()
+
+	file, err := os.Create(location)
+	if err != nil {
+		return err
+	}
+	_, err = matWriter.WriteTo(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(location)
+		return err
+	}
+	return nil
+}
+
+// CreateRawMatlabFile writes every signal of the run into a HDF5 file.
+// The layout of the file is read from the hdf5_layout_version of the INIT message.
 func CreateRawMatlabFile(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
 	var matlabWriter *subscribers.RawMatlabWriter
-	var fileName string
-	var filePath string
 	for msg := range ch {
 		if msg.GetContent().Topic == EOF {
 			break
 		} else if msg.GetContent().Topic == INIT {
-			if name, exists := msg.GetContent().Data["file_name"]; exists {
-				fileName = name.(string)
-			} else {
-				break
+			data := msg.GetContent().Data
+			fileName, okName := data["file_name"].(string)
+			filePath, okPath := data["file_path"].(string)
+			if !okName || !okPath {
+				log.Printf("could not start matlab worker: no file name or path given")
+				matlabWriter = nil
+				continue
 			}
 
-			if path, exists := msg.GetContent().Data["file_path"]; exists {
-				filePath = path.(string)
-			} else {
-				break
+			layoutVersion, ok := data["hdf5_layout_version"].(int)
+			if !ok {
+				layoutVersion = utils.HDF5LayoutChunked
 			}
+
+			metadata, schemaVersions, budget := hdf5FileMetadata(data)
 			var err error
-			matlabWriter, err = subscribers.CreateRawMatlabWriter(filePath, fileName)
+			matlabWriter, err = subscribers.CreateRawMatlabWriter(filePath, fileName, layoutVersion, metadata, schemaVersions, budget)
 			if err != nil {
 				log.Printf("could not start matlab worker: %v", err)
-				break
+				matlabWriter = nil
+				continue
 			}
+			matlabWriter.WithSignalUnits(signalUnits(data))
 		} else {
 			if matlabWriter != nil {
 				err := matlabWriter.AddSignalValue(msg.GetContent())
 				if err != nil {
-					log.Fatal(err)
+					log.Printf("could not write raw hdf5 signal: %v", err)
 				}
 			}
 		}
 	}
 
-	if matlabWriter.MaxSignalLength() > 0 {
-		err := matlabWriter.HDF5Writer.ChunkWrite(matlabWriter.AllSignalData())
+	result := make(map[string]interface{})
+	if matlabWriter != nil {
+		err := matlabWriter.Close()
 		if err != nil {
-			log.Printf("could not chunk write hdf5 file: %v", err)
+			log.Printf("could not close hdf5 file: %v", err)
+		}
+		result["file_path"] = matlabWriter.FilePath()
+		result["failed_messages"] = len(matlabWriter.FailedMessages())
+	}
+
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// hdf5FileMetadata reads the run metadata and the schema versions for the attributes of a HDF5 file from the INIT message,
+// along with the buffer budget shared by the HDF5 writers of the job (nil if there is none)
+func hdf5FileMetadata(data map[string]interface{}) (map[string]interface{}, map[string]string, *utils.HDF5BufferBudget) {
+	metadata, ok := data["run_metadata"].(map[string]interface{})
+	if !ok {
+		metadata = make(map[string]interface{})
+	}
+	schemaVersions, ok := data["schema_versions"].(map[string]string)
+	if !ok {
+		schemaVersions = make(map[string]string)
+	}
+	budget, _ := data["hdf5_buffer_budget"].(*utils.HDF5BufferBudget)
+
+	return metadata, schemaVersions, budget
+}
+
+// runCarMetrics reads the vehicle parameters of the run from the INIT message, they are the defaults if there are none
+func runCarMetrics(data map[string]interface{}) models.CarMetricsModel {
+	carMetrics, ok := data["car_metrics"].(models.CarMetricsModel)
+	if !ok {
+		return subscribers.DefaultCarMetrics()
+	}
+	return carMetrics
+}
+
+// runStartTime reads the log time of the first message of the run from the run metadata of the INIT message
+func runStartTime(data map[string]interface{}) (uint64, bool) {
+	metadata, ok := data["run_metadata"].(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	startTime, ok := metadata["start_time_ns"].(int64)
+	if !ok {
+		return 0, false
+	}
+	return uint64(startTime), true
+}
+
+// signalUnits reads the units of the signals whose units can't be guessed from their name (derived channels) from the INIT message
+func signalUnits(data map[string]interface{}) map[string]string {
+	units, ok := data["signal_units"].(map[string]string)
+	if !ok {
+		return make(map[string]string)
+	}
+	return units
+}
+
+func CreateSignalCatalog(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	catalog := subscribers.NewSignalCatalog()
+	for msg := range ch {
+		if msg.GetContent().Topic == EOF {
+			break
+		} else if msg.GetContent().Topic == INIT {
+			catalog.WithSignalUnits(signalUnits(msg.GetContent().Data))
+			continue
 		}
 
-		err = matlabWriter.HDF5Writer.Close()
+		catalog.AddMessage(msg.GetContent())
+	}
+
+	result := make(map[string]interface{})
+	result["signals"] = catalog.Signals()
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// CreatePreviewPyramid builds a multi-resolution min/max/mean pyramid of every numeric signal for fast plotting.
+// Its times are measured from the start time of the run like the signal queries, so a tile can be zoomed into with them.
+func CreatePreviewPyramid(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	builder := utils.NewPreviewPyramidBuilder()
+	var firstLogTime *uint64
+	for msg := range ch {
+		if msg.GetContent().Topic == EOF {
+			break
+		} else if msg.GetContent().Topic == INIT {
+			if startTime, ok := runStartTime(msg.GetContent().Data); ok {
+				firstLogTime = &startTime
+			}
+			continue
+		}
+
+		logTime := msg.GetContent().LogTime
+		if firstLogTime == nil {
+			firstLogTime = &logTime
+		}
+		timestamp := float64(int64(logTime-*firstLogTime)) / 1e9
+
+		utils.WalkSignals(msg.GetContent(), func(path string, field *desc.FieldDescriptor, value interface{}) {
+			if floatValue, ok := utils.SignalFloatValue(value); ok {
+				builder.AddValue(path, timestamp, floatValue)
+			}
+		})
+	}
+
+	var writerTo io.WriterTo = builder
+	result := make(map[string]interface{})
+	result["writer_to"] = &writerTo
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// CreateDataQualityReport checks the messages for gaps, non-monotonic log times and decode failures.
+// Decode failures are sent to it as DECODE_ERROR messages containing the schema and error of the failed message.
+func CreateDataQualityReport(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	checker := subscribers.NewDataQualityChecker()
+	for msg := range ch {
+		content := msg.GetContent()
+		if content.Topic == EOF {
+			break
+		} else if content.Topic == INIT {
+			if topics, ok := content.Data["schema_list"].([]string); ok {
+				checker.SetDeclaredTopics(topics)
+			}
+		} else if content.Topic == DECODE_ERROR {
+			schema, _ := content.Data["schema"].(string)
+			decodeError, _ := content.Data["error"].(string)
+			checker.AddDecodeFailure(schema, content.LogTime, decodeError)
+		} else {
+			checker.AddMessage(content)
+		}
+	}
+
+	result := make(map[string]interface{})
+	result["report"] = checker.Report()
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// CreateEnergyReport integrates the accumulator power of the run into an energy report with the energy of every lap,
+// and plots the power over time. The voltage and current signals are the energy_signals of the INIT message and
+// the state of charge drop is estimated from the battery capacity of its car_metrics.
+// No report or plot is made for runs which did not log the voltage and current.
+func CreateEnergyReport(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	analyzer := subscribers.NewEnergyAnalyzer()
+	for msg := range ch {
+		content := msg.GetContent()
+		if content.Topic == EOF {
+			break
+		} else if content.Topic == INIT {
+			if signals, ok := content.Data["energy_signals"].(subscribers.EnergySignals); ok {
+				analyzer.WithSignals(signals)
+			}
+			analyzer.WithBatteryCapacity(runCarMetrics(content.Data).BatteryCapacity)
+			if startTime, ok := runStartTime(content.Data); ok {
+				analyzer.WithStartTime(startTime)
+			}
+			continue
+		}
+
+		analyzer.AddMessage(content)
+	}
+
+	result := make(map[string]interface{})
+	result["laps"] = analyzer.Laps()
+	if report := analyzer.Report(); report != nil {
+		result["report"] = report
+
+		writerTo, err := analyzer.PowerPlot()
 		if err != nil {
-			log.Printf("could not close hdf5 file: %v", err)
+			log.Println(err)
+		} else {
+			result["writer_to"] = writerTo
+		}
+	}
+
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// CreateGGDiagram plots the lateral against the longitudinal acceleration of the car and computes its acceleration envelope.
+// No diagram is made for runs which did not log the VectorNav accelerations.
+func CreateGGDiagram(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	diagram := subscribers.NewGGDiagram()
+	for msg := range ch {
+		if msg.GetContent().Topic == EOF {
+			break
+		} else if msg.GetContent().Topic == INIT {
+			continue
+		}
+
+		diagram.AddMessage(msg.GetContent())
+	}
+
+	result := make(map[string]interface{})
+	if envelope := diagram.Envelope(); envelope != nil {
+		result["envelope"] = envelope
+
+		writerTo, err := diagram.Plot(envelope)
+		if err != nil {
+			log.Println(err)
+		} else {
+			result["writer_to"] = writerTo
+		}
+	}
+
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// CreateUsageReport computes the distance driven, the time the motors were on and the max speeds of the run.
+// The wheel speed is computed with the car_metrics of the INIT message.
+func CreateUsageReport(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	tracker := subscribers.NewUsageTracker()
+	for msg := range ch {
+		if msg.GetContent().Topic == EOF {
+			break
+		} else if msg.GetContent().Topic == INIT {
+			tracker.WithCarMetrics(runCarMetrics(msg.GetContent().Data))
+			continue
 		}
+
+		tracker.AddMessage(msg.GetContent())
 	}
 
 	result := make(map[string]interface{})
-	result["file_path"] = matlabWriter.FilePath()
+	if usage := tracker.Usage(); usage != nil {
+		result["usage"] = usage
+	}
+
 	if results != nil {
 		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
 	}
 }
 
-func getInterpolatedSchemaMap(message *SubscribedMessage) (map[string]map[string][]float64, error) {
-	data := message.GetContent().Data
+// CreateSegments splits the run into idle, moving and charging segments from the speed and motor rpms of the car and
+// the accumulator current, which is the current of the energy_signals of the INIT message.
+func CreateSegments(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	segmenter := subscribers.NewSegmenter()
+	for msg := range ch {
+		content := msg.GetContent()
+		if content.Topic == EOF {
+			break
+		} else if content.Topic == INIT {
+			if signals, ok := content.Data["energy_signals"].(subscribers.EnergySignals); ok {
+				segmenter.WithSignals(signals)
+			}
+			segmenter.WithCarMetrics(runCarMetrics(content.Data))
+			if startTime, ok := runStartTime(content.Data); ok {
+				segmenter.WithStartTime(startTime)
+			}
+			continue
+		}
+
+		segmenter.AddMessage(content)
+	}
 
-	if schemas, found := data["schema_list"]; found {
-		if reflect.TypeOf(schemas) != reflect.SliceOf(reflect.TypeOf("")) {
-			return nil, fmt.Errorf("correct schema is not provided for matlab generation")
+	result := make(map[string]interface{})
+	result["segments"] = segmenter.Segments()
+
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// CreateEventTimeline finds the events of the run with the event_rules of the INIT message
+func CreateEventTimeline(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	var extractor *subscribers.EventExtractor
+	for msg := range ch {
+		content := msg.GetContent()
+		if content.Topic == EOF {
+			break
+		} else if content.Topic == INIT {
+			rules, _ := content.Data["event_rules"].([]models.EventRuleModel)
+			extractor = subscribers.NewEventExtractor(rules)
+			if startTime, ok := runStartTime(content.Data); ok {
+				extractor.WithStartTime(startTime)
+			}
+			continue
 		}
 
-		return utils.GetMcapSchemaMap(schemas.([]string))
+		if extractor != nil {
+			extractor.AddMessage(content)
+		}
+	}
+
+	result := make(map[string]interface{})
+	if extractor != nil {
+		result["events"] = extractor.Events()
+		result["event_counts"] = extractor.EventCounts()
 	}
 
-	return nil, fmt.Errorf("correct schema is not provided for matlab generation")
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// CreateSpectrum computes the power spectral density of the spectrum_signals of the INIT message, keeps their peak frequencies
+// and plots their spectra. No plot is made for runs which did not log enough samples of any of the signals.
+func CreateSpectrum(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	analyzer := subscribers.NewSpectrumAnalyzer()
+	for msg := range ch {
+		content := msg.GetContent()
+		if content.Topic == EOF {
+			break
+		} else if content.Topic == INIT {
+			if signals, ok := content.Data["spectrum_signals"].([]string); ok {
+				analyzer.WithSignals(signals)
+			}
+			if startTime, ok := runStartTime(content.Data); ok {
+				analyzer.WithStartTime(startTime)
+			}
+			continue
+		}
+
+		analyzer.AddMessage(content)
+	}
+
+	spectra := analyzer.Spectra()
+	result := make(map[string]interface{})
+	result["spectra"] = subscribers.SpectrumModels(spectra)
+
+	writerTo, err := subscribers.SpectrumPlot(spectra)
+	if err != nil {
+		log.Println(err)
+	} else if writerTo != nil {
+		result["writer_to"] = writerTo
+	}
+
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
+}
+
+// CreateDriverInputReport computes how the driver used the throttle, brake and steering over the run and on every lap.
+// The signals are the driver_input_signals of the INIT message.
+// No report is made for runs which did not log the throttle.
+func CreateDriverInputReport(id int, subscriberName string, ch <-chan SubscribedMessage, results chan<- SubscriberResult) {
+	analyzer := subscribers.NewDriverInputAnalyzer()
+	for msg := range ch {
+		content := msg.GetContent()
+		if content.Topic == EOF {
+			break
+		} else if content.Topic == INIT {
+			if signals, ok := content.Data["driver_input_signals"].(subscribers.DriverInputSignals); ok {
+				analyzer.WithSignals(signals)
+			}
+			if startTime, ok := runStartTime(content.Data); ok {
+				analyzer.WithStartTime(startTime)
+			}
+			continue
+		}
+
+		analyzer.AddMessage(content)
+	}
+
+	result := make(map[string]interface{})
+	if report := analyzer.Report(); report != nil {
+		result["report"] = report
+	}
+
+	if results != nil {
+		results <- SubscriberResult{SubscriberID: id, SubscriberName: subscriberName, ResultData: result}
+	}
 }
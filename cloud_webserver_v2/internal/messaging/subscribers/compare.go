@@ -0,0 +1,285 @@
+package subscribers
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+)
+
+// CompareAlign is the axis the runs of a comparison are aligned on
+type CompareAlign string
+
+const (
+	// CompareAlignTime aligns the runs on the time since the start of their window
+	CompareAlignTime CompareAlign = "time"
+
+	// CompareAlignDistance aligns the runs on the GPS distance driven since the start of their window
+	CompareAlignDistance CompareAlign = "distance"
+
+	// CompareAlignLap aligns the runs on the time since the start of one of their laps
+	CompareAlignLap CompareAlign = "lap"
+)
+
+const (
+	// DefaultComparePoints is the number of points the signals of a comparison are resampled on
+	DefaultComparePoints = 1000
+
+	// MaxComparePoints is the most points the signals of a comparison can be resampled on
+	MaxComparePoints = 10000
+)
+
+// ParseCompareAlign turns the name of an alignment into a CompareAlign
+func ParseCompareAlign(align string) (CompareAlign, error) {
+	switch CompareAlign(align) {
+	case CompareAlignTime, CompareAlignDistance, CompareAlignLap:
+		return CompareAlign(align), nil
+	default:
+		return "", fmt.Errorf("unknown align %s, must be time, distance or lap", align)
+	}
+}
+
+// CompareRun is a run of a comparison with its signals read in the window of the run.
+// Latitude and Longitude are the vn_gps position of the car in the window, they are only needed to align by distance.
+type CompareRun struct {
+	Run       models.CompareRunModel
+	Series    []*utils.SignalSeries
+	Latitude  *utils.SignalSeries
+	Longitude *utils.SignalSeries
+}
+
+// CompareRuns aligns runs on align and resamples every one of their signals on points points of the part of the axis all the runs have.
+// The signals are linearly interpolated, a signal which stops being logged before the end of its run holds its first and last values.
+// The series of every run are in the order of signals, and the first run is the reference of the deltas.
+func CompareRuns(runs []CompareRun, signals []string, align CompareAlign, points int) (*models.CompareModel, error) {
+	// axes are the positions of the samples of every series of every run on the common axis
+	axes := make([][][]float64, len(runs))
+	low, high := math.Inf(-1), math.Inf(1)
+	for i, run := range runs {
+		toAxis, err := compareAxis(run, align)
+		if err != nil {
+			return nil, err
+		}
+
+		runLow, runHigh := math.Inf(1), math.Inf(-1)
+		axes[i] = make([][]float64, len(run.Series))
+		for j, series := range run.Series {
+			axes[i][j] = make([]float64, len(series.Times))
+			for k, t := range series.Times {
+				axes[i][j][k] = toAxis(t)
+			}
+			if len(series.Times) > 0 {
+				runLow = math.Min(runLow, axes[i][j][0])
+				runHigh = math.Max(runHigh, axes[i][j][len(series.Times)-1])
+			}
+		}
+		if runLow >= runHigh {
+			return nil, fmt.Errorf("run %s did not log any of the signals %v", run.Run.Id, signals)
+		}
+		low, high = math.Max(low, runLow), math.Min(high, runHigh)
+	}
+	if low >= high {
+		return nil, fmt.Errorf("the runs do not overlap when aligned by %s", align)
+	}
+
+	comparison := &models.CompareModel{
+		Align:   string(align),
+		Axis:    compareAxisName(align),
+		Runs:    make([]models.CompareRunModel, len(runs)),
+		X:       make([]float64, points),
+		Signals: make([]models.CompareSignalModel, len(signals)),
+	}
+	for i, run := range runs {
+		comparison.Runs[i] = run.Run
+	}
+	for i := range comparison.X {
+		comparison.X[i] = low + (high-low)*float64(i)/float64(points-1)
+	}
+
+	for j, signal := range signals {
+		compareSignal := models.CompareSignalModel{Signal: signal, Runs: make([]models.CompareSignalRunModel, len(runs))}
+		var reference []float64
+		var referenceStats *models.CompareStatsModel
+		for i, run := range runs {
+			signalRun := models.CompareSignalRunModel{RunId: run.Run.Id}
+			series := run.Series[j]
+			if len(series.Times) > 0 {
+				signalRun.Values = make([]float64, points)
+				for k, x := range comparison.X {
+					signalRun.Values[k] = interpolateAt(axes[i][j], series.Values, x)
+				}
+				signalRun.Stats = compareStats(signalRun.Values)
+
+				if i == 0 {
+					reference, referenceStats = signalRun.Values, signalRun.Stats
+				} else if reference != nil {
+					signalRun.Delta = compareDelta(signalRun.Values, signalRun.Stats, reference, referenceStats)
+				}
+			}
+			compareSignal.Runs[i] = signalRun
+		}
+		comparison.Signals[j] = compareSignal
+	}
+
+	return comparison, nil
+}
+
+// compareAxis returns the function placing the times of a run on the axis of align
+func compareAxis(run CompareRun, align CompareAlign) (func(t float64) float64, error) {
+	if align != CompareAlignDistance {
+		return func(t float64) float64 { return t - run.Run.Start }, nil
+	}
+
+	times, distances := gpsDistances(run.Latitude, run.Longitude)
+	if len(times) < 2 {
+		return nil, fmt.Errorf("run %s has no GPS track to align by distance", run.Run.Id)
+	}
+	return func(t float64) float64 { return interpolateAt(times, distances, t) }, nil
+}
+
+func compareAxisName(align CompareAlign) string {
+	switch align {
+	case CompareAlignDistance:
+		return "distance (m)"
+	case CompareAlignLap:
+		return "lap time (s)"
+	default:
+		return "time (s)"
+	}
+}
+
+// gpsDistances returns the distance driven at the time of every GPS fix, steps shorter than minLapStep are GPS noise and left out
+func gpsDistances(latitude, longitude *utils.SignalSeries) ([]float64, []float64) {
+	times, distances := make([]float64, 0), make([]float64, 0)
+	if latitude == nil || longitude == nil || len(latitude.Times) != len(longitude.Times) {
+		return times, distances
+	}
+
+	var originLat, originLon, x, y, distance float64
+	for i, t := range latitude.Times {
+		lat, lon := latitude.Values[i], longitude.Values[i]
+		if lat == 0 || lon == 0 {
+			continue
+		}
+		if len(times) == 0 {
+			originLat, originLon = lat, lon
+		}
+
+		nextX, nextY := LatLonToCartesian(lat, lon, originLat, originLon)
+		if step := math.Hypot(nextX-x, nextY-y); step >= minLapStep {
+			distance += step
+			x, y = nextX, nextY
+		}
+		times = append(times, t)
+		distances = append(distances, distance)
+	}
+
+	return times, distances
+}
+
+// interpolateAt linearly interpolates the value at x of values sampled at xs, which are in ascending order.
+// Outside of xs the first and last values are held.
+func interpolateAt(xs, values []float64, x float64) float64 {
+	i := sort.SearchFloat64s(xs, x)
+	if i == 0 {
+		return values[0]
+	}
+	if i == len(xs) {
+		return values[len(values)-1]
+	}
+	if xs[i] == x {
+		return values[i]
+	}
+
+	ratio := (x - xs[i-1]) / (xs[i] - xs[i-1])
+	return values[i-1] + ratio*(values[i]-values[i-1])
+}
+
+func compareStats(values []float64) *models.CompareStatsModel {
+	stats := &models.CompareStatsModel{Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, value := range values {
+		stats.Mean += value
+		stats.Min = math.Min(stats.Min, value)
+		stats.Max = math.Max(stats.Max, value)
+	}
+	stats.Mean /= float64(len(values))
+
+	for _, value := range values {
+		stats.StdDev += (value - stats.Mean) * (value - stats.Mean)
+	}
+	stats.StdDev = math.Sqrt(stats.StdDev / float64(len(values)))
+
+	return stats
+}
+
+func compareDelta(values []float64, stats *models.CompareStatsModel, reference []float64, referenceStats *models.CompareStatsModel) *models.CompareDeltaModel {
+	delta := &models.CompareDeltaModel{
+		Mean:   stats.Mean - referenceStats.Mean,
+		Min:    stats.Min - referenceStats.Min,
+		Max:    stats.Max - referenceStats.Max,
+		StdDev: stats.StdDev - referenceStats.StdDev,
+	}
+
+	for i, value := range values {
+		difference := value - reference[i]
+		delta.RMS += difference * difference
+		delta.MaxAbs = math.Max(delta.MaxAbs, math.Abs(difference))
+	}
+	delta.RMS = math.Sqrt(delta.RMS / float64(len(values)))
+
+	return delta
+}
+
+// ComparePlot plots the signals of comparison stacked on top of each other, with a line for every run which logged them
+func ComparePlot(comparison *models.CompareModel) (*io.WriterTo, error) {
+	if len(comparison.Signals) == 0 {
+		return nil, nil
+	}
+
+	plots := make([][]*plot.Plot, len(comparison.Signals))
+	for i, signal := range comparison.Signals {
+		p := plot.New()
+		p.Title.Text = signal.Signal
+		p.X.Label.Text = comparison.Axis
+		p.Add(plotter.NewGrid())
+
+		for j, signalRun := range signal.Runs {
+			if signalRun.Values == nil {
+				continue
+			}
+
+			points := make(plotter.XYs, len(comparison.X))
+			for k, x := range comparison.X {
+				points[k] = plotter.XY{X: x, Y: signalRun.Values[k]}
+			}
+			line, err := plotter.NewLine(points)
+			if err != nil {
+				return nil, fmt.Errorf("could not create comparison line: %+v", err)
+			}
+			line.LineStyle.Color = plotColors[j%len(plotColors)]
+			p.Add(line)
+			p.Legend.Add(comparison.Runs[j].Label, line)
+		}
+		p.Legend.Top = true
+		plots[i] = []*plot.Plot{p}
+	}
+
+	img := vgimg.New(25*vg.Centimeter, vg.Length(len(plots))*10*vg.Centimeter)
+	tiles := draw.Tiles{Rows: len(plots), Cols: 1, PadTop: vg.Millimeter, PadBottom: vg.Millimeter, PadY: 5 * vg.Millimeter}
+	canvases := plot.Align(plots, tiles, draw.New(img))
+	for i := range plots {
+		plots[i][0].Draw(canvases[i][0])
+	}
+
+	var writer io.WriterTo = vgimg.PngCanvas{Canvas: img}
+	return &writer, nil
+}
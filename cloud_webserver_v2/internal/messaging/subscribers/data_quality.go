@@ -0,0 +1,200 @@
+package subscribers
+
+import (
+	"math"
+	"sort"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+)
+
+const (
+	// GapPeriodFactor is how many expected periods two messages need to be apart to count as a gap
+	GapPeriodFactor = 5.0
+
+	// MinGapDuration is the shortest time in seconds between two messages that can count as a gap.
+	// It stops scheduling jitter on fast topics from being reported.
+	MinGapDuration = 0.05
+
+	// periodSampleSize is the number of intervals used to estimate the expected period of a topic
+	periodSampleSize = 1000
+
+	// maxReportedGaps is the max number of gaps stored per topic
+	maxReportedGaps = 20
+)
+
+// DataQualityChecker looks for issues in a stream of MCAP messages.
+// It finds gaps in the topics, non-monotonic log times and keeps count of messages that failed to decode.
+type DataQualityChecker struct {
+	topics         map[string]*topicQuality
+	decodeFailures map[string]*models.DecodeFailureModel
+	declaredTopics []string
+	firstLogTime   *uint64
+	lastLogTime    uint64
+	messageCount   int64
+}
+
+// topicQuality keeps track of the timing of a single topic
+type topicQuality struct {
+	report       models.TopicQualityModel
+	firstLogTime uint64
+	lastLogTime  uint64
+
+	// The first intervals of the topic are held onto until we know the expected period of the topic
+	sampleIntervals []float64
+	sampleStarts    []float64
+}
+
+func NewDataQualityChecker() *DataQualityChecker {
+	return &DataQualityChecker{
+		topics:         make(map[string]*topicQuality),
+		decodeFailures: make(map[string]*models.DecodeFailureModel),
+	}
+}
+
+// SetDeclaredTopics sets all the topics which have a channel in the MCAP file
+func (c *DataQualityChecker) SetDeclaredTopics(topics []string) {
+	c.declaredTopics = topics
+}
+
+// AddDecodeFailure counts a message of schema which could not be decoded
+func (c *DataQualityChecker) AddDecodeFailure(schema string, logTime uint64, err string) {
+	c.addLogTime(logTime)
+
+	failure, ok := c.decodeFailures[schema]
+	if !ok {
+		failure = &models.DecodeFailureModel{Schema: schema}
+		c.decodeFailures[schema] = failure
+	}
+	failure.Count++
+	failure.LastError = err
+}
+
+// AddMessage checks the timing of a successfully decoded message
+func (c *DataQualityChecker) AddMessage(decodedMessage *utils.DecodedMessage) {
+	logTime := decodedMessage.LogTime
+	c.addLogTime(logTime)
+
+	topic, ok := c.topics[decodedMessage.Topic]
+	if !ok {
+		c.topics[decodedMessage.Topic] = &topicQuality{
+			report:       models.TopicQualityModel{Topic: decodedMessage.Topic, MessageCount: 1, Gaps: make([]models.TimeRangeModel, 0)},
+			firstLogTime: logTime,
+			lastLogTime:  logTime,
+		}
+		return
+	}
+	topic.report.MessageCount++
+
+	if logTime < topic.lastLogTime {
+		topic.report.NonMonotonicCount++
+		return
+	}
+
+	interval := float64(logTime-topic.lastLogTime) / 1e9
+	intervalStart := c.relativeTime(topic.lastLogTime)
+	topic.lastLogTime = logTime
+
+	if len(topic.sampleIntervals) < periodSampleSize {
+		topic.sampleIntervals = append(topic.sampleIntervals, interval)
+		topic.sampleStarts = append(topic.sampleStarts, intervalStart)
+		if len(topic.sampleIntervals) == periodSampleSize {
+			topic.estimatePeriod()
+		}
+		return
+	}
+
+	topic.checkGap(intervalStart, interval)
+}
+
+// Report returns the data quality report of all the messages seen so far.
+// The score is not calculated as other subscribers may still need to add to the report.
+func (c *DataQualityChecker) Report() *models.DataQualityModel {
+	report := &models.DataQualityModel{
+		MessageCount:      c.messageCount,
+		DecodeFailures:    make([]models.DecodeFailureModel, 0, len(c.decodeFailures)),
+		ZeroMessageTopics: make([]string, 0),
+		Topics:            make([]models.TopicQualityModel, 0, len(c.topics)),
+	}
+
+	if c.firstLogTime != nil {
+		report.Duration = c.relativeTime(c.lastLogTime)
+	}
+
+	for _, failure := range c.decodeFailures {
+		report.DecodeFailures = append(report.DecodeFailures, *failure)
+	}
+	sort.Slice(report.DecodeFailures, func(i, j int) bool {
+		return report.DecodeFailures[i].Schema < report.DecodeFailures[j].Schema
+	})
+
+	for _, topic := range c.topics {
+		// Topics with fewer messages than the sample size never had their period estimated
+		if len(topic.sampleIntervals) < periodSampleSize {
+			topic.estimatePeriod()
+		}
+
+		duration := float64(topic.lastLogTime-topic.firstLogTime) / 1e9
+		if duration > 0 {
+			topic.report.Rate = float64(topic.report.MessageCount-1) / duration
+		}
+		report.Topics = append(report.Topics, topic.report)
+	}
+	sort.Slice(report.Topics, func(i, j int) bool {
+		return report.Topics[i].Topic < report.Topics[j].Topic
+	})
+
+	for _, declaredTopic := range c.declaredTopics {
+		if _, ok := c.topics[declaredTopic]; !ok {
+			if _, failed := c.decodeFailures[declaredTopic]; !failed {
+				report.ZeroMessageTopics = append(report.ZeroMessageTopics, declaredTopic)
+			}
+		}
+	}
+
+	return report
+}
+
+func (c *DataQualityChecker) addLogTime(logTime uint64) {
+	c.messageCount++
+	if c.firstLogTime == nil {
+		c.firstLogTime = &logTime
+	}
+	c.lastLogTime = max(c.lastLogTime, logTime)
+}
+
+// relativeTime turns a log time into seconds since the first message
+func (c *DataQualityChecker) relativeTime(logTime uint64) float64 {
+	return float64(int64(logTime-*c.firstLogTime)) / 1e9
+}
+
+// estimatePeriod sets the expected period of the topic to the median of the sampled intervals
+// and checks the sampled intervals for gaps now that we know what a gap is
+func (t *topicQuality) estimatePeriod() {
+	if len(t.sampleIntervals) == 0 {
+		return
+	}
+
+	sortedIntervals := make([]float64, len(t.sampleIntervals))
+	copy(sortedIntervals, t.sampleIntervals)
+	sort.Float64s(sortedIntervals)
+	t.report.ExpectedPeriod = sortedIntervals[len(sortedIntervals)/2]
+
+	for i, interval := range t.sampleIntervals {
+		t.checkGap(t.sampleStarts[i], interval)
+	}
+	t.sampleStarts = nil
+}
+
+func (t *topicQuality) checkGap(start, interval float64) {
+	if interval < MinGapDuration || interval <= GapPeriodFactor*t.report.ExpectedPeriod {
+		return
+	}
+
+	t.report.GapCount++
+	t.report.TotalGapTime += interval
+	t.report.MaxGap = math.Max(t.report.MaxGap, interval)
+	if len(t.report.Gaps) < maxReportedGaps {
+		t.report.Gaps = append(t.report.Gaps, models.TimeRangeModel{Start: start, End: start + interval})
+	}
+}
@@ -0,0 +1,184 @@
+package subscribers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// DerivedTopic is the topic of the messages holding derived channel values, their signals are Derived.<name>
+const DerivedTopic = "Derived"
+
+var derivedChannelNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// DerivedChannelConstants are the names derived channel expressions can use besides signal paths and pi,
+// their values are the vehicle parameters of the run
+func DerivedChannelConstants(parameters models.CarMetricsModel) map[string]float64 {
+	return map[string]float64{
+		"GearboxRatio":           parameters.GearboxRatio,
+		"TireRadius":             parameters.TireRadius,
+		"WheelDiameter":          2 * parameters.TireRadius,
+		"Mass":                   parameters.Mass,
+		"Wheelbase":              parameters.Wheelbase,
+		"TrackWidth":             parameters.TrackWidth,
+		"BatteryCapacity":        parameters.BatteryCapacity,
+		"RpmToMetersPerSecond":   RpmToMetersPerSecond(parameters),
+		"RpmToKilometersPerHour": RpmToMetersPerSecond(parameters) * 3600.0 / 1000.0,
+	}
+}
+
+// ParseDerivedChannel checks the name of a derived channel and parses its expression
+func ParseDerivedChannel(channel models.DerivedChannelModel, constants map[string]float64) (*utils.Expression, error) {
+	if !derivedChannelNamePattern.MatchString(channel.Name) {
+		return nil, fmt.Errorf("invalid derived channel name %q, names must start with a letter and only contain letters, digits and underscores", channel.Name)
+	}
+
+	expression, err := utils.ParseExpression(channel.Expression, constants)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression for derived channel %s: %v", channel.Name, err)
+	}
+	if len(expression.Signals()) == 0 {
+		return nil, fmt.Errorf("invalid expression for derived channel %s: it does not use any signal", channel.Name)
+	}
+
+	return expression, nil
+}
+
+// derivedChannel is a derived channel ready to be evaluated
+type derivedChannel struct {
+	name       string
+	path       string
+	expression *utils.Expression
+	arguments  []float64
+}
+
+// DerivedChannelEvaluator computes derived channels from the decoded messages of a run.
+// Every channel holds the last value of each signal its expression uses and is evaluated whenever one of them is logged,
+// once all of them have been logged at least once. Channels are evaluated in the order of the registry,
+// so a channel can use the derived channels defined before it.
+type DerivedChannelEvaluator struct {
+	channels []*derivedChannel
+	units    map[string]string
+
+	// values holds the last value of every signal used by a channel
+	values map[string]float64
+
+	// dependents maps every signal used by a channel to the indexes of the channels using it
+	dependents map[string][]int
+
+	// topics are the trimmed topics holding the used signals, other messages are skipped without walking them
+	topics map[string]bool
+}
+
+// NewDerivedChannelEvaluator parses the expressions of channels, channels which can't be parsed are logged and left out
+func NewDerivedChannelEvaluator(channels []models.DerivedChannelModel, constants map[string]float64) *DerivedChannelEvaluator {
+	evaluator := &DerivedChannelEvaluator{
+		channels:   make([]*derivedChannel, 0, len(channels)),
+		units:      make(map[string]string),
+		values:     make(map[string]float64),
+		dependents: make(map[string][]int),
+		topics:     make(map[string]bool),
+	}
+
+	for _, channel := range channels {
+		expression, err := ParseDerivedChannel(channel, constants)
+		if err != nil {
+			log.Printf("skipping derived channel: %v", err)
+			continue
+		}
+
+		index := len(evaluator.channels)
+		path := DerivedTopic + "." + channel.Name
+		evaluator.channels = append(evaluator.channels, &derivedChannel{
+			name:       channel.Name,
+			path:       path,
+			expression: expression,
+			arguments:  make([]float64, len(expression.Signals())),
+		})
+		evaluator.units[path] = channel.Units
+
+		for _, signal := range expression.Signals() {
+			evaluator.dependents[signal] = append(evaluator.dependents[signal], index)
+			evaluator.topics[strings.Split(signal, ".")[0]] = true
+		}
+	}
+
+	return evaluator
+}
+
+// Units returns the units of every derived signal by path
+func (e *DerivedChannelEvaluator) Units() map[string]string {
+	return e.units
+}
+
+// AddMessage updates the signals of decodedMessage used by derived channels and returns a message of the DerivedTopic
+// with the channels that were computed, or nil if there are none. Values which are not finite (like divisions by 0) are left out.
+func (e *DerivedChannelEvaluator) AddMessage(decodedMessage *utils.DecodedMessage) *utils.DecodedMessage {
+	if e == nil || len(e.channels) == 0 || decodedMessage == nil || !e.topics[utils.TrimTopic(decodedMessage.Topic)] {
+		return nil
+	}
+
+	triggered := make([]bool, len(e.channels))
+	anyTriggered := false
+	utils.WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+		indexes, ok := e.dependents[path]
+		if !ok {
+			return
+		}
+		floatValue, ok := utils.SignalFloatValue(value)
+		if !ok {
+			return
+		}
+
+		e.values[path] = floatValue
+		for _, index := range indexes {
+			triggered[index] = true
+			anyTriggered = true
+		}
+	})
+	if !anyTriggered {
+		return nil
+	}
+
+	data := make(map[string]interface{})
+	for i, channel := range e.channels {
+		if !triggered[i] || !e.hasArguments(channel) {
+			continue
+		}
+
+		value := channel.expression.Evaluate(channel.arguments)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		data[channel.name] = value
+
+		// Channels after this one may use it
+		e.values[channel.path] = value
+		for _, index := range e.dependents[channel.path] {
+			triggered[index] = true
+		}
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+	return &utils.DecodedMessage{Topic: DerivedTopic, Data: data, LogTime: decodedMessage.LogTime}
+}
+
+// hasArguments fills the arguments of channel with the last values of its signals, it returns false if one was never logged
+func (e *DerivedChannelEvaluator) hasArguments(channel *derivedChannel) bool {
+	for i, signal := range channel.expression.Signals() {
+		value, ok := e.values[signal]
+		if !ok {
+			return false
+		}
+		channel.arguments[i] = value
+	}
+	return true
+}
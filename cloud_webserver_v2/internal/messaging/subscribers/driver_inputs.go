@@ -0,0 +1,373 @@
+package subscribers
+
+import (
+	"math"
+	"strings"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/desc"
+)
+
+const (
+	// FullThrottle is the pedal travel, as a fraction of the full travel, the throttle needs to be pressed past to be at full throttle
+	FullThrottle = 0.95
+
+	// BrakeOnPedal is the travel the brake needs to be pressed past to count as a brake application,
+	// the application ends once the brake is released under BrakeOffPedal
+	BrakeOnPedal  = 0.10
+	BrakeOffPedal = 0.05
+
+	// CoastingPedal is the travel both pedals need to be under for the car to be coasting
+	CoastingPedal = 0.05
+
+	// SteeringReversalAngle is how far in degrees the steering wheel needs to turn back from its furthest angle
+	// for a change of direction to count as a reversal, smaller corrections are sensor noise
+	SteeringReversalAngle = 5.0
+
+	// MaxDriverInputGap is the longest time in seconds the inputs are held between two samples,
+	// longer gaps in the signals are left out of the durations
+	MaxDriverInputGap = 1.0
+
+	// pedalPercentLimit tells pedal signals logged in percent from the ones logged as a fraction,
+	// a pedal which is ever logged over it is in percent
+	pedalPercentLimit = 1.5
+)
+
+// DriverInputSignals are the paths of the throttle and brake pedal travel signals and of the steering wheel angle (degrees) signal.
+// The pedals can be logged as a fraction of their travel or in percent.
+type DriverInputSignals struct {
+	Throttle string
+	Brake    string
+	Steering string
+}
+
+// DefaultDriverInputSignals are the pedal travels of the pedals system and the steering angle of the VCR
+func DefaultDriverInputSignals() DriverInputSignals {
+	return DriverInputSignals{
+		Throttle: "VehicleData.pedals_system_data.accel_percent",
+		Brake:    "VehicleData.pedals_system_data.brake_percent",
+		Steering: "VehicleData.steering_data.analog_steering_degrees",
+	}
+}
+
+// driverInputSample is the last value of every driver input signal at the time one of them was logged,
+// steering is NaN until the steering angle is logged
+type driverInputSample struct {
+	time     float64
+	throttle float64
+	brake    float64
+	steering float64
+}
+
+// driverInputTotals accumulates the driver inputs of a run or a lap, times are in seconds
+type driverInputTotals struct {
+	duration          float64
+	fullThrottle      float64
+	throttle          float64
+	braking           float64
+	coasting          float64
+	brakeApplications int
+	steeringTravel    float64
+	steeringReversals int
+}
+
+// add holds the inputs for dt seconds
+func (t *driverInputTotals) add(throttle float64, braking bool, coasting bool, dt float64) {
+	t.duration += dt
+	t.throttle += throttle * dt
+	if throttle >= FullThrottle {
+		t.fullThrottle += dt
+	}
+	if braking {
+		t.braking += dt
+	}
+	if coasting {
+		t.coasting += dt
+	}
+}
+
+func (t *driverInputTotals) stats(hasSteering bool) models.DriverInputStatsModel {
+	stats := models.DriverInputStatsModel{
+		Duration:          t.duration,
+		BrakeApplications: t.brakeApplications,
+		CoastingTime:      t.coasting,
+	}
+	if t.duration > 0 {
+		stats.FullThrottle = t.fullThrottle / t.duration * 100
+		stats.AverageThrottle = t.throttle / t.duration * 100
+		stats.Braking = t.braking / t.duration * 100
+	}
+
+	if hasSteering {
+		steeringRate := 0.0
+		if t.duration > 0 {
+			steeringRate = t.steeringTravel / t.duration
+		}
+		steeringReversals := t.steeringReversals
+		stats.SteeringRate = &steeringRate
+		stats.SteeringReversals = &steeringReversals
+	}
+
+	return stats
+}
+
+// steeringReversalCounter counts the changes of direction of the steering wheel
+type steeringReversalCounter struct {
+	hasAngle  bool
+	direction int
+	extreme   float64
+}
+
+// add turns the steering wheel to angle and returns true if it changed direction
+func (s *steeringReversalCounter) add(angle float64) bool {
+	if !s.hasAngle {
+		s.hasAngle, s.extreme = true, angle
+		return false
+	}
+
+	switch s.direction {
+	case 0:
+		// The first direction is the one the wheel is turned to from its first angle
+		if math.Abs(angle-s.extreme) > SteeringReversalAngle {
+			s.direction, s.extreme = sign(angle-s.extreme), angle
+		}
+	case 1:
+		if angle > s.extreme {
+			s.extreme = angle
+		} else if s.extreme-angle > SteeringReversalAngle {
+			s.direction, s.extreme = -1, angle
+			return true
+		}
+	case -1:
+		if angle < s.extreme {
+			s.extreme = angle
+		} else if angle-s.extreme > SteeringReversalAngle {
+			s.direction, s.extreme = 1, angle
+			return true
+		}
+	}
+	return false
+}
+
+// DriverInputAnalyzer computes how the driver used the throttle, brake and steering for the whole run and for every lap
+// found by a LapDetector. The inputs are the last values of their signals, held until the next sample (up to MaxDriverInputGap).
+type DriverInputAnalyzer struct {
+	signals DriverInputSignals
+	topics  map[string]bool
+
+	startTime    uint64
+	hasStartTime bool
+
+	throttle    float64
+	brake       float64
+	steering    float64
+	hasThrottle bool
+	maxThrottle float64
+	maxBrake    float64
+
+	samples    []driverInputSample
+	lapTracker *LapDetector
+}
+
+func NewDriverInputAnalyzer() *DriverInputAnalyzer {
+	analyzer := &DriverInputAnalyzer{
+		steering:   math.NaN(),
+		samples:    make([]driverInputSample, 0),
+		lapTracker: NewLapDetector(),
+	}
+	return analyzer.WithSignals(DefaultDriverInputSignals())
+}
+
+// WithSignals sets the throttle, brake and steering signals, signals which are empty keep their default
+func (a *DriverInputAnalyzer) WithSignals(signals DriverInputSignals) *DriverInputAnalyzer {
+	defaults := DefaultDriverInputSignals()
+	if signals.Throttle == "" {
+		signals.Throttle = defaults.Throttle
+	}
+	if signals.Brake == "" {
+		signals.Brake = defaults.Brake
+	}
+	if signals.Steering == "" {
+		signals.Steering = defaults.Steering
+	}
+
+	a.signals = signals
+	a.topics = map[string]bool{
+		strings.Split(signals.Throttle, ".")[0]: true,
+		strings.Split(signals.Brake, ".")[0]:    true,
+		strings.Split(signals.Steering, ".")[0]: true,
+	}
+	return a
+}
+
+// WithStartTime sets the log time the times of the laps are relative to
+func (a *DriverInputAnalyzer) WithStartTime(startTime uint64) *DriverInputAnalyzer {
+	a.startTime = startTime
+	a.hasStartTime = true
+	a.lapTracker.WithStartTime(startTime)
+	return a
+}
+
+// AddMessage reads the driver inputs of a decoded message, or the position of a VNData message to find laps
+func (a *DriverInputAnalyzer) AddMessage(decodedMessage *utils.DecodedMessage) {
+	if !a.hasStartTime {
+		a.WithStartTime(decodedMessage.LogTime)
+	}
+
+	topic := utils.TrimTopic(decodedMessage.Topic)
+	if topic == "VNData" {
+		a.lapTracker.AddMessage(decodedMessage)
+	}
+	if !a.topics[topic] || decodedMessage.LogTime < a.startTime {
+		return
+	}
+
+	updated := false
+	utils.WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+		if path != a.signals.Throttle && path != a.signals.Brake && path != a.signals.Steering {
+			return
+		}
+		floatValue, ok := utils.SignalFloatValue(value)
+		if !ok || math.IsNaN(floatValue) || math.IsInf(floatValue, 0) {
+			return
+		}
+
+		switch path {
+		case a.signals.Throttle:
+			a.throttle, a.hasThrottle = floatValue, true
+			a.maxThrottle = math.Max(a.maxThrottle, floatValue)
+		case a.signals.Brake:
+			a.brake = floatValue
+			a.maxBrake = math.Max(a.maxBrake, floatValue)
+		case a.signals.Steering:
+			a.steering = floatValue
+		}
+		updated = true
+	})
+	if !updated || !a.hasThrottle {
+		return
+	}
+
+	sample := driverInputSample{
+		time:     LogTimeToTime(decodedMessage.LogTime, a.startTime),
+		throttle: a.throttle,
+		brake:    a.brake,
+		steering: a.steering,
+	}
+	if len(a.samples) > 0 {
+		last := &a.samples[len(a.samples)-1]
+		// Samples logged out of order are skipped, signals logged at the same time share a sample
+		if sample.time < last.time {
+			return
+		} else if sample.time == last.time {
+			*last = sample
+			return
+		}
+	}
+	a.samples = append(a.samples, sample)
+}
+
+// Laps returns the laps of the run
+func (a *DriverInputAnalyzer) Laps() []models.LapModel {
+	return a.lapTracker.Laps()
+}
+
+// Report returns the driver input report of the run, or nil if the throttle was never logged.
+// A brake which was never logged is released the whole run.
+func (a *DriverInputAnalyzer) Report() *models.DriverInputModel {
+	if len(a.samples) == 0 {
+		return nil
+	}
+
+	throttleScale, brakeScale := pedalScale(a.maxThrottle), pedalScale(a.maxBrake)
+	laps := a.lapTracker.Laps()
+	lapTotals := make([]driverInputTotals, len(laps))
+	var run driverInputTotals
+
+	hasSteering := false
+	braking := false
+	reversals := &steeringReversalCounter{}
+	lap := 0
+	for i, sample := range a.samples {
+		// The samples and laps are both in time order, the inputs between two samples count towards the lap of the first one
+		for lap < len(laps) && sample.time >= laps[lap].End {
+			lap++
+		}
+		totals := []*driverInputTotals{&run}
+		if lap < len(laps) && sample.time >= laps[lap].Start {
+			totals = append(totals, &lapTotals[lap])
+		}
+
+		brake := sample.brake / brakeScale
+		applied := !braking && brake >= BrakeOnPedal
+		if applied {
+			braking = true
+		} else if braking && brake < BrakeOffPedal {
+			braking = false
+		}
+
+		reversed := false
+		if !math.IsNaN(sample.steering) {
+			hasSteering = true
+			reversed = reversals.add(sample.steering)
+		}
+
+		for _, t := range totals {
+			if applied {
+				t.brakeApplications++
+			}
+			if reversed {
+				t.steeringReversals++
+			}
+		}
+
+		if i+1 == len(a.samples) {
+			break
+		}
+		next := a.samples[i+1]
+		dt := next.time - sample.time
+		if dt > MaxDriverInputGap {
+			continue
+		}
+
+		throttle := sample.throttle / throttleScale
+		coasting := throttle < CoastingPedal && brake < CoastingPedal
+		steeringTravel := 0.0
+		if !math.IsNaN(sample.steering) {
+			steeringTravel = math.Abs(next.steering - sample.steering)
+		}
+		for _, t := range totals {
+			t.add(throttle, braking, coasting, dt)
+			t.steeringTravel += steeringTravel
+		}
+	}
+
+	report := &models.DriverInputModel{
+		ThrottleSignal: a.signals.Throttle,
+		BrakeSignal:    a.signals.Brake,
+		SteeringSignal: a.signals.Steering,
+		Run:            run.stats(hasSteering),
+		Laps:           make([]models.LapDriverInputModel, len(laps)),
+	}
+	for i := range lapTotals {
+		report.Laps[i] = models.LapDriverInputModel{Lap: laps[i].Number, DriverInputStatsModel: lapTotals[i].stats(hasSteering)}
+	}
+
+	return report
+}
+
+// pedalScale returns the value of a fully pressed pedal which was logged up to maxValue
+func pedalScale(maxValue float64) float64 {
+	if maxValue > pedalPercentLimit {
+		return 100
+	}
+	return 1
+}
+
+func sign(value float64) int {
+	if value < 0 {
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,289 @@
+package subscribers
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/desc"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+const (
+	// MaxEnergyGap is the longest time in seconds the power is held between two samples,
+	// the power is not integrated over longer gaps in the voltage and current signals
+	MaxEnergyGap = 1.0
+
+	// powerPlotPoints is the number of points the power plot is downsampled to
+	powerPlotPoints = 4000
+)
+
+// EnergySignals are the paths of the accumulator voltage (V) and current (A) signals, current is positive when discharging
+type EnergySignals struct {
+	Voltage string
+	Current string
+}
+
+// DefaultEnergySignals are the pack voltage and current measured by the ACU
+func DefaultEnergySignals() EnergySignals {
+	return EnergySignals{
+		Voltage: "ACUCoreData.pack_voltage",
+		Current: "ACUCoreData.pack_current",
+	}
+}
+
+// energyTotals accumulates the energy of a run or a lap, energies are in joules and powers in watts
+type energyTotals struct {
+	consumed  float64
+	regen     float64
+	peak      float64
+	peakRegen float64
+	duration  float64
+}
+
+// add holds power for dt seconds
+func (t *energyTotals) add(power, dt float64) {
+	if power >= 0 {
+		t.consumed += power * dt
+	} else {
+		t.regen -= power * dt
+	}
+	t.duration += dt
+}
+
+// addPeak keeps track of the highest discharge and charge powers
+func (t *energyTotals) addPeak(power float64) {
+	t.peak = math.Max(t.peak, power)
+	t.peakRegen = math.Max(t.peakRegen, -power)
+}
+
+func (t *energyTotals) averagePower() float64 {
+	if t.duration == 0 {
+		return 0
+	}
+	return (t.consumed - t.regen) / t.duration
+}
+
+// joulesToKilowattHours converts joules to kWh
+func joulesToKilowattHours(joules float64) float64 {
+	return joules / 3.6e6
+}
+
+// EnergyAnalyzer integrates the accumulator power of a run, for the whole run and for every lap found by a LapDetector.
+// The power is the product of the last voltage and current values, held until the next sample (up to MaxEnergyGap).
+type EnergyAnalyzer struct {
+	signals         EnergySignals
+	topics          map[string]bool
+	batteryCapacity float64
+
+	startTime    uint64
+	hasStartTime bool
+
+	voltage    float64
+	current    float64
+	hasVoltage bool
+	hasCurrent bool
+
+	lastPower     float64
+	lastPowerTime uint64
+	hasPower      bool
+
+	total      energyTotals
+	lap        energyTotals
+	lapEnergy  []models.LapEnergyModel
+	lapTracker *LapDetector
+
+	times  []float64
+	powers []float64
+}
+
+func NewEnergyAnalyzer() *EnergyAnalyzer {
+	analyzer := &EnergyAnalyzer{
+		lapEnergy:  make([]models.LapEnergyModel, 0),
+		lapTracker: NewLapDetector(),
+		times:      make([]float64, 0),
+		powers:     make([]float64, 0),
+	}
+	return analyzer.WithSignals(DefaultEnergySignals())
+}
+
+// WithSignals sets the voltage and current signals, signals which are empty keep their default
+func (a *EnergyAnalyzer) WithSignals(signals EnergySignals) *EnergyAnalyzer {
+	defaults := DefaultEnergySignals()
+	if signals.Voltage == "" {
+		signals.Voltage = defaults.Voltage
+	}
+	if signals.Current == "" {
+		signals.Current = defaults.Current
+	}
+
+	a.signals = signals
+	a.topics = map[string]bool{
+		strings.Split(signals.Voltage, ".")[0]: true,
+		strings.Split(signals.Current, ".")[0]: true,
+	}
+	return a
+}
+
+// WithBatteryCapacity sets the usable energy of the accumulator in kWh used to estimate the state of charge drop
+func (a *EnergyAnalyzer) WithBatteryCapacity(batteryCapacity float64) *EnergyAnalyzer {
+	a.batteryCapacity = batteryCapacity
+	return a
+}
+
+// WithStartTime sets the log time the times of the plot and the laps are relative to
+func (a *EnergyAnalyzer) WithStartTime(startTime uint64) *EnergyAnalyzer {
+	a.startTime = startTime
+	a.hasStartTime = true
+	a.lapTracker.WithStartTime(startTime)
+	return a
+}
+
+// AddMessage reads the voltage and current of a decoded message, or the position of a VNData message to find laps
+func (a *EnergyAnalyzer) AddMessage(decodedMessage *utils.DecodedMessage) {
+	if !a.hasStartTime {
+		a.WithStartTime(decodedMessage.LogTime)
+	}
+
+	topic := utils.TrimTopic(decodedMessage.Topic)
+	if topic == "VNData" && a.lapTracker.AddMessage(decodedMessage) {
+		a.closeLap()
+	}
+	if !a.topics[topic] {
+		return
+	}
+
+	updated := false
+	utils.WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+		if path != a.signals.Voltage && path != a.signals.Current {
+			return
+		}
+		floatValue, ok := utils.SignalFloatValue(value)
+		if !ok || math.IsNaN(floatValue) || math.IsInf(floatValue, 0) {
+			return
+		}
+
+		if path == a.signals.Voltage {
+			a.voltage, a.hasVoltage = floatValue, true
+		}
+		if path == a.signals.Current {
+			a.current, a.hasCurrent = floatValue, true
+		}
+		updated = true
+	})
+	if !updated || !a.hasVoltage || !a.hasCurrent {
+		return
+	}
+
+	a.addPower(decodedMessage.LogTime, a.voltage*a.current)
+}
+
+// addPower integrates the previous power up to logTime and starts holding power
+func (a *EnergyAnalyzer) addPower(logTime uint64, power float64) {
+	if a.hasPower && logTime > a.lastPowerTime {
+		dt := float64(logTime-a.lastPowerTime) / 1e9
+		if dt <= MaxEnergyGap {
+			a.total.add(a.lastPower, dt)
+			a.lap.add(a.lastPower, dt)
+		}
+	} else if a.hasPower && logTime < a.lastPowerTime {
+		// Samples logged out of order are skipped
+		return
+	}
+
+	a.total.addPeak(power)
+	a.lap.addPeak(power)
+	a.lastPower, a.lastPowerTime, a.hasPower = power, logTime, true
+
+	a.times = append(a.times, LogTimeToTime(max(logTime, a.startTime), a.startTime))
+	a.powers = append(a.powers, power/1000)
+}
+
+// closeLap stores the energy of the lap which was just completed and starts the next one
+func (a *EnergyAnalyzer) closeLap() {
+	a.lapEnergy = append(a.lapEnergy, models.LapEnergyModel{
+		Lap:            len(a.lapEnergy) + 1,
+		ConsumedEnergy: joulesToKilowattHours(a.lap.consumed),
+		RegenEnergy:    joulesToKilowattHours(a.lap.regen),
+		NetEnergy:      joulesToKilowattHours(a.lap.consumed - a.lap.regen),
+		PeakPower:      a.lap.peak / 1000,
+		AveragePower:   a.lap.averagePower() / 1000,
+	})
+	a.lap = energyTotals{}
+}
+
+// Laps returns the laps of the run
+func (a *EnergyAnalyzer) Laps() []models.LapModel {
+	return a.lapTracker.Laps()
+}
+
+// Report returns the energy report of the run, or nil if the voltage and current were never logged together
+func (a *EnergyAnalyzer) Report() *models.EnergyModel {
+	if !a.hasPower {
+		return nil
+	}
+
+	report := &models.EnergyModel{
+		VoltageSignal:  a.signals.Voltage,
+		CurrentSignal:  a.signals.Current,
+		ConsumedEnergy: joulesToKilowattHours(a.total.consumed),
+		RegenEnergy:    joulesToKilowattHours(a.total.regen),
+		NetEnergy:      joulesToKilowattHours(a.total.consumed - a.total.regen),
+		PeakPower:      a.total.peak / 1000,
+		PeakRegenPower: a.total.peakRegen / 1000,
+		AveragePower:   a.total.averagePower() / 1000,
+		Duration:       a.total.duration,
+		Laps:           a.lapEnergy,
+	}
+
+	if a.batteryCapacity > 0 {
+		stateOfChargeDrop := report.NetEnergy / a.batteryCapacity * 100
+		report.StateOfChargeDrop = &stateOfChargeDrop
+	}
+
+	return report
+}
+
+// PowerPlot returns a plot of the accumulator power over time, or nil if the voltage and current were never logged together
+func (a *EnergyAnalyzer) PowerPlot() (*io.WriterTo, error) {
+	if !a.hasPower {
+		return nil, nil
+	}
+
+	times, powers := utils.DownsampleMinMax(a.times, a.powers, powerPlotPoints)
+	return GeneratePowerPlot(times, powers)
+}
+
+// GeneratePowerPlot plots powers in kW over times in seconds
+func GeneratePowerPlot(times, powers []float64) (*io.WriterTo, error) {
+	p := plot.New()
+	p.Title.Text = "Accumulator Power"
+	p.X.Label.Text = "time (s)"
+	p.Y.Label.Text = "power (kW)"
+	p.Add(plotter.NewGrid())
+
+	pts := make(plotter.XYs, len(times))
+	for i := range times {
+		pts[i].X = times[i]
+		pts[i].Y = powers[i]
+	}
+
+	line, err := plotter.NewLine(pts)
+	if err != nil {
+		return nil, fmt.Errorf("could not create line plot: %+v", err)
+	}
+	p.Add(line)
+
+	writer, err := p.WriterTo(25*vg.Centimeter, 15*vg.Centimeter, "png")
+	if err != nil {
+		return nil, fmt.Errorf("could not get plot writer: %+v", err)
+	}
+
+	return &writer, nil
+}
@@ -0,0 +1,169 @@
+package subscribers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// MaxEventsPerRule is the most events a rule finds in a run, so that a noisy signal can't flood the events collection
+const MaxEventsPerRule = 1000
+
+var eventRuleNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// ValidateEventRule checks the name, signal and condition of an event rule
+func ValidateEventRule(rule models.EventRuleModel) error {
+	if !eventRuleNamePattern.MatchString(rule.Name) {
+		return fmt.Errorf("invalid event rule name %q, names must start with a letter and only contain letters, digits and underscores", rule.Name)
+	}
+
+	parts := strings.Split(rule.Signal, ".")
+	if len(parts) < 2 || parts[0] == "" || parts[len(parts)-1] == "" {
+		return fmt.Errorf("invalid signal %q for event rule %s, it must be a signal path like VehicleData.current_rpms.FL", rule.Signal, rule.Name)
+	}
+
+	switch rule.Condition {
+	case models.EventConditionBecomesTrue, models.EventConditionChanges, models.EventConditionRisesAbove, models.EventConditionFallsBelow:
+	default:
+		return fmt.Errorf("invalid condition %q for event rule %s, must be %s, %s, %s or %s", rule.Condition, rule.Name,
+			models.EventConditionBecomesTrue, models.EventConditionChanges, models.EventConditionRisesAbove, models.EventConditionFallsBelow)
+	}
+
+	return nil
+}
+
+// eventRule is an event rule with the last value of its signal
+type eventRule struct {
+	rule      models.EventRuleModel
+	value     float64
+	hasValue  bool
+	numEvents int
+}
+
+// EventExtractor finds the events of a run by following the signals of the event rules.
+// An event is the sample where the condition of a rule starts being met.
+type EventExtractor struct {
+	// rules maps the signal paths to the rules watching them
+	rules  map[string][]*eventRule
+	topics map[string]bool
+
+	startTime    uint64
+	hasStartTime bool
+
+	events []models.EventModel
+}
+
+// NewEventExtractor checks rules, rules which are invalid are logged and left out
+func NewEventExtractor(rules []models.EventRuleModel) *EventExtractor {
+	extractor := &EventExtractor{
+		rules:  make(map[string][]*eventRule),
+		topics: make(map[string]bool),
+		events: make([]models.EventModel, 0),
+	}
+
+	for _, rule := range rules {
+		if err := ValidateEventRule(rule); err != nil {
+			log.Printf("skipping event rule: %v", err)
+			continue
+		}
+		extractor.rules[rule.Signal] = append(extractor.rules[rule.Signal], &eventRule{rule: rule})
+		extractor.topics[strings.Split(rule.Signal, ".")[0]] = true
+	}
+
+	return extractor
+}
+
+// WithStartTime sets the log time the times of the events are relative to
+func (e *EventExtractor) WithStartTime(startTime uint64) *EventExtractor {
+	e.startTime = startTime
+	e.hasStartTime = true
+	return e
+}
+
+// AddMessage checks the rules watching the signals of a decoded message
+func (e *EventExtractor) AddMessage(decodedMessage *utils.DecodedMessage) {
+	if !e.hasStartTime {
+		e.WithStartTime(decodedMessage.LogTime)
+	}
+	if !e.topics[utils.TrimTopic(decodedMessage.Topic)] {
+		return
+	}
+
+	eventTime := LogTimeToTime(max(decodedMessage.LogTime, e.startTime), e.startTime)
+	utils.WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+		rules, found := e.rules[path]
+		if !found {
+			return
+		}
+		floatValue, ok := utils.SignalFloatValue(value)
+		if !ok || math.IsNaN(floatValue) {
+			return
+		}
+
+		for _, rule := range rules {
+			if rule.check(floatValue) && rule.numEvents < MaxEventsPerRule {
+				e.addEvent(rule, eventTime, floatValue, field)
+			}
+			rule.value, rule.hasValue = floatValue, true
+		}
+	})
+}
+
+// check returns true if value meets the condition of the rule and the last value of its signal did not
+func (r *eventRule) check(value float64) bool {
+	switch r.rule.Condition {
+	case models.EventConditionBecomesTrue:
+		return value != 0 && (!r.hasValue || r.value == 0)
+	case models.EventConditionChanges:
+		return r.hasValue && value != r.value
+	case models.EventConditionRisesAbove:
+		return value > r.rule.Threshold && (!r.hasValue || r.value <= r.rule.Threshold)
+	case models.EventConditionFallsBelow:
+		return value < r.rule.Threshold && (!r.hasValue || r.value >= r.rule.Threshold)
+	}
+	return false
+}
+
+func (e *EventExtractor) addEvent(rule *eventRule, eventTime float64, value float64, field *desc.FieldDescriptor) {
+	event := models.EventModel{
+		Type:   rule.rule.Name,
+		Signal: rule.rule.Signal,
+		Time:   eventTime,
+		Value:  value,
+	}
+	if rule.hasValue {
+		previousValue := rule.value
+		event.PreviousValue = &previousValue
+	}
+	if field != nil && field.GetEnumType() != nil {
+		if enumValue := field.GetEnumType().FindValueByNumber(int32(value)); enumValue != nil {
+			event.Label = enumValue.GetName()
+		}
+	}
+
+	rule.numEvents++
+	if rule.numEvents == MaxEventsPerRule {
+		log.Printf("event rule %s found %d events, the next ones are skipped", rule.rule.Name, MaxEventsPerRule)
+	}
+	e.events = append(e.events, event)
+}
+
+// Events returns the events of the run in the order they happened
+func (e *EventExtractor) Events() []models.EventModel {
+	return e.events
+}
+
+// EventCounts returns the number of events of every type
+func (e *EventExtractor) EventCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, event := range e.events {
+		counts[event.Type]++
+	}
+	return counts
+}
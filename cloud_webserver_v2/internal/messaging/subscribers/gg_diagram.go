@@ -0,0 +1,206 @@
+package subscribers
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/dynamic"
+	"gonum.org/v1/gonum/stat"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+const (
+	// StandardGravity converts accelerations in m/s^2 to g
+	StandardGravity = 9.80665
+
+	// GGEnvelopePercentile is the percentile the acceleration limits of the envelope are taken at
+	GGEnvelopePercentile = 0.99
+
+	// GGSectorCount is the number of directions the envelope is split into
+	GGSectorCount = 36
+
+	// MinGGSpeed is the speed in m/s the car needs to go for its accelerations to count, it leaves out the IMU noise of a car standing still
+	MinGGSpeed = 2.0
+
+	// ggPlotPoints is the max number of accelerations drawn on the diagram
+	ggPlotPoints = 20000
+)
+
+// GGDiagram collects the linear accelerations (vn_linear_accel_m_ss) of hytech_msgs.VNData messages into a
+// G-G diagram, lateral against longitudinal acceleration, and computes the acceleration envelope of the run.
+// Accelerations logged while the car goes slower than MinGGSpeed (from vn_vel_m_s) are left out.
+type GGDiagram struct {
+	longitudinal []float64
+	lateral      []float64
+}
+
+func NewGGDiagram() *GGDiagram {
+	return &GGDiagram{
+		longitudinal: make([]float64, 0),
+		lateral:      make([]float64, 0),
+	}
+}
+
+// AddMessage adds the acceleration of a decoded VNData message to the diagram
+func (g *GGDiagram) AddMessage(decodedMessage *utils.DecodedMessage) {
+	accel, found := decodedMessage.Data["vn_linear_accel_m_ss"].(*dynamic.Message)
+	if !found {
+		return
+	}
+
+	x, okX := dynamicFloatField(accel, "x")
+	y, okY := dynamicFloatField(accel, "y")
+	if !okX || !okY {
+		return
+	}
+
+	if velocity, found := decodedMessage.Data["vn_vel_m_s"].(*dynamic.Message); found {
+		vx, okVx := dynamicFloatField(velocity, "x")
+		vy, okVy := dynamicFloatField(velocity, "y")
+		if okVx && okVy && math.Hypot(vx, vy) < MinGGSpeed {
+			return
+		}
+	}
+
+	// The x axis of the VectorNav points to the front of the car and its y axis to the right
+	g.longitudinal = append(g.longitudinal, x/StandardGravity)
+	g.lateral = append(g.lateral, y/StandardGravity)
+}
+
+// NumSamples returns the number of accelerations in the diagram
+func (g *GGDiagram) NumSamples() int {
+	return len(g.longitudinal)
+}
+
+// Envelope computes the acceleration envelope of the run, or returns nil if no accelerations were logged
+func (g *GGDiagram) Envelope() *models.GGEnvelopeModel {
+	if len(g.longitudinal) == 0 {
+		return nil
+	}
+
+	var acceleration, braking, left, right, combined []float64
+	sectors := make([][]float64, GGSectorCount)
+	for i := range g.longitudinal {
+		longitudinal, lateral := g.longitudinal[i], g.lateral[i]
+		if longitudinal > 0 {
+			acceleration = append(acceleration, longitudinal)
+		} else {
+			braking = append(braking, -longitudinal)
+		}
+		if lateral > 0 {
+			right = append(right, lateral)
+		} else {
+			left = append(left, -lateral)
+		}
+
+		magnitude := math.Hypot(longitudinal, lateral)
+		combined = append(combined, magnitude)
+		sector := int(ggAngle(longitudinal, lateral) / (360.0 / GGSectorCount))
+		sectors[min(sector, GGSectorCount-1)] = append(sectors[min(sector, GGSectorCount-1)], magnitude)
+	}
+
+	envelope := &models.GGEnvelopeModel{
+		Percentile:      GGEnvelopePercentile * 100,
+		SampleCount:     int64(len(g.longitudinal)),
+		MaxAcceleration: envelopeLimit(acceleration),
+		MaxBraking:      envelopeLimit(braking),
+		MaxLateralLeft:  envelopeLimit(left),
+		MaxLateralRight: envelopeLimit(right),
+		MaxCombined:     envelopeLimit(combined),
+		Sectors:         make([]models.GGSectorModel, GGSectorCount),
+	}
+	for i, magnitudes := range sectors {
+		envelope.Sectors[i] = models.GGSectorModel{
+			StartAngle: float64(i) * 360.0 / GGSectorCount,
+			EndAngle:   float64(i+1) * 360.0 / GGSectorCount,
+			Limit:      envelopeLimit(magnitudes),
+		}
+	}
+
+	return envelope
+}
+
+// ggAngle returns the direction of an acceleration in degrees clockwise from straight ahead, between 0 and 360
+func ggAngle(longitudinal, lateral float64) float64 {
+	return math.Mod(math.Atan2(lateral, longitudinal)*180/math.Pi+360, 360)
+}
+
+// envelopeLimit returns the GGEnvelopePercentile of values, or 0 if there are none
+func envelopeLimit(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	return stat.Quantile(GGEnvelopePercentile, stat.Empirical, values, nil)
+}
+
+// Plot returns a scatter plot of the accelerations with envelope drawn over it, or nil if no accelerations were logged
+func (g *GGDiagram) Plot(envelope *models.GGEnvelopeModel) (*io.WriterTo, error) {
+	if envelope == nil {
+		return nil, nil
+	}
+
+	p := plot.New()
+	p.Title.Text = "G-G Diagram"
+	p.X.Label.Text = "lateral acceleration (g)"
+	p.Y.Label.Text = "longitudinal acceleration (g)"
+	p.Add(plotter.NewGrid())
+
+	// Long runs are thinned out evenly, the envelope is computed from every sample
+	stride := max(1, len(g.longitudinal)/ggPlotPoints)
+	points := make(plotter.XYs, 0, len(g.longitudinal)/stride+1)
+	for i := 0; i < len(g.longitudinal); i += stride {
+		points = append(points, plotter.XY{X: g.lateral[i], Y: g.longitudinal[i]})
+	}
+	scatter, err := plotter.NewScatter(points)
+	if err != nil {
+		return nil, fmt.Errorf("could not create scatter plot: %+v", err)
+	}
+	scatter.GlyphStyle.Radius = vg.Points(1)
+	scatter.GlyphStyle.Color = color.RGBA{R: 31, G: 119, B: 180, A: 80}
+	p.Add(scatter)
+
+	envelopePoints := make(plotter.XYs, 0, GGSectorCount+1)
+	for _, sector := range envelope.Sectors {
+		if sector.Limit == 0 {
+			continue
+		}
+		angle := (sector.StartAngle + sector.EndAngle) / 2 * math.Pi / 180
+		envelopePoints = append(envelopePoints, plotter.XY{X: sector.Limit * math.Sin(angle), Y: sector.Limit * math.Cos(angle)})
+	}
+	if len(envelopePoints) > 1 {
+		envelopePoints = append(envelopePoints, envelopePoints[0])
+		line, err := plotter.NewLine(envelopePoints)
+		if err != nil {
+			return nil, fmt.Errorf("could not create envelope line: %+v", err)
+		}
+		line.LineStyle.Color = color.RGBA{R: 214, G: 39, B: 40, A: 255}
+		line.LineStyle.Width = vg.Points(2)
+		p.Add(line)
+		p.Legend.Add(fmt.Sprintf("%.0fth percentile envelope", envelope.Percentile), line)
+	}
+
+	// Both axes use the same scale so the friction circle is round
+	limit := 0.5
+	for _, point := range points {
+		limit = math.Max(limit, math.Max(math.Abs(point.X), math.Abs(point.Y)))
+	}
+	limit = math.Ceil(limit*10) / 10
+	p.X.Min, p.X.Max = -limit, limit
+	p.Y.Min, p.Y.Max = -limit, limit
+
+	writer, err := p.WriterTo(25*vg.Centimeter, 25*vg.Centimeter, "png")
+	if err != nil {
+		return nil, fmt.Errorf("could not get plot writer: %+v", err)
+	}
+
+	return &writer, nil
+}
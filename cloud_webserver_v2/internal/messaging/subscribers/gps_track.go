@@ -0,0 +1,213 @@
+package subscribers
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+// gpsTrackPoint is a single position of the car, speed and heading are NaN when VNData did not have them
+type gpsTrackPoint struct {
+	time    time.Time
+	lat     float64
+	lon     float64
+	speed   float64
+	heading float64
+}
+
+// GPSTrack collects the vn_gps positions of hytech_msgs.VNData messages into a track
+// which can be written as a GPX file (lap-analysis tools) or a KML file (Google Earth).
+// Speed is the horizontal speed of vn_vel_m_s in m/s and heading is the yaw of vn_ypr_rad in degrees,
+// both are left out of points logged without them.
+type GPSTrack struct {
+	name   string
+	points []gpsTrackPoint
+}
+
+func NewGPSTrack(name string) *GPSTrack {
+	return &GPSTrack{name: name, points: make([]gpsTrackPoint, 0)}
+}
+
+// AddMessage adds the position of a decoded VNData message to the track.
+// Messages without a GPS fix (a latitude or longitude of 0) and positions the car has not moved from are skipped.
+func (t *GPSTrack) AddMessage(decodedMessage *utils.DecodedMessage) {
+	gpsDynamicMessage, found := decodedMessage.Data["vn_gps"].(*dynamic.Message)
+	if !found {
+		return
+	}
+
+	lat, okLat := dynamicFloatField(gpsDynamicMessage, "lat")
+	lon, okLon := dynamicFloatField(gpsDynamicMessage, "lon")
+	if !okLat || !okLon || lat == 0 || lon == 0 {
+		return
+	}
+
+	if len(t.points) > 0 {
+		last := t.points[len(t.points)-1]
+		if last.lat == lat && last.lon == lon {
+			return
+		}
+	}
+
+	point := gpsTrackPoint{
+		time:    time.Unix(0, int64(decodedMessage.LogTime)).UTC(),
+		lat:     lat,
+		lon:     lon,
+		speed:   math.NaN(),
+		heading: math.NaN(),
+	}
+
+	if velocity, found := decodedMessage.Data["vn_vel_m_s"].(*dynamic.Message); found {
+		x, okX := dynamicFloatField(velocity, "x")
+		y, okY := dynamicFloatField(velocity, "y")
+		if okX && okY {
+			point.speed = math.Hypot(x, y)
+		}
+	}
+
+	if ypr, found := decodedMessage.Data["vn_ypr_rad"].(*dynamic.Message); found {
+		if yaw, ok := dynamicFloatField(ypr, "yaw"); ok {
+			// Headings go from 0 to 360 degrees clockwise from north, which is what the VectorNav yaw is in
+			point.heading = math.Mod(yaw*180/math.Pi+360, 360)
+		}
+	}
+
+	t.points = append(t.points, point)
+}
+
+// dynamicFloatField returns the value of a numeric field of a decoded message as a float64
+func dynamicFloatField(message *dynamic.Message, name string) (float64, bool) {
+	fieldDescriptor := message.FindFieldDescriptorByName(name)
+	if fieldDescriptor == nil {
+		return 0, false
+	}
+	return utils.SignalFloatValue(message.GetField(fieldDescriptor))
+}
+
+// NumPoints returns the number of positions in the track
+func (t *GPSTrack) NumPoints() int {
+	return len(t.points)
+}
+
+// GPX returns a writer of the track as a GPX 1.1 file.
+// Speed and heading are written with the Garmin TrackPointExtension, which most lap-analysis tools read.
+func (t *GPSTrack) GPX() *io.WriterTo {
+	var writerTo io.WriterTo = gpsTrackWriter(t.writeGPX)
+	return &writerTo
+}
+
+// KML returns a writer of the track as a KML file with a single gx:Track, speed and heading are track data
+func (t *GPSTrack) KML() *io.WriterTo {
+	var writerTo io.WriterTo = gpsTrackWriter(t.writeKML)
+	return &writerTo
+}
+
+// gpsTrackWriter lets the writeGPX and writeKML methods be uploaded like the plots of a run
+type gpsTrackWriter func(w *bufio.Writer) error
+
+func (f gpsTrackWriter) WriteTo(w io.Writer) (int64, error) {
+	counter := &gpsCountingWriter{writer: w}
+	buffered := bufio.NewWriter(counter)
+	err := f(buffered)
+	if err != nil {
+		return counter.count, err
+	}
+	err = buffered.Flush()
+	return counter.count, err
+}
+
+type gpsCountingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (c *gpsCountingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+func (t *GPSTrack) writeGPX(w *bufio.Writer) error {
+	w.WriteString(xml.Header)
+	w.WriteString(`<gpx version="1.1" creator="HyTech cloud webserver" xmlns="http://www.topografix.com/GPX/1/1" `)
+	w.WriteString(`xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v2">` + "\n")
+	w.WriteString("<trk>\n<name>")
+	xml.EscapeText(w, []byte(t.name))
+	w.WriteString("</name>\n<trkseg>\n")
+
+	for _, point := range t.points {
+		fmt.Fprintf(w, `<trkpt lat="%s" lon="%s"><time>%s</time>`, gpsCoordinate(point.lat), gpsCoordinate(point.lon), point.time.Format(time.RFC3339Nano))
+		if !math.IsNaN(point.speed) || !math.IsNaN(point.heading) {
+			w.WriteString("<extensions><gpxtpx:TrackPointExtension>")
+			if !math.IsNaN(point.speed) {
+				fmt.Fprintf(w, "<gpxtpx:speed>%.3f</gpxtpx:speed>", point.speed)
+			}
+			if !math.IsNaN(point.heading) {
+				fmt.Fprintf(w, "<gpxtpx:course>%.2f</gpxtpx:course>", point.heading)
+			}
+			w.WriteString("</gpxtpx:TrackPointExtension></extensions>")
+		}
+		w.WriteString("</trkpt>\n")
+	}
+
+	_, err := w.WriteString("</trkseg>\n</trk>\n</gpx>\n")
+	return err
+}
+
+func (t *GPSTrack) writeKML(w *bufio.Writer) error {
+	w.WriteString(xml.Header)
+	w.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">` + "\n")
+	w.WriteString("<Document>\n<name>")
+	xml.EscapeText(w, []byte(t.name))
+	w.WriteString("</name>\n")
+	w.WriteString(`<Style id="track"><LineStyle><color>ff0000ff</color><width>3</width></LineStyle></Style>` + "\n")
+	w.WriteString(`<Schema id="track_data">` +
+		`<gx:SimpleArrayField name="speed" type="float"><displayName>Speed (m/s)</displayName></gx:SimpleArrayField>` +
+		`<gx:SimpleArrayField name="heading" type="float"><displayName>Heading (deg)</displayName></gx:SimpleArrayField>` +
+		"</Schema>\n")
+	w.WriteString("<Placemark>\n<name>")
+	xml.EscapeText(w, []byte(t.name))
+	w.WriteString("</name>\n<styleUrl>#track</styleUrl>\n<gx:Track>\n<altitudeMode>clampToGround</altitudeMode>\n")
+
+	// gx:Track lists every time, then every coordinate, then every data value, all in the same order
+	for _, point := range t.points {
+		fmt.Fprintf(w, "<when>%s</when>\n", point.time.Format(time.RFC3339Nano))
+	}
+	for _, point := range t.points {
+		fmt.Fprintf(w, "<gx:coord>%s %s 0</gx:coord>\n", gpsCoordinate(point.lon), gpsCoordinate(point.lat))
+	}
+
+	w.WriteString("<ExtendedData>\n<SchemaData schemaUrl=\"#track_data\">\n")
+	writeKMLArrayData(w, "speed", t.points, func(point gpsTrackPoint) float64 { return point.speed }, 3)
+	writeKMLArrayData(w, "heading", t.points, func(point gpsTrackPoint) float64 { return point.heading }, 2)
+	w.WriteString("</SchemaData>\n</ExtendedData>\n")
+
+	_, err := w.WriteString("</gx:Track>\n</Placemark>\n</Document>\n</kml>\n")
+	return err
+}
+
+// writeKMLArrayData writes a gx:SimpleArrayData with a value for every point, points without the value get an empty one
+func writeKMLArrayData(w *bufio.Writer, name string, points []gpsTrackPoint, value func(gpsTrackPoint) float64, precision int) {
+	fmt.Fprintf(w, "<gx:SimpleArrayData name=\"%s\">\n", name)
+	for _, point := range points {
+		pointValue := value(point)
+		if math.IsNaN(pointValue) {
+			w.WriteString("<gx:value/>\n")
+		} else {
+			fmt.Fprintf(w, "<gx:value>%s</gx:value>\n", strconv.FormatFloat(pointValue, 'f', precision, 64))
+		}
+	}
+	w.WriteString("</gx:SimpleArrayData>\n")
+}
+
+// gpsCoordinate formats a latitude or longitude with 7 decimals, about a centimeter
+func gpsCoordinate(degrees float64) string {
+	return strconv.FormatFloat(degrees, 'f', 7, 64)
+}
@@ -0,0 +1,122 @@
+package subscribers
+
+import (
+	"math"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+const (
+	// LapGateRadius is how close in meters the car needs to come back to the start/finish point to complete a lap
+	LapGateRadius = 15.0
+
+	// MinLapDistance is the distance in meters the car needs to drive before it can complete a lap,
+	// it keeps the car from completing laps while it sits next to the start/finish point
+	MinLapDistance = 200.0
+
+	// minLapStep is the distance in meters the car needs to move before it counts towards the lap distance,
+	// smaller steps are GPS noise of a car standing still
+	minLapStep = 2.0
+)
+
+// LapDetector splits a run into laps from the vn_gps positions of hytech_msgs.VNData messages.
+// The start/finish point is the first position of the run with a GPS fix, a lap is completed every time
+// the car comes back within LapGateRadius of it after driving at least MinLapDistance.
+type LapDetector struct {
+	startTime    uint64
+	hasStartTime bool
+
+	hasGate  bool
+	gateLat  float64
+	gateLon  float64
+	lapStart uint64
+
+	// x and y are the last position counted towards distance, in meters from the start/finish point
+	x        float64
+	y        float64
+	distance float64
+
+	laps []models.LapModel
+}
+
+func NewLapDetector() *LapDetector {
+	return &LapDetector{laps: make([]models.LapModel, 0)}
+}
+
+// WithStartTime sets the log time lap times are relative to, by default it is the first position of the run
+func (d *LapDetector) WithStartTime(startTime uint64) *LapDetector {
+	d.startTime = startTime
+	d.hasStartTime = true
+	return d
+}
+
+// AddMessage moves the car to the position of a decoded VNData message and returns true if it completed a lap
+func (d *LapDetector) AddMessage(decodedMessage *utils.DecodedMessage) bool {
+	gpsDynamicMessage, found := decodedMessage.Data["vn_gps"].(*dynamic.Message)
+	if !found {
+		return false
+	}
+
+	lat, okLat := dynamicFloatField(gpsDynamicMessage, "lat")
+	lon, okLon := dynamicFloatField(gpsDynamicMessage, "lon")
+	if !okLat || !okLon || lat == 0 || lon == 0 {
+		return false
+	}
+
+	return d.AddPosition(decodedMessage.LogTime, lat, lon)
+}
+
+// AddPosition moves the car to lat and lon at logTime and returns true if it completed a lap
+func (d *LapDetector) AddPosition(logTime uint64, lat, lon float64) bool {
+	if !d.hasStartTime {
+		d.WithStartTime(logTime)
+	}
+	if !d.hasGate {
+		d.hasGate = true
+		d.gateLat, d.gateLon = lat, lon
+		d.lapStart = logTime
+		return false
+	}
+
+	x, y := LatLonToCartesian(lat, lon, d.gateLat, d.gateLon)
+	step := math.Hypot(x-d.x, y-d.y)
+	if step < minLapStep {
+		return false
+	}
+	d.distance += step
+	d.x, d.y = x, y
+
+	if d.distance < MinLapDistance || math.Hypot(x, y) > LapGateRadius {
+		return false
+	}
+
+	d.laps = append(d.laps, models.LapModel{
+		Number:   len(d.laps) + 1,
+		Start:    d.relativeTime(d.lapStart),
+		End:      d.relativeTime(logTime),
+		Duration: float64(logTime-d.lapStart) / 1e9,
+		Distance: d.distance,
+	})
+	d.lapStart = logTime
+	d.distance = 0
+	return true
+}
+
+// CurrentLap returns the number of the lap the car is driving, starting at 1
+func (d *LapDetector) CurrentLap() int {
+	return len(d.laps) + 1
+}
+
+// Laps returns every completed lap, the time after the last one is not a lap
+func (d *LapDetector) Laps() []models.LapModel {
+	return d.laps
+}
+
+func (d *LapDetector) relativeTime(logTime uint64) float64 {
+	if logTime < d.startTime {
+		return 0
+	}
+	return LogTimeToTime(logTime, d.startTime)
+}
@@ -1,150 +1,253 @@
 package subscribers
 
 import (
+	"fmt"
+	"math"
+
 	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/desc"
 )
 
-// Really annoying to write but at least im able to pass time on my ✈️
+// ResampleMethod is how a signal is resampled onto the uniform time base of the interpolated HDF5 and MCAP files
+type ResampleMethod string
 
-// new idea -> have all the data in the slices. Then just do another scan opf the inteprolated times and tkae the average of the 2 bounds
+const (
+	// ResampleLinear linearly interpolates between the two samples around a point in time
+	ResampleLinear ResampleMethod = "linear"
 
-type InterpolatedMatlabWriter struct {
-	allSignalData map[string]map[string][]float64
-	firstLogTime  *float64
-	interpValue   float64
-	factor        uint16
-}
+	// ResampleZeroOrderHold holds the last sample until a new one comes in
+	ResampleZeroOrderHold ResampleMethod = "zoh"
 
-func CreateInterpolatedMatlabWriter(interpolationValue float64, schema map[string]map[string][]float64) *InterpolatedMatlabWriter {
-	i := interpolationValue
-	var factor uint16 = 1
-	for i < 1 {
-		factor *= 10
-		i *= 10
-	}
+	// ResampleNearest takes the sample closest in time
+	ResampleNearest ResampleMethod = "nearest"
+)
 
-	return &InterpolatedMatlabWriter{
-		allSignalData: schema,
-		firstLogTime:  nil,
-		interpValue:   interpolationValue,
-		factor:        factor,
+const (
+	// DefaultInterpolationRate is the rate in Hz of the uniform time base when none is given
+	DefaultInterpolationRate = 100.0
+
+	// MaxInterpolationRate stops a single upload from creating an unreasonably large file
+	MaxInterpolationRate = 1000.0
+)
+
+// ParseResampleMethod turns the name of a resample method into a ResampleMethod
+func ParseResampleMethod(method string) (ResampleMethod, error) {
+	switch ResampleMethod(method) {
+	case ResampleLinear, ResampleZeroOrderHold, ResampleNearest:
+		return ResampleMethod(method), nil
+	default:
+		return "", fmt.Errorf("unknown resample method %s, must be one of linear, zoh or nearest", method)
 	}
 }
 
-func (w *InterpolatedMatlabWriter) AddSignalValue(decodedMessage *utils.DecodedMessage) {
-	topic := decodedMessage.Topic
-	data := decodedMessage.Data
-	logTime := float64(decodedMessage.LogTime) / 1e9
+// signalResampler resamples a single signal onto the uniform time base.
+// Time base points are at index * period seconds since the first message of the run so all signals line up.
+// A signal only has values between its first and last sample, nothing is extrapolated.
+type signalResampler struct {
+	method    ResampleMethod
+	hasSample bool
+	lastTime  float64
+	lastValue float64
+	nextIndex int64
+
+	// values are the resampled values which have not been written to the HDF5 file yet
+	values []*utils.HDF5WrapperMessage
+}
 
-	if w.firstLogTime == nil {
-		w.firstLogTime = &logTime
-		timeSlice := w.getLogTimeSlice()
-		timeSlice = append(timeSlice, *w.firstLogTime)
-		w.allSignalData["global_times"]["times"] = timeSlice
+// addSample adds a sample at timestamp seconds and resamples every time base point between it and the previous sample
+func (s *signalResampler) addSample(timestamp, value, period float64) {
+	if !s.hasSample {
+		s.hasSample = true
+		s.lastTime = timestamp
+		s.lastValue = value
+		s.nextIndex = int64(math.Ceil(timestamp / period))
+		if float64(s.nextIndex)*period <= timestamp {
+			s.emit(timestamp, value)
+			s.nextIndex++
+		}
+		return
 	}
 
-	if innerMap, ok := w.allSignalData[topic]; ok {
-		for signalName, signalValueInterface := range data {
-			if _, ok = innerMap[signalName]; !ok {
-				innerMap[signalName] = make([]float64, 0)
-			}
+	// Samples going back in time can't be resampled without buffering the whole signal, so they are dropped
+	if timestamp < s.lastTime {
+		return
+	}
 
-			signalSlice := w.getSliceWithTopicAndSignal(topic, signalName)
-			if signalSlice == nil {
-				continue
-			}
+	for pointTime := float64(s.nextIndex) * period; pointTime <= timestamp; pointTime = float64(s.nextIndex) * period {
+		s.emit(pointTime, resampleValue(s.method, s.lastTime, s.lastValue, timestamp, value, pointTime))
+		s.nextIndex++
+	}
 
-			signalValueFloat := utils.GetFloatValueOfInterface(signalValueInterface)
-
-			if len(signalSlice) == 0 {
-				if logTime != *w.firstLogTime {
-					w.addInterpolatedValuesToSlice(signalSlice, logTime, signalValueFloat, topic, signalName)
-				} else {
-					signalSlice = append(signalSlice, signalValueFloat)
-					w.allSignalData[topic][signalName] = signalSlice
-				}
-			} else {
-				w.addInterpolatedValuesToSlice(signalSlice, logTime, signalValueFloat, topic, signalName)
-			}
+	s.lastTime = timestamp
+	s.lastValue = value
+}
+
+// resampleValue returns the value of a signal at pointTime, which is between the previous sample (lastTime, lastValue)
+// and the new sample (timestamp, value)
+func resampleValue(method ResampleMethod, lastTime, lastValue, timestamp, value, pointTime float64) float64 {
+	if pointTime >= timestamp {
+		return value
+	}
+
+	switch method {
+	case ResampleLinear:
+		return lastValue + (value-lastValue)*(pointTime-lastTime)/(timestamp-lastTime)
+	case ResampleNearest:
+		if pointTime-lastTime < timestamp-pointTime {
+			return lastValue
 		}
+		return value
+	default:
+		return lastValue
 	}
 }
 
-func (w *InterpolatedMatlabWriter) addInterpolatedValuesToSlice(signalSlice []float64, logTime float64, value float64, topic string, signalName string) {
-	lastSignalTime, lastSignalValue := w.determineLastLogTimeAndSignal(signalSlice)
-	timeSlice := w.getLogTimeSlice()
-	interpTime := lastSignalTime + w.interpValue
+func (s *signalResampler) emit(timestamp, value float64) {
+	s.values = append(s.values, &utils.HDF5WrapperMessage{Data: value, Timestamp: timestamp})
+}
 
-	if lastSignalValue == nil {
-		lastSignalValue = &value
-		lastSignalTime = *w.firstLogTime
-		signalSlice = append(signalSlice, *lastSignalValue)
-		interpTime += w.interpValue
+// ResampleSeries resamples a series read from a run at rate Hz, on the same time base as the interpolated HDF5 file.
+// Series resampled at the same rate share their timestamps.
+func ResampleSeries(series *utils.SignalSeries, rate float64, method ResampleMethod) *utils.SignalSeries {
+	period := 1 / rate
+	resampler := &signalResampler{method: method}
+	resampled := &utils.SignalSeries{
+		Path:   series.Path,
+		Times:  make([]float64, 0),
+		Values: make([]float64, 0),
 	}
 
-	deltaValue := (value - *lastSignalValue) / (logTime - lastSignalTime)
+	for i, timestamp := range series.Times {
+		resampler.addSample(timestamp, series.Values[i], period)
+		for _, value := range resampler.values {
+			resampled.Times = append(resampled.Times, value.Timestamp)
+			resampled.Values = append(resampled.Values, value.Data.(float64))
+		}
+		resampler.values = resampler.values[:0]
+	}
 
-	for interpTime <= logTime {
-		interpValue := *lastSignalValue + deltaValue*(interpTime-lastSignalTime)
-		signalSlice = append(signalSlice, interpValue)
+	return resampled
+}
+
+// InterpolatedMatlabWriter constructs a HDF5 file where every numeric signal is resampled onto a uniform time base.
+// The file always uses the utils.HDF5LayoutSignals layout, resampled values are appended to the dataset of their signal
+// as soon as they are known.
+type InterpolatedMatlabWriter struct {
+	signalWriter   *utils.HDF5SignalWriter
+	schemaVersions map[string]string
+	signalUnits    map[string]string
+	filePath       string
+	period         float64
+	method         ResampleMethod
+	firstLogTime   *uint64
+	signals        map[string]*signalResampler
+}
 
-		lastSignalValue = &interpValue
-		lastSignalTime = interpTime
+// CreateInterpolatedMatlabWriter creates a writer which resamples signals at rate Hz.
+// method is used for numeric signals, bool and enum signals are never linearly interpolated (see methodForSignal).
+// metadata is written as attributes of the root group along with the rate and method.
+// Buffered values are counted against budget, which may be nil.
+func CreateInterpolatedMatlabWriter(filePath, fileName string, rate float64, method ResampleMethod, metadata map[string]interface{}, schemaVersions map[string]string, budget *utils.HDF5BufferBudget) (*InterpolatedMatlabWriter, error) {
+	if rate <= 0 || rate > MaxInterpolationRate {
+		return nil, fmt.Errorf("interpolation rate must be between 0 and %v Hz, got %v", MaxInterpolationRate, rate)
+	}
 
-		for len(timeSlice) != 0 && timeSlice[len(timeSlice)-1] < interpTime {
-			timeSlice = append(timeSlice, interpTime)
-		}
+	fileMetadata := make(map[string]interface{}, len(metadata)+2)
+	for name, value := range metadata {
+		fileMetadata[name] = value
+	}
+	fileMetadata["interpolation_rate"] = rate
+	fileMetadata["interpolation_method"] = string(method)
 
-		interpTime += w.interpValue
+	hdf5Location := fmt.Sprintf("%s/%s_interpolated.h5", filePath, fileName)
+	signalWriter, err := utils.NewHDF5SignalWriter(hdf5Location, fileMetadata)
+	if err != nil {
+		return nil, err
 	}
 
-	w.allSignalData[topic][signalName] = signalSlice
-	w.allSignalData["global_times"]["times"] = timeSlice
+	return &InterpolatedMatlabWriter{
+		signalWriter:   signalWriter.WithBufferBudget(budget),
+		schemaVersions: schemaVersions,
+		filePath:       hdf5Location,
+		period:         1 / rate,
+		method:         method,
+		signals:        make(map[string]*signalResampler),
+	}, nil
 }
 
-func (w *InterpolatedMatlabWriter) getLogTimeSlice() []float64 {
-	return w.getSliceWithTopicAndSignal("global_times", "times")
+// WithSignalUnits sets the units of signals whose units can't be guessed from their name, like derived channels
+func (w *InterpolatedMatlabWriter) WithSignalUnits(signalUnits map[string]string) *InterpolatedMatlabWriter {
+	w.signalUnits = signalUnits
+	return w
 }
 
-func (w *InterpolatedMatlabWriter) determineLastLogTimeAndSignal(slice []float64) (float64, *float64) {
-	if len(slice) == 0 {
-		return float64(*w.firstLogTime), nil
+// AddSignalValue resamples all numeric signals of decodedMessage.
+// Non-numeric signals (like strings) have no meaningful interpolation and are left out of the file.
+func (w *InterpolatedMatlabWriter) AddSignalValue(decodedMessage *utils.DecodedMessage) error {
+	if decodedMessage == nil || decodedMessage.Data == nil {
+		return nil
 	}
 
-	return float64(*w.firstLogTime) + (float64(len(slice)-1) * w.interpValue), &(slice)[len(slice)-1]
-}
+	if w.firstLogTime == nil {
+		firstLogTime := decodedMessage.LogTime
+		w.firstLogTime = &firstLogTime
+	}
+	timestamp := float64(int64(decodedMessage.LogTime-*w.firstLogTime)) / 1e9
 
-func (w *InterpolatedMatlabWriter) getSliceWithTopicAndSignal(topic string, signal string) []float64 {
-	if value1, found1 := w.allSignalData[topic]; found1 {
-		if value2, found2 := value1[signal]; found2 {
-			return value2
+	var err error
+	utils.WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+		if err != nil {
+			return
 		}
-	}
 
-	return nil
-}
+		floatValue, ok := utils.SignalFloatValue(value)
+		if !ok {
+			return
+		}
 
-func (w *InterpolatedMatlabWriter) InterpolateEndOfSignalSlices() {
-	lenTimeSlice := len(w.allSignalData["global_times"]["times"])
-	for topic, signalNameMap := range w.allSignalData {
-		for signalName, signalSlice := range signalNameMap {
-			if signalSlice == nil {
-				continue
+		resampler, ok := w.signals[path]
+		if !ok {
+			resampler = &signalResampler{method: methodForSignal(w.method, field, value)}
+			w.signals[path] = resampler
+		}
+		resampler.addSample(timestamp, floatValue, w.period)
+
+		for _, resampled := range resampler.values {
+			_, err = w.signalWriter.AddValue(path, resampled.Timestamp, resampled.Data, func() utils.HDF5SignalAttributes {
+				return hdf5SignalAttributes(decodedMessage.Topic, w.schemaVersions[decodedMessage.Topic], path, field, value, w.signalUnits)
+			})
+			if err != nil {
+				return
 			}
+		}
+		resampler.values = resampler.values[:0]
+	})
 
-			for len(signalSlice) < lenTimeSlice {
-				signalSlice = append(signalSlice, signalSlice[len(signalSlice)-1])
-			}
+	return err
+}
 
-			w.allSignalData[topic][signalName] = signalSlice
-		}
+// methodForSignal returns the resample method of a signal.
+// Bool and enum signals are states, a value in between two states does not exist so they fall back to zero-order-hold.
+func methodForSignal(method ResampleMethod, field *desc.FieldDescriptor, value interface{}) ResampleMethod {
+	if method != ResampleLinear {
+		return method
+	}
+
+	if _, isBool := value.(bool); isBool {
+		return ResampleZeroOrderHold
 	}
+	if field != nil && field.GetEnumType() != nil {
+		return ResampleZeroOrderHold
+	}
+
+	return method
 }
 
-func (w *InterpolatedMatlabWriter) GetAllSignalData() map[string]map[string][]float64 {
-	return w.allSignalData
+// Close writes the remaining resampled values and closes the HDF5 file
+func (w *InterpolatedMatlabWriter) Close() error {
+	return w.signalWriter.Close()
 }
 
-func (w *InterpolatedMatlabWriter) GetSignalLengths(topic string, signal string) int {
-	return len(w.allSignalData[topic][signal])
+func (w *InterpolatedMatlabWriter) FilePath() string {
+	return w.filePath
 }
@@ -0,0 +1,184 @@
+package subscribers
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/desc/builder"
+)
+
+func TestResampleSeries(t *testing.T) {
+	tests := []struct {
+		name       string
+		times      []float64
+		values     []float64
+		rate       float64
+		method     ResampleMethod
+		wantTimes  []float64
+		wantValues []float64
+	}{
+		{
+			name:       "linear ramp",
+			times:      []float64{0, 1},
+			values:     []float64{0, 10},
+			rate:       4,
+			method:     ResampleLinear,
+			wantTimes:  []float64{0, 0.25, 0.5, 0.75, 1},
+			wantValues: []float64{0, 2.5, 5, 7.5, 10},
+		},
+		{
+			name:       "zoh ramp",
+			times:      []float64{0, 1},
+			values:     []float64{0, 10},
+			rate:       4,
+			method:     ResampleZeroOrderHold,
+			wantTimes:  []float64{0, 0.25, 0.5, 0.75, 1},
+			wantValues: []float64{0, 0, 0, 0, 10},
+		},
+		{
+			name:       "nearest ramp",
+			times:      []float64{0, 1},
+			values:     []float64{0, 10},
+			rate:       4,
+			method:     ResampleNearest,
+			wantTimes:  []float64{0, 0.25, 0.5, 0.75, 1},
+			wantValues: []float64{0, 0, 10, 10, 10},
+		},
+		{
+			name:       "linear step",
+			times:      []float64{0, 0.5, 0.5, 1},
+			values:     []float64{0, 0, 8, 8},
+			rate:       4,
+			method:     ResampleLinear,
+			wantTimes:  []float64{0, 0.25, 0.5, 0.75, 1},
+			wantValues: []float64{0, 0, 0, 8, 8},
+		},
+		{
+			name:       "zoh step",
+			times:      []float64{0, 0.6, 1},
+			values:     []float64{1, 5, 5},
+			rate:       4,
+			method:     ResampleZeroOrderHold,
+			wantTimes:  []float64{0, 0.25, 0.5, 0.75, 1},
+			wantValues: []float64{1, 1, 1, 5, 5},
+		},
+		{
+			name:       "nearest step",
+			times:      []float64{0, 0.6, 1},
+			values:     []float64{1, 5, 5},
+			rate:       4,
+			method:     ResampleNearest,
+			wantTimes:  []float64{0, 0.25, 0.5, 0.75, 1},
+			wantValues: []float64{1, 1, 5, 5, 5},
+		},
+		{
+			name:       "first sample on the time base",
+			times:      []float64{0.25, 0.75},
+			values:     []float64{2, 4},
+			rate:       4,
+			method:     ResampleLinear,
+			wantTimes:  []float64{0.25, 0.5, 0.75},
+			wantValues: []float64{2, 3, 4},
+		},
+		{
+			name:       "first sample off the time base",
+			times:      []float64{0.1, 0.6},
+			values:     []float64{1, 6},
+			rate:       4,
+			method:     ResampleLinear,
+			wantTimes:  []float64{0.25, 0.5},
+			wantValues: []float64{2.5, 5},
+		},
+		{
+			name:       "out of order samples are dropped",
+			times:      []float64{0, 1, 0.5, 2},
+			values:     []float64{0, 10, 100, 20},
+			rate:       2,
+			method:     ResampleLinear,
+			wantTimes:  []float64{0, 0.5, 1, 1.5, 2},
+			wantValues: []float64{0, 5, 10, 15, 20},
+		},
+		{
+			name:       "single sample between time base points",
+			times:      []float64{0.1},
+			values:     []float64{1},
+			rate:       4,
+			method:     ResampleLinear,
+			wantTimes:  []float64{},
+			wantValues: []float64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series := &utils.SignalSeries{Path: "VehicleData.signal", Times: tt.times, Values: tt.values}
+			resampled := ResampleSeries(series, tt.rate, tt.method)
+
+			if resampled.Path != series.Path {
+				t.Errorf("path = %q, want %q", resampled.Path, series.Path)
+			}
+			assertFloats(t, "times", resampled.Times, tt.wantTimes)
+			assertFloats(t, "values", resampled.Values, tt.wantValues)
+		})
+	}
+}
+
+func TestMethodForSignal(t *testing.T) {
+	enumType := builder.NewEnum("State").
+		AddValue(builder.NewEnumValue("OFF")).
+		AddValue(builder.NewEnumValue("ON"))
+	message, err := builder.NewMessage("Status").
+		AddField(builder.NewField("state", builder.FieldTypeEnum(enumType))).
+		AddField(builder.NewField("current", builder.FieldTypeFloat())).
+		AddField(builder.NewField("enabled", builder.FieldTypeBool())).
+		Build()
+	if err != nil {
+		t.Fatalf("could not build test message: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method ResampleMethod
+		field  *desc.FieldDescriptor
+		value  interface{}
+		want   ResampleMethod
+	}{
+		{"numeric stays linear", ResampleLinear, message.FindFieldByName("current"), float32(1.5), ResampleLinear},
+		{"bool is forced to zoh", ResampleLinear, message.FindFieldByName("enabled"), true, ResampleZeroOrderHold},
+		{"enum is forced to zoh", ResampleLinear, message.FindFieldByName("state"), int32(1), ResampleZeroOrderHold},
+		{"bool without field is forced to zoh", ResampleLinear, nil, false, ResampleZeroOrderHold},
+		{"numeric without field stays linear", ResampleLinear, nil, 3.0, ResampleLinear},
+		{"nearest is kept for bools", ResampleNearest, message.FindFieldByName("enabled"), true, ResampleNearest},
+		{"zoh is kept for numerics", ResampleZeroOrderHold, message.FindFieldByName("current"), float32(1.5), ResampleZeroOrderHold},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := methodForSignal(tt.method, tt.field, tt.value); got != tt.want {
+				t.Errorf("methodForSignal(%v) = %v, want %v", tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResampleSeriesBoolSignalsHold(t *testing.T) {
+	// A bool signal read as 0 and 1 is resampled with zoh, so it never takes values in between
+	series := &utils.SignalSeries{Times: []float64{0, 1}, Values: []float64{0, 1}}
+	resampled := ResampleSeries(series, 4, methodForSignal(ResampleLinear, nil, true))
+
+	assertFloats(t, "values", resampled.Values, []float64{0, 0, 0, 0, 1})
+}
+
+func assertFloats(t *testing.T, name string, got, want []float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s = %v, want %v", name, got, want)
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Fatalf("%s = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -1,49 +1,106 @@
 package subscribers
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
 )
 
 // This constructs a HDF5 file with a stream of messages it gets from a MCAP file.
-// It chunk writes to the HDF5 file in groups.
-// It does this by saving information into allSignalData and occasionally chunk writes all the data into the HDF5 file.
+// With the utils.HDF5LayoutChunked layout it chunk writes to the HDF5 file in groups.
+// It does this by saving information into allSignalData and writing the signals which are full (chunkedSignalMaxLength)
+// or hold old values (chunkedSignalMaxAge) into a new chunk, the other signals stay in memory until they are due.
+// With the utils.HDF5LayoutSignals layout every value is handed to signalWriter, which buffers every signal on its own.
+// Both layouts count their buffered values against the budget of the job and write their largest signals early
+// when the job is over its ceiling.
 type RawMatlabWriter struct {
 	firstTime       *float64
 	HDF5Writer      *utils.HDF5Writer
+	signalWriter    *utils.HDF5SignalWriter
+	budget          *utils.HDF5BufferBudget
+	schemaVersions  map[string]string
+	signalUnits     map[string]string
 	allSignalData   map[string]map[string]interface{}
 	filePath        string
 	failedMessages  [][2]interface{}
-	maxSignalLength int // Constantly updated so we know what the max len of a data slice is
+	maxSignalLength int   // Constantly updated so we know what the max len of a data slice is
+	bufferedBytes   int64 // Estimated size of allSignalData
+	nextAgeCheck    float64
+	sinceRelief     int64 // Bytes added to allSignalData since the budget was last relieved
 }
 
-func CreateRawMatlabWriter(filePath, fileName string) (*RawMatlabWriter, error) {
+const (
+	// hdf5WrapperMessageBytes is roughly what a single value in allSignalData costs: the pointer in the slice,
+	// the HDF5WrapperMessage and the boxed value it holds
+	hdf5WrapperMessageBytes = 56
+
+	// chunkedSignalMaxLength is the most values a signal holds in memory in the utils.HDF5LayoutChunked layout
+	// before it is written into a chunk
+	chunkedSignalMaxLength = 100_000
+
+	// chunkedSignalMaxAge is the longest time in seconds of the run a signal of the utils.HDF5LayoutChunked layout
+	// holds on to a value before it is written into a chunk
+	chunkedSignalMaxAge = 30.0
+)
+
+// chunkedSignal is a signal of allSignalData which holds values, keys are the map keys leading to its values
+type chunkedSignal struct {
+	keys   []string
+	values []*utils.HDF5WrapperMessage
+}
+
+// CreateRawMatlabWriter creates a HDF5 file with the given layout version (utils.HDF5LayoutChunked or utils.HDF5LayoutSignals).
+// metadata is written as attributes of the root group, schemaVersions (schema name to version) are written on every signal.
+// budget may be nil, in which case the buffered values are not limited by anything but the size of the chunks.
+func CreateRawMatlabWriter(filePath, fileName string, layoutVersion int, metadata map[string]interface{}, schemaVersions map[string]string, budget *utils.HDF5BufferBudget) (*RawMatlabWriter, error) {
 	hdf5Location := fmt.Sprintf("%s/%s.h5", filePath, fileName)
 	log.Println(hdf5Location)
-	hdf5Writer, err := utils.NewHDF5Writer(hdf5Location)
-	if err != nil {
-		return nil, err
-	}
 
-	return &RawMatlabWriter{
+	writer := &RawMatlabWriter{
 		allSignalData:   make(map[string]map[string]interface{}),
 		firstTime:       nil,
+		schemaVersions:  schemaVersions,
+		budget:          budget,
 		failedMessages:  make([][2]interface{}, 0),
-		HDF5Writer:      hdf5Writer,
 		maxSignalLength: 0,
 		filePath:        hdf5Location,
-	}, nil
+	}
+
+	var err error
+	switch layoutVersion {
+	case utils.HDF5LayoutChunked:
+		writer.HDF5Writer, err = utils.NewHDF5Writer(hdf5Location, metadata)
+	case utils.HDF5LayoutSignals:
+		writer.signalWriter, err = utils.NewHDF5SignalWriter(hdf5Location, metadata)
+		if err == nil {
+			writer.signalWriter.WithBufferBudget(budget)
+		}
+	default:
+		return nil, fmt.Errorf("unknown hdf5 layout version %d", layoutVersion)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// WithSignalUnits sets the units of signals whose units can't be guessed from their name, like derived channels
+func (w *RawMatlabWriter) WithSignalUnits(signalUnits map[string]string) *RawMatlabWriter {
+	w.signalUnits = signalUnits
+	return w
 }
 
 // AddSignalValue adds the values of the decodedMessage to allSignalData.
-// If there exists a slice of signal values in allSignalData whose length is greater than
-// maxSignalLength, then AddSignalValue will chunk write all the data in allSignalData to the
-// currently open HDF5 file.
+// If a signal of allSignalData is full or holds values older than chunkedSignalMaxAge, the signals which are due
+// are chunk written to the currently open HDF5 file. If the job is over the ceiling of its budget, the largest signals are.
 func (w *RawMatlabWriter) AddSignalValue(decodedMessage *utils.DecodedMessage) error {
 	if decodedMessage == nil || decodedMessage.Data == nil {
 		return nil
@@ -59,26 +116,192 @@ func (w *RawMatlabWriter) AddSignalValue(decodedMessage *utils.DecodedMessage) e
 		w.firstTime = &firstValue
 	}
 
+	if w.signalWriter != nil {
+		return w.appendSignalValues(decodedMessage)
+	}
+
 	if w.allSignalData[trimmedTopic] == nil {
 		w.allSignalData[trimmedTopic] = make(map[string]interface{})
 	}
 
+	bufferedBytes := w.bufferedBytes
 	for signalName, value := range signalValues {
 		w.processSignalValue(trimmedTopic, signalName, trimmedTopic+"."+signalName, value, float64(decodedMessage.LogTime)/1e9)
 	}
+	w.sinceRelief += w.bufferedBytes - bufferedBytes
+	overCeiling := w.budget.Add(w.bufferedBytes - bufferedBytes)
 
-	if w.maxSignalLength > 100_000 {
-		err := w.HDF5Writer.ChunkWrite(w.allSignalData)
-		if err != nil {
-			return err
+	timestamp := float64(decodedMessage.LogTime)/1e9 - *w.firstTime
+	if w.maxSignalLength >= chunkedSignalMaxLength || timestamp >= w.nextAgeCheck {
+		w.nextAgeCheck = timestamp + 1
+		return w.chunkWriteDueSignals(timestamp - chunkedSignalMaxAge)
+	}
+
+	if overCeiling && w.sinceRelief >= utils.HDF5BufferReliefBytes {
+		return w.relieveBudget()
+	}
+
+	return nil
+}
+
+// chunkWrite writes all of allSignalData into a new chunk of the HDF5 file and releases it
+func (w *RawMatlabWriter) chunkWrite() error {
+	return w.chunkWriteSignals(w.bufferedSignals())
+}
+
+// chunkWriteDueSignals writes the signals which are full or hold values from before timestamp into a new chunk
+func (w *RawMatlabWriter) chunkWriteDueSignals(timestamp float64) error {
+	due := make([]chunkedSignal, 0)
+	for _, signal := range w.bufferedSignals() {
+		if len(signal.values) >= chunkedSignalMaxLength || signal.values[0].Timestamp < timestamp {
+			due = append(due, signal)
+		}
+	}
+	return w.chunkWriteSignals(due)
+}
+
+// relieveBudget writes the largest signals into a new chunk until the job is under the low water mark of its budget.
+// Signals with fewer than utils.HDF5BufferReliefMinValues values are kept, so the job may stay over its ceiling
+// when the bytes are held by other writers.
+func (w *RawMatlabWriter) relieveBudget() error {
+	w.sinceRelief = 0
+
+	signals := w.bufferedSignals()
+	sort.Slice(signals, func(i, j int) bool { return len(signals[i].values) > len(signals[j].values) })
+
+	excess := w.budget.BytesOverLowWater()
+	largest := make([]chunkedSignal, 0)
+	for _, signal := range signals {
+		if excess <= 0 || len(signal.values) < utils.HDF5BufferReliefMinValues {
+			break
 		}
-		w.allSignalData = make(map[string]map[string]interface{})
-		w.maxSignalLength = 0
+		largest = append(largest, signal)
+		excess -= int64(len(signal.values)) * hdf5WrapperMessageBytes
 	}
+	return w.chunkWriteSignals(largest)
+}
+
+// bufferedSignals returns every signal of allSignalData which holds values
+func (w *RawMatlabWriter) bufferedSignals() []chunkedSignal {
+	signals := make([]chunkedSignal, 0)
+	var walk func(keys []string, data map[string]interface{})
+	walk = func(keys []string, data map[string]interface{}) {
+		for key, value := range data {
+			signalKeys := append(append(make([]string, 0, len(keys)+1), keys...), key)
+			switch castedValue := value.(type) {
+			case map[string]interface{}:
+				walk(signalKeys, castedValue)
+			case []*utils.HDF5WrapperMessage:
+				if len(castedValue) > 0 {
+					signals = append(signals, chunkedSignal{keys: signalKeys, values: castedValue})
+				}
+			}
+		}
+	}
+	for topic, data := range w.allSignalData {
+		walk([]string{topic}, data)
+	}
+	return signals
+}
 
+// chunkWriteSignals writes signals into a new chunk of the HDF5 file and releases them from allSignalData.
+// The chunk has the same nesting as allSignalData so the datasets are named after the full signal path.
+func (w *RawMatlabWriter) chunkWriteSignals(signals []chunkedSignal) error {
+	if len(signals) == 0 {
+		return nil
+	}
+
+	chunk := make(map[string]map[string]interface{})
+	var releasedBytes int64
+	for _, signal := range signals {
+		topic := signal.keys[0]
+		if chunk[topic] == nil {
+			chunk[topic] = make(map[string]interface{})
+		}
+		chunkParent, bufferedParent := chunk[topic], w.allSignalData[topic]
+		for _, key := range signal.keys[1 : len(signal.keys)-1] {
+			if chunkParent[key] == nil {
+				chunkParent[key] = make(map[string]interface{})
+			}
+			chunkParent = chunkParent[key].(map[string]interface{})
+			bufferedParent = bufferedParent[key].(map[string]interface{})
+		}
+
+		name := signal.keys[len(signal.keys)-1]
+		chunkParent[name] = signal.values
+		delete(bufferedParent, name)
+		releasedBytes += int64(len(signal.values)) * hdf5WrapperMessageBytes
+	}
+
+	err := w.HDF5Writer.ChunkWrite(chunk)
+	if err != nil {
+		return err
+	}
+
+	w.budget.Add(-releasedBytes)
+	w.bufferedBytes -= releasedBytes
+	w.maxSignalLength = 0
+	for _, signal := range w.bufferedSignals() {
+		w.maxSignalLength = max(w.maxSignalLength, len(signal.values))
+	}
 	return nil
 }
 
+// countValue keeps track of the size of allSignalData after a value is added to a signal which now has length values
+func (w *RawMatlabWriter) countValue(length int) {
+	w.maxSignalLength = max(w.maxSignalLength, length)
+	w.bufferedBytes += hdf5WrapperMessageBytes
+}
+
+// appendSignalValues appends every signal of decodedMessage to its dataset.
+// Unlike the chunked layout, enums are stored as their number and their names are kept in an attribute of the dataset.
+func (w *RawMatlabWriter) appendSignalValues(decodedMessage *utils.DecodedMessage) error {
+	timestamp := float64(decodedMessage.LogTime)/1e9 - *w.firstTime
+
+	var err error
+	utils.WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+		if err != nil {
+			return
+		}
+
+		var ok bool
+		ok, err = w.signalWriter.AddValue(path, timestamp, value, func() utils.HDF5SignalAttributes {
+			return hdf5SignalAttributes(decodedMessage.Topic, w.schemaVersions[decodedMessage.Topic], path, field, value, w.signalUnits)
+		})
+		if err == nil && !ok {
+			w.failedMessages = append(w.failedMessages, [2]interface{}{path, value})
+		}
+	})
+
+	return err
+}
+
+// hdf5SignalAttributes describes a signal of topic for the dataset attributes of the utils.HDF5LayoutSignals layout.
+// The units of signals in signalUnits (like derived channels) are used over the ones guessed from their name.
+func hdf5SignalAttributes(topic, schemaVersion, path string, field *desc.FieldDescriptor, value interface{}, signalUnits map[string]string) utils.HDF5SignalAttributes {
+	attributes := utils.HDF5SignalAttributes{
+		Topic:         topic,
+		Type:          utils.SignalType(field, value),
+		Units:         utils.SignalUnits(path),
+		SchemaVersion: schemaVersion,
+	}
+	if units, ok := signalUnits[path]; ok {
+		attributes.Units = units
+	}
+
+	if field != nil && field.GetEnumType() != nil {
+		enumNames := make(map[string]string)
+		for _, enumValue := range field.GetEnumType().GetValues() {
+			enumNames[strconv.Itoa(int(enumValue.GetNumber()))] = enumValue.GetName()
+		}
+		if enumNamesJson, err := json.Marshal(enumNames); err == nil {
+			attributes.EnumNames = string(enumNamesJson)
+		}
+	}
+
+	return attributes
+}
+
 // processSignalValue handles logic for whether to continue to dynamically decode the protobuf value or to directly add it to allSignalData
 func (w *RawMatlabWriter) processSignalValue(topic, signalName, signalPath string, value interface{}, logTime float64) {
 	switch value.(type) {
@@ -117,7 +340,7 @@ func (w *RawMatlabWriter) processSignalValue(topic, signalName, signalPath strin
 			Timestamp: (logTime - *w.firstTime),
 		}
 		w.allSignalData[topic][signalName] = append(w.allSignalData[topic][signalName].([]*utils.HDF5WrapperMessage), valueRawM)
-		w.maxSignalLength = max(w.maxSignalLength, len(w.allSignalData[topic][signalName].([]*utils.HDF5WrapperMessage)))
+		w.countValue(len(w.allSignalData[topic][signalName].([]*utils.HDF5WrapperMessage)))
 	}
 }
 
@@ -139,7 +362,7 @@ func (w *RawMatlabWriter) addJSONValues(decodedValue map[string]interface{}, giv
 				Timestamp: (logTime - *w.firstTime),
 			}
 			givenMap[signalName] = append(givenMap[signalName].([]*utils.HDF5WrapperMessage), valueRawM)
-			w.maxSignalLength = max(w.maxSignalLength, len(givenMap[signalName].([]*utils.HDF5WrapperMessage)))
+			w.countValue(len(givenMap[signalName].([]*utils.HDF5WrapperMessage)))
 		}
 		baseSignalPath = signalPath
 	}
@@ -184,7 +407,7 @@ func (w *RawMatlabWriter) addNondynamicSliceValues(fieldName string, decodedValu
 			}
 			givenMap[repeatedFieldName] = append(givenMap[repeatedFieldName].([]*utils.HDF5WrapperMessage), valueRawM)
 
-			w.maxSignalLength = max(w.maxSignalLength, len(givenMap[repeatedFieldName].([]*utils.HDF5WrapperMessage)))
+			w.countValue(len(givenMap[repeatedFieldName].([]*utils.HDF5WrapperMessage)))
 		}
 	}
 }
@@ -249,7 +472,13 @@ func (w *RawMatlabWriter) addNestedValues(signalPath string, nestedMap map[strin
 		enum := field.GetEnumType()
 		if enum != nil {
 			enumValue := enum.FindValueByNumber(decodedValue.(int32)) // Enum values always represented as int
-			decodedValue = enumValue.GetName()                        // Just replace  decoded value with the its enum name value (not number value)
+			if enumValue == nil {
+				// The value is not part of the enum (usually a schema mismatch), so we can't write it
+				w.failedMessages = append(w.failedMessages, [2]interface{}{baseSignalPath, decodedValue})
+				baseSignalPath = signalPath
+				continue
+			}
+			decodedValue = enumValue.GetName() // Just replace  decoded value with the its enum name value (not number value)
 		}
 
 		// Non-Dynamic message case
@@ -264,17 +493,36 @@ func (w *RawMatlabWriter) addNestedValues(signalPath string, nestedMap map[strin
 				Timestamp: (logTime - *w.firstTime),
 			}
 			nestedMap[fieldName] = append(nestedMap[fieldName].([]*utils.HDF5WrapperMessage), valueRawM)
-			w.maxSignalLength = max(w.maxSignalLength, len(nestedMap[fieldName].([]*utils.HDF5WrapperMessage)))
+			w.countValue(len(nestedMap[fieldName].([]*utils.HDF5WrapperMessage)))
 		}
 
 		baseSignalPath = signalPath
 	}
 }
 
+// Close writes the signal data still held in memory and closes the HDF5 file
+func (w *RawMatlabWriter) Close() error {
+	if w.signalWriter != nil {
+		return w.signalWriter.Close()
+	}
+
+	err := w.chunkWrite()
+	if err != nil {
+		return fmt.Errorf("could not chunk write hdf5 file: %v", err)
+	}
+
+	return w.HDF5Writer.Close()
+}
+
 func (w *RawMatlabWriter) AllSignalData() map[string]map[string]interface{} {
 	return w.allSignalData
 }
 
+// FailedMessages returns the signal paths and values which could not be added to the HDF5 file
+func (w *RawMatlabWriter) FailedMessages() [][2]interface{} {
+	return w.failedMessages
+}
+
 func (w *RawMatlabWriter) MaxSignalLength() int {
 	return w.maxSignalLength
 }
@@ -0,0 +1,293 @@
+package subscribers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// topicResampler resamples all the signals of a topic onto the uniform time base together,
+// so every point of the time base becomes a single message with all the signals of the topic.
+type topicResampler struct {
+	channelID uint16
+	sequence  uint32
+	hasSample bool
+	lastTime  float64
+	nextIndex int64
+
+	// lastValues are the latest values of every signal of the topic, keyed by the path without the topic
+	lastValues map[string]float64
+	methods    map[string]ResampleMethod
+}
+
+// InterpolatedMcapWriter constructs a MCAP file where every topic is resampled onto a uniform time base.
+// Messages are JSON encoded and use the same time base as the interpolated HDF5 file, so they open cleanly in Foxglove Studio.
+// All signals are written as numbers, nested the same way as in the original message.
+//
+// Messages of a topic are written as soon as the next sample of that topic comes in, so messages of different
+// topics may be slightly out of order in the file. The MCAP index takes care of ordering them when read.
+type InterpolatedMcapWriter struct {
+	file         *os.File
+	writer       *mcap.Writer
+	filePath     string
+	period       float64
+	periodNanos  uint64
+	method       ResampleMethod
+	firstLogTime *uint64
+	topics       map[string]*topicResampler
+}
+
+// CreateInterpolatedMcapWriter creates a writer which resamples every topic at rate Hz using method (see methodForSignal)
+func CreateInterpolatedMcapWriter(filePath, fileName string, rate float64, method ResampleMethod) (*InterpolatedMcapWriter, error) {
+	if rate <= 0 || rate > MaxInterpolationRate {
+		return nil, fmt.Errorf("interpolation rate must be between 0 and %v Hz, got %v", MaxInterpolationRate, rate)
+	}
+
+	mcapLocation := fmt.Sprintf("%s/%s_interpolated.mcap", filePath, fileName)
+	log.Println(mcapLocation)
+	file, err := os.Create(mcapLocation)
+	if err != nil {
+		return nil, fmt.Errorf("could not create interpolated mcap file: %v", err)
+	}
+
+	writer, err := mcap.NewWriter(file, &mcap.WriterOptions{
+		Chunked:     true,
+		ChunkSize:   4 * 1024 * 1024,
+		Compression: mcap.CompressionZSTD,
+		IncludeCRC:  true,
+	})
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not create mcap writer: %v", err)
+	}
+
+	err = writer.WriteHeader(&mcap.Header{})
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not write mcap header: %v", err)
+	}
+
+	return &InterpolatedMcapWriter{
+		file:        file,
+		writer:      writer,
+		filePath:    mcapLocation,
+		period:      1 / rate,
+		periodNanos: uint64(math.Round(1e9 / rate)),
+		method:      method,
+		topics:      make(map[string]*topicResampler),
+	}, nil
+}
+
+// AddSignalValue resamples all numeric signals of decodedMessage and writes a message for every time base point
+// between the previous message of the topic and this one
+func (w *InterpolatedMcapWriter) AddSignalValue(decodedMessage *utils.DecodedMessage) error {
+	if decodedMessage == nil || decodedMessage.Data == nil {
+		return nil
+	}
+
+	if w.firstLogTime == nil {
+		firstLogTime := decodedMessage.LogTime
+		w.firstLogTime = &firstLogTime
+	}
+	timestamp := float64(int64(decodedMessage.LogTime-*w.firstLogTime)) / 1e9
+
+	values := make(map[string]float64)
+	methods := make(map[string]ResampleMethod)
+	utils.WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+		floatValue, ok := utils.SignalFloatValue(value)
+		if !ok {
+			return
+		}
+
+		_, signalPath, _ := strings.Cut(path, ".")
+		values[signalPath] = floatValue
+		methods[signalPath] = methodForSignal(w.method, field, value)
+	})
+	if len(values) == 0 {
+		return nil
+	}
+
+	topic, ok := w.topics[decodedMessage.Topic]
+	if !ok {
+		var err error
+		topic, err = w.addTopic(decodedMessage.Topic, values)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !topic.hasSample {
+		topic.hasSample = true
+		topic.lastTime = timestamp
+		topic.lastValues = values
+		topic.methods = methods
+		topic.nextIndex = int64(math.Ceil(timestamp / w.period))
+		if float64(topic.nextIndex)*w.period <= timestamp {
+			return w.writePoint(topic, values)
+		}
+		return nil
+	}
+
+	// Messages going back in time are dropped, same as the interpolated HDF5 file
+	if timestamp < topic.lastTime {
+		return nil
+	}
+
+	for pointTime := float64(topic.nextIndex) * w.period; pointTime <= timestamp; pointTime = float64(topic.nextIndex) * w.period {
+		pointValues := make(map[string]float64, len(values))
+		for signalPath, value := range values {
+			lastValue, ok := topic.lastValues[signalPath]
+			if !ok {
+				// The signal did not exist before this message so there is nothing to interpolate from
+				if pointTime < timestamp {
+					continue
+				}
+				lastValue = value
+			}
+			pointValues[signalPath] = resampleValue(methods[signalPath], topic.lastTime, lastValue, timestamp, value, pointTime)
+		}
+
+		// Signals missing from this message (like a repeated field which got shorter) hold their last value
+		for signalPath, lastValue := range topic.lastValues {
+			if _, ok := values[signalPath]; !ok {
+				pointValues[signalPath] = lastValue
+			}
+		}
+
+		err := w.writePoint(topic, pointValues)
+		if err != nil {
+			return err
+		}
+	}
+
+	topic.lastTime = timestamp
+	for signalPath, value := range values {
+		topic.lastValues[signalPath] = value
+		topic.methods[signalPath] = methods[signalPath]
+	}
+
+	return nil
+}
+
+// addTopic writes the schema and channel of a topic. The JSON schema is built from the signals of its first message.
+func (w *InterpolatedMcapWriter) addTopic(topicName string, values map[string]float64) (*topicResampler, error) {
+	id := uint16(len(w.topics) + 1)
+
+	properties := make(map[string]interface{})
+	for signalPath := range values {
+		addSchemaProperty(properties, strings.Split(signalPath, "."))
+	}
+	schemaData, err := json.Marshal(map[string]interface{}{
+		"type":       "object",
+		"title":      topicName,
+		"properties": properties,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal json schema of %s: %v", topicName, err)
+	}
+
+	err = w.writer.WriteSchema(&mcap.Schema{ID: id, Name: topicName, Encoding: "jsonschema", Data: schemaData})
+	if err != nil {
+		return nil, fmt.Errorf("could not write schema of %s: %v", topicName, err)
+	}
+
+	err = w.writer.WriteChannel(&mcap.Channel{
+		ID:              id,
+		SchemaID:        id,
+		Topic:           utils.TrimTopic(topicName),
+		MessageEncoding: "json",
+		Metadata:        map[string]string{"interpolation_rate": fmt.Sprintf("%v", 1/w.period)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not write channel of %s: %v", topicName, err)
+	}
+
+	topic := &topicResampler{channelID: id}
+	w.topics[topicName] = topic
+	return topic, nil
+}
+
+// addSchemaProperty adds a nested number property to a JSON schema, creating the objects in between
+func addSchemaProperty(properties map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		properties[path[0]] = map[string]interface{}{"type": "number"}
+		return
+	}
+
+	nested, ok := properties[path[0]].(map[string]interface{})
+	if !ok {
+		nested = map[string]interface{}{"type": "object", "properties": make(map[string]interface{})}
+		properties[path[0]] = nested
+	}
+	addSchemaProperty(nested["properties"].(map[string]interface{}), path[1:])
+}
+
+// writePoint writes the values of a topic at its next time base point as a JSON message
+func (w *InterpolatedMcapWriter) writePoint(topic *topicResampler, values map[string]float64) error {
+	message := make(map[string]interface{})
+	for signalPath, value := range values {
+		// NaN and Inf are not valid JSON
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		nestSignalValue(message, strings.Split(signalPath, "."), value)
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("could not marshal interpolated message: %v", err)
+	}
+
+	logTime := *w.firstLogTime + uint64(topic.nextIndex)*w.periodNanos
+	err = w.writer.WriteMessage(&mcap.Message{
+		ChannelID:   topic.channelID,
+		Sequence:    topic.sequence,
+		LogTime:     logTime,
+		PublishTime: logTime,
+		Data:        data,
+	})
+	if err != nil {
+		return fmt.Errorf("could not write interpolated message: %v", err)
+	}
+
+	topic.sequence++
+	topic.nextIndex++
+	return nil
+}
+
+// nestSignalValue puts value into message at path, creating the nested objects in between
+func nestSignalValue(message map[string]interface{}, path []string, value float64) {
+	if len(path) == 1 {
+		message[path[0]] = value
+		return
+	}
+
+	nested, ok := message[path[0]].(map[string]interface{})
+	if !ok {
+		nested = make(map[string]interface{})
+		message[path[0]] = nested
+	}
+	nestSignalValue(nested, path[1:], value)
+}
+
+// Close writes the summary section of the MCAP file and closes it
+func (w *InterpolatedMcapWriter) Close() error {
+	err := w.writer.Close()
+	if err != nil {
+		w.file.Close()
+		return fmt.Errorf("could not close mcap writer: %v", err)
+	}
+
+	return w.file.Close()
+}
+
+func (w *InterpolatedMcapWriter) FilePath() string {
+	return w.filePath
+}
@@ -0,0 +1,228 @@
+package subscribers
+
+import (
+	"math"
+	"strings"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+const (
+	// MovingSpeed is the speed in m/s the car needs to go faster than to start moving,
+	// and StoppedSpeed the speed it needs to go slower than to stop
+	MovingSpeed  = 1.0
+	StoppedSpeed = 0.3
+
+	// ChargingCurrent is the current in A flowing into the accumulator for a stopped car to be charging,
+	// it stops charging when less than half of it flows
+	ChargingCurrent = 1.0
+
+	// MinSegmentDuration is the time in seconds a state needs to hold for a new segment to start
+	MinSegmentDuration = 5.0
+)
+
+// Segmenter splits a run into idle, moving and charging segments.
+// The car is moving while the wheel speed (the vn_vel_m_s speed for runs without current_rpms) is over MovingSpeed
+// or the inverters spin the motors, and charging while it is stopped and the current of its energy signals is negative.
+// The speeds and currents have hysteresis and a state needs to hold for MinSegmentDuration, so that short stops
+// in a stint or noisy signals don't split it.
+type Segmenter struct {
+	parameters    models.CarMetricsModel
+	currentSignal string
+	currentTopic  string
+
+	startTime    uint64
+	hasStartTime bool
+	lastTime     uint64
+
+	wheelSpeed    float64
+	hasWheelSpeed bool
+	gpsSpeed      float64
+	motorsOn      bool
+	current       float64
+
+	// state is the state of the last sample, it only becomes a segment once it held for MinSegmentDuration
+	state        string
+	hasState     bool
+	segmentType  string
+	segmentStart float64
+	pending      string
+	pendingStart float64
+
+	segments []models.SegmentModel
+}
+
+func NewSegmenter() *Segmenter {
+	segmenter := &Segmenter{
+		parameters: DefaultCarMetrics(),
+		segments:   make([]models.SegmentModel, 0),
+	}
+	return segmenter.WithSignals(DefaultEnergySignals())
+}
+
+// WithCarMetrics sets the gearing and tires the wheel speed is computed with
+func (s *Segmenter) WithCarMetrics(parameters models.CarMetricsModel) *Segmenter {
+	s.parameters = parameters
+	return s
+}
+
+// WithSignals sets the accumulator current signal of the charging segments, an empty current keeps the default
+func (s *Segmenter) WithSignals(signals EnergySignals) *Segmenter {
+	if signals.Current == "" {
+		signals.Current = DefaultEnergySignals().Current
+	}
+
+	s.currentSignal = signals.Current
+	s.currentTopic = strings.Split(signals.Current, ".")[0]
+	return s
+}
+
+// WithStartTime sets the log time the times of the segments are relative to
+func (s *Segmenter) WithStartTime(startTime uint64) *Segmenter {
+	s.startTime = startTime
+	s.hasStartTime = true
+	return s
+}
+
+// AddMessage reads the speed, motor rpms or accumulator current of a decoded message and updates the state of the car
+func (s *Segmenter) AddMessage(decodedMessage *utils.DecodedMessage) {
+	if !s.hasStartTime {
+		s.WithStartTime(decodedMessage.LogTime)
+	}
+
+	updated := false
+	switch topic := utils.TrimTopic(decodedMessage.Topic); topic {
+	case "VNData":
+		updated = s.addGPSSpeed(decodedMessage)
+	case "VehicleData":
+		updated = s.addWheelSpeed(decodedMessage)
+	}
+	if utils.TrimTopic(decodedMessage.Topic) == s.currentTopic {
+		updated = s.addCurrent(decodedMessage) || updated
+	}
+	if !updated {
+		return
+	}
+
+	s.lastTime = max(s.lastTime, decodedMessage.LogTime)
+	s.addState(LogTimeToTime(max(decodedMessage.LogTime, s.startTime), s.startTime))
+}
+
+func (s *Segmenter) addGPSSpeed(decodedMessage *utils.DecodedMessage) bool {
+	velocity, found := decodedMessage.Data["vn_vel_m_s"].(*dynamic.Message)
+	if !found {
+		return false
+	}
+	x, okX := dynamicFloatField(velocity, "x")
+	y, okY := dynamicFloatField(velocity, "y")
+	if !okX || !okY {
+		return false
+	}
+
+	s.gpsSpeed = math.Hypot(x, y)
+	return !s.hasWheelSpeed
+}
+
+func (s *Segmenter) addWheelSpeed(decodedMessage *utils.DecodedMessage) bool {
+	rpms, found := decodedMessage.Data["current_rpms"].(*dynamic.Message)
+	if !found {
+		return false
+	}
+
+	total, count, maxRpm := 0.0, 0, 0.0
+	for _, wheel := range []string{"FL", "FR", "RL", "RR"} {
+		rpm, ok := dynamicFloatField(rpms, wheel)
+		if !ok {
+			continue
+		}
+		total += math.Abs(rpm)
+		count++
+		maxRpm = math.Max(maxRpm, math.Abs(rpm))
+	}
+	if count == 0 {
+		return false
+	}
+
+	s.wheelSpeed = total / float64(count) * RpmToMetersPerSecond(s.parameters)
+	s.hasWheelSpeed = true
+	s.motorsOn = maxRpm >= MotorOnRpm
+	return true
+}
+
+func (s *Segmenter) addCurrent(decodedMessage *utils.DecodedMessage) bool {
+	updated := false
+	utils.WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+		if path != s.currentSignal {
+			return
+		}
+		floatValue, ok := utils.SignalFloatValue(value)
+		if !ok || math.IsNaN(floatValue) || math.IsInf(floatValue, 0) {
+			return
+		}
+		s.current = floatValue
+		updated = true
+	})
+	return updated
+}
+
+// nextState returns the state of the car from its last inputs, with hysteresis on the state of the last sample
+func (s *Segmenter) nextState() string {
+	speed := s.gpsSpeed
+	if s.hasWheelSpeed {
+		speed = s.wheelSpeed
+	}
+
+	if s.motorsOn || speed > MovingSpeed || (s.state == models.SegmentTypeMoving && speed > StoppedSpeed) {
+		return models.SegmentTypeMoving
+	}
+	if s.current < -ChargingCurrent || (s.state == models.SegmentTypeCharging && s.current < -ChargingCurrent/2) {
+		return models.SegmentTypeCharging
+	}
+	return models.SegmentTypeIdle
+}
+
+// addState updates the state at time t and starts a new segment once a new state held for MinSegmentDuration
+func (s *Segmenter) addState(t float64) {
+	s.state = s.nextState()
+	if !s.hasState {
+		s.hasState = true
+		s.segmentType, s.segmentStart = s.state, 0
+		return
+	}
+
+	switch {
+	case s.state == s.segmentType:
+		s.pending = ""
+	case s.state != s.pending:
+		s.pending, s.pendingStart = s.state, t
+	case t-s.pendingStart >= MinSegmentDuration:
+		s.segments = append(s.segments, s.segment(s.pendingStart))
+		s.segmentType, s.segmentStart = s.pending, s.pendingStart
+		s.pending = ""
+	}
+}
+
+// segment returns the current segment ending at end
+func (s *Segmenter) segment(end float64) models.SegmentModel {
+	return models.SegmentModel{
+		Id:       len(s.segments) + 1,
+		Type:     s.segmentType,
+		Start:    s.segmentStart,
+		End:      end,
+		Duration: end - s.segmentStart,
+	}
+}
+
+// Segments returns the segments of the run, they follow each other from the start of the run to its last sample.
+// A run which logged neither speeds nor currents has no segments.
+func (s *Segmenter) Segments() []models.SegmentModel {
+	if !s.hasState {
+		return s.segments
+	}
+
+	lastSegment := s.segment(LogTimeToTime(max(s.lastTime, s.startTime), s.startTime))
+	return append(append(make([]models.SegmentModel, 0, len(s.segments)+1), s.segments...), lastSegment)
+}
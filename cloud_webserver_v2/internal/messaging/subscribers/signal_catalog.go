@@ -0,0 +1,107 @@
+package subscribers
+
+import (
+	"math"
+	"sort"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// SignalCatalog keeps track of every signal seen in a stream of decoded MCAP messages.
+// It lets us know which topics and fields a run contains without having to open its HDF5 file.
+type SignalCatalog struct {
+	signals     map[string]*catalogEntry
+	signalUnits map[string]string
+}
+
+// catalogEntry holds the information of a signal as well as the log times needed to compute its rate
+// and the values needed to compute its statistics
+type catalogEntry struct {
+	signal       models.SignalModel
+	firstLogTime uint64
+	lastLogTime  uint64
+
+	valueCount int64
+	sum        float64
+	min        float64
+	max        float64
+}
+
+func NewSignalCatalog() *SignalCatalog {
+	return &SignalCatalog{
+		signals: make(map[string]*catalogEntry),
+	}
+}
+
+// WithSignalUnits sets the units of signals whose units can't be guessed from their name, like derived channels
+func (c *SignalCatalog) WithSignalUnits(signalUnits map[string]string) *SignalCatalog {
+	c.signalUnits = signalUnits
+	return c
+}
+
+// AddMessage adds every signal in decodedMessage to the catalog
+func (c *SignalCatalog) AddMessage(decodedMessage *utils.DecodedMessage) {
+	if decodedMessage == nil {
+		return
+	}
+
+	logTime := decodedMessage.LogTime
+	utils.WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+		entry, ok := c.signals[path]
+		if !ok {
+			entry = &catalogEntry{
+				signal: models.SignalModel{
+					Topic:     decodedMessage.Topic,
+					Path:      path,
+					Type:      utils.SignalType(field, value),
+					Units:     utils.SignalUnits(path),
+					EnumNames: utils.SignalEnumNames(field),
+				},
+				firstLogTime: logTime,
+			}
+			if units, ok := c.signalUnits[path]; ok {
+				entry.signal.Units = units
+			}
+			c.signals[path] = entry
+		}
+
+		entry.signal.MessageCount++
+		entry.firstLogTime = min(entry.firstLogTime, logTime)
+		entry.lastLogTime = max(entry.lastLogTime, logTime)
+
+		if floatValue, ok := utils.SignalFloatValue(value); ok && !math.IsNaN(floatValue) && !math.IsInf(floatValue, 0) {
+			if entry.valueCount == 0 {
+				entry.min, entry.max = floatValue, floatValue
+			}
+			entry.valueCount++
+			entry.sum += floatValue
+			entry.min = math.Min(entry.min, floatValue)
+			entry.max = math.Max(entry.max, floatValue)
+		}
+	})
+}
+
+// Signals returns all the signals in the catalog sorted by their path
+func (c *SignalCatalog) Signals() []models.SignalModel {
+	signals := make([]models.SignalModel, 0, len(c.signals))
+	for _, entry := range c.signals {
+		signal := entry.signal
+		duration := float64(entry.lastLogTime-entry.firstLogTime) / 1e9
+		if signal.MessageCount > 1 && duration > 0 {
+			signal.Rate = float64(signal.MessageCount-1) / duration
+		}
+		if entry.valueCount > 0 {
+			minValue, maxValue, mean := entry.min, entry.max, entry.sum/float64(entry.valueCount)
+			signal.Min, signal.Max, signal.Mean = &minValue, &maxValue, &mean
+		}
+		signals = append(signals, signal)
+	}
+
+	sort.Slice(signals, func(i, j int) bool {
+		return signals[i].Path < signals[j].Path
+	})
+
+	return signals
+}
@@ -0,0 +1,301 @@
+package subscribers
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/desc"
+	"gonum.org/v1/gonum/dsp/fourier"
+	"gonum.org/v1/gonum/dsp/window"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+const (
+	// SpectrumSegmentLength is the number of samples of the overlapping segments the PSD is averaged over,
+	// signals with fewer samples use the largest power of two they have
+	SpectrumSegmentLength = 1024
+
+	// minSpectrumSegmentLength is the fewest samples a signal needs for its PSD to be computed
+	minSpectrumSegmentLength = 64
+
+	// SpectrumPeakCount is the number of peak frequencies kept for every signal
+	SpectrumPeakCount = 5
+)
+
+// DefaultSpectrumSignals are the linear accelerations of the VectorNav, the chassis vibrations
+func DefaultSpectrumSignals() []string {
+	return []string{
+		"VNData.vn_linear_accel_m_ss.x",
+		"VNData.vn_linear_accel_m_ss.y",
+		"VNData.vn_linear_accel_m_ss.z",
+	}
+}
+
+// SpectrumAnalyzer computes the power spectral density of signals with Welch's method: the signal is resampled at its
+// median sample rate, split into segments overlapping by half, and the periodograms of the Hann windowed segments are averaged.
+type SpectrumAnalyzer struct {
+	signals []string
+	series  map[string]*utils.SignalSeries
+	topics  map[string]bool
+
+	startTime    uint64
+	hasStartTime bool
+}
+
+func NewSpectrumAnalyzer() *SpectrumAnalyzer {
+	return (&SpectrumAnalyzer{}).WithSignals(DefaultSpectrumSignals())
+}
+
+// WithSignals sets the paths of the signals to analyze, no signals keeps the defaults
+func (a *SpectrumAnalyzer) WithSignals(signals []string) *SpectrumAnalyzer {
+	if len(signals) == 0 {
+		signals = DefaultSpectrumSignals()
+	}
+
+	a.signals = signals
+	a.series = make(map[string]*utils.SignalSeries)
+	a.topics = make(map[string]bool)
+	for _, signal := range signals {
+		a.series[signal] = &utils.SignalSeries{Path: signal, Times: make([]float64, 0), Values: make([]float64, 0)}
+		a.topics[strings.Split(signal, ".")[0]] = true
+	}
+	return a
+}
+
+// WithStartTime sets the log time the times of the samples are relative to
+func (a *SpectrumAnalyzer) WithStartTime(startTime uint64) *SpectrumAnalyzer {
+	a.startTime = startTime
+	a.hasStartTime = true
+	return a
+}
+
+// AddMessage adds the values of the analyzed signals of a decoded message
+func (a *SpectrumAnalyzer) AddMessage(decodedMessage *utils.DecodedMessage) {
+	if !a.hasStartTime {
+		a.WithStartTime(decodedMessage.LogTime)
+	}
+	if !a.topics[utils.TrimTopic(decodedMessage.Topic)] || decodedMessage.LogTime < a.startTime {
+		return
+	}
+
+	sampleTime := LogTimeToTime(decodedMessage.LogTime, a.startTime)
+	utils.WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+		series, found := a.series[path]
+		if !found {
+			return
+		}
+		floatValue, ok := utils.SignalFloatValue(value)
+		if !ok || math.IsNaN(floatValue) || math.IsInf(floatValue, 0) {
+			return
+		}
+		// Samples logged out of order are skipped
+		if len(series.Times) > 0 && sampleTime <= series.Times[len(series.Times)-1] {
+			return
+		}
+		series.Times = append(series.Times, sampleTime)
+		series.Values = append(series.Values, floatValue)
+	})
+}
+
+// SignalSpectrum is the PSD of a signal, frequencies are in Hz and powers in units^2/Hz
+type SignalSpectrum struct {
+	Model       models.SpectrumModel
+	Frequencies []float64
+	Powers      []float64
+}
+
+// Spectra computes the PSD of every signal with enough samples, in the order of the signals
+func (a *SpectrumAnalyzer) Spectra() []SignalSpectrum {
+	spectra := make([]SignalSpectrum, 0, len(a.signals))
+	for _, signal := range a.signals {
+		if spectrum := welch(a.series[signal]); spectrum != nil {
+			spectra = append(spectra, *spectrum)
+		}
+	}
+	return spectra
+}
+
+// SpectrumModels returns the sample rates and peak frequencies of spectra
+func SpectrumModels(spectra []SignalSpectrum) []models.SpectrumModel {
+	spectrumModels := make([]models.SpectrumModel, len(spectra))
+	for i, spectrum := range spectra {
+		spectrumModels[i] = spectrum.Model
+	}
+	return spectrumModels
+}
+
+// welch computes the PSD of series, or returns nil if it has less than minSpectrumSegmentLength samples
+func welch(series *utils.SignalSeries) *SignalSpectrum {
+	if len(series.Times) < minSpectrumSegmentLength {
+		return nil
+	}
+
+	sampleRate := medianSampleRate(series.Times)
+	if sampleRate <= 0 {
+		return nil
+	}
+	sampleRate = math.Min(sampleRate, MaxInterpolationRate)
+	values := ResampleSeries(series, sampleRate, ResampleLinear).Values
+
+	segmentLength := SpectrumSegmentLength
+	for segmentLength > len(values) {
+		segmentLength /= 2
+	}
+	if segmentLength < minSpectrumSegmentLength {
+		return nil
+	}
+
+	// The PSD is scaled by the power of the window so it does not depend on the window or segment length
+	hann := window.Hann(ones(segmentLength))
+	windowPower := 0.0
+	for _, w := range hann {
+		windowPower += w * w
+	}
+
+	fft := fourier.NewFFT(segmentLength)
+	segment := make([]float64, segmentLength)
+	coefficients := make([]complex128, segmentLength/2+1)
+	powers := make([]float64, segmentLength/2+1)
+	segmentCount := 0
+	for start := 0; start+segmentLength <= len(values); start += segmentLength / 2 {
+		// Every segment is detrended by removing its mean so the DC component does not leak into the low frequencies
+		mean := 0.0
+		for _, value := range values[start : start+segmentLength] {
+			mean += value
+		}
+		mean /= float64(segmentLength)
+		for i, value := range values[start : start+segmentLength] {
+			segment[i] = (value - mean) * hann[i]
+		}
+
+		fft.Coefficients(coefficients, segment)
+		for i, coefficient := range coefficients {
+			power := real(coefficient)*real(coefficient) + imag(coefficient)*imag(coefficient)
+			// The spectrum is one-sided, every frequency but DC and Nyquist holds the power of its negative frequency too
+			if i != 0 && i != segmentLength/2 {
+				power *= 2
+			}
+			powers[i] += power / (sampleRate * windowPower)
+		}
+		segmentCount++
+	}
+
+	frequencies := make([]float64, len(powers))
+	for i := range powers {
+		powers[i] /= float64(segmentCount)
+		frequencies[i] = fft.Freq(i) * sampleRate
+	}
+
+	return &SignalSpectrum{
+		Model: models.SpectrumModel{
+			Signal:        series.Path,
+			SampleRate:    sampleRate,
+			SegmentLength: segmentLength,
+			SegmentCount:  segmentCount,
+			Resolution:    sampleRate / float64(segmentLength),
+			Peaks:         spectrumPeaks(frequencies, powers),
+		},
+		Frequencies: frequencies,
+		Powers:      powers,
+	}
+}
+
+// medianSampleRate returns the sample rate of the median time between two samples
+func medianSampleRate(times []float64) float64 {
+	intervals := make([]float64, 0, len(times)-1)
+	for i := 1; i < len(times); i++ {
+		intervals = append(intervals, times[i]-times[i-1])
+	}
+	sort.Float64s(intervals)
+
+	median := intervals[len(intervals)/2]
+	if median <= 0 {
+		return 0
+	}
+	return 1 / median
+}
+
+// spectrumPeaks returns the SpectrumPeakCount local maxima of powers with the most power, DC is never a peak
+func spectrumPeaks(frequencies, powers []float64) []models.SpectrumPeakModel {
+	peaks := make([]models.SpectrumPeakModel, 0)
+	for i := 1; i < len(powers); i++ {
+		if powers[i] <= powers[i-1] || (i+1 < len(powers) && powers[i] < powers[i+1]) {
+			continue
+		}
+		peaks = append(peaks, models.SpectrumPeakModel{Frequency: frequencies[i], Power: powers[i]})
+	}
+
+	sort.Slice(peaks, func(i, j int) bool { return peaks[i].Power > peaks[j].Power })
+	return peaks[:min(len(peaks), SpectrumPeakCount)]
+}
+
+func ones(n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = 1
+	}
+	return values
+}
+
+// SpectrumPlot returns a plot of spectra with a log scale power axis, or nil if there are no spectra
+func SpectrumPlot(spectra []SignalSpectrum) (*io.WriterTo, error) {
+	if len(spectra) == 0 {
+		return nil, nil
+	}
+
+	p := plot.New()
+	p.Title.Text = "Power Spectral Density"
+	p.X.Label.Text = "frequency (Hz)"
+	p.Y.Label.Text = "power spectral density (units²/Hz)"
+	p.Y.Scale = plot.LogScale{}
+	p.Y.Tick.Marker = plot.LogTicks{Prec: 0}
+	p.Add(plotter.NewGrid())
+
+	for i, spectrum := range spectra {
+		// The log scale can't draw the frequencies without power, DC is left out since every segment was detrended
+		points := make(plotter.XYs, 0, len(spectrum.Powers))
+		for j := 1; j < len(spectrum.Powers); j++ {
+			if spectrum.Powers[j] > 0 {
+				points = append(points, plotter.XY{X: spectrum.Frequencies[j], Y: spectrum.Powers[j]})
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+
+		line, err := plotter.NewLine(points)
+		if err != nil {
+			return nil, fmt.Errorf("could not create spectrum line: %+v", err)
+		}
+		line.LineStyle.Color = plotColors[i%len(plotColors)]
+		p.Add(line)
+		p.Legend.Add(spectrum.Model.Signal, line)
+	}
+	p.Legend.Top = true
+
+	writer, err := p.WriterTo(25*vg.Centimeter, 15*vg.Centimeter, "png")
+	if err != nil {
+		return nil, fmt.Errorf("could not get plot writer: %+v", err)
+	}
+
+	return &writer, nil
+}
+
+// plotColors are the colors of the lines of plots with several lines
+var plotColors = []color.Color{
+	color.RGBA{R: 31, G: 119, B: 180, A: 255},
+	color.RGBA{R: 255, G: 127, B: 14, A: 255},
+	color.RGBA{R: 44, G: 160, B: 44, A: 255},
+	color.RGBA{R: 214, G: 39, B: 40, A: 255},
+	color.RGBA{R: 148, G: 103, B: 189, A: 255},
+	color.RGBA{R: 140, G: 86, B: 75, A: 255},
+}
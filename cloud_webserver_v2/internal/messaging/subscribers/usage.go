@@ -0,0 +1,154 @@
+package subscribers
+
+import (
+	"math"
+
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+const (
+	// MotorOnRpm is the rpm a motor needs to spin at for the motors to count as on
+	MotorOnRpm = 50.0
+
+	// MaxUsageGap is the longest time in seconds the wheel speed is held between two samples
+	MaxUsageGap = 1.0
+
+	// maxGPSSpeed is the fastest the car can go in m/s, positions further apart are GPS glitches and are not counted
+	maxGPSSpeed = 60.0
+)
+
+// UsageTracker computes how much a run used the car: the distance driven from the vn_gps positions of hytech_msgs.VNData
+// messages and from the wheel speed (current_rpms of hytech_msgs.VehicleData messages), the time the motors were spinning
+// and the max speeds.
+type UsageTracker struct {
+	parameters models.CarMetricsModel
+	usage      models.RunUsageModel
+
+	hasPosition  bool
+	lastLat      float64
+	lastLon      float64
+	positionTime uint64
+
+	hasWheelSpeed  bool
+	lastWheelSpeed float64
+	lastMotorOn    bool
+	wheelSpeedTime uint64
+}
+
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{parameters: DefaultCarMetrics()}
+}
+
+// WithCarMetrics sets the gearing and tires the wheel speed is computed with
+func (u *UsageTracker) WithCarMetrics(parameters models.CarMetricsModel) *UsageTracker {
+	u.parameters = parameters
+	return u
+}
+
+// AddMessage adds the position of a VNData message or the wheel speed of a VehicleData message to the usage
+func (u *UsageTracker) AddMessage(decodedMessage *utils.DecodedMessage) {
+	switch utils.TrimTopic(decodedMessage.Topic) {
+	case "VNData":
+		u.addPosition(decodedMessage)
+	case "VehicleData":
+		u.addWheelSpeed(decodedMessage)
+	}
+}
+
+func (u *UsageTracker) addPosition(decodedMessage *utils.DecodedMessage) {
+	if velocity, found := decodedMessage.Data["vn_vel_m_s"].(*dynamic.Message); found {
+		x, okX := dynamicFloatField(velocity, "x")
+		y, okY := dynamicFloatField(velocity, "y")
+		if okX && okY {
+			u.usage.MaxGPSSpeed = math.Max(u.usage.MaxGPSSpeed, math.Hypot(x, y))
+		}
+	}
+
+	gpsDynamicMessage, found := decodedMessage.Data["vn_gps"].(*dynamic.Message)
+	if !found {
+		return
+	}
+	lat, okLat := dynamicFloatField(gpsDynamicMessage, "lat")
+	lon, okLon := dynamicFloatField(gpsDynamicMessage, "lon")
+	if !okLat || !okLon || lat == 0 || lon == 0 {
+		return
+	}
+
+	logTime := decodedMessage.LogTime
+	if !u.hasPosition {
+		u.hasPosition = true
+		u.lastLat, u.lastLon, u.positionTime = lat, lon, logTime
+		return
+	}
+
+	x, y := LatLonToCartesian(lat, lon, u.lastLat, u.lastLon)
+	step := math.Hypot(x, y)
+	if step < minLapStep {
+		return
+	}
+	if logTime > u.positionTime && step/(float64(logTime-u.positionTime)/1e9) <= maxGPSSpeed {
+		u.usage.GPSDistance += step
+	}
+	u.lastLat, u.lastLon, u.positionTime = lat, lon, logTime
+}
+
+func (u *UsageTracker) addWheelSpeed(decodedMessage *utils.DecodedMessage) {
+	rpms, found := decodedMessage.Data["current_rpms"].(*dynamic.Message)
+	if !found {
+		return
+	}
+
+	total, count, maxRpm := 0.0, 0, 0.0
+	for _, wheel := range []string{"FL", "FR", "RL", "RR"} {
+		rpm, ok := dynamicFloatField(rpms, wheel)
+		if !ok {
+			continue
+		}
+		total += math.Abs(rpm)
+		count++
+		maxRpm = math.Max(maxRpm, math.Abs(rpm))
+	}
+	if count == 0 {
+		return
+	}
+
+	logTime := decodedMessage.LogTime
+	wheelSpeed := total / float64(count) * RpmToMetersPerSecond(u.parameters)
+	if u.hasWheelSpeed && logTime > u.wheelSpeedTime {
+		dt := float64(logTime-u.wheelSpeedTime) / 1e9
+		if dt <= MaxUsageGap {
+			u.usage.WheelSpeedDistance += u.lastWheelSpeed * dt
+			if u.lastMotorOn {
+				u.usage.MotorOnTime += dt
+			}
+		}
+	} else if u.hasWheelSpeed {
+		return
+	}
+
+	u.usage.MaxWheelSpeed = math.Max(u.usage.MaxWheelSpeed, wheelSpeed)
+	u.hasWheelSpeed = true
+	u.lastWheelSpeed, u.lastMotorOn, u.wheelSpeedTime = wheelSpeed, maxRpm >= MotorOnRpm, logTime
+}
+
+// Usage returns the usage of the run, the distance is the GPS distance unless the run had no GPS fix.
+// It returns nil if neither the positions nor the wheel speed were logged.
+func (u *UsageTracker) Usage() *models.RunUsageModel {
+	if !u.hasPosition && !u.hasWheelSpeed {
+		return nil
+	}
+
+	usage := u.usage
+	if usage.GPSDistance > 0 {
+		usage.Distance = usage.GPSDistance
+		usage.DistanceSource = models.DistanceSourceGPS
+		usage.MaxSpeed = usage.MaxGPSSpeed
+	} else {
+		usage.Distance = usage.WheelSpeedDistance
+		usage.DistanceSource = models.DistanceSourceWheelSpeed
+		usage.MaxSpeed = usage.MaxWheelSpeed
+	}
+	return &usage
+}
@@ -5,18 +5,29 @@ import (
 	"io"
 	"math"
 
+	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
+
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/vg"
 )
 
-const GearboxRatio = 11.86
-const WheelDiameter = 0.4064 // meters
-const RpmToMetersPerSecond = WheelDiameter * math.Pi / GearboxRatio / 60.0
-const RpmToKilometersPerHour = RpmToMetersPerSecond * 3600.0 / 1000.0
+// DefaultCarMetrics are the parameters of HT09 used for runs whose car has no parameter set in effect on their date
+func DefaultCarMetrics() models.CarMetricsModel {
+	return models.CarMetricsModel{
+		CarModel:     "HT09",
+		GearboxRatio: 11.86,
+		TireRadius:   0.2032, // meters
+	}
+}
+
+// RpmToMetersPerSecond converts a motor rpm to the speed of the car with the gearing and tires of parameters
+func RpmToMetersPerSecond(parameters models.CarMetricsModel) float64 {
+	return 2 * parameters.TireRadius * math.Pi / parameters.GearboxRatio / 60.0
+}
 
-func RPMToLinearVelocity(rpm float32) float64 {
-	return float64(rpm) * RpmToMetersPerSecond
+func RPMToLinearVelocity(rpm float32, parameters models.CarMetricsModel) float64 {
+	return float64(rpm) * RpmToMetersPerSecond(parameters)
 }
 
 func LogTimeToTime(logTime uint64, initialTime uint64) float64 {
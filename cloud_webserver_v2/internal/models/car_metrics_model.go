@@ -1,10 +1,42 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
 
-// CarMetricsModel contains
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CarMetricsModel is a versioned set of vehicle parameters of a car.
+// A car has a new parameter set every time something like its gearing or tires changes, and a run uses the
+// parameter set of its car with the latest EffectiveFrom before the date of the run.
+// Fields are omitted when empty so an update only replaces the parameters it is given.
 type CarMetricsModel struct {
 	Id       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	CarModel string             `json:"car_model" bson:"car_model,omitempty"`
-	// TODO: Add Information we want to track, empty for now as we haven't decided
+
+	// Version counts the parameter sets of CarModel, starting at 1
+	Version int `json:"version" bson:"version,omitempty"`
+
+	// EffectiveFrom is the date the parameters started being used on the car
+	EffectiveFrom time.Time `json:"effective_from" bson:"effective_from,omitempty"`
+
+	// GearboxRatio is the number of motor revolutions per wheel revolution
+	GearboxRatio float64 `json:"gearbox_ratio" bson:"gearbox_ratio,omitempty"`
+
+	// TireRadius is the loaded radius of the tires in meters
+	TireRadius float64 `json:"tire_radius" bson:"tire_radius,omitempty"`
+
+	// Mass is the mass of the car with a driver in kg
+	Mass float64 `json:"mass" bson:"mass,omitempty"`
+
+	// Wheelbase is the distance between the front and rear axles in meters
+	Wheelbase float64 `json:"wheelbase" bson:"wheelbase,omitempty"`
+
+	// TrackWidth is the distance between the left and right tires in meters
+	TrackWidth float64 `json:"track_width" bson:"track_width,omitempty"`
+
+	// BatteryCapacity is the usable energy of the accumulator in kWh
+	BatteryCapacity float64 `json:"battery_capacity" bson:"battery_capacity,omitempty"`
+
+	Notes string `json:"notes,omitempty" bson:"notes,omitempty"`
 }
@@ -0,0 +1,64 @@
+package models
+
+// CompareModel overlays signals of several runs on a common axis, the time or distance from the start of the runs or of one of their laps.
+// The first run is the reference the other runs are compared to.
+type CompareModel struct {
+	// Align is how the runs were aligned (time, distance or lap) and Axis the name and unit of X
+	Align string `json:"align" bson:"align"`
+	Axis  string `json:"axis" bson:"axis"`
+
+	Runs []CompareRunModel `json:"runs" bson:"runs"`
+
+	// X is the axis every signal is resampled on, it only covers the part of the axis all the runs have
+	X []float64 `json:"x" bson:"x"`
+
+	Signals []CompareSignalModel `json:"signals" bson:"signals"`
+
+	// Plot is the overlay plot of the signals, a base64 encoded PNG
+	Plot string `json:"plot,omitempty" bson:"plot,omitempty"`
+}
+
+// CompareRunModel is a run of a comparison and the window of it which was compared, in seconds relative to the start of the run
+type CompareRunModel struct {
+	Id    string  `json:"id" bson:"id"`
+	Label string  `json:"label" bson:"label"`
+	Lap   *int    `json:"lap,omitempty" bson:"lap,omitempty"`
+	Start float64 `json:"start" bson:"start"`
+	End   float64 `json:"end" bson:"end"`
+}
+
+// CompareSignalModel is a signal of a comparison with its values in every run, in the order of the runs
+type CompareSignalModel struct {
+	Signal string                  `json:"signal" bson:"signal"`
+	Runs   []CompareSignalRunModel `json:"runs" bson:"runs"`
+}
+
+// CompareSignalRunModel is a signal of a run resampled on the X of the comparison.
+// Values, Stats and Delta are left out if the run did not log the signal.
+type CompareSignalRunModel struct {
+	RunId  string             `json:"run_id" bson:"run_id"`
+	Values []float64          `json:"values,omitempty" bson:"values,omitempty"`
+	Stats  *CompareStatsModel `json:"stats,omitempty" bson:"stats,omitempty"`
+	Delta  *CompareDeltaModel `json:"delta,omitempty" bson:"delta,omitempty"`
+}
+
+// CompareStatsModel are the statistics of a resampled signal
+type CompareStatsModel struct {
+	Mean   float64 `json:"mean" bson:"mean"`
+	Min    float64 `json:"min" bson:"min"`
+	Max    float64 `json:"max" bson:"max"`
+	StdDev float64 `json:"std_dev" bson:"std_dev"`
+}
+
+// CompareDeltaModel is how a resampled signal differs from the same signal of the reference run, differences are the run minus the reference
+type CompareDeltaModel struct {
+	// Mean, Min, Max and StdDev are the differences between the statistics of the run and of the reference
+	Mean   float64 `json:"mean" bson:"mean"`
+	Min    float64 `json:"min" bson:"min"`
+	Max    float64 `json:"max" bson:"max"`
+	StdDev float64 `json:"std_dev" bson:"std_dev"`
+
+	// RMS is the root mean square of the pointwise differences and MaxAbs the largest absolute pointwise difference
+	RMS    float64 `json:"rms" bson:"rms"`
+	MaxAbs float64 `json:"max_abs" bson:"max_abs"`
+}
@@ -0,0 +1,99 @@
+package models
+
+import "math"
+
+// DataQualityModel is the data quality report of a run.
+// It is generated while a run is ingested and summarizes everything that went wrong while logging and decoding it.
+type DataQualityModel struct {
+	// Score goes from 0 (unusable) to 100 (no issues found), see CalculateScore
+	Score float64 `json:"score" bson:"score"`
+
+	// MessageCount is the total number of messages in the MCAP file, including the ones which failed to decode
+	MessageCount int64 `json:"message_count" bson:"message_count"`
+
+	// Duration is the time between the first and last message in seconds
+	Duration float64 `json:"duration" bson:"duration"`
+
+	// DecodeFailures contains the number of messages which could not be decoded for each schema
+	DecodeFailures []DecodeFailureModel `json:"decode_failures" bson:"decode_failures"`
+
+	// FailedHDF5Messages is the number of values which could not be written into the HDF5 file
+	FailedHDF5Messages int64 `json:"failed_hdf5_messages" bson:"failed_hdf5_messages"`
+
+	// ZeroMessageTopics are topics which have a channel in the MCAP file but never logged a message
+	ZeroMessageTopics []string `json:"zero_message_topics" bson:"zero_message_topics"`
+
+	// Topics contains the timing report of every topic which logged messages
+	Topics []TopicQualityModel `json:"topics" bson:"topics"`
+}
+
+// DecodeFailureModel contains the decode failures of a single schema
+type DecodeFailureModel struct {
+	Schema    string `json:"schema" bson:"schema"`
+	Count     int64  `json:"count" bson:"count"`
+	LastError string `json:"last_error" bson:"last_error"`
+}
+
+// TopicQualityModel contains the timing report of a single topic
+type TopicQualityModel struct {
+	Topic        string  `json:"topic" bson:"topic"`
+	MessageCount int64   `json:"message_count" bson:"message_count"`
+	Rate         float64 `json:"rate" bson:"rate"`
+
+	// ExpectedPeriod is the median time between two messages of the topic in seconds
+	ExpectedPeriod float64 `json:"expected_period" bson:"expected_period"`
+
+	// Gaps are the times between two messages which were much longer than the expected period.
+	// Only the first few gaps are kept, GapCount and TotalGapTime account for all of them.
+	GapCount     int64            `json:"gap_count" bson:"gap_count"`
+	TotalGapTime float64          `json:"total_gap_time" bson:"total_gap_time"`
+	MaxGap       float64          `json:"max_gap" bson:"max_gap"`
+	Gaps         []TimeRangeModel `json:"gaps" bson:"gaps"`
+
+	// NonMonotonicCount is the number of messages whose log time came before the previous message of the topic
+	NonMonotonicCount int64 `json:"non_monotonic_count" bson:"non_monotonic_count"`
+}
+
+// TimeRangeModel is a window of time in seconds relative to the start of a run
+type TimeRangeModel struct {
+	Start float64 `json:"start" bson:"start"`
+	End   float64 `json:"end" bson:"end"`
+}
+
+// CalculateScore sets the score of the report. Every kind of issue takes away a part of the score
+// proportional to how much of the run it affects:
+//   - up to 40 points for messages which failed to decode
+//   - up to 30 points for time spent in gaps
+//   - up to 10 points for non-monotonic log times
+//   - up to 10 points for topics with zero messages
+//   - up to 10 points for values which could not be written into the HDF5 file
+func (report *DataQualityModel) CalculateScore() {
+	score := 100.0
+	if report.MessageCount > 0 {
+		var decodeFailures, nonMonotonic int64
+		for _, failure := range report.DecodeFailures {
+			decodeFailures += failure.Count
+		}
+		for _, topic := range report.Topics {
+			nonMonotonic += topic.NonMonotonicCount
+		}
+
+		score -= 40 * float64(decodeFailures) / float64(report.MessageCount)
+		score -= 10 * math.Min(float64(nonMonotonic)/float64(report.MessageCount), 1)
+		score -= 10 * math.Min(float64(report.FailedHDF5Messages)/float64(report.MessageCount), 1)
+	}
+
+	if report.Duration > 0 && len(report.Topics) > 0 {
+		totalGapTime := 0.0
+		for _, topic := range report.Topics {
+			totalGapTime += topic.TotalGapTime
+		}
+		score -= 30 * math.Min(totalGapTime/(report.Duration*float64(len(report.Topics))), 1)
+	}
+
+	if topicCount := len(report.Topics) + len(report.ZeroMessageTopics); topicCount > 0 {
+		score -= 10 * float64(len(report.ZeroMessageTopics)) / float64(topicCount)
+	}
+
+	report.Score = math.Round(math.Max(score, 0)*100) / 100
+}
@@ -0,0 +1,21 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// DerivedChannelModel is a signal computed from other signals of a run while it is ingested,
+// like wheel speed from current_rpms or electrical power from pack voltage and current.
+// Derived signals are stored under the Derived topic (Derived.<Name>) in the HDF5 files and the signal catalog.
+type DerivedChannelModel struct {
+	Id primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+
+	// Name is the name of the signal, made of letters, digits and underscores
+	Name string `json:"name" bson:"name"`
+
+	// Expression computes the signal from other signal paths, e.g. VehicleData.current_rpms.FL * 2 * pi * TireRadius / GearboxRatio / 60
+	Expression string `json:"expression" bson:"expression"`
+
+	// Units of the computed signal
+	Units string `json:"units" bson:"units"`
+
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+}
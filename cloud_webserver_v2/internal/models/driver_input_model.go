@@ -0,0 +1,45 @@
+package models
+
+// DriverInputModel is the driver input report of a run, how the driver used the throttle, brake and steering
+// over the whole run and on every lap, so drivers can be compared across sessions.
+type DriverInputModel struct {
+	// ThrottleSignal, BrakeSignal and SteeringSignal are the signal paths the report was computed from
+	ThrottleSignal string `json:"throttle_signal" bson:"throttle_signal"`
+	BrakeSignal    string `json:"brake_signal" bson:"brake_signal"`
+	SteeringSignal string `json:"steering_signal" bson:"steering_signal"`
+
+	// Run are the inputs of the whole run, the time before the first and after the last lap included
+	Run DriverInputStatsModel `json:"run" bson:"run"`
+
+	// Laps are the inputs of every lap detected in the run
+	Laps []LapDriverInputModel `json:"laps" bson:"laps"`
+}
+
+// DriverInputStatsModel is how the driver used the throttle, brake and steering during a part of a run.
+// Percentages are of Duration and times are in seconds.
+type DriverInputStatsModel struct {
+	// Duration is the time the throttle was logged for, gaps in the signals are left out
+	Duration float64 `json:"duration" bson:"duration"`
+
+	// FullThrottle is the percentage of the time the throttle was fully pressed and AverageThrottle its mean travel in percent
+	FullThrottle    float64 `json:"full_throttle" bson:"full_throttle"`
+	AverageThrottle float64 `json:"average_throttle" bson:"average_throttle"`
+
+	// BrakeApplications is the number of times the brake was pressed and Braking the percentage of the time it was pressed
+	BrakeApplications int     `json:"brake_applications" bson:"brake_applications"`
+	Braking           float64 `json:"braking" bson:"braking"`
+
+	// CoastingTime is the time neither the throttle nor the brake were pressed
+	CoastingTime float64 `json:"coasting_time" bson:"coasting_time"`
+
+	// SteeringRate is the mean absolute speed of the steering wheel in degrees per second and SteeringReversals
+	// the number of times it changed direction. They are left out if the steering was not logged.
+	SteeringRate      *float64 `json:"steering_rate,omitempty" bson:"steering_rate,omitempty"`
+	SteeringReversals *int     `json:"steering_reversals,omitempty" bson:"steering_reversals,omitempty"`
+}
+
+// LapDriverInputModel is how the driver used the throttle, brake and steering during a single lap
+type LapDriverInputModel struct {
+	Lap                   int `json:"lap" bson:"lap"`
+	DriverInputStatsModel `bson:",inline"`
+}
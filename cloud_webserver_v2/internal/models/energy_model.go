@@ -0,0 +1,44 @@
+package models
+
+// EnergyModel is the energy report of a run, the accumulator power integrated over time.
+// Power is the product of the accumulator voltage and current, positive when the accumulator is discharging.
+// Energies are in kWh and powers in kW.
+type EnergyModel struct {
+	// VoltageSignal and CurrentSignal are the signal paths the power was computed from
+	VoltageSignal string `json:"voltage_signal" bson:"voltage_signal"`
+	CurrentSignal string `json:"current_signal" bson:"current_signal"`
+
+	// ConsumedEnergy is the energy drawn from the accumulator and RegenEnergy the energy put back into it by regenerative braking
+	ConsumedEnergy float64 `json:"consumed_energy" bson:"consumed_energy"`
+	RegenEnergy    float64 `json:"regen_energy" bson:"regen_energy"`
+
+	// NetEnergy is ConsumedEnergy minus RegenEnergy
+	NetEnergy float64 `json:"net_energy" bson:"net_energy"`
+
+	// PeakPower is the highest discharge power and PeakRegenPower the highest charge power, both are positive
+	PeakPower      float64 `json:"peak_power" bson:"peak_power"`
+	PeakRegenPower float64 `json:"peak_regen_power" bson:"peak_regen_power"`
+
+	// AveragePower is NetEnergy over Duration
+	AveragePower float64 `json:"average_power" bson:"average_power"`
+
+	// Duration is the time in seconds the power was logged for, gaps in the signals are left out
+	Duration float64 `json:"duration" bson:"duration"`
+
+	// StateOfChargeDrop is NetEnergy as a percentage of the battery capacity of the car metrics of the run.
+	// It is left out if the car metrics have no battery capacity.
+	StateOfChargeDrop *float64 `json:"state_of_charge_drop,omitempty" bson:"state_of_charge_drop,omitempty"`
+
+	// Laps is the energy of every lap detected in the run
+	Laps []LapEnergyModel `json:"laps" bson:"laps"`
+}
+
+// LapEnergyModel is the energy used during a single lap
+type LapEnergyModel struct {
+	Lap            int     `json:"lap" bson:"lap"`
+	ConsumedEnergy float64 `json:"consumed_energy" bson:"consumed_energy"`
+	RegenEnergy    float64 `json:"regen_energy" bson:"regen_energy"`
+	NetEnergy      float64 `json:"net_energy" bson:"net_energy"`
+	PeakPower      float64 `json:"peak_power" bson:"peak_power"`
+	AveragePower   float64 `json:"average_power" bson:"average_power"`
+}
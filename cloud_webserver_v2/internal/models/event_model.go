@@ -0,0 +1,54 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// EventRuleModel finds events in a signal while a run is ingested, like a BMS fault flag being set or the state of an inverter changing
+type EventRuleModel struct {
+	Id primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+
+	// Name is the type of the events found by the rule, made of letters, digits and underscores
+	Name string `json:"name" bson:"name"`
+
+	// Signal is the path of the signal the rule watches, e.g. ACUCoreData.bms_fault
+	Signal string `json:"signal" bson:"signal"`
+
+	// Condition is one of the EventCondition constants
+	Condition string `json:"condition" bson:"condition"`
+
+	// Threshold is the value crossed by the rises_above and falls_below conditions
+	Threshold float64 `json:"threshold,omitempty" bson:"threshold,omitempty"`
+
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+}
+
+// Conditions of an EventRuleModel
+const (
+	// EventConditionBecomesTrue finds the samples where the signal becomes non zero, including the first one
+	EventConditionBecomesTrue = "becomes_true"
+
+	// EventConditionChanges finds the samples where the signal changes, like the state enum of a state machine
+	EventConditionChanges = "changes"
+
+	// EventConditionRisesAbove and EventConditionFallsBelow find the samples where the signal crosses the threshold
+	EventConditionRisesAbove = "rises_above"
+	EventConditionFallsBelow = "falls_below"
+)
+
+// EventModel is an event found in a run by an EventRuleModel.
+// Its time is in seconds relative to the first message of the run like the signal queries.
+type EventModel struct {
+	Id    primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	RunId primitive.ObjectID `json:"run_id" bson:"run_id"`
+
+	// Type is the name of the rule which found the event
+	Type   string  `json:"type" bson:"type"`
+	Signal string  `json:"signal" bson:"signal"`
+	Time   float64 `json:"time" bson:"time"`
+	Value  float64 `json:"value" bson:"value"`
+
+	// PreviousValue is the value of the signal before the event, it is nil for the first sample of the signal
+	PreviousValue *float64 `json:"previous_value" bson:"previous_value"`
+
+	// Label is the name of the enum value of the signal, if it is an enum
+	Label string `json:"label,omitempty" bson:"label,omitempty"`
+}
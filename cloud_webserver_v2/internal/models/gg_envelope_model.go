@@ -0,0 +1,30 @@
+package models
+
+// GGEnvelopeModel is the acceleration envelope of a run, the friction circle the car used.
+// Accelerations are in g, longitudinal is positive when the car speeds up and lateral is positive to the right.
+type GGEnvelopeModel struct {
+	// Percentile is the percentile of the accelerations every limit is taken at, outliers above it are left out
+	Percentile float64 `json:"percentile" bson:"percentile"`
+
+	// SampleCount is the number of acceleration samples the envelope was computed from
+	SampleCount int64 `json:"sample_count" bson:"sample_count"`
+
+	MaxAcceleration float64 `json:"max_acceleration" bson:"max_acceleration"`
+	MaxBraking      float64 `json:"max_braking" bson:"max_braking"`
+	MaxLateralLeft  float64 `json:"max_lateral_left" bson:"max_lateral_left"`
+	MaxLateralRight float64 `json:"max_lateral_right" bson:"max_lateral_right"`
+
+	// MaxCombined is the limit of the total horizontal acceleration in any direction
+	MaxCombined float64 `json:"max_combined" bson:"max_combined"`
+
+	// Sectors is the limit of the total acceleration in every direction of the diagram
+	Sectors []GGSectorModel `json:"sectors" bson:"sectors"`
+}
+
+// GGSectorModel is the acceleration limit of the directions between StartAngle and EndAngle,
+// angles are in degrees clockwise from straight ahead (0 is accelerating, 90 is turning right, 180 is braking)
+type GGSectorModel struct {
+	StartAngle float64 `json:"start_angle" bson:"start_angle"`
+	EndAngle   float64 `json:"end_angle" bson:"end_angle"`
+	Limit      float64 `json:"limit" bson:"limit"`
+}
@@ -0,0 +1,16 @@
+package models
+
+// LapModel is a lap of a run, its times are in seconds relative to the first message of the run like the signal queries
+type LapModel struct {
+	// Number counts the laps of the run, starting at 1
+	Number int `json:"number" bson:"number"`
+
+	Start float64 `json:"start" bson:"start"`
+	End   float64 `json:"end" bson:"end"`
+
+	// Duration is the lap time in seconds
+	Duration float64 `json:"duration" bson:"duration"`
+
+	// Distance is the GPS distance driven during the lap in meters
+	Distance float64 `json:"distance" bson:"distance"`
+}
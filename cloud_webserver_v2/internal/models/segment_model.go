@@ -0,0 +1,22 @@
+package models
+
+// SegmentModel is a part of a run where the car was idle, moving or charging.
+// Its times are in seconds relative to the first message of the run like the signal queries.
+type SegmentModel struct {
+	// Id counts the segments of the run, starting at 1
+	Id   int    `json:"id" bson:"id"`
+	Type string `json:"type" bson:"type"`
+
+	Start float64 `json:"start" bson:"start"`
+	End   float64 `json:"end" bson:"end"`
+
+	// Duration is the length of the segment in seconds
+	Duration float64 `json:"duration" bson:"duration"`
+}
+
+// Types of a SegmentModel
+const (
+	SegmentTypeIdle     = "idle"
+	SegmentTypeMoving   = "moving"
+	SegmentTypeCharging = "charging"
+)
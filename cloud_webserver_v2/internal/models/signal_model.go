@@ -0,0 +1,41 @@
+package models
+
+// SignalModel describes a single signal found in a run.
+// These are collected while a run is ingested so we know what is inside of it without opening the HDF5 file.
+type SignalModel struct {
+	// Topic is the MCAP topic (schema name) the signal was found in
+	Topic string `json:"topic" bson:"topic"`
+
+	// Path is the full path of the signal, e.g. VehicleData.current_rpms.FL
+	Path string `json:"path" bson:"path"`
+
+	// Type is the protobuf type of the signal (float, int32, enum, bool...)
+	Type string `json:"type" bson:"type"`
+
+	// Units of the signal, empty if they are unknown
+	Units string `json:"units,omitempty" bson:"units,omitempty"`
+
+	// EnumNames contains the names of all the enum values if the signal is an enum
+	EnumNames []string `json:"enum_names,omitempty" bson:"enum_names,omitempty"`
+
+	// MessageCount is the number of times the signal was logged in the run
+	MessageCount int64 `json:"message_count" bson:"message_count"`
+
+	// Rate is the average rate the signal was logged at in Hz
+	Rate float64 `json:"rate" bson:"rate"`
+
+	// Min, Max and Mean are the statistics of the values of numeric, bool and enum signals, they are left out for other signals
+	Min  *float64 `json:"min,omitempty" bson:"min,omitempty"`
+	Max  *float64 `json:"max,omitempty" bson:"max,omitempty"`
+	Mean *float64 `json:"mean,omitempty" bson:"mean,omitempty"`
+}
+
+// SignalSummaryModel describes a signal across all the runs it is found in
+type SignalSummaryModel struct {
+	Path         string   `json:"path" bson:"_id"`
+	Topic        string   `json:"topic" bson:"topic"`
+	Type         string   `json:"type" bson:"type"`
+	EnumNames    []string `json:"enum_names,omitempty" bson:"enum_names,omitempty"`
+	RunCount     int64    `json:"run_count" bson:"run_count"`
+	MessageCount int64    `json:"message_count" bson:"message_count"`
+}
@@ -0,0 +1,26 @@
+package models
+
+// SpectrumModel is the power spectral density of a signal of a run, computed with Welch's method while the run is ingested.
+// Frequencies are in Hz and powers in the squared units of the signal per Hz.
+type SpectrumModel struct {
+	Signal string `json:"signal" bson:"signal"`
+
+	// SampleRate is the rate the signal was resampled at, the median rate it was logged at
+	SampleRate float64 `json:"sample_rate" bson:"sample_rate"`
+
+	// SegmentLength is the number of samples of the segments the PSD was averaged over and SegmentCount the number of segments
+	SegmentLength int `json:"segment_length" bson:"segment_length"`
+	SegmentCount  int `json:"segment_count" bson:"segment_count"`
+
+	// Resolution is the spacing of the frequencies of the PSD
+	Resolution float64 `json:"resolution" bson:"resolution"`
+
+	// Peaks are the frequencies with the most power, sorted by power
+	Peaks []SpectrumPeakModel `json:"peaks" bson:"peaks"`
+}
+
+// SpectrumPeakModel is a local maximum of a power spectral density
+type SpectrumPeakModel struct {
+	Frequency float64 `json:"frequency" bson:"frequency"`
+	Power     float64 `json:"power" bson:"power"`
+}
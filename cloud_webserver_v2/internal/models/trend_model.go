@@ -0,0 +1,96 @@
+package models
+
+// TrendQueryModel aggregates a statistic of the runs matching the run filters, like the best lap time at a track per car
+// or the max pack temperature per day.
+type TrendQueryModel struct {
+	// Metric is one of the TrendMetrics, or of the TrendSignalMetrics with Signal the path of the signal
+	Metric string
+	Signal string
+
+	// Function is how the values of the metric are aggregated, one of the TrendFunctions
+	Function string
+
+	// GroupBy are the TrendGroups the runs are grouped by, no groups aggregates every run together
+	GroupBy []string
+
+	// Interval is the TrendIntervals the dates are grouped by
+	Interval string
+}
+
+// TrendBucketModel is the aggregated metric of a group of runs
+type TrendBucketModel struct {
+	// Group is the value of every group of the query, dates are formatted by the interval (2024-05-14, 2024-W20 or 2024-05)
+	Group map[string]interface{} `json:"group" bson:"group"`
+
+	// Value is the aggregated metric, it is nil if none of the runs of the group had the metric
+	Value *float64 `json:"value" bson:"value"`
+
+	// SampleCount is the number of values aggregated, which are laps for lap metrics, and RunCount the number of runs they come from
+	SampleCount int64 `json:"sample_count" bson:"sample_count"`
+	RunCount    int64 `json:"run_count" bson:"run_count"`
+}
+
+// TrendMetrics maps the metrics trends are computed on to the fields of the runs holding them.
+// Fields inside of the laps array have a value for every lap.
+var TrendMetrics = map[string]string{
+	"lap_time":             "laps.duration",
+	"lap_distance":         "laps.distance",
+	"distance":             "usage.distance",
+	"motor_on_time":        "usage.motor_on_time",
+	"max_speed":            "usage.max_speed",
+	"consumed_energy":      "energy.consumed_energy",
+	"regen_energy":         "energy.regen_energy",
+	"net_energy":           "energy.net_energy",
+	"peak_power":           "energy.peak_power",
+	"state_of_charge_drop": "energy.state_of_charge_drop",
+	"max_acceleration":     "gg_envelope.max_acceleration",
+	"max_braking":          "gg_envelope.max_braking",
+	"max_lateral_left":     "gg_envelope.max_lateral_left",
+	"max_lateral_right":    "gg_envelope.max_lateral_right",
+	"max_combined_accel":   "gg_envelope.max_combined",
+	"quality_score":        "quality_report.score",
+	"full_throttle":        "driver_inputs.run.full_throttle",
+	"average_throttle":     "driver_inputs.run.average_throttle",
+	"brake_applications":   "driver_inputs.run.brake_applications",
+	"coasting_time":        "driver_inputs.run.coasting_time",
+}
+
+// TrendSignalMetrics maps the metrics of a single signal to the fields of the signals of the runs holding them
+var TrendSignalMetrics = map[string]string{
+	"signal_min":  "signals.min",
+	"signal_max":  "signals.max",
+	"signal_mean": "signals.mean",
+}
+
+// Functions aggregating the values of a TrendQueryModel
+const (
+	TrendFunctionAvg   = "avg"
+	TrendFunctionMin   = "min"
+	TrendFunctionMax   = "max"
+	TrendFunctionSum   = "sum"
+	TrendFunctionCount = "count"
+)
+
+// TrendFunctions are all the functions of a TrendQueryModel
+var TrendFunctions = []string{TrendFunctionAvg, TrendFunctionMin, TrendFunctionMax, TrendFunctionSum, TrendFunctionCount}
+
+// Groups of a TrendQueryModel
+const (
+	TrendGroupDate      = "date"
+	TrendGroupLocation  = "location"
+	TrendGroupCar       = "car"
+	TrendGroupEventType = "event_type"
+)
+
+// TrendGroups are all the groups of a TrendQueryModel
+var TrendGroups = []string{TrendGroupDate, TrendGroupLocation, TrendGroupCar, TrendGroupEventType}
+
+// Intervals the dates of a TrendQueryModel are grouped by
+const (
+	TrendIntervalDay   = "day"
+	TrendIntervalWeek  = "week"
+	TrendIntervalMonth = "month"
+)
+
+// TrendIntervals are all the intervals of a TrendQueryModel
+var TrendIntervals = []string{TrendIntervalDay, TrendIntervalWeek, TrendIntervalMonth}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RunUsageModel is how much a run used the car, computed while it is ingested.
+// Distances are in meters, speeds in m/s and times in seconds.
+type RunUsageModel struct {
+	// Distance is GPSDistance, or WheelSpeedDistance for runs without a GPS fix
+	Distance       float64 `json:"distance" bson:"distance"`
+	DistanceSource string  `json:"distance_source" bson:"distance_source"`
+
+	// GPSDistance is the length of the vn_gps track and WheelSpeedDistance the wheel speed integrated over time
+	GPSDistance        float64 `json:"gps_distance" bson:"gps_distance"`
+	WheelSpeedDistance float64 `json:"wheel_speed_distance" bson:"wheel_speed_distance"`
+
+	// MotorOnTime is the time the motors were spinning
+	MotorOnTime float64 `json:"motor_on_time" bson:"motor_on_time"`
+
+	// MaxGPSSpeed is the max horizontal speed of vn_vel_m_s, MaxSpeed is the max speed of the distance source
+	MaxGPSSpeed   float64 `json:"max_gps_speed" bson:"max_gps_speed"`
+	MaxWheelSpeed float64 `json:"max_wheel_speed" bson:"max_wheel_speed"`
+	MaxSpeed      float64 `json:"max_speed" bson:"max_speed"`
+}
+
+// Distance sources of a RunUsageModel
+const (
+	DistanceSourceGPS        = "gps"
+	DistanceSourceWheelSpeed = "wheel_speed"
+)
+
+// UsageTotalsModel adds up the usage of every run of a car in a window of time, like the odometer of the car
+// or the usage of a component while it was installed.
+// FirstRun and LastRun are left out of the document while they are nil, so the first run counted sets them with $min and $max.
+type UsageTotalsModel struct {
+	RunCount    int64   `json:"run_count" bson:"run_count"`
+	Distance    float64 `json:"distance" bson:"distance"`
+	MotorOnTime float64 `json:"motor_on_time" bson:"motor_on_time"`
+	MaxSpeed    float64 `json:"max_speed" bson:"max_speed"`
+
+	// FirstRun and LastRun are the dates of the first and last runs counted, they are nil if no run was counted
+	FirstRun *time.Time `json:"first_run" bson:"first_run,omitempty"`
+	LastRun  *time.Time `json:"last_run" bson:"last_run,omitempty"`
+}
+
+// CarUsageModel holds the cumulative usage counters of a car and of its components, one per car in the car_usage collection.
+// The counters are incremented when a run of the car is ingested, and counted again from the runs when one is deleted or moved.
+type CarUsageModel struct {
+	Id       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CarModel string             `json:"car_model" bson:"car_model"`
+
+	// Usage is the odometer of the car, the usage of all its runs
+	Usage UsageTotalsModel `json:"usage" bson:"usage"`
+
+	Components []CarComponentModel `json:"components" bson:"components"`
+}
+
+// CarComponentModel is a part of a car that wears out, like a tire set or a motor.
+// Its usage is the usage of every run of the car between InstalledAt and RemovedAt.
+type CarComponentModel struct {
+	Id       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	CarModel string             `json:"car_model" bson:"car_model"`
+
+	// Type groups components that replace each other, like tire_set or motor_fl
+	Type string `json:"type" bson:"type"`
+	Name string `json:"name" bson:"name"`
+
+	InstalledAt time.Time `json:"installed_at" bson:"installed_at"`
+
+	// RemovedAt is nil while the component is on the car
+	RemovedAt *time.Time `json:"removed_at" bson:"removed_at"`
+
+	Notes string `json:"notes,omitempty" bson:"notes,omitempty"`
+
+	// Usage is counted again from the runs when the component is created or its dates change,
+	// and incremented with every run of the car ingested while it is installed
+	Usage UsageTotalsModel `json:"usage" bson:"usage"`
+}
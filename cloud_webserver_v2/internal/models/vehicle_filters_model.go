@@ -9,12 +9,63 @@ import (
 // VehicleRunModelFilters contians all the possible ways to filter and query
 // for a VehicleRun.
 type VehicleRunModelFilters struct {
-	ID          *primitive.ObjectID `bson:"id",omitempty`
+	ID          *primitive.ObjectID `bson:"id,omitempty"`
 	BeforeDate  *time.Time          `bson:"before_date,omitempty"`
 	AfterDate   *time.Time          `bson:"after_date,omitempty"`
-	Location    *string             `bson:"location",omitempty`
-	EventType   *string             `bson:"event_type",omitempty`
-	CarModel    *string             `bson:"car_model",omitempty`
+	Location    *string             `bson:"location,omitempty"`
+	EventType   *string             `bson:"event_type,omitempty"`
+	CarModel    *string             `bson:"car_model,omitempty"`
 	SearchText  *string
-	MpsFunction *string `bson:"mps_function,omitempty"`
+	FileName    *string
+	Tags        []string `bson:"tags,omitempty"`
+	MpsFunction *string  `bson:"mps_function,omitempty"`
+	HasSignal   *string  `bson:"has_signal,omitempty"`
+	HasEvent    *string  `bson:"has_event,omitempty"`
+
+	MinQualityScore *float64 `bson:"min_quality_score,omitempty"`
+	MaxQualityScore *float64 `bson:"max_quality_score,omitempty"`
+}
+
+const (
+	// DefaultVehicleRunPageLimit is the number of runs of a page when the limit is not set
+	DefaultVehicleRunPageLimit = 50
+
+	// MaxVehicleRunPageLimit is the most runs a page can have
+	MaxVehicleRunPageLimit = 500
+)
+
+// VehicleRunPageModel selects the page of the runs matching the run filters to read, and which of their fields to read
+type VehicleRunPageModel struct {
+	// Page is the number of the page, starting at 1, and Limit the number of runs of every page
+	Page  int
+	Limit int
+
+	// SortField is the VehicleRunModel field the runs are sorted by, runs with the same value are sorted by id
+	SortField      string
+	SortDescending bool
+
+	// SortByRelevance sorts the runs by how well they match the SearchText of the filters, best match first, instead of by SortField
+	SortByRelevance bool
+
+	// Fields are the VehicleRunModel fields to read, nil reads every field
+	Fields []string
+}
+
+// VehicleRunSortRelevance is the sort of the run listing ranking the runs by how well they match the search text
+const VehicleRunSortRelevance = "relevance"
+
+// VehicleRunSortFields maps the sorts of the run listing to the VehicleRunModel fields they sort by
+var VehicleRunSortFields = map[string]string{
+	"date":     "date",
+	"location": "location",
+	"car":      "car_model",
+}
+
+// PaginationModel describes a page of a listing
+type PaginationModel struct {
+	// Total is the number of items matching the filters across every page
+	Total int64 `json:"total"`
+	Page  int   `json:"page"`
+	Limit int   `json:"limit"`
+	Pages int64 `json:"pages"`
 }
@@ -14,6 +14,7 @@ type FileModel struct {
 	FilePath  string `bson:"file_path"`
 	FileName  string `bson:"file_name"`
 	FileHash  string `bson:"file_hash,omitempty"` // SHA-256 hash
+	FileSize  int64  `bson:"file_size,omitempty"` // bytes, unset for files stored before sizes were recorded
 }
 
 // FileModel contains the information for a serialized response of a file (object) stored on S3
@@ -54,12 +55,23 @@ type VehicleRunModel struct {
 	Notes          *string                `bson:"notes,omitempty"`
 	Location       *string                `bson:"location,omitempty"`
 	EventType      *string                `bson:"event_type,omitempty"`
+	Tags           []string               `bson:"tags,omitempty"`
 	DynamicFields  map[string]interface{} `bson:"dynamic_fields,omitempty"`
 	McapFiles      []FileModel            `bson:"mcap_files,omitempty"`
 	CarModel       string                 `bson:"car_model,omitempty"`
 	Date           time.Time              `bson:"date"`
 	MatFiles       []FileModel            `bson:"mat_files,omitempty"`
 	MpsRecord      MpsRecordModel         `bson:"mps_record,omitempty"`
+	Signals        []SignalModel          `bson:"signals,omitempty"`
+	QualityReport  *DataQualityModel      `bson:"quality_report,omitempty"`
+	Laps           []LapModel             `bson:"laps,omitempty"`
+	Energy         *EnergyModel           `bson:"energy,omitempty"`
+	GGEnvelope     *GGEnvelopeModel       `bson:"gg_envelope,omitempty"`
+	Usage          *RunUsageModel         `bson:"usage,omitempty"`
+	Segments       []SegmentModel         `bson:"segments,omitempty"`
+	EventCounts    map[string]int         `bson:"event_counts,omitempty"`
+	Spectra        []SpectrumModel        `bson:"spectra,omitempty"`
+	DriverInputs   *DriverInputModel      `bson:"driver_inputs,omitempty"`
 }
 
 type VehicleRunModelResponse struct {
@@ -73,11 +85,57 @@ type VehicleRunModelResponse struct {
 	ContentFiles   map[string][]FileModelResponse `json:"content_files"`
 	Location       *string                        `json:"location"`
 	EventType      *string                        `json:"event_type"`
+	Tags           []string                       `json:"tags"`
 	DynamicFields  map[string]interface{}         `json:"dynamic_fields"`
 	MpsRecord      MpsRecordModel                 `json:"mps_record"`
+	QualityReport  *DataQualityModel              `json:"quality_report"`
+	Laps           []LapModel                     `json:"laps"`
+	Energy         *EnergyModel                   `json:"energy"`
+	GGEnvelope     *GGEnvelopeModel               `json:"gg_envelope"`
+	Usage          *RunUsageModel                 `json:"usage"`
+	Segments       []SegmentModel                 `json:"segments"`
+	EventCounts    map[string]int                 `json:"event_counts"`
+	Spectra        []SpectrumModel                `json:"spectra"`
+	DriverInputs   *DriverInputModel              `json:"driver_inputs"`
+}
+
+// VehicleRunResponseFields maps the fields of a VehicleRunModelResponse to the fields of the VehicleRunModel they are read from
+var VehicleRunResponseFields = map[string]string{
+	"id":              "_id",
+	"date":            "date",
+	"car_model":       "car_model",
+	"schema_versions": "schema_versions",
+	"notes":           "notes",
+	"mcap_files":      "mcap_files",
+	"mat_files":       "mat_files",
+	"content_files":   "content_files",
+	"location":        "location",
+	"event_type":      "event_type",
+	"tags":            "tags",
+	"dynamic_fields":  "dynamic_fields",
+	"mps_record":      "mps_record",
+	"quality_report":  "quality_report",
+	"laps":            "laps",
+	"energy":          "energy",
+	"gg_envelope":     "gg_envelope",
+	"usage":           "usage",
+	"segments":        "segments",
+	"event_counts":    "event_counts",
+	"spectra":         "spectra",
+	"driver_inputs":   "driver_inputs",
 }
 
 func VehicleRunSerialize(ctx context.Context, s3Repo *s3.S3Repository, model VehicleRunModel) VehicleRunModelResponse {
+	return VehicleRunSerializeFields(ctx, s3Repo, model, nil)
+}
+
+// VehicleRunSerializeFields serializes model like VehicleRunSerialize, but only signs the urls of the files and MPS results
+// of the VehicleRunResponseFields in fields since signing is the slow part of listing runs. A nil fields signs every url.
+func VehicleRunSerializeFields(ctx context.Context, s3Repo *s3.S3Repository, model VehicleRunModel, fields map[string]bool) VehicleRunModelResponse {
+	wanted := func(field string) bool {
+		return fields == nil || fields[field]
+	}
+
 	modelOut := VehicleRunModelResponse{
 		Id:             model.Id.Hex(),
 		Date:           model.Date,
@@ -86,24 +144,36 @@ func VehicleRunSerialize(ctx context.Context, s3Repo *s3.S3Repository, model Veh
 		Notes:          model.Notes,
 		Location:       model.Location,
 		EventType:      model.EventType,
+		Tags:           model.Tags,
 		DynamicFields:  model.DynamicFields,
+		QualityReport:  model.QualityReport,
+		Laps:           model.Laps,
+		Energy:         model.Energy,
+		GGEnvelope:     model.GGEnvelope,
+		Usage:          model.Usage,
+		Segments:       model.Segments,
+		EventCounts:    model.EventCounts,
+		Spectra:        model.Spectra,
+		DriverInputs:   model.DriverInputs,
 	}
 
-	modelOut.MpsRecord = serializeMPSRecord(ctx, s3Repo, model.MpsRecord)
+	if wanted("mps_record") {
+		modelOut.MpsRecord = serializeMPSRecord(ctx, s3Repo, model.MpsRecord)
+	}
 
-	if model.McapFiles != nil && len(model.McapFiles) > 0 {
+	if wanted("mcap_files") && model.McapFiles != nil && len(model.McapFiles) > 0 {
 		fileResponses := getFileModelResponse(ctx, s3Repo, model.McapFiles)
 		modelOut.McapFiles = fileResponses
 	}
 
-	if model.MatFiles != nil && len(model.MatFiles) > 0 {
+	if wanted("mat_files") && model.MatFiles != nil && len(model.MatFiles) > 0 {
 		fileResponses := getFileModelResponse(ctx, s3Repo, model.MatFiles)
 		modelOut.MatFiles = fileResponses
 	}
 
 	modelOut.ContentFiles = make(map[string][]FileModelResponse)
 	for key, files := range model.ContentFiles {
-		if len(files) > 0 {
+		if wanted("content_files") && len(files) > 0 {
 			fileResponses := getFileModelResponse(ctx, s3Repo, files)
 			modelOut.ContentFiles[key] = fileResponses
 		}
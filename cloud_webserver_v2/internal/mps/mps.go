@@ -9,17 +9,16 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/hytech-racing/cloud-webserver-v2/internal/database"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/models"
 	"github.com/hytech-racing/cloud-webserver-v2/internal/s3"
+	"github.com/hytech-racing/cloud-webserver-v2/internal/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// h5FileDirectory is the directory where the .h5 files are stored locally, acts as s3 cache
-const h5FileDirectory = "/data/run_metadata/"
-
 // mpsInstanceDirectory is the path of the MPS instance directory
 // /mps_data is the mount point of the mps_data Docker volume on this container
 const mpsInstanceDirectory = "/mps_data/mps_workspace/Instances/mps_2"
@@ -114,7 +113,8 @@ type matlabJobRequestPayload struct {
 	Nargout int `json:"nargout"`
 
 	// Input arguments to the deployed MATLAB function, specified as an array of strings
-	// For our purposes, RHS will be an array of 1 string which is the path to an .h5 file
+	// For our purposes, RHS will be the path to an .h5 file, followed by the start and end times
+	// of the segment to process when the job is for a segment of the run
 	RHS []string `json:"rhs"`
 
 	// Specify the notation of the MATLAB output response
@@ -127,7 +127,7 @@ type matlabJobRequestPayload struct {
 
 // Creates a new MATLAB job request payload
 // rhs represents the arguments passed into the function which should
-// always be a single string array which is the filepath to the h5 file
+// always start with the filepath to the h5 file
 func newMatlabJobRequestPayload(rhs []string) *matlabJobRequestPayload {
 	return &matlabJobRequestPayload{
 		Nargout: 1,
@@ -250,7 +250,7 @@ func (m *MatlabClient) processResult(job mpsJob, s3Repo *s3.S3Repository) {
 
 		// copy the generated file to the local s3 cache directory
 		s3FilePath := job.mcapId.Hex() + "/" + job.packageVersion + "/" + job.functionName + "/" + filepath.Base(scriptResult.Result)
-		s3CacheFileLocation := h5FileDirectory + s3FilePath
+		s3CacheFileLocation := utils.RunFileCacheDirectory + s3FilePath
 		err = os.MkdirAll(filepath.Dir(s3CacheFileLocation), 0755)
 		if err != nil {
 			log.Fatalf("error creating local directory for file %s: %v", s3CacheFileLocation, err)
@@ -345,7 +345,8 @@ func (m *MatlabClient) deleteMatlabJobResult(jobId string) {
 // Submits a new synchronous job to the MPS.
 // The MPS client will save the job id and wait for the result and process it in the background
 // View https://www.mathworks.com/help/mps/restfuljson/postasynchronousrequest.html for more information
-func (m *MatlabClient) SubmitMatlabJob(ctx context.Context, s3Repo *s3.S3Repository, mcapId string, packageName string, functionName string) {
+// If segment is not nil, its start and end times are passed to the function after the path to the .h5 file
+func (m *MatlabClient) SubmitMatlabJob(ctx context.Context, s3Repo *s3.S3Repository, mcapId string, packageName string, functionName string, segment *models.SegmentModel) {
 	log.Println("submitting matlab job")
 
 	primitiveId, err := primitive.ObjectIDFromHex(mcapId)
@@ -358,9 +359,9 @@ func (m *MatlabClient) SubmitMatlabJob(ctx context.Context, s3Repo *s3.S3Reposit
 		log.Fatalf("error getting vehicle run model: %v", err)
 	}
 
-	// ensure that the .h5 file exists on file system in h5FileDirectory
+	// ensure that the .h5 file exists on file system in utils.RunFileCacheDirectory
 	h5FilePath := model.MatFiles[0].FilePath
-	localFilePath := h5FileDirectory + h5FilePath
+	localFilePath := utils.RunFileCacheDirectory + h5FilePath
 	if _, err := os.Stat(localFilePath); os.IsNotExist(err) {
 		err = s3Repo.DownloadObject(ctx, model.MatFiles[0].AwsBucket, h5FilePath, localFilePath)
 		if err != nil {
@@ -368,7 +369,12 @@ func (m *MatlabClient) SubmitMatlabJob(ctx context.Context, s3Repo *s3.S3Reposit
 		}
 	}
 
-	payload := newMatlabJobRequestPayload([]string{h5FileDirectory + h5FilePath})
+	rhs := []string{utils.RunFileCacheDirectory + h5FilePath}
+	if segment != nil {
+		rhs = append(rhs, strconv.FormatFloat(segment.Start, 'f', -1, 64), strconv.FormatFloat(segment.End, 'f', -1, 64))
+	}
+
+	payload := newMatlabJobRequestPayload(rhs)
 	payloadJson, err := json.Marshal(payload)
 	if err != nil {
 		log.Fatalf("error marshalling payload: %v", err)
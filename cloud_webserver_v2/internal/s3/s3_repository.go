@@ -130,6 +130,21 @@ func (s *S3Repository) DownloadObject(ctx context.Context, bucket string, object
 	return nil
 }
 
+// ObjectSize returns the size in bytes of the object located at the bucket and object path
+func (s *S3Repository) ObjectSize(ctx context.Context, bucket string, objectPath string) (int64, error) {
+	params := &s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &objectPath,
+	}
+
+	resp, err := s.s3_session.client.HeadObject(ctx, params)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get object size from S3: %w", err)
+	}
+
+	return aws.ToInt64(resp.ContentLength), nil
+}
+
 func (s *S3Repository) Bucket() string {
 	return s.s3_session.bucket
 }
@@ -0,0 +1,98 @@
+package utils
+
+import "math"
+
+// DownsampleLTTB reduces times and values to at most threshold points using the
+// Largest-Triangle-Three-Buckets algorithm, which keeps the visual shape of a signal when plotted.
+// If there are already less than threshold points the slices are returned unchanged.
+func DownsampleLTTB(times, values []float64, threshold int) ([]float64, []float64) {
+	if threshold >= len(times) || threshold < 3 {
+		return times, values
+	}
+
+	outTimes := make([]float64, 0, threshold)
+	outValues := make([]float64, 0, threshold)
+
+	// The first and last points are always kept, everything else is split into threshold - 2 buckets
+	bucketSize := float64(len(times)-2) / float64(threshold-2)
+	selected := 0
+	outTimes = append(outTimes, times[0])
+	outValues = append(outValues, values[0])
+
+	for bucket := 0; bucket < threshold-2; bucket++ {
+		// Average point of the next bucket is used as the third point of the triangle
+		nextStart := int(math.Floor(float64(bucket+1)*bucketSize)) + 1
+		nextEnd := min(max(int(math.Floor(float64(bucket+2)*bucketSize))+1, nextStart+1), len(times))
+		avgTime, avgValue := 0.0, 0.0
+		for i := nextStart; i < nextEnd; i++ {
+			avgTime += times[i]
+			avgValue += values[i]
+		}
+		nextLength := float64(nextEnd - nextStart)
+		avgTime /= nextLength
+		avgValue /= nextLength
+
+		// Pick the point in the current bucket that creates the largest triangle
+		start := int(math.Floor(float64(bucket)*bucketSize)) + 1
+		end := int(math.Floor(float64(bucket+1)*bucketSize)) + 1
+		maxArea := -1.0
+		maxIndex := start
+		for i := start; i < end; i++ {
+			area := math.Abs((times[selected]-avgTime)*(values[i]-values[selected]) - (times[selected]-times[i])*(avgValue-values[selected]))
+			if area > maxArea {
+				maxArea = area
+				maxIndex = i
+			}
+		}
+
+		outTimes = append(outTimes, times[maxIndex])
+		outValues = append(outValues, values[maxIndex])
+		selected = maxIndex
+	}
+
+	outTimes = append(outTimes, times[len(times)-1])
+	outValues = append(outValues, values[len(values)-1])
+	return outTimes, outValues
+}
+
+// DownsampleMinMax reduces times and values to at most threshold points by splitting them into
+// threshold / 2 buckets and keeping the minimum and maximum of every bucket in time order.
+// This never hides spikes, which makes it a better fit than LTTB for fault and limit checking.
+func DownsampleMinMax(times, values []float64, threshold int) ([]float64, []float64) {
+	if threshold >= len(times) || threshold < 2 {
+		return times, values
+	}
+
+	bucketCount := threshold / 2
+	bucketSize := float64(len(times)) / float64(bucketCount)
+	outTimes := make([]float64, 0, bucketCount*2)
+	outValues := make([]float64, 0, bucketCount*2)
+
+	for bucket := 0; bucket < bucketCount; bucket++ {
+		start := int(math.Floor(float64(bucket) * bucketSize))
+		end := min(int(math.Floor(float64(bucket+1)*bucketSize)), len(times))
+		if start >= end {
+			continue
+		}
+
+		minIndex, maxIndex := start, start
+		for i := start; i < end; i++ {
+			if values[i] < values[minIndex] {
+				minIndex = i
+			}
+			if values[i] > values[maxIndex] {
+				maxIndex = i
+			}
+		}
+
+		first, second := min(minIndex, maxIndex), max(minIndex, maxIndex)
+		outTimes = append(outTimes, times[first])
+		outValues = append(outValues, values[first])
+		if first != second {
+			outTimes = append(outTimes, times[second])
+			outValues = append(outValues, values[second])
+		}
+	}
+
+	return outTimes, outValues
+}
@@ -0,0 +1,251 @@
+package utils
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/xitongsys/parquet-go-source/writerfile"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// ExportFormat is the file format signals are exported to for tools other than MATLAB (pandas, Excel)
+type ExportFormat string
+
+const (
+	ExportCSV     ExportFormat = "csv"
+	ExportParquet ExportFormat = "parquet"
+)
+
+// ExportLayout is the shape of an exported table
+type ExportLayout string
+
+const (
+	// ExportWide has a time column followed by one column per signal, cells of signals without a value at that time are empty
+	ExportWide ExportLayout = "wide"
+
+	// ExportLong has a time, signal and value column with one row per value
+	ExportLong ExportLayout = "long"
+)
+
+// ParseExportFormat turns the name of an export format into an ExportFormat
+func ParseExportFormat(format string) (ExportFormat, error) {
+	switch ExportFormat(format) {
+	case ExportCSV, ExportParquet:
+		return ExportFormat(format), nil
+	default:
+		return "", fmt.Errorf("unknown export format %s, must be csv or parquet", format)
+	}
+}
+
+// ParseExportLayout turns the name of an export layout into an ExportLayout
+func ParseExportLayout(layout string) (ExportLayout, error) {
+	switch ExportLayout(layout) {
+	case ExportWide, ExportLong:
+		return ExportLayout(layout), nil
+	default:
+		return "", fmt.Errorf("unknown export layout %s, must be wide or long", layout)
+	}
+}
+
+// ContentType returns the MIME type of files in the format
+func (f ExportFormat) ContentType() string {
+	if f == ExportParquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv"
+}
+
+// exportTableWriter writes the rows of an exported table, a nil cell is a missing value
+type exportTableWriter interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+// WriteSignalTable writes allSeries to w as a table, rows are in time order.
+// Values of different signals share a row in the wide layout only when they have the exact same timestamp,
+// which is always the case for series resampled at the same rate.
+func WriteSignalTable(w io.Writer, format ExportFormat, layout ExportLayout, allSeries []*SignalSeries) error {
+	columns := []exportColumn{{name: "time", kind: exportDouble}}
+	if layout == ExportLong {
+		columns = append(columns, exportColumn{name: "signal", kind: exportString}, exportColumn{name: "value", kind: exportDouble})
+	} else {
+		for _, series := range allSeries {
+			columns = append(columns, exportColumn{name: series.Path, kind: exportDouble, optional: true})
+		}
+	}
+
+	tableWriter, err := newExportTableWriter(w, format, columns)
+	if err != nil {
+		return err
+	}
+
+	// Every series is read in time order, so the next row is always made of the earliest unread values
+	positions := make([]int, len(allSeries))
+	for {
+		rowTime := math.Inf(1)
+		for i, series := range allSeries {
+			if positions[i] < len(series.Times) {
+				rowTime = math.Min(rowTime, series.Times[positions[i]])
+			}
+		}
+		if math.IsInf(rowTime, 1) {
+			break
+		}
+
+		if layout == ExportLong {
+			for i, series := range allSeries {
+				for positions[i] < len(series.Times) && series.Times[positions[i]] == rowTime {
+					err = tableWriter.Write([]interface{}{rowTime, series.Path, series.Values[positions[i]]})
+					if err != nil {
+						return err
+					}
+					positions[i]++
+				}
+			}
+			continue
+		}
+
+		// A signal logged twice at the same time keeps its first value
+		row := make([]interface{}, len(columns))
+		row[0] = rowTime
+		for i, series := range allSeries {
+			if positions[i] < len(series.Times) && series.Times[positions[i]] == rowTime {
+				row[i+1] = series.Values[positions[i]]
+				for positions[i] < len(series.Times) && series.Times[positions[i]] == rowTime {
+					positions[i]++
+				}
+			}
+		}
+		err = tableWriter.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tableWriter.Close()
+}
+
+// WriteTable writes rows of numbers to w as a table with a column for every name of columns, a nil cell is a missing value
+func WriteTable(w io.Writer, format ExportFormat, columns []string, rows [][]interface{}) error {
+	exportColumns := make([]exportColumn, len(columns))
+	for i, name := range columns {
+		exportColumns[i] = exportColumn{name: name, kind: exportDouble, optional: true}
+	}
+
+	tableWriter, err := newExportTableWriter(w, format, exportColumns)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		err = tableWriter.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tableWriter.Close()
+}
+
+func newExportTableWriter(w io.Writer, format ExportFormat, columns []exportColumn) (exportTableWriter, error) {
+	switch format {
+	case ExportCSV:
+		return newCSVTableWriter(w, columns)
+	case ExportParquet:
+		return newParquetTableWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("unknown export format %s", format)
+	}
+}
+
+type exportColumnKind int
+
+const (
+	exportDouble exportColumnKind = iota
+	exportString
+)
+
+type exportColumn struct {
+	name     string
+	kind     exportColumnKind
+	optional bool
+}
+
+type csvTableWriter struct {
+	writer *csv.Writer
+	record []string
+}
+
+func newCSVTableWriter(w io.Writer, columns []exportColumn) (*csvTableWriter, error) {
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.name
+	}
+
+	writer := csv.NewWriter(w)
+	err := writer.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &csvTableWriter{writer: writer, record: make([]string, len(columns))}, nil
+}
+
+func (c *csvTableWriter) Write(row []interface{}) error {
+	for i, cell := range row {
+		switch value := cell.(type) {
+		case float64:
+			c.record[i] = strconv.FormatFloat(value, 'g', -1, 64)
+		case string:
+			c.record[i] = value
+		default:
+			c.record[i] = ""
+		}
+	}
+	return c.writer.Write(c.record)
+}
+
+func (c *csvTableWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type parquetTableWriter struct {
+	writer *writer.CSVWriter
+}
+
+// newParquetTableWriter creates a snappy compressed parquet writer, signal paths are kept as is for the column names
+func newParquetTableWriter(w io.Writer, columns []exportColumn) (*parquetTableWriter, error) {
+	schema := make([]string, len(columns))
+	for i, column := range columns {
+		switch column.kind {
+		case exportString:
+			schema[i] = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8", column.name)
+		default:
+			schema[i] = fmt.Sprintf("name=%s, type=DOUBLE", column.name)
+		}
+		if column.optional {
+			schema[i] += ", repetitiontype=OPTIONAL"
+		}
+	}
+
+	parquetWriter, err := writer.NewCSVWriter(schema, writerfile.NewWriterFile(w), 1)
+	if err != nil {
+		return nil, fmt.Errorf("could not create parquet writer: %v", err)
+	}
+	parquetWriter.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	return &parquetTableWriter{writer: parquetWriter}, nil
+}
+
+func (p *parquetTableWriter) Write(row []interface{}) error {
+	return p.writer.Write(row)
+}
+
+func (p *parquetTableWriter) Close() error {
+	return p.writer.WriteStop()
+}
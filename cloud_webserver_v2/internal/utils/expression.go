@@ -0,0 +1,366 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a parsed arithmetic expression over signal paths, used to compute derived channels.
+// It supports numbers, + - * / ^, parentheses, named constants and the functions in expressionFunctions.
+// Names containing a dot are signal paths (VehicleData.current_rpms.FL), names without one are constants or functions.
+type Expression struct {
+	source  string
+	signals []string
+	root    expressionNode
+}
+
+// expressionNode evaluates a part of an expression, values holds the current value of every signal of the expression
+type expressionNode func(values []float64) float64
+
+// expressionFunctions are the functions expressions can call, by name and number of arguments
+var expressionFunctions = map[string]struct {
+	arguments int
+	call      func(args []float64) float64
+}{
+	"abs":   {1, func(args []float64) float64 { return math.Abs(args[0]) }},
+	"sqrt":  {1, func(args []float64) float64 { return math.Sqrt(args[0]) }},
+	"exp":   {1, func(args []float64) float64 { return math.Exp(args[0]) }},
+	"log":   {1, func(args []float64) float64 { return math.Log(args[0]) }},
+	"sin":   {1, func(args []float64) float64 { return math.Sin(args[0]) }},
+	"cos":   {1, func(args []float64) float64 { return math.Cos(args[0]) }},
+	"tan":   {1, func(args []float64) float64 { return math.Tan(args[0]) }},
+	"atan":  {1, func(args []float64) float64 { return math.Atan(args[0]) }},
+	"atan2": {2, func(args []float64) float64 { return math.Atan2(args[0], args[1]) }},
+	"hypot": {2, func(args []float64) float64 { return math.Hypot(args[0], args[1]) }},
+	"min":   {2, func(args []float64) float64 { return math.Min(args[0], args[1]) }},
+	"max":   {2, func(args []float64) float64 { return math.Max(args[0], args[1]) }},
+}
+
+// ParseExpression parses source, constants are the names usable in the expression besides signal paths
+func ParseExpression(source string, constants map[string]float64) (*Expression, error) {
+	parser := &expressionParser{
+		source:       source,
+		constants:    constants,
+		signalIndex:  make(map[string]int),
+		expression:   &Expression{source: source, signals: make([]string, 0)},
+		currentToken: expressionToken{},
+	}
+
+	err := parser.next()
+	if err != nil {
+		return nil, err
+	}
+	root, err := parser.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if parser.currentToken.kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected %q at position %d", parser.currentToken.text, parser.currentToken.position)
+	}
+
+	parser.expression.root = root
+	return parser.expression, nil
+}
+
+// Signals returns the paths of the signals used in the expression, in the order Evaluate expects their values
+func (e *Expression) Signals() []string {
+	return e.signals
+}
+
+// Evaluate computes the expression with values, the current value of every signal in the order of Signals
+func (e *Expression) Evaluate(values []float64) float64 {
+	return e.root(values)
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+type expressionTokenKind int
+
+const (
+	tokenEnd expressionTokenKind = iota
+	tokenNumber
+	tokenName
+	tokenOperator
+)
+
+type expressionToken struct {
+	kind     expressionTokenKind
+	text     string
+	position int
+}
+
+// expressionParser is a recursive descent parser, every parse method consumes the tokens of the rule it is named after
+type expressionParser struct {
+	source       string
+	position     int
+	constants    map[string]float64
+	signalIndex  map[string]int
+	expression   *Expression
+	currentToken expressionToken
+}
+
+// next reads the next token of the source into currentToken
+func (p *expressionParser) next() error {
+	for p.position < len(p.source) && unicode.IsSpace(rune(p.source[p.position])) {
+		p.position++
+	}
+	start := p.position
+	if p.position >= len(p.source) {
+		p.currentToken = expressionToken{kind: tokenEnd, position: start}
+		return nil
+	}
+
+	c := rune(p.source[p.position])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.position < len(p.source) && (unicode.IsDigit(rune(p.source[p.position])) || p.source[p.position] == '.') {
+			p.position++
+		}
+		// Exponents (1e-3)
+		if p.position < len(p.source) && (p.source[p.position] == 'e' || p.source[p.position] == 'E') {
+			p.position++
+			if p.position < len(p.source) && (p.source[p.position] == '-' || p.source[p.position] == '+') {
+				p.position++
+			}
+			for p.position < len(p.source) && unicode.IsDigit(rune(p.source[p.position])) {
+				p.position++
+			}
+		}
+		p.currentToken = expressionToken{kind: tokenNumber, text: p.source[start:p.position], position: start}
+	case unicode.IsLetter(c) || c == '_':
+		for p.position < len(p.source) {
+			c = rune(p.source[p.position])
+			if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' && c != '.' {
+				break
+			}
+			p.position++
+		}
+		p.currentToken = expressionToken{kind: tokenName, text: p.source[start:p.position], position: start}
+	case strings.ContainsRune("+-*/^(),", c):
+		p.position++
+		p.currentToken = expressionToken{kind: tokenOperator, text: string(c), position: start}
+	default:
+		return fmt.Errorf("unexpected %q at position %d", c, start)
+	}
+
+	return nil
+}
+
+// isOperator returns true if the current token is the operator
+func (p *expressionParser) isOperator(operator string) bool {
+	return p.currentToken.kind == tokenOperator && p.currentToken.text == operator
+}
+
+// expect consumes operator or fails if the current token is something else
+func (p *expressionParser) expect(operator string) error {
+	if !p.isOperator(operator) {
+		return fmt.Errorf("expected %q at position %d", operator, p.currentToken.position)
+	}
+	return p.next()
+}
+
+// parseSum parses terms separated by + and -
+func (p *expressionParser) parseSum() (expressionNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("+") || p.isOperator("-") {
+		operator := p.currentToken.text
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		if operator == "+" {
+			left = func(values []float64) float64 { return l(values) + right(values) }
+		} else {
+			left = func(values []float64) float64 { return l(values) - right(values) }
+		}
+	}
+
+	return left, nil
+}
+
+// parseProduct parses factors separated by * and /
+func (p *expressionParser) parseProduct() (expressionNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("*") || p.isOperator("/") {
+		operator := p.currentToken.text
+		err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		l := left
+		if operator == "*" {
+			left = func(values []float64) float64 { return l(values) * right(values) }
+		} else {
+			left = func(values []float64) float64 { return l(values) / right(values) }
+		}
+	}
+
+	return left, nil
+}
+
+// parseUnary parses a signed power, -x^2 is -(x^2)
+func (p *expressionParser) parseUnary() (expressionNode, error) {
+	if p.isOperator("-") || p.isOperator("+") {
+		negate := p.currentToken.text == "-"
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			return func(values []float64) float64 { return -operand(values) }, nil
+		}
+		return operand, nil
+	}
+
+	return p.parsePower()
+}
+
+// parsePower parses a primary raised to a power, powers are right associative (2^3^2 is 2^9)
+func (p *expressionParser) parsePower() (expressionNode, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOperator("^") {
+		return base, nil
+	}
+
+	err = p.next()
+	if err != nil {
+		return nil, err
+	}
+	exponent, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return func(values []float64) float64 { return math.Pow(base(values), exponent(values)) }, nil
+}
+
+// parsePrimary parses a number, a signal, a constant, a function call or an expression in parentheses
+func (p *expressionParser) parsePrimary() (expressionNode, error) {
+	token := p.currentToken
+	switch {
+	case token.kind == tokenNumber:
+		value, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", token.text, token.position)
+		}
+		return func([]float64) float64 { return value }, p.next()
+	case token.kind == tokenName:
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if p.isOperator("(") {
+			return p.parseCall(token)
+		}
+		return p.parseName(token)
+	case p.isOperator("("):
+		err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	case token.kind == tokenEnd:
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", token.text, token.position)
+	}
+}
+
+// parseName turns a name into a signal or a constant
+func (p *expressionParser) parseName(token expressionToken) (expressionNode, error) {
+	if strings.Contains(token.text, ".") {
+		index, ok := p.signalIndex[token.text]
+		if !ok {
+			index = len(p.expression.signals)
+			p.signalIndex[token.text] = index
+			p.expression.signals = append(p.expression.signals, token.text)
+		}
+		return func(values []float64) float64 { return values[index] }, nil
+	}
+
+	if token.text == "pi" {
+		return func([]float64) float64 { return math.Pi }, nil
+	}
+	value, ok := p.constants[token.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown constant %s at position %d, signals must be full paths like VehicleData.current_rpms.FL", token.text, token.position)
+	}
+	return func([]float64) float64 { return value }, nil
+}
+
+// parseCall parses the arguments of a call to the function named by token, the current token is the opening parenthesis
+func (p *expressionParser) parseCall(token expressionToken) (expressionNode, error) {
+	function, ok := expressionFunctions[token.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at position %d", token.text, token.position)
+	}
+
+	err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	arguments := make([]expressionNode, 0, function.arguments)
+	for !p.isOperator(")") {
+		if len(arguments) > 0 {
+			err = p.expect(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+		argument, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, argument)
+	}
+	err = p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(arguments) != function.arguments {
+		return nil, fmt.Errorf("function %s takes %d arguments, got %d", token.text, function.arguments, len(arguments))
+	}
+
+	return func(values []float64) float64 {
+		args := make([]float64, len(arguments))
+		for i, argument := range arguments {
+			args[i] = argument(values)
+		}
+		return function.call(args)
+	}, nil
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseExpressionEvaluate(t *testing.T) {
+	constants := map[string]float64{"wheel_radius": 0.2, "gear_ratio": 4}
+
+	tests := []struct {
+		name   string
+		source string
+		values []float64
+		want   float64
+	}{
+		{"number", "42", nil, 42},
+		{"decimal and exponent numbers", "1.5 + 2e-1 + .5", nil, 2.2},
+		{"product before sum", "1 + 2 * 3", nil, 7},
+		{"quotient before difference", "10 - 6 / 3", nil, 8},
+		{"sums are left associative", "10 - 4 - 3", nil, 3},
+		{"quotients are left associative", "8 / 4 / 2", nil, 1},
+		{"power before product", "2 * 3 ^ 2", nil, 18},
+		{"powers are right associative", "2 ^ 3 ^ 2", nil, 512},
+		{"parentheses", "(1 + 2) * 3", nil, 9},
+		{"unary minus binds looser than power", "-2 ^ 2", nil, -4},
+		{"parenthesized unary minus", "(-2) ^ 2", nil, 4},
+		{"negative exponent", "2 ^ -1", nil, 0.5},
+		{"unary minus in a product", "3 * -2", nil, -6},
+		{"double unary minus", "--3", nil, 3},
+		{"unary plus", "+3", nil, 3},
+		{"pi", "pi", nil, math.Pi},
+		{"constants", "gear_ratio * wheel_radius", nil, 0.8},
+		{"functions", "hypot(3, 4) + abs(-1) + max(2, min(5, 7))", nil, 11},
+		{"signals", "VehicleData.current_rpms.FL * 2 + VehicleData.current_rpms.FR", []float64{10, 1}, 21},
+		{"repeated signal", "VehicleData.speed * VehicleData.speed", []float64{3}, 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expression, err := ParseExpression(tt.source, constants)
+			if err != nil {
+				t.Fatalf("ParseExpression(%q) returned %v", tt.source, err)
+			}
+			if got := expression.Evaluate(tt.values); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("%q = %v, want %v", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExpressionSignals(t *testing.T) {
+	expression, err := ParseExpression("VehicleData.b + VehicleData.a * VehicleData.b", nil)
+	if err != nil {
+		t.Fatalf("ParseExpression returned %v", err)
+	}
+
+	want := []string{"VehicleData.b", "VehicleData.a"}
+	if got := expression.Signals(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Signals() = %v, want %v", got, want)
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"malformed number", "1.2.3", `invalid number "1.2.3"`},
+		{"malformed exponent", "1e", `invalid number "1e"`},
+		{"unknown constant", "speed * 2", "unknown constant speed"},
+		{"unknown function", "floor(1)", "unknown function floor"},
+		{"too few arguments", "atan2(1)", "function atan2 takes 2 arguments, got 1"},
+		{"too many arguments", "abs(1, 2)", "function abs takes 1 arguments, got 2"},
+		{"no arguments", "sqrt()", "function sqrt takes 1 arguments, got 0"},
+		{"unexpected character", "1 + $", `unexpected '$' at position 4`},
+		{"missing closing parenthesis", "(1 + 2", `expected ")" at position 6`},
+		{"trailing token", "1 2", `unexpected "2" at position 2`},
+		{"missing operand", "1 +", "unexpected end of expression"},
+		{"empty expression", "", "unexpected end of expression"},
+		{"dangling power", "2 ^", "unexpected end of expression"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseExpression(tt.source, nil)
+			if err == nil {
+				t.Fatalf("ParseExpression(%q) returned no error", tt.source)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseExpression(%q) = %v, want an error containing %q", tt.source, err, tt.wantErr)
+			}
+		})
+	}
+}
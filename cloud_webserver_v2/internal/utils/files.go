@@ -7,6 +7,10 @@ import (
 	"os"
 )
 
+// RunFileCacheDirectory is the directory where the files of the runs are stored locally, it acts as a cache of S3.
+// A file is stored under its object path on S3.
+const RunFileCacheDirectory = "/data/run_metadata/"
+
 // Reads the contents of an os.File and returns its SHA-256 hash as a string
 func CreateFileHash(file *os.File) (string, error) {
 	if _, err := file.Seek(0, 0); err != nil {
@@ -0,0 +1,88 @@
+package utils
+
+import "sync/atomic"
+
+// DefaultHDF5BufferCeiling is the number of bytes all HDF5 writers of a job may buffer when no ceiling is configured
+const DefaultHDF5BufferCeiling = 512 * 1024 * 1024
+
+const (
+	// HDF5BufferReliefBytes is how many bytes a writer buffers between two reliefs of a job over its ceiling,
+	// so a job kept over its ceiling by other writers doesn't make a writer look for buffers to flush on every value
+	HDF5BufferReliefBytes = 1024 * 1024
+
+	// HDF5BufferReliefMinValues is the fewest values a buffer needs to be flushed to relieve the budget,
+	// smaller buffers would only turn into tiny writes
+	HDF5BufferReliefMinValues = 256
+)
+
+// hdf5BufferLowWaterPercent is the part of the ceiling, in percent, writers flush down to once the job goes over it,
+// so the job isn't pushed back over the ceiling by the very next value
+const hdf5BufferLowWaterPercent = 75
+
+// HDF5BufferBudget is shared by all the HDF5 writers of a job so the values they hold in memory stay under a single ceiling.
+// Writers run in their own subscriber goroutines, so the counters are atomic.
+// All methods are safe to call on a nil budget, which has no ceiling and keeps no count.
+type HDF5BufferBudget struct {
+	ceiling  int64
+	buffered atomic.Int64
+	peak     atomic.Int64
+}
+
+func NewHDF5BufferBudget(ceiling int64) *HDF5BufferBudget {
+	return &HDF5BufferBudget{ceiling: ceiling}
+}
+
+// Add adds n bytes to the buffered bytes of the job, n is negative when a writer flushes.
+// It returns true if the job is buffering more than its ceiling, in which case the writer should flush.
+func (b *HDF5BufferBudget) Add(n int64) bool {
+	if b == nil {
+		return false
+	}
+
+	buffered := b.buffered.Add(n)
+	for {
+		peak := b.peak.Load()
+		if buffered <= peak || b.peak.CompareAndSwap(peak, buffered) {
+			break
+		}
+	}
+
+	return b.ceiling > 0 && buffered > b.ceiling
+}
+
+// OverLowWater returns true while the job is buffering more than the low water mark of its ceiling.
+// A writer relieving the budget flushes its largest buffers until it returns false.
+func (b *HDF5BufferBudget) OverLowWater() bool {
+	return b.BytesOverLowWater() > 0
+}
+
+// BytesOverLowWater returns how many bytes the job needs to flush to get under the low water mark of its ceiling
+func (b *HDF5BufferBudget) BytesOverLowWater() int64 {
+	if b == nil || b.ceiling <= 0 {
+		return 0
+	}
+	return max(b.buffered.Load()-b.ceiling*hdf5BufferLowWaterPercent/100, 0)
+}
+
+// BufferedBytes returns the number of bytes currently buffered by the writers of the job
+func (b *HDF5BufferBudget) BufferedBytes() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.buffered.Load()
+}
+
+// PeakBytes returns the most bytes the writers of the job have buffered at once
+func (b *HDF5BufferBudget) PeakBytes() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.peak.Load()
+}
+
+func (b *HDF5BufferBudget) Ceiling() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.ceiling
+}
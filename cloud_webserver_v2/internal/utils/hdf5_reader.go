@@ -0,0 +1,367 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/hdf5"
+)
+
+// HDF5Reader reads signals back out of the HDF5 files written by HDF5Writer and HDF5SignalWriter,
+// so stored runs can be analyzed without decoding their MCAP file again.
+// Signals are addressed by the same paths in every layout (VehicleData.current_rpms.FL).
+type HDF5Reader struct {
+	file          *hdf5.File
+	layoutVersion int
+
+	// datasets maps every signal path to the datasets holding its values in order.
+	// A signal has one dataset per chunk in the HDF5LayoutChunked layout and a single dataset in HDF5LayoutSignals.
+	datasets map[string][]string
+}
+
+// errHDF5NotNumeric is returned when a dataset of a signal holds values which can't be read as numbers
+var errHDF5NotNumeric = errors.New("signal is not numeric")
+
+// hdf5Field describes where a field of a compound packet lives and how to turn it into a float64
+type hdf5Field struct {
+	offset int
+	decode func([]byte) float64
+}
+
+// NewHDF5Reader opens a HDF5 file and indexes its signals. The layout is read from the layout_version attribute,
+// files without it were written before it existed and use HDF5LayoutChunked.
+func NewHDF5Reader(filename string) (*HDF5Reader, error) {
+	file, err := hdf5.OpenFile(filename, hdf5.F_ACC_RDONLY)
+	if err != nil {
+		return nil, fmt.Errorf("could not open hdf5 file: %v", err)
+	}
+
+	reader := &HDF5Reader{
+		file:          file,
+		layoutVersion: readHDF5LayoutVersion(file),
+		datasets:      make(map[string][]string),
+	}
+
+	dataGroup, err := file.OpenGroup("data")
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("could not open data group: %v", err)
+	}
+	defer dataGroup.Close()
+
+	switch reader.layoutVersion {
+	case HDF5LayoutChunked:
+		err = reader.indexChunks(dataGroup)
+	case HDF5LayoutSignals:
+		err = reader.indexSignalGroup(dataGroup, "/data", "")
+	default:
+		err = fmt.Errorf("unknown hdf5 layout version %d", reader.layoutVersion)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// readHDF5LayoutVersion reads the layout_version attribute of the root group
+func readHDF5LayoutVersion(file *hdf5.File) int {
+	root, err := file.OpenGroup("/")
+	if err != nil {
+		return HDF5LayoutChunked
+	}
+	defer root.Close()
+
+	attribute, err := root.OpenAttribute(HDF5LayoutVersionAttribute)
+	if err != nil {
+		return HDF5LayoutChunked
+	}
+	defer attribute.Close()
+
+	var layoutVersion int64
+	err = attribute.Read(&layoutVersion, hdf5.T_NATIVE_INT64)
+	if err != nil {
+		return HDF5LayoutChunked
+	}
+	return int(layoutVersion)
+}
+
+// indexChunks finds the datasets of every signal in the /data/chunk_N groups, in chunk order
+func (r *HDF5Reader) indexChunks(dataGroup *hdf5.Group) error {
+	chunkNames, err := hdf5ObjectNames(dataGroup, hdf5.H5G_GROUP)
+	if err != nil {
+		return err
+	}
+
+	// Names are sorted alphabetically by HDF5 (chunk_10 before chunk_2), the values need to be in chunk order
+	sort.Slice(chunkNames, func(i, j int) bool {
+		return hdf5ChunkNumber(chunkNames[i]) < hdf5ChunkNumber(chunkNames[j])
+	})
+
+	for _, chunkName := range chunkNames {
+		chunk, err := dataGroup.OpenGroup(chunkName)
+		if err != nil {
+			return fmt.Errorf("could not open %s: %v", chunkName, err)
+		}
+
+		datasetNames, err := hdf5ObjectNames(chunk, hdf5.H5G_DATASET)
+		chunk.Close()
+		if err != nil {
+			return err
+		}
+
+		// Datasets of the chunked layout are named after the full signal path
+		for _, datasetName := range datasetNames {
+			r.datasets[datasetName] = append(r.datasets[datasetName], "/data/"+chunkName+"/"+datasetName)
+		}
+	}
+
+	return nil
+}
+
+func hdf5ChunkNumber(chunkName string) int {
+	number, err := strconv.Atoi(strings.TrimPrefix(chunkName, "chunk_"))
+	if err != nil {
+		return math.MaxInt
+	}
+	return number
+}
+
+// indexSignalGroup walks the groups of the signal layout, every dataset is a signal whose path is made of the group names above it
+func (r *HDF5Reader) indexSignalGroup(group *hdf5.Group, groupPath, signalPath string) error {
+	numObjects, err := group.NumObjects()
+	if err != nil {
+		return err
+	}
+
+	for i := uint(0); i < numObjects; i++ {
+		name, err := group.ObjectNameByIndex(i)
+		if err != nil {
+			return err
+		}
+		objectType, err := group.ObjectTypeByIndex(i)
+		if err != nil {
+			return err
+		}
+
+		path := name
+		if signalPath != "" {
+			path = signalPath + "." + name
+		}
+
+		switch objectType {
+		case hdf5.H5G_DATASET:
+			r.datasets[path] = []string{groupPath + "/" + name}
+		case hdf5.H5G_GROUP:
+			nested, err := group.OpenGroup(name)
+			if err != nil {
+				return fmt.Errorf("could not open %s: %v", path, err)
+			}
+			err = r.indexSignalGroup(nested, groupPath+"/"+name, path)
+			nested.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// hdf5ObjectNames returns the names of all objects of a type in a group
+func hdf5ObjectNames(group *hdf5.Group, objectType hdf5.GType) ([]string, error) {
+	numObjects, err := group.NumObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, numObjects)
+	for i := uint(0); i < numObjects; i++ {
+		currentType, err := group.ObjectTypeByIndex(i)
+		if err != nil {
+			return nil, err
+		}
+		if currentType != objectType {
+			continue
+		}
+
+		name, err := group.ObjectNameByIndex(i)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// LayoutVersion returns the layout of the file, HDF5LayoutChunked or HDF5LayoutSignals
+func (r *HDF5Reader) LayoutVersion() int {
+	return r.layoutVersion
+}
+
+// Signals returns the paths of every signal in the file in alphabetical order
+func (r *HDF5Reader) Signals() []string {
+	signals := make([]string, 0, len(r.datasets))
+	for path := range r.datasets {
+		signals = append(signals, path)
+	}
+	sort.Strings(signals)
+	return signals
+}
+
+// ReadSignals reads the signals in query from the file, keeping only the values inside its time window.
+// Numeric and bool signals are returned as float64. Signals which are not numbers (like the enum names and strings
+// of the chunked layout) are returned without values and with their Error set, so they don't fail the other signals.
+func (r *HDF5Reader) ReadSignals(query SignalQuery) ([]*SignalSeries, error) {
+	allSeries := make([]*SignalSeries, 0, len(query.Signals))
+	for _, signalPath := range query.Signals {
+		datasetPaths, ok := r.datasets[signalPath]
+		if !ok {
+			return nil, fmt.Errorf("signal %s was not found in the hdf5 file", signalPath)
+		}
+
+		series := &SignalSeries{
+			Path:   signalPath,
+			Times:  make([]float64, 0),
+			Values: make([]float64, 0),
+		}
+		for _, datasetPath := range datasetPaths {
+			err := r.readDataset(datasetPath, query, series)
+			if errors.Is(err, errHDF5NotNumeric) {
+				series.Times, series.Values = make([]float64, 0), make([]float64, 0)
+				series.Error = err.Error()
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not read %s: %v", signalPath, err)
+			}
+		}
+
+		allSeries = append(allSeries, series)
+	}
+
+	return allSeries, nil
+}
+
+// readDataset appends the values of a single (timestamp, value) dataset inside the time window of query to series
+func (r *HDF5Reader) readDataset(datasetPath string, query SignalQuery, series *SignalSeries) error {
+	dataset, err := r.file.OpenDataset(datasetPath)
+	if err != nil {
+		return err
+	}
+	defer dataset.Close()
+
+	dtype, err := dataset.Datatype()
+	if err != nil {
+		return err
+	}
+	defer dtype.Close()
+
+	if dtype.Class() != hdf5.T_COMPOUND {
+		return fmt.Errorf("%w: dataset %s is not a table of values and timestamps", errHDF5NotNumeric, datasetPath)
+	}
+	compoundType := &hdf5.CompoundType{Datatype: *dtype}
+
+	// The chunked layout names its fields Timestamp and Data, the signal layout uses timestamp and value
+	timestampField, err := hdf5NumericField(compoundType, "Timestamp", "timestamp")
+	if err != nil {
+		return err
+	}
+	valueField, err := hdf5NumericField(compoundType, "Data", "value")
+	if err != nil {
+		return err
+	}
+
+	dataspace := dataset.Space()
+	if dataspace == nil {
+		return fmt.Errorf("could not get the dataspace of %s", datasetPath)
+	}
+	numRecords := dataspace.SimpleExtentNPoints()
+	dataspace.Close()
+	if numRecords == 0 {
+		return nil
+	}
+
+	recordSize := int(dtype.Size())
+	buffer := make([]byte, numRecords*recordSize)
+	err = dataset.Read(&buffer)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < numRecords; i++ {
+		record := buffer[i*recordSize : (i+1)*recordSize]
+		timestamp := timestampField.decode(record[timestampField.offset:])
+		if timestamp < query.Start || (query.End > 0 && timestamp > query.End) {
+			continue
+		}
+		series.Times = append(series.Times, timestamp)
+		series.Values = append(series.Values, valueField.decode(record[valueField.offset:]))
+	}
+
+	return nil
+}
+
+// hdf5NumericField finds the first of names in a compound type and returns how to decode it as a float64
+func hdf5NumericField(compoundType *hdf5.CompoundType, names ...string) (*hdf5Field, error) {
+	for _, name := range names {
+		index := compoundType.MemberIndex(name)
+		if index < 0 {
+			continue
+		}
+
+		memberType, err := compoundType.MemberType(index)
+		if err != nil {
+			return nil, err
+		}
+		defer memberType.Close()
+
+		decode := hdf5FloatDecoder(memberType)
+		if decode == nil {
+			return nil, fmt.Errorf("%w: field %s is not a number", errHDF5NotNumeric, name)
+		}
+		return &hdf5Field{offset: compoundType.MemberOffset(index), decode: decode}, nil
+	}
+
+	return nil, fmt.Errorf("none of the fields %v were found", names)
+}
+
+// hdf5FloatDecoder returns a function reading a value of dtype as a float64, or nil if dtype is not a number
+func hdf5FloatDecoder(dtype *hdf5.Datatype) func([]byte) float64 {
+	switch {
+	case dtype.Equal(hdf5.T_NATIVE_DOUBLE):
+		return func(b []byte) float64 { return math.Float64frombits(binary.NativeEndian.Uint64(b)) }
+	case dtype.Equal(hdf5.T_NATIVE_FLOAT):
+		return func(b []byte) float64 { return float64(math.Float32frombits(binary.NativeEndian.Uint32(b))) }
+	case dtype.Equal(hdf5.T_NATIVE_INT64):
+		return func(b []byte) float64 { return float64(int64(binary.NativeEndian.Uint64(b))) }
+	case dtype.Equal(hdf5.T_NATIVE_UINT64):
+		return func(b []byte) float64 { return float64(binary.NativeEndian.Uint64(b)) }
+	case dtype.Equal(hdf5.T_NATIVE_INT32):
+		return func(b []byte) float64 { return float64(int32(binary.NativeEndian.Uint32(b))) }
+	case dtype.Equal(hdf5.T_NATIVE_UINT32):
+		return func(b []byte) float64 { return float64(binary.NativeEndian.Uint32(b)) }
+	case dtype.Equal(hdf5.T_NATIVE_INT16):
+		return func(b []byte) float64 { return float64(int16(binary.NativeEndian.Uint16(b))) }
+	case dtype.Equal(hdf5.T_NATIVE_UINT16):
+		return func(b []byte) float64 { return float64(binary.NativeEndian.Uint16(b)) }
+	case dtype.Equal(hdf5.T_NATIVE_INT8):
+		return func(b []byte) float64 { return float64(int8(b[0])) }
+	case dtype.Equal(hdf5.T_NATIVE_UINT8), dtype.Equal(hdf5.T_NATIVE_HBOOL):
+		return func(b []byte) float64 { return float64(b[0]) }
+	default:
+		return nil
+	}
+}
+
+// Close closes the HDF5 file
+func (r *HDF5Reader) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,523 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/hdf5"
+)
+
+// HDF5 files come in two layouts. The layout of a file is stored in the layout_version attribute of the root group,
+// files without the attribute were written before it existed and use HDF5LayoutChunked.
+const (
+	// HDF5LayoutChunked splits the run into /data/chunk_N groups, each with a table of Data and Timestamp per signal
+	// (/data/chunk_0/VehicleData.current_rpms.FL). MPS scripts written for it need to stitch the chunks together.
+	HDF5LayoutChunked = 1
+
+	// HDF5LayoutSignals has a single extendible dataset of (timestamp, value) per signal, with groups for every
+	// level of the signal path (/data/VehicleData/current_rpms/FL) and attributes describing the signal.
+	HDF5LayoutSignals = 2
+
+	HDF5LayoutVersionAttribute = "layout_version"
+)
+
+const (
+	// hdf5SignalChunkSize is the number of packets in a chunk of a signal dataset, which is also the number of
+	// values held in memory for a signal before they are appended to its dataset
+	hdf5SignalChunkSize = 4096
+
+	// hdf5SignalMaxAge is the longest time in seconds of the run a value is held in memory before it is appended,
+	// so slow signals don't hold on to their buffers for the whole run
+	hdf5SignalMaxAge = 30.0
+
+	// hdf5SignalCompression is the deflate level of the signal datasets
+	hdf5SignalCompression = 4
+
+	// hdf5StringRecordBytes is the size of a buffered string packet, excluding the bytes of the string
+	hdf5StringRecordBytes = 40
+)
+
+// HDF5SignalAttributes are written as attributes on the dataset of a signal when it is created
+type HDF5SignalAttributes struct {
+	Topic         string
+	Type          string
+	Units         string
+	SchemaVersion string
+
+	// EnumNames is a JSON object of enum numbers to names, empty if the signal is not an enum
+	EnumNames string
+}
+
+// HDF5SignalWriter writes HDF5 files with the HDF5LayoutSignals layout.
+// Every signal has its own buffer which is appended to its dataset once it is full or holds values older than
+// hdf5SignalMaxAge, so signals are flushed independently and the whole file is never held in memory.
+// If the writer has a budget and the job goes over its ceiling, the largest buffers are flushed until the job is back
+// under the low water mark of the budget (see relieveBudget).
+type HDF5SignalWriter struct {
+	file         *hdf5.File
+	rootGroup    *hdf5.Group
+	groups       map[string]*hdf5.Group
+	signals      map[string]*hdf5Signal
+	budget       *HDF5BufferBudget
+	nextAgeCheck float64
+
+	// sinceRelief is the number of bytes buffered since the writer last relieved the budget
+	sinceRelief int64
+}
+
+// hdf5Signal is the dataset of a single signal and the values which have not been appended to it yet
+type hdf5Signal struct {
+	table      *hdf5.Table
+	kind       reflect.Kind
+	recordSize int
+
+	// buffer holds the encoded packets of numeric and bool signals.
+	// It is allocated once with room for hdf5SignalChunkSize packets and reused after every flush.
+	buffer []byte
+
+	// stringRecords holds the packets of string signals, which can't be encoded ahead of time
+	stringRecords []interface{}
+
+	count           int
+	bufferedBytes   int64
+	oldestTimestamp float64
+}
+
+// hdf5Record is a single encoded (timestamp, value) packet, sliced out of the buffer of its signal.
+// It implements the cmem.CMarshaler interface so the packet is appended exactly as laid out in the compound type.
+type hdf5Record []byte
+
+func (r hdf5Record) MarshalC() ([]byte, error) {
+	return r, nil
+}
+
+// hdf5StringRecord is a (timestamp, value) packet of a string signal.
+// Strings are variable length so they are appended one at a time through the default encoder.
+type hdf5StringRecord struct {
+	Timestamp float64
+	Value     string
+}
+
+// NewHDF5SignalWriter creates a HDF5 file at filename. Every entry of metadata is written as an attribute of the root group
+// and must be a string, float64 or int64.
+func NewHDF5SignalWriter(filename string, metadata map[string]interface{}) (*HDF5SignalWriter, error) {
+	file, err := hdf5.CreateFile(filename, hdf5.F_ACC_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeHDF5RootAttributes(file, HDF5LayoutSignals, metadata)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	rootGroup, err := file.CreateGroup("data")
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &HDF5SignalWriter{
+		file:      file,
+		rootGroup: rootGroup,
+		groups:    make(map[string]*hdf5.Group),
+		signals:   make(map[string]*hdf5Signal),
+	}, nil
+}
+
+// WithBufferBudget counts the bytes buffered by the writer against budget, which is shared by the writers of a job
+func (w *HDF5SignalWriter) WithBufferBudget(budget *HDF5BufferBudget) *HDF5SignalWriter {
+	w.budget = budget
+	return w
+}
+
+// AddValue appends a value to the dataset of the signal at path (VehicleData.current_rpms.FL).
+// The dataset type is picked from the first value of a signal, attributes is only called when the dataset is created.
+// It returns false if the value does not fit in the dataset of the signal.
+func (w *HDF5SignalWriter) AddValue(path string, timestamp float64, value interface{}, attributes func() HDF5SignalAttributes) (bool, error) {
+	signal, ok := w.signals[path]
+	if !ok {
+		var err error
+		signal, err = w.createSignal(path, reflect.TypeOf(value).Kind(), attributes())
+		if err != nil {
+			return false, err
+		}
+		if signal == nil {
+			return false, nil
+		}
+	}
+
+	var recordBytes int64
+	if signal.kind == reflect.String {
+		stringValue, ok := value.(string)
+		if !ok {
+			return false, nil
+		}
+		signal.stringRecords = append(signal.stringRecords, hdf5StringRecord{Timestamp: timestamp, Value: stringValue})
+		recordBytes = int64(hdf5StringRecordBytes + len(stringValue))
+	} else {
+		buffer, ok := appendHDF5Record(signal.buffer, signal.kind, timestamp, value)
+		if !ok {
+			return false, nil
+		}
+		signal.buffer = buffer
+		recordBytes = int64(signal.recordSize)
+	}
+
+	if signal.count == 0 {
+		signal.oldestTimestamp = timestamp
+	}
+	signal.count++
+	signal.bufferedBytes += recordBytes
+	w.sinceRelief += recordBytes
+	overCeiling := w.budget.Add(recordBytes)
+
+	if signal.count >= hdf5SignalChunkSize {
+		return true, w.flush(path, signal)
+	}
+
+	if overCeiling && w.sinceRelief >= HDF5BufferReliefBytes {
+		return true, w.relieveBudget()
+	}
+
+	if timestamp >= w.nextAgeCheck {
+		w.nextAgeCheck = timestamp + 1
+		return true, w.flushOlderThan(timestamp - hdf5SignalMaxAge)
+	}
+
+	return true, nil
+}
+
+// createSignal creates the groups of a signal path and the dataset of the signal.
+// It returns nil if values of kind can't be stored.
+func (w *HDF5SignalWriter) createSignal(path string, kind reflect.Kind, attributes HDF5SignalAttributes) (*hdf5Signal, error) {
+	valueType := hdf5SignalValueType(kind)
+	if valueType == nil {
+		return nil, nil
+	}
+
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		// HDF5 uses / to separate groups so it can't be part of a name
+		names[i] = strings.ReplaceAll(name, "/", "_")
+	}
+
+	group := w.rootGroup
+	for i := range names[:len(names)-1] {
+		groupPath := strings.Join(names[:i+1], "/")
+		nextGroup, ok := w.groups[groupPath]
+		if !ok {
+			var err error
+			nextGroup, err = group.CreateGroup(names[i])
+			if err != nil {
+				return nil, fmt.Errorf("could not create group %s: %v", groupPath, err)
+			}
+			w.groups[groupPath] = nextGroup
+		}
+		group = nextGroup
+	}
+
+	// Timestamp always comes first and the value follows it directly, the compound type has no padding
+	compoundType, err := hdf5.NewCompoundType(8 + int(valueType.Size()))
+	if err != nil {
+		return nil, err
+	}
+	defer compoundType.Close()
+	err = compoundType.Insert("timestamp", 0, hdf5.T_NATIVE_DOUBLE)
+	if err != nil {
+		return nil, err
+	}
+	err = compoundType.Insert("value", 8, valueType)
+	if err != nil {
+		return nil, err
+	}
+
+	datasetName := names[len(names)-1]
+	table, err := group.CreateTable(datasetName, &compoundType.Datatype, hdf5SignalChunkSize, hdf5SignalCompression)
+	if err != nil {
+		return nil, fmt.Errorf("could not create dataset %s: %v", path, err)
+	}
+
+	dataset, err := group.OpenDataset(datasetName)
+	if err != nil {
+		table.Close()
+		return nil, fmt.Errorf("could not open dataset %s: %v", path, err)
+	}
+	defer dataset.Close()
+
+	signalAttributes := map[string]interface{}{
+		"topic":          attributes.Topic,
+		"type":           attributes.Type,
+		"units":          attributes.Units,
+		"schema_version": attributes.SchemaVersion,
+	}
+	if attributes.EnumNames != "" {
+		signalAttributes["enum_names"] = attributes.EnumNames
+	}
+	err = writeHDF5Attributes(dataset, signalAttributes)
+	if err != nil {
+		table.Close()
+		return nil, fmt.Errorf("could not write attributes of %s: %v", path, err)
+	}
+
+	signal := &hdf5Signal{
+		table:      table,
+		kind:       kind,
+		recordSize: 8 + int(valueType.Size()),
+	}
+	if kind != reflect.String {
+		signal.buffer = make([]byte, 0, hdf5SignalChunkSize*signal.recordSize)
+	}
+	w.signals[path] = signal
+	return signal, nil
+}
+
+// hdf5SignalValueType returns the HDF5 type of the value field of a signal dataset, or nil if the kind is not supported
+func hdf5SignalValueType(kind reflect.Kind) *hdf5.Datatype {
+	switch kind {
+	case reflect.Float64:
+		return hdf5.T_NATIVE_DOUBLE
+	case reflect.Float32:
+		return hdf5.T_NATIVE_FLOAT
+	case reflect.Int32:
+		return hdf5.T_NATIVE_INT32
+	case reflect.Int64, reflect.Int:
+		return hdf5.T_NATIVE_INT64
+	case reflect.Uint32:
+		return hdf5.T_NATIVE_UINT32
+	case reflect.Uint64:
+		return hdf5.T_NATIVE_UINT64
+	case reflect.Bool:
+		return hdf5.T_NATIVE_UINT8
+	case reflect.String:
+		return hdf5.T_GO_STRING
+	default:
+		return nil
+	}
+}
+
+// appendHDF5Record encodes a numeric or bool packet onto the end of buffer. Values which are a different type than
+// the dataset are only converted if the dataset stores doubles.
+func appendHDF5Record(buffer []byte, kind reflect.Kind, timestamp float64, value interface{}) ([]byte, bool) {
+	record := binary.NativeEndian.AppendUint64(buffer, math.Float64bits(timestamp))
+
+	switch castedValue := value.(type) {
+	case float64:
+		if kind == reflect.Float64 {
+			return binary.NativeEndian.AppendUint64(record, math.Float64bits(castedValue)), true
+		}
+	case float32:
+		if kind == reflect.Float32 {
+			return binary.NativeEndian.AppendUint32(record, math.Float32bits(castedValue)), true
+		}
+	case int32:
+		if kind == reflect.Int32 {
+			return binary.NativeEndian.AppendUint32(record, uint32(castedValue)), true
+		}
+	case int64:
+		if kind == reflect.Int64 {
+			return binary.NativeEndian.AppendUint64(record, uint64(castedValue)), true
+		}
+	case int:
+		if kind == reflect.Int {
+			return binary.NativeEndian.AppendUint64(record, uint64(castedValue)), true
+		}
+	case uint32:
+		if kind == reflect.Uint32 {
+			return binary.NativeEndian.AppendUint32(record, castedValue), true
+		}
+	case uint64:
+		if kind == reflect.Uint64 {
+			return binary.NativeEndian.AppendUint64(record, castedValue), true
+		}
+	case bool:
+		if kind == reflect.Bool {
+			if castedValue {
+				return append(record, 1), true
+			}
+			return append(record, 0), true
+		}
+	}
+
+	if kind == reflect.Float64 {
+		if floatValue, ok := SignalFloatValue(value); ok {
+			return binary.NativeEndian.AppendUint64(record, math.Float64bits(floatValue)), true
+		}
+	}
+	return buffer, false
+}
+
+// flush appends the buffered values of the signal to its dataset and empties its buffer
+func (w *HDF5SignalWriter) flush(path string, signal *hdf5Signal) error {
+	if signal.count == 0 {
+		return nil
+	}
+
+	if signal.kind == reflect.String {
+		for _, record := range signal.stringRecords {
+			if err := signal.table.Append(record); err != nil {
+				return fmt.Errorf("could not write values of %s: %v", path, err)
+			}
+		}
+		clear(signal.stringRecords)
+		signal.stringRecords = signal.stringRecords[:0]
+	} else {
+		records := make([]interface{}, signal.count)
+		for i := range records {
+			records[i] = hdf5Record(signal.buffer[i*signal.recordSize : (i+1)*signal.recordSize])
+		}
+		if err := signal.table.Append(records...); err != nil {
+			return fmt.Errorf("could not write values of %s: %v", path, err)
+		}
+		signal.buffer = signal.buffer[:0]
+	}
+
+	w.budget.Add(-signal.bufferedBytes)
+	signal.count = 0
+	signal.bufferedBytes = 0
+	return nil
+}
+
+// flushOlderThan flushes every signal which has held on to a value since before timestamp
+func (w *HDF5SignalWriter) flushOlderThan(timestamp float64) error {
+	for path, signal := range w.signals {
+		if signal.count > 0 && signal.oldestTimestamp < timestamp {
+			if err := w.flush(path, signal); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// relieveBudget flushes the largest buffers of the writer until the job is under the low water mark of its budget.
+// Buffers with fewer than HDF5BufferReliefMinValues values are kept, so the job may stay over its ceiling
+// when the bytes are held by other writers.
+func (w *HDF5SignalWriter) relieveBudget() error {
+	w.sinceRelief = 0
+
+	paths := make([]string, 0, len(w.signals))
+	for path, signal := range w.signals {
+		if signal.count >= HDF5BufferReliefMinValues {
+			paths = append(paths, path)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return w.signals[paths[i]].bufferedBytes > w.signals[paths[j]].bufferedBytes
+	})
+
+	for _, path := range paths {
+		if !w.budget.OverLowWater() {
+			break
+		}
+		if err := w.flush(path, w.signals[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *HDF5SignalWriter) flushAll() error {
+	for path, signal := range w.signals {
+		if err := w.flush(path, signal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close appends all buffered values and closes every dataset, group and the file
+func (w *HDF5SignalWriter) Close() error {
+	if err := w.flushAll(); err != nil {
+		return err
+	}
+
+	for path, signal := range w.signals {
+		if err := signal.table.Close(); err != nil {
+			return fmt.Errorf("could not close dataset %s: %v", path, err)
+		}
+	}
+
+	for groupPath, group := range w.groups {
+		if err := group.Close(); err != nil {
+			return fmt.Errorf("could not close group %s: %v", groupPath, err)
+		}
+	}
+
+	if err := w.rootGroup.Close(); err != nil {
+		return fmt.Errorf("could not close rootGroup: %v", err)
+	}
+
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("could not close HDF5 file: %v", err)
+	}
+
+	return nil
+}
+
+// hdf5AttributeCreator is implemented by both hdf5.Group and hdf5.Dataset
+type hdf5AttributeCreator interface {
+	CreateAttribute(name string, dtype *hdf5.Datatype, dspace *hdf5.Dataspace) (*hdf5.Attribute, error)
+}
+
+// writeHDF5RootAttributes writes the layout version and the run metadata on the root group of file
+func writeHDF5RootAttributes(file *hdf5.File, layoutVersion int64, metadata map[string]interface{}) error {
+	root, err := file.OpenGroup("/")
+	if err != nil {
+		return fmt.Errorf("could not open root group: %v", err)
+	}
+	defer root.Close()
+
+	attributes := make(map[string]interface{}, len(metadata)+1)
+	for name, value := range metadata {
+		attributes[name] = value
+	}
+	attributes[HDF5LayoutVersionAttribute] = layoutVersion
+
+	return writeHDF5Attributes(root, attributes)
+}
+
+// writeHDF5Attributes writes every attribute as a scalar. Values must be a string, float64 or int64.
+func writeHDF5Attributes(location hdf5AttributeCreator, attributes map[string]interface{}) error {
+	scalar, err := hdf5.CreateDataspace(hdf5.S_SCALAR)
+	if err != nil {
+		return err
+	}
+	defer scalar.Close()
+
+	for name, value := range attributes {
+		switch value.(type) {
+		case string, float64, int64:
+		default:
+			return fmt.Errorf("attribute %s has unsupported type %T", name, value)
+		}
+
+		dtype, err := hdf5.NewDataTypeFromType(reflect.TypeOf(value))
+		if err != nil {
+			return err
+		}
+
+		attribute, err := location.CreateAttribute(name, dtype, scalar)
+		if err != nil {
+			dtype.Close()
+			return fmt.Errorf("could not create attribute %s: %v", name, err)
+		}
+
+		// Attribute.Write reads the value through a pointer
+		valuePointer := reflect.New(reflect.TypeOf(value))
+		valuePointer.Elem().Set(reflect.ValueOf(value))
+		err = attribute.Write(valuePointer.Interface(), dtype)
+		attribute.Close()
+		dtype.Close()
+		if err != nil {
+			return fmt.Errorf("could not write attribute %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
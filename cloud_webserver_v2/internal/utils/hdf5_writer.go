@@ -20,12 +20,20 @@ type HDF5Writer struct {
 	currentChunk int
 }
 
-func NewHDF5Writer(filename string) (*HDF5Writer, error) {
+// NewHDF5Writer creates a HDF5 file with the HDF5LayoutChunked layout. Every entry of metadata is written as an attribute
+// of the root group and must be a string, float64 or int64.
+func NewHDF5Writer(filename string, metadata map[string]interface{}) (*HDF5Writer, error) {
 	file, err := hdf5.CreateFile(filename, hdf5.F_ACC_TRUNC)
 	if err != nil {
 		return nil, err
 	}
 
+	err = writeHDF5RootAttributes(file, HDF5LayoutChunked, metadata)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
 	rootGroup, err := file.CreateGroup("data")
 	if err != nil {
 		return nil, err
@@ -104,27 +112,6 @@ func (writer *HDF5Writer) exploreAndAddDataset(path string, chunk *hdf5.Group, d
 			}
 		}
 
-	// Leaving this open for interpolatedMat subscriber for now but we should really take this out tbh
-	case [][]float64:
-		flattenedSlice := FlattenSlice(data.([][]float64))
-		dims := []uint{uint(len(flattenedSlice) / 2), 2} // 2 rows: timestamp and value
-		dspace, err := hdf5.CreateSimpleDataspace(dims, nil)
-		if err != nil {
-			return fmt.Errorf("failed to create dataspace for path %s: %v", path, err)
-		}
-		defer dspace.Close()
-
-		// Create a dataset within the group for the specific signal
-		dataset, err := chunk.CreateDataset(path, hdf5.T_NATIVE_DOUBLE, dspace)
-		if err != nil {
-			return fmt.Errorf("failed to create dataset for %s: %v", path, err)
-		}
-		defer dataset.Close()
-
-		// Write the data to the dataset
-		if err := dataset.Write(&flattenedSlice); err != nil {
-			return fmt.Errorf("failed to write data to %s dataset: %v", path, err)
-		}
 	default:
 		log.Printf("unsupported type: %v", reflect.TypeOf(data))
 	}
@@ -132,18 +119,6 @@ func (writer *HDF5Writer) exploreAndAddDataset(path string, chunk *hdf5.Group, d
 	return nil
 }
 
-// FlattenSlice flattens a 2D slice to 1D
-func FlattenSlice(data [][]float64) []float64 {
-	flattened := make([]float64, len(data)*len(data[0]))
-	for i, innerList := range data {
-		for j, val := range innerList {
-			flattened[i*len(data[0])+j] = val
-		}
-	}
-
-	return flattened
-}
-
 // CreateDataType func Creates DataType based on the given message
 func CreateHDF5DataType(data []*HDF5WrapperMessage) (*hdf5.Datatype, error) {
 	var dtype *hdf5.Datatype
@@ -0,0 +1,514 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MAT v5 data types and array classes, see the MAT-File Format documentation from MathWorks
+const (
+	matInt8       = 1
+	matInt32      = 5
+	matUint32     = 6
+	matDouble     = 9
+	matMatrix     = 14
+	matCompressed = 15
+
+	matStructClass = 2
+	matDoubleClass = 6
+
+	// matMaxNameLength is the longest variable or field name MATLAB accepts
+	matMaxNameLength = 63
+
+	// matSignalChunkSize is the number of samples a signal holds in memory before they are spilled to disk
+	matSignalChunkSize = 4096
+
+	// matSampleBytes is the size of a buffered sample, its time and value
+	matSampleBytes = 16
+)
+
+// matStruct is a MATLAB struct, its fields are either nested structs or signals
+type matStruct struct {
+	fields map[string]interface{}
+}
+
+// matSignal is the leaf of the struct tree, written as a struct with a time and a value field.
+// Only the latest samples are held in memory, the earlier ones are in chunks of the spill file of the writer.
+type matSignal struct {
+	times  []float64
+	values []float64
+	chunks []matChunk
+	count  int
+}
+
+// matChunk is a run of n samples of a signal in the spill file, its n times followed by its n values
+type matChunk struct {
+	offset int64
+	n      int
+}
+
+// MatWriter builds a MATLAB MAT v5 file without any MATLAB or Python dependencies.
+// Every topic becomes a top level struct variable, and every signal becomes a nested struct with
+// a time and a value column vector, so a signal is read in MATLAB with VehicleData.current_rpms.FL.value.
+//
+// Every signal holds up to matSignalChunkSize samples in memory (16 bytes per sample) before they are spilled to a
+// temporary file, so a run is never held in memory. Every topic is compressed on its own into a temporary file when
+// the MAT file is written, so the size of a single topic is the only thing limited by the 4GB element size of the format.
+// The buffered samples are counted against the budget of the job, and the largest signals are spilled early when
+// the job is over its ceiling.
+type MatWriter struct {
+	topics    *matStruct
+	signals   []*matSignal
+	directory string
+	spill     *os.File
+	spillSize int64
+	budget    *HDF5BufferBudget
+
+	// sinceRelief is the number of bytes buffered since the writer last relieved the budget
+	sinceRelief int64
+}
+
+// NewMatWriter creates a writer whose temporary files are created in directory.
+// budget may be nil, in which case the buffered samples are only limited by matSignalChunkSize.
+// Close must be called to remove the temporary files.
+func NewMatWriter(directory string, budget *HDF5BufferBudget) (*MatWriter, error) {
+	spill, err := os.CreateTemp(directory, "mat_spill_*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create mat spill file: %v", err)
+	}
+
+	return &MatWriter{
+		topics:    &matStruct{fields: make(map[string]interface{})},
+		directory: directory,
+		spill:     spill,
+		budget:    budget,
+	}, nil
+}
+
+// AddValue adds a sample to the signal at path (MCUOutputData.current_rpms.FL).
+// timestamp is in seconds relative to the start of the run.
+func (m *MatWriter) AddValue(path string, timestamp float64, value float64) error {
+	names := strings.Split(path, ".")
+	current := m.topics
+	for i, name := range names {
+		name = MatName(name)
+		field, ok := current.fields[name]
+
+		if i == len(names)-1 {
+			if !ok {
+				field = &matSignal{}
+				current.fields[name] = field
+				m.signals = append(m.signals, field.(*matSignal))
+			}
+			// A path can't be both a signal and a struct, the first one to show up wins
+			signal, ok := field.(*matSignal)
+			if !ok {
+				return nil
+			}
+			return m.addSample(signal, timestamp, value)
+		}
+
+		if !ok {
+			field = &matStruct{fields: make(map[string]interface{})}
+			current.fields[name] = field
+		}
+		nested, ok := field.(*matStruct)
+		if !ok {
+			return nil
+		}
+		current = nested
+	}
+	return nil
+}
+
+func (m *MatWriter) addSample(signal *matSignal, timestamp float64, value float64) error {
+	signal.times = append(signal.times, timestamp)
+	signal.values = append(signal.values, value)
+	signal.count++
+	m.sinceRelief += matSampleBytes
+	overCeiling := m.budget.Add(matSampleBytes)
+
+	if len(signal.times) >= matSignalChunkSize {
+		return m.spillSignal(signal)
+	}
+
+	if overCeiling && m.sinceRelief >= HDF5BufferReliefBytes {
+		return m.relieveBudget()
+	}
+
+	return nil
+}
+
+// spillSignal appends the samples a signal holds in memory to the spill file as a new chunk
+func (m *MatWriter) spillSignal(signal *matSignal) error {
+	n := len(signal.times)
+	if n == 0 {
+		return nil
+	}
+
+	buffer := make([]byte, 0, 2*8*n)
+	for _, column := range [][]float64{signal.times, signal.values} {
+		for _, value := range column {
+			buffer = binary.LittleEndian.AppendUint64(buffer, math.Float64bits(value))
+		}
+	}
+	_, err := m.spill.WriteAt(buffer, m.spillSize)
+	if err != nil {
+		return fmt.Errorf("could not spill mat signal to disk: %v", err)
+	}
+
+	signal.chunks = append(signal.chunks, matChunk{offset: m.spillSize, n: n})
+	m.spillSize += int64(len(buffer))
+	m.budget.Add(-int64(n) * matSampleBytes)
+	signal.times = signal.times[:0]
+	signal.values = signal.values[:0]
+	return nil
+}
+
+// relieveBudget spills the largest signals until the job is under the low water mark of its budget.
+// Signals with fewer than HDF5BufferReliefMinValues samples are kept, so the job may stay over its ceiling
+// when the bytes are held by other writers.
+func (m *MatWriter) relieveBudget() error {
+	m.sinceRelief = 0
+
+	largest := make([]*matSignal, 0)
+	for _, signal := range m.signals {
+		if len(signal.times) >= HDF5BufferReliefMinValues {
+			largest = append(largest, signal)
+		}
+	}
+	sort.Slice(largest, func(i, j int) bool { return len(largest[i].times) > len(largest[j].times) })
+
+	for _, signal := range largest {
+		if !m.budget.OverLowWater() {
+			break
+		}
+		if err := m.spillSignal(signal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the samples held in memory and removes the spill file
+func (m *MatWriter) Close() error {
+	for _, signal := range m.signals {
+		m.budget.Add(-int64(len(signal.times)) * matSampleBytes)
+		signal.times, signal.values = nil, nil
+	}
+
+	m.spill.Close()
+	if err := os.Remove(m.spill.Name()); err != nil {
+		return fmt.Errorf("could not remove mat spill file: %v", err)
+	}
+	return nil
+}
+
+// MatName turns name into a valid MATLAB variable or field name
+func MatName(name string) string {
+	var builder strings.Builder
+	for _, c := range name {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+			builder.WriteRune(c)
+		} else {
+			builder.WriteRune('_')
+		}
+	}
+
+	out := builder.String()
+	if out == "" || !((out[0] >= 'a' && out[0] <= 'z') || (out[0] >= 'A' && out[0] <= 'Z')) {
+		out = "x" + out
+	}
+	if len(out) > matMaxNameLength {
+		out = out[:matMaxNameLength]
+	}
+	return out
+}
+
+// WriteTo writes the MAT file header followed by a compressed variable for every topic
+func (m *MatWriter) WriteTo(w io.Writer) (int64, error) {
+	counter := &matCountingWriter{writer: w}
+
+	err := writeMatHeader(counter)
+	if err != nil {
+		return counter.count, err
+	}
+
+	for _, topicName := range sortedMatFieldNames(m.topics) {
+		err = m.writeCompressedTopic(counter, topicName)
+		if err != nil {
+			return counter.count, err
+		}
+	}
+
+	return counter.count, nil
+}
+
+// writeCompressedTopic compresses the variable of a topic into a temporary file, its size is needed before it can be written
+func (m *MatWriter) writeCompressedTopic(w io.Writer, topicName string) error {
+	compressed, err := os.CreateTemp(m.directory, "mat_topic_*")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file for %s: %v", topicName, err)
+	}
+	defer os.Remove(compressed.Name())
+	defer compressed.Close()
+
+	zlibWriter := zlib.NewWriter(compressed)
+	err = m.writeMatElement(zlibWriter, topicName, m.topics.fields[topicName])
+	if err != nil {
+		return fmt.Errorf("could not write %s to mat file: %v", topicName, err)
+	}
+	err = zlibWriter.Close()
+	if err != nil {
+		return fmt.Errorf("could not compress %s: %v", topicName, err)
+	}
+
+	size, err := compressed.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if size > math.MaxUint32 {
+		return fmt.Errorf("topic %s is too large for a mat file", topicName)
+	}
+	err = writeMatTag(w, matCompressed, int(size))
+	if err != nil {
+		return err
+	}
+
+	_, err = compressed.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, compressed)
+	return err
+}
+
+// writeMatHeader writes the 128 byte MAT v5 header: descriptive text, subsystem offset, version and endian indicator
+func writeMatHeader(w io.Writer) error {
+	header := make([]byte, 128)
+	text := fmt.Sprintf("MATLAB 5.0 MAT-file, Platform: GLNXA64, Created on: %s", time.Now().Format("Mon Jan _2 15:04:05 2006"))
+	copy(header, bytes.Repeat([]byte(" "), 116))
+	copy(header, text)
+	binary.LittleEndian.PutUint16(header[124:126], 0x0100)
+	copy(header[126:128], "IM")
+
+	_, err := w.Write(header)
+	return err
+}
+
+// writeMatElement writes field (a struct or a signal) as a miMATRIX element named name.
+// Nested fields have an empty name, only top level variables are named.
+func (m *MatWriter) writeMatElement(w io.Writer, name string, field interface{}) error {
+	size, err := matElementSize(name, field)
+	if err != nil {
+		return err
+	}
+	err = writeMatTag(w, matMatrix, size)
+	if err != nil {
+		return err
+	}
+
+	switch castedField := field.(type) {
+	case *matStruct:
+		fieldNames := sortedMatFieldNames(castedField)
+		err = writeMatStructHeader(w, name, fieldNames)
+		if err != nil {
+			return err
+		}
+		for _, fieldName := range fieldNames {
+			err = m.writeMatElement(w, "", castedField.fields[fieldName])
+			if err != nil {
+				return err
+			}
+		}
+	case *matSignal:
+		err = writeMatStructHeader(w, name, []string{"time", "value"})
+		if err != nil {
+			return err
+		}
+		err = m.writeMatDoubleArray(w, castedField, 0)
+		if err != nil {
+			return err
+		}
+		err = m.writeMatDoubleArray(w, castedField, 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matElementSize returns the size in bytes of the miMATRIX element of field, excluding its tag
+func matElementSize(name string, field interface{}) (int, error) {
+	var size int
+	switch castedField := field.(type) {
+	case *matStruct:
+		fieldNames := sortedMatFieldNames(castedField)
+		size = matStructHeaderSize(name, fieldNames)
+		for _, fieldName := range fieldNames {
+			fieldSize, err := matElementSize("", castedField.fields[fieldName])
+			if err != nil {
+				return 0, err
+			}
+			size += 8 + fieldSize
+		}
+	case *matSignal:
+		size = matStructHeaderSize(name, []string{"time", "value"}) +
+			2*(8+matDoubleArraySize(castedField.count))
+	}
+
+	if size > math.MaxUint32 {
+		return 0, fmt.Errorf("%s is too large for a mat file", name)
+	}
+	return size, nil
+}
+
+// matStructHeaderSize is the size of everything in a struct element before its fields
+func matStructHeaderSize(name string, fieldNames []string) int {
+	fieldNameLength := matFieldNameLength(fieldNames)
+	return 16 + 16 + 8 + matPadding(len(name)) + 8 + 8 + matPadding(fieldNameLength*len(fieldNames))
+}
+
+// matDoubleArraySize is the size of a n x 1 unnamed double array element, excluding its tag
+func matDoubleArraySize(n int) int {
+	return 16 + 16 + 8 + 8 + matPadding(8*n)
+}
+
+func writeMatStructHeader(w io.Writer, name string, fieldNames []string) error {
+	err := writeMatArrayFlagsAndDimensions(w, matStructClass, 1)
+	if err != nil {
+		return err
+	}
+	err = writeMatData(w, matInt8, []byte(name))
+	if err != nil {
+		return err
+	}
+
+	// Field name length uses the small data element format, the tag and the value share 8 bytes
+	fieldNameLength := matFieldNameLength(fieldNames)
+	err = binary.Write(w, binary.LittleEndian, []uint32{4<<16 | matInt32, uint32(fieldNameLength)})
+	if err != nil {
+		return err
+	}
+
+	names := make([]byte, fieldNameLength*len(fieldNames))
+	for i, fieldName := range fieldNames {
+		copy(names[i*fieldNameLength:], fieldName)
+	}
+	return writeMatData(w, matInt8, names)
+}
+
+// writeMatDoubleArray writes a column of a signal, 0 for its times and 1 for its values, as a n x 1 double array.
+// The chunks spilled to disk are read back one at a time, followed by the samples held in memory.
+func (m *MatWriter) writeMatDoubleArray(w io.Writer, signal *matSignal, column int) error {
+	err := writeMatTag(w, matMatrix, matDoubleArraySize(signal.count))
+	if err != nil {
+		return err
+	}
+	err = writeMatArrayFlagsAndDimensions(w, matDoubleClass, signal.count)
+	if err != nil {
+		return err
+	}
+	err = writeMatData(w, matInt8, nil)
+	if err != nil {
+		return err
+	}
+
+	err = writeMatTag(w, matDouble, 8*signal.count)
+	if err != nil {
+		return err
+	}
+
+	buffer := make([]byte, 0, 8*matSignalChunkSize)
+	for _, chunk := range signal.chunks {
+		buffer = buffer[:8*chunk.n]
+		_, err = m.spill.ReadAt(buffer, chunk.offset+int64(column*8*chunk.n))
+		if err != nil {
+			return fmt.Errorf("could not read mat signal back from disk: %v", err)
+		}
+		_, err = w.Write(buffer)
+		if err != nil {
+			return err
+		}
+	}
+
+	buffered := signal.times
+	if column == 1 {
+		buffered = signal.values
+	}
+	buffer = buffer[:0]
+	for _, value := range buffered {
+		buffer = binary.LittleEndian.AppendUint64(buffer, math.Float64bits(value))
+	}
+	_, err = w.Write(buffer)
+	return err
+}
+
+// writeMatArrayFlagsAndDimensions writes the array flags and the dimensions (rows x 1) of a miMATRIX element
+func writeMatArrayFlagsAndDimensions(w io.Writer, class uint32, rows int) error {
+	return binary.Write(w, binary.LittleEndian, []uint32{
+		matUint32, 8, class, 0,
+		matInt32, 8, uint32(rows), 1,
+	})
+}
+
+// writeMatData writes a data element and pads it to 8 bytes
+func writeMatData(w io.Writer, dataType uint32, data []byte) error {
+	err := writeMatTag(w, dataType, len(data))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(make([]byte, matPadding(len(data))-len(data)))
+	return err
+}
+
+func writeMatTag(w io.Writer, dataType uint32, size int) error {
+	return binary.Write(w, binary.LittleEndian, []uint32{dataType, uint32(size)})
+}
+
+// matFieldNameLength is the length every field name is padded to, including a null terminator
+func matFieldNameLength(fieldNames []string) int {
+	length := 0
+	for _, fieldName := range fieldNames {
+		length = max(length, len(fieldName))
+	}
+	return length + 1
+}
+
+// matPadding rounds n up to the next multiple of 8
+func matPadding(n int) int {
+	return (n + 7) / 8 * 8
+}
+
+func sortedMatFieldNames(s *matStruct) []string {
+	fieldNames := make([]string, 0, len(s.fields))
+	for fieldName := range s.fields {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	sort.Strings(fieldNames)
+	return fieldNames
+}
+
+type matCountingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (c *matCountingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.count += int64(n)
+	return n, err
+}
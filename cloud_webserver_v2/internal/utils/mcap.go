@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +11,7 @@ import (
 	"strconv"
 
 	"github.com/foxglove/mcap/go/mcap"
+	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/dynamic"
 )
 
@@ -21,6 +24,10 @@ type McapReader struct {
 	Reader     *mcap.Reader
 	Info       *mcap.Info
 	SchemaList []string
+
+	// SchemaVersions maps every schema name to a short hash of its definition,
+	// so files generated from runs with different protobuf definitions can be told apart
+	SchemaVersions map[string]string
 }
 
 // DecodedMessage contains decoded data from a protobuf encoded message
@@ -28,6 +35,10 @@ type DecodedMessage struct {
 	Data    map[string]interface{}
 	Topic   string
 	LogTime uint64
+
+	// Descriptor is the protobuf descriptor of the top level message.
+	// It is nil for JSON encoded messages.
+	Descriptor *desc.MessageDescriptor
 }
 
 func NewMcapUtils() *McapUtils {
@@ -58,12 +69,32 @@ func (m *McapUtils) NewReader(r io.Reader) (*McapReader, error) {
 	}
 
 	return &McapReader{
-		Reader:     reader,
-		Info:       info,
-		SchemaList: schemaList,
+		Reader:         reader,
+		Info:           info,
+		SchemaList:     schemaList,
+		SchemaVersions: GetMcapSchemaVersions(info),
 	}, nil
 }
 
+// StartTime returns the log time of the first message of the MCAP file, the origin of the times of every signal of the run.
+// It is read from the statistics of the file, or from its first message if the file has no statistics.
+// It returns io.EOF if the file has no messages.
+func (r *McapReader) StartTime() (uint64, error) {
+	if r.Info != nil && r.Info.Statistics != nil {
+		return r.Info.Statistics.MessageStartTime, nil
+	}
+
+	messageIterator, err := r.Reader.Messages()
+	if err != nil {
+		return 0, fmt.Errorf("could not get mcap messages: %v", err)
+	}
+	_, _, message, err := messageIterator.NextInto(nil)
+	if err != nil {
+		return 0, err
+	}
+	return message.LogTime, nil
+}
+
 // GetDecodedMessage checks whether the encoding is json or protobuf and decodes
 func (m *McapUtils) GetDecodedMessage(schema *mcap.Schema, message *mcap.Message) (*DecodedMessage, error) {
 	// Schema encoding may only be omitted for self-describing message encodings such as json.
@@ -116,9 +147,10 @@ func (m *McapUtils) DecodeProtobuf(schema *mcap.Schema, message *mcap.Message) (
 	}
 
 	decodedMessage := DecodedMessage{
-		Topic:   schema.Name,
-		Data:    make(map[string]interface{}),
-		LogTime: message.LogTime,
+		Topic:      schema.Name,
+		Data:       make(map[string]interface{}),
+		LogTime:    message.LogTime,
+		Descriptor: messageDescriptor,
 	}
 
 	fields := dynMsg.GetKnownFields()
@@ -172,16 +204,15 @@ func (m *McapUtils) GetMcapSchemaList(reader *mcap.Reader) ([]string, error) {
 	return schemaList, nil
 }
 
-func GetMcapSchemaMap(schemaList []string) (map[string]map[string][]float64, error) {
-	mcapSchemaMap := make(map[string]map[string][]float64)
-	mcapSchemaMap["global_times"] = make(map[string][]float64)
-	mcapSchemaMap["global_times"]["times"] = make([]float64, 0)
-
-	for _, schemaName := range schemaList {
-		mcapSchemaMap[schemaName] = make(map[string][]float64)
+// GetMcapSchemaVersions returns the first 12 hex characters of the sha256 of every schema definition in the MCAP
+func GetMcapSchemaVersions(info *mcap.Info) map[string]string {
+	schemaVersions := make(map[string]string)
+	for _, schema := range info.Schemas {
+		hash := sha256.Sum256(schema.Data)
+		schemaVersions[schema.Name] = hex.EncodeToString(hash[:])[:12]
 	}
 
-	return mcapSchemaMap, nil
+	return schemaVersions
 }
 
 func GetFloatValueOfInterface(val interface{}) float64 {
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/foxglove/mcap/go/mcap"
+	"github.com/jhump/protoreflect/desc"
+)
+
+// SignalSeries contains the values of a single signal over time.
+// Error is set, and Times and Values are empty, when the signal exists but could not be read as numbers.
+type SignalSeries struct {
+	Path   string    `json:"path"`
+	Times  []float64 `json:"times"`
+	Values []float64 `json:"values"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// SignalQuery describes which signals to read from an MCAP file and which time window to read them in.
+// Start and End are in seconds relative to the first message of the MCAP file.
+// An End of 0 reads until the end of the file.
+type SignalQuery struct {
+	Signals []string
+	Start   float64
+	End     float64
+}
+
+// QuerySignals reads the signals in query from an MCAP file.
+// It uses the MCAP index to only read the chunks inside the time window and the topics the signals live in,
+// so we don't decode the whole file to get a couple of signals.
+// All the returned series share the same time origin (the first message of the file, see McapReader.StartTime) so they are aligned.
+func (m *McapUtils) QuerySignals(r io.ReadSeeker, query SignalQuery) ([]*SignalSeries, error) {
+	mcapReader, err := m.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Signal paths always start with their trimmed topic, which we use to find the channels to read
+	seriesMap := make(map[string]*SignalSeries)
+	wantedTopics := make(map[string]bool)
+	for _, signalPath := range query.Signals {
+		seriesMap[signalPath] = &SignalSeries{
+			Path:   signalPath,
+			Times:  make([]float64, 0),
+			Values: make([]float64, 0),
+		}
+		wantedTopics[topicOfSignalPath(signalPath)] = true
+	}
+
+	channelTopics := make([]string, 0)
+	for _, channel := range mcapReader.Info.Channels {
+		schema, ok := mcapReader.Info.Schemas[channel.SchemaID]
+		if !ok {
+			continue
+		}
+		if wantedTopics[TrimTopic(schema.Name)] {
+			channelTopics = append(channelTopics, channel.Topic)
+		}
+	}
+
+	if len(channelTopics) == 0 {
+		return nil, fmt.Errorf("none of the signals %v were found in the mcap file", query.Signals)
+	}
+
+	firstLogTime, err := mcapReader.StartTime()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the start time of the mcap file: %v", err)
+	}
+
+	endNanos := uint64(math.MaxUint64)
+	if query.End > 0 {
+		endNanos = firstLogTime + uint64(query.End*1e9)
+	}
+
+	messageIterator, err := mcapReader.Reader.Messages(
+		mcap.UsingIndex(true),
+		mcap.InOrder(mcap.LogTimeOrder),
+		mcap.WithTopics(channelTopics),
+		mcap.AfterNanos(firstLogTime+uint64(max(query.Start, 0)*1e9)),
+		mcap.BeforeNanos(endNanos),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not get mcap messages: %v", err)
+	}
+
+	for {
+		schema, _, message, err := messageIterator.NextInto(nil)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading mcap message: %v", err)
+		}
+		if schema == nil {
+			continue
+		}
+
+		decodedMessage, err := m.GetDecodedMessage(schema, message)
+		if err != nil {
+			continue
+		}
+
+		logTime := float64(decodedMessage.LogTime-firstLogTime) / 1e9
+		WalkSignals(decodedMessage, func(path string, field *desc.FieldDescriptor, value interface{}) {
+			series, ok := seriesMap[path]
+			if !ok {
+				return
+			}
+
+			floatValue, ok := SignalFloatValue(value)
+			if !ok {
+				return
+			}
+
+			series.Times = append(series.Times, logTime)
+			series.Values = append(series.Values, floatValue)
+		})
+	}
+
+	allSeries := make([]*SignalSeries, len(query.Signals))
+	for i, signalPath := range query.Signals {
+		allSeries[i] = seriesMap[signalPath]
+	}
+
+	return allSeries, nil
+}
+
+// topicOfSignalPath returns the topic part of a signal path (VehicleData.current_rpms.FL -> VehicleData)
+func topicOfSignalPath(signalPath string) string {
+	topic, _, _ := strings.Cut(signalPath, ".")
+	return topic
+}
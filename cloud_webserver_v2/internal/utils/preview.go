@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Preview pyramids are pre-computed, multi-resolution summaries of every numeric signal in a run.
+// Level 0 splits a signal into buckets of PreviewBaseBucketWidth seconds, and every level above it
+// merges PreviewLevelFactor buckets of the level below. Each bucket stores the min, max and mean of its samples,
+// which is all a plotter needs to draw a signal at a given pixel width.
+//
+// The pyramid is stored as a single object with the following little-endian layout:
+//
+//	4 bytes magic "HTPV"
+//	uint32 format version
+//	uint32 length of the JSON header, followed by the header (see previewHeader)
+//	blocks of buckets, each bucket is a uint32 index followed by float32 min, max and mean
+//
+// The header contains the offset of every (signal, level) block so a reader only reads what it needs.
+
+const (
+	PreviewBaseBucketWidth = 0.1 // seconds
+	PreviewLevelFactor     = 4
+	PreviewMaxLevels       = 8
+
+	previewMagic      = "HTPV"
+	previewVersion    = 1
+	previewBucketSize = 16 // bytes
+)
+
+// previewHeader is the JSON header of a stored preview pyramid
+type previewHeader struct {
+	BaseBucketWidth float64              `json:"base_bucket_width"`
+	LevelFactor     int                  `json:"level_factor"`
+	Duration        float64              `json:"duration"`
+	Signals         []previewSignalIndex `json:"signals"`
+}
+
+type previewSignalIndex struct {
+	Path   string              `json:"path"`
+	Levels []previewLevelIndex `json:"levels"`
+}
+
+type previewLevelIndex struct {
+	BucketWidth float64 `json:"bucket_width"`
+	Offset      int64   `json:"offset"` // relative to the end of the header
+	Count       int     `json:"count"`
+}
+
+// previewBucket accumulates the samples of a signal which fall into the same time bucket
+type previewBucket struct {
+	index uint32
+	min   float64
+	max   float64
+	sum   float64
+	count uint32
+}
+
+func (b *previewBucket) add(value float64) {
+	b.min = math.Min(b.min, value)
+	b.max = math.Max(b.max, value)
+	b.sum += value
+	b.count++
+}
+
+func (b *previewBucket) merge(other previewBucket) {
+	b.min = math.Min(b.min, other.min)
+	b.max = math.Max(b.max, other.max)
+	b.sum += other.sum
+	b.count += other.count
+}
+
+// PreviewPyramidBuilder builds the level 0 buckets of every signal as values come in.
+// The coarser levels are only computed when the pyramid is written.
+type PreviewPyramidBuilder struct {
+	signals  map[string][]previewBucket
+	duration float64
+}
+
+func NewPreviewPyramidBuilder() *PreviewPyramidBuilder {
+	return &PreviewPyramidBuilder{
+		signals: make(map[string][]previewBucket),
+	}
+}
+
+// AddValue adds a single sample of a signal. timestamp is in seconds relative to the start of the run.
+func (p *PreviewPyramidBuilder) AddValue(path string, timestamp float64, value float64) {
+	if timestamp < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+
+	p.duration = math.Max(p.duration, timestamp)
+	index := uint32(timestamp / PreviewBaseBucketWidth)
+	buckets := p.signals[path]
+
+	// Samples of a signal almost always come in order, so we only search when they don't
+	bucketPosition := len(buckets)
+	if len(buckets) > 0 && buckets[len(buckets)-1].index >= index {
+		bucketPosition = sort.Search(len(buckets), func(i int) bool { return buckets[i].index >= index })
+	}
+
+	if bucketPosition < len(buckets) && buckets[bucketPosition].index == index {
+		buckets[bucketPosition].add(value)
+		return
+	}
+
+	newBucket := previewBucket{index: index, min: value, max: value, sum: value, count: 1}
+	buckets = append(buckets, previewBucket{})
+	copy(buckets[bucketPosition+1:], buckets[bucketPosition:])
+	buckets[bucketPosition] = newBucket
+	p.signals[path] = buckets
+}
+
+// WriteTo computes all the levels of the pyramid and writes them to w
+func (p *PreviewPyramidBuilder) WriteTo(w io.Writer) (int64, error) {
+	paths := make([]string, 0, len(p.signals))
+	for path := range p.signals {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	header := previewHeader{
+		BaseBucketWidth: PreviewBaseBucketWidth,
+		LevelFactor:     PreviewLevelFactor,
+		Duration:        p.duration,
+		Signals:         make([]previewSignalIndex, 0, len(paths)),
+	}
+
+	var data bytes.Buffer
+	for _, path := range paths {
+		signalIndex := previewSignalIndex{Path: path}
+		buckets := p.signals[path]
+		bucketWidth := PreviewBaseBucketWidth
+		for level := 0; level < PreviewMaxLevels; level++ {
+			signalIndex.Levels = append(signalIndex.Levels, previewLevelIndex{
+				BucketWidth: bucketWidth,
+				Offset:      int64(data.Len()),
+				Count:       len(buckets),
+			})
+			for _, bucket := range buckets {
+				binary.Write(&data, binary.LittleEndian, bucket.index)
+				binary.Write(&data, binary.LittleEndian, float32(bucket.min))
+				binary.Write(&data, binary.LittleEndian, float32(bucket.max))
+				binary.Write(&data, binary.LittleEndian, float32(bucket.sum/float64(bucket.count)))
+			}
+
+			if len(buckets) <= 1 {
+				break
+			}
+			buckets = mergePreviewBuckets(buckets)
+			bucketWidth *= PreviewLevelFactor
+		}
+		header.Signals = append(header.Signals, signalIndex)
+	}
+
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return 0, fmt.Errorf("could not marshal preview header: %v", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(previewMagic)
+	binary.Write(&out, binary.LittleEndian, uint32(previewVersion))
+	binary.Write(&out, binary.LittleEndian, uint32(len(headerBytes)))
+	out.Write(headerBytes)
+	out.Write(data.Bytes())
+
+	return out.WriteTo(w)
+}
+
+// mergePreviewBuckets merges every PreviewLevelFactor buckets into the buckets of the next level
+func mergePreviewBuckets(buckets []previewBucket) []previewBucket {
+	merged := make([]previewBucket, 0, len(buckets)/PreviewLevelFactor+1)
+	for _, bucket := range buckets {
+		index := bucket.index / PreviewLevelFactor
+		if len(merged) > 0 && merged[len(merged)-1].index == index {
+			merged[len(merged)-1].merge(bucket)
+			continue
+		}
+		bucket.index = index
+		merged = append(merged, bucket)
+	}
+	return merged
+}
+
+// PreviewTile is the part of a preview pyramid level which covers a requested time window
+type PreviewTile struct {
+	Path        string    `json:"path"`
+	Level       int       `json:"level"`
+	BucketWidth float64   `json:"bucket_width"`
+	Times       []float64 `json:"times"`
+	Min         []float32 `json:"min"`
+	Max         []float32 `json:"max"`
+	Mean        []float32 `json:"mean"`
+}
+
+// PreviewReader reads tiles out of a stored preview pyramid
+type PreviewReader struct {
+	reader     io.ReadSeeker
+	header     previewHeader
+	dataOffset int64
+}
+
+func NewPreviewReader(r io.ReadSeeker) (*PreviewReader, error) {
+	magic := make([]byte, len(previewMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("could not read preview magic: %v", err)
+	}
+	if string(magic) != previewMagic {
+		return nil, errors.New("file is not a preview pyramid")
+	}
+
+	var version, headerLength uint32
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("could not read preview version: %v", err)
+	}
+	if version != previewVersion {
+		return nil, fmt.Errorf("unsupported preview version %d", version)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &headerLength); err != nil {
+		return nil, fmt.Errorf("could not read preview header length: %v", err)
+	}
+
+	headerBytes := make([]byte, headerLength)
+	if _, err := io.ReadFull(r, headerBytes); err != nil {
+		return nil, fmt.Errorf("could not read preview header: %v", err)
+	}
+
+	reader := &PreviewReader{
+		reader:     r,
+		dataOffset: int64(len(previewMagic) + 8 + int(headerLength)),
+	}
+	if err := json.Unmarshal(headerBytes, &reader.header); err != nil {
+		return nil, fmt.Errorf("could not decode preview header: %v", err)
+	}
+
+	return reader, nil
+}
+
+// Duration returns the time of the last sample in the pyramid in seconds
+func (p *PreviewReader) Duration() float64 {
+	return p.header.Duration
+}
+
+// ReadTile picks the finest level of a signal which covers the window [start, end] with at most width buckets
+// (one per pixel) and returns the buckets inside of the window.
+func (p *PreviewReader) ReadTile(path string, start, end float64, width int) (*PreviewTile, error) {
+	var signalIndex *previewSignalIndex
+	for i := range p.header.Signals {
+		if p.header.Signals[i].Path == path {
+			signalIndex = &p.header.Signals[i]
+			break
+		}
+	}
+	if signalIndex == nil || len(signalIndex.Levels) == 0 {
+		return nil, fmt.Errorf("signal %s not found in preview", path)
+	}
+
+	level := len(signalIndex.Levels) - 1
+	for i, levelIndex := range signalIndex.Levels {
+		if (end-start)/levelIndex.BucketWidth <= float64(width) {
+			level = i
+			break
+		}
+	}
+	levelIndex := signalIndex.Levels[level]
+
+	_, err := p.reader.Seek(p.dataOffset+levelIndex.Offset, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("could not seek to preview level: %v", err)
+	}
+
+	tile := &PreviewTile{
+		Path:        path,
+		Level:       level,
+		BucketWidth: levelIndex.BucketWidth,
+		Times:       make([]float64, 0),
+		Min:         make([]float32, 0),
+		Max:         make([]float32, 0),
+		Mean:        make([]float32, 0),
+	}
+
+	bucketBytes := make([]byte, levelIndex.Count*previewBucketSize)
+	if _, err := io.ReadFull(p.reader, bucketBytes); err != nil {
+		return nil, fmt.Errorf("could not read preview level: %v", err)
+	}
+
+	for i := 0; i < levelIndex.Count; i++ {
+		bucket := bucketBytes[i*previewBucketSize : (i+1)*previewBucketSize]
+		bucketTime := float64(binary.LittleEndian.Uint32(bucket[0:4])) * levelIndex.BucketWidth
+		if bucketTime+levelIndex.BucketWidth < start || bucketTime > end {
+			continue
+		}
+
+		tile.Times = append(tile.Times, bucketTime)
+		tile.Min = append(tile.Min, math.Float32frombits(binary.LittleEndian.Uint32(bucket[4:8])))
+		tile.Max = append(tile.Max, math.Float32frombits(binary.LittleEndian.Uint32(bucket[8:12])))
+		tile.Mean = append(tile.Mean, math.Float32frombits(binary.LittleEndian.Uint32(bucket[12:16])))
+	}
+
+	return tile, nil
+}
@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+)
+
+// SignalVisitor is called for every leaf signal found in a decoded message.
+// field is the protobuf field descriptor of the signal and is nil for JSON encoded messages.
+type SignalVisitor func(path string, field *desc.FieldDescriptor, value interface{})
+
+// TrimTopic turns topics in the format of "hytech_msgs.MCUOutputData" into "MCUOutputData"
+func TrimTopic(topic string) string {
+	trimmedTopicSlice := strings.Split(topic, ".")
+	return trimmedTopicSlice[len(trimmedTopicSlice)-1]
+}
+
+// WalkSignals flattens a decoded message into its leaf signals and calls visit on each one.
+// Signal paths are built the same way as the HDF5 layout: the trimmed topic followed by every nested
+// field name separated by dots (VehicleData.current_rpms.FL). Repeated values get an _index suffix.
+func WalkSignals(decodedMessage *DecodedMessage, visit SignalVisitor) {
+	if decodedMessage == nil || decodedMessage.Data == nil {
+		return
+	}
+
+	topic := TrimTopic(decodedMessage.Topic)
+	for signalName, value := range decodedMessage.Data {
+		var field *desc.FieldDescriptor
+		if decodedMessage.Descriptor != nil {
+			field = decodedMessage.Descriptor.FindFieldByName(signalName)
+		}
+		walkSignalValue(topic+"."+signalName, field, value, visit)
+	}
+}
+
+// walkSignalValue recursively explores value until it finds a non-nested value to visit
+func walkSignalValue(path string, field *desc.FieldDescriptor, value interface{}, visit SignalVisitor) {
+	switch castedValue := value.(type) {
+	case nil:
+		return
+	case *dynamic.Message: // Dynamic message
+		if castedValue == nil {
+			return
+		}
+		for _, nestedField := range castedValue.GetKnownFields() {
+			walkSignalValue(path+"."+nestedField.GetName(), nestedField, castedValue.GetField(nestedField), visit)
+		}
+	case map[string]interface{}: // JSON message
+		for signalName, nestedValue := range castedValue {
+			walkSignalValue(path+"."+signalName, nil, nestedValue, visit)
+		}
+	case []interface{}: // Repeated message
+		for i, repeatedValue := range castedValue {
+			walkSignalValue(path+"_"+strconv.Itoa(i), field, repeatedValue, visit)
+		}
+	case map[interface{}]interface{}: // Protobuf maps have no fixed layout so we don't treat them as signals
+		return
+	default:
+		visit(path, field, value)
+	}
+}
+
+// SignalType returns a short name for the type of a signal, using the protobuf type when it is known
+func SignalType(field *desc.FieldDescriptor, value interface{}) string {
+	if field != nil {
+		return strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+	}
+
+	switch value.(type) {
+	case float64, float32:
+		return "double"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	default:
+		return "unknown"
+	}
+}
+
+// signalUnitSuffixes maps the suffixes of signal names to their units.
+// Our protobuf definitions don't carry units, so this only covers the naming conventions used on the car.
+var signalUnitSuffixes = []struct {
+	suffix string
+	units  string
+}{
+	{"_rpms", "rpm"},
+	{"_rpm", "rpm"},
+	{"_m_s", "m/s"},
+	{"_mps", "m/s"},
+	{"_deg", "deg"},
+	{"_rad", "rad"},
+	{"_volts", "V"},
+	{"_voltage", "V"},
+	{"_amps", "A"},
+	{"_temp", "degC"},
+	{"_kw", "kW"},
+	{"_nm", "N*m"},
+	{"_ms", "ms"},
+	{"lat", "deg"},
+	{"lon", "deg"},
+}
+
+// SignalUnits returns the units of a signal based on the name of its last field, or "" if they are unknown
+func SignalUnits(path string) string {
+	// Repeated values (FL_0) and nested fields (current_rpms.FL) take the units of the field they belong to
+	for _, name := range reverseSignalPath(path) {
+		name = strings.ToLower(name)
+		for _, unitSuffix := range signalUnitSuffixes {
+			// Suffixes without an underscore are whole field names
+			if name == strings.TrimPrefix(unitSuffix.suffix, "_") || (strings.HasPrefix(unitSuffix.suffix, "_") && strings.HasSuffix(name, unitSuffix.suffix)) {
+				return unitSuffix.units
+			}
+		}
+	}
+
+	return ""
+}
+
+// reverseSignalPath returns the field names of a signal path from the leaf up to (but excluding) the topic,
+// with the index suffix of repeated values removed
+func reverseSignalPath(path string) []string {
+	names := strings.Split(path, ".")[1:]
+	reversed := make([]string, 0, len(names))
+	for i := len(names) - 1; i >= 0; i-- {
+		name := names[i]
+		if index := strings.LastIndex(name, "_"); index != -1 {
+			if _, err := strconv.Atoi(name[index+1:]); err == nil {
+				name = name[:index]
+			}
+		}
+		reversed = append(reversed, name)
+	}
+	return reversed
+}
+
+// SignalEnumNames returns the names of all the values of an enum signal in order of their number.
+// It returns nil if the signal is not an enum.
+func SignalEnumNames(field *desc.FieldDescriptor) []string {
+	if field == nil || field.GetEnumType() == nil {
+		return nil
+	}
+
+	values := field.GetEnumType().GetValues()
+	names := make([]string, len(values))
+	for i, value := range values {
+		names[i] = value.GetName()
+	}
+	return names
+}
+
+// SignalFloatValue converts a numeric, bool or enum signal value into a float64.
+// It returns false if the value cannot be represented as a number.
+func SignalFloatValue(value interface{}) (float64, bool) {
+	switch x := value.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint32:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}